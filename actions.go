@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WORKFLOW_RUNS_CHECKED is how many of a workflow's most recent successful runs are checked for artifacts that
+// haven't expired yet.
+const WORKFLOW_RUNS_CHECKED = 10
+
+// githubWorkflowRuns represents the response from the GitHub API specified here:
+// https://docs.github.com/en/rest/actions/workflow-runs?apiVersion=2022-11-28#list-workflow-runs-for-a-workflow
+type githubWorkflowRuns struct {
+	WorkflowRuns []*githubWorkflowRun `json:"workflow_runs"`
+}
+
+type githubWorkflowRun struct {
+	Id         int64  `json:"id"`
+	RunNumber  int    `json:"run_number"`
+	HeadSha    string `json:"head_sha"`
+	HeadBranch string `json:"head_branch"`
+	HtmlUrl    string `json:"html_url"`
+}
+
+// githubArtifacts represents the response from the GitHub API specified here:
+// https://docs.github.com/en/rest/actions/artifacts?apiVersion=2022-11-28#list-workflow-run-artifacts
+type githubArtifacts struct {
+	Artifacts []*githubArtifact `json:"artifacts"`
+}
+
+type githubArtifact struct {
+	Name               string `json:"name"`
+	Size               int64  `json:"size_in_bytes"`
+	ArchiveDownloadUrl string `json:"archive_download_url"`
+	Expired            bool   `json:"expired"`
+}
+
+// version is the version of the package built by the run: its run number, which only ever goes up, with the commit it
+// built as build metadata, e.g. 1234+1a2b3c4.
+func (run *githubWorkflowRun) version() string {
+	return strconv.Itoa(run.RunNumber) + "+" + run.HeadSha[:min(7, len(run.HeadSha))]
+}
+
+// workflowSource is a GitHub repository's Actions workflow whose artifacts are installed instead of its releases,
+// for projects that only publish nightly builds that way. It is written as the URL of the workflow's page, with the
+// branch whose runs are used in the query, e.g. github.com/user/repo/actions/workflows/nightly.yml?branch=main, which
+// is the source recorded for packages installed from it.
+type workflowSource struct {
+	// RepoPath is the /user/repo path of the repository.
+	RepoPath string
+	// Workflow is the workflow's file name, e.g. nightly.yml, or its ID.
+	Workflow string
+	// Branch is the branch whose runs are used, or "" for any.
+	Branch string
+}
+
+// parseWorkflowSource returns the workflow that source is the page of, if it is one.
+func parseWorkflowSource(u *url.URL) (*workflowSource, bool) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Hostname() != "github.com" || len(parts) != 5 || parts[2] != "actions" || parts[3] != "workflows" {
+		return nil, false
+	}
+	return &workflowSource{RepoPath: "/" + parts[0] + "/" + parts[1], Workflow: parts[4], Branch: u.Query().Get("branch")}, true
+}
+
+// workflowSourceFor returns the workflow of a repository given with --workflow and --branch.
+func workflowSourceFor(reqPath string, opts resolveOpts) (*workflowSource, error) {
+	repoPath, ok := githubRepoPath(reqPath)
+	if !ok {
+		return nil, withKind(errUsage, fmt.Errorf("--workflow can only be used with a repository on GitHub, not %s", reqPath))
+	}
+	return &workflowSource{RepoPath: repoPath, Workflow: opts.Workflow, Branch: opts.Branch}, nil
+}
+
+func (w *workflowSource) String() string {
+	s := "github.com" + w.RepoPath + "/actions/workflows/" + w.Workflow
+	if w.Branch != "" {
+		s += "?" + url.Values{"branch": {w.Branch}}.Encode()
+	}
+	return s
+}
+
+// latestRun finds the most recent successful run of the workflow with artifacts that haven't expired, returning
+// them along with it.
+func (w *workflowSource) latestRun() (*githubWorkflowRun, []*githubArtifact, error) {
+	query := url.Values{"status": {"success"}, "exclude_pull_requests": {"true"}, "per_page": {strconv.Itoa(WORKFLOW_RUNS_CHECKED)}}
+	if w.Branch != "" {
+		query.Set("branch", w.Branch)
+	}
+	runs := &githubWorkflowRuns{}
+	endpoint := path.Join("actions/workflows", url.PathEscape(w.Workflow), "runs") + "?" + query.Encode()
+	if err := fetchGithubApi(w.RepoPath, endpoint, runs); err != nil {
+		return nil, nil, err
+	}
+
+	for _, run := range runs.WorkflowRuns {
+		artifacts := &githubArtifacts{}
+		if err := fetchGithubApi(w.RepoPath, "actions/runs/"+strconv.FormatInt(run.Id, 10)+"/artifacts", artifacts); err != nil {
+			return nil, nil, err
+		}
+		unexpired := []*githubArtifact{}
+		for _, a := range artifacts.Artifacts {
+			if !a.Expired {
+				unexpired = append(unexpired, a)
+			}
+		}
+		if len(unexpired) > 0 {
+			return run, unexpired, nil
+		}
+		slog.Debug("workflow run has no artifacts that haven't expired", "run", run.HtmlUrl)
+	}
+	return nil, nil, withKind(errNoMatchingAsset, fmt.Errorf("none of the last %d successful runs of workflow %s in %s have artifacts that haven't expired", WORKFLOW_RUNS_CHECKED, w.Workflow, strings.Trim(w.RepoPath, "/")))
+}
+
+// resolveWorkflow finds the artifact to download from the latest successful run of a workflow, choosing between its
+// artifacts the same way as between the assets of a release. GitHub only lets artifacts be downloaded with a token,
+// even from public repositories.
+func resolveWorkflow(w *workflowSource, opts resolveOpts) (*resolvedSource, error) {
+	if token, _ := lookupToken("github"); token == "" {
+		return nil, withKind(errUsage, errors.New(tr("GitHub only lets Actions artifacts be downloaded with a token. Set GITHUB_TOKEN or log in with infpm auth login github.")))
+	}
+	name := path.Base(w.RepoPath)
+	if opts.Prefer == "" {
+		opts.Prefer = config.PreferFor(name)
+	}
+
+	run, artifacts, err := w.latestRun()
+	if err != nil {
+		return nil, withPackage(err, name, w.String())
+	}
+	if opts.Tag != "" && !sameVersion(opts.Tag, run.version()) {
+		return nil, withKind(errUsage, errors.New(tr("Only the latest build of a workflow can be installed, which is %s, not %s.", run.version(), opts.Tag)))
+	}
+	// The artifacts of a run are chosen between like the assets of a release, with the run standing in for it.
+	release := &githubApiReleases{Name: tr("run %d of %s on %s", run.RunNumber, w.Workflow, run.HeadBranch), TagName: run.version(), HtmlUrl: run.HtmlUrl}
+	for _, a := range artifacts {
+		release.Assets = append(release.Assets, &githubApiReleaseAsset{Name: a.Name, BrowserDownloadUrl: a.ArchiveDownloadUrl, ApiUrl: a.ArchiveDownloadUrl, Size: a.Size})
+	}
+	if opts.NonInteractive {
+		slog.Info("found workflow run", "run", release.Name, "url", run.HtmlUrl)
+	} else {
+		fmt.Println(tr("Found workflow run: %s. Read about this run: %s", release.Name, run.HtmlUrl))
+	}
+
+	assets := matchingAssets(release, opts)
+	if len(assets) == 0 && len(release.Assets) == 1 {
+		// A workflow that only uploads one artifact doesn't need to say what it is for.
+		assets = rankAssets(release.Assets, opts.Platform, opts.Prefer)
+	}
+	var asset *githubApiReleaseAsset
+	switch {
+	case len(assets) == 0 && opts.NonInteractive:
+		return nil, withPackage(withKind(errNoMatchingAsset, fmt.Errorf("no artifacts of %s match %s", release.Name, opts.Platform)), name, w.String())
+	case opts.NonInteractive:
+		asset, err = chooseAssetNonInteractively(assets, opts, release.TagName)
+	default:
+		asset, err = chooseAssetInteractively(release, assets, opts)
+	}
+	if err != nil {
+		return nil, withPackage(err, name, w.String())
+	}
+
+	progress.Emit(progressEvent{Event: PROGRESS_RESOLVE, Package: name, Version: release.TagName, Url: asset.ApiUrl})
+	return &resolvedSource{Name: name, Version: release.TagName, Url: asset.ApiUrl, Filename: asset.Name + ".zip"}, nil
+}
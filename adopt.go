@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Adopt brings a file or directory that was installed by hand under infpm's management. A single file is placed in
+// the bin directory of a new package in the store, while a directory is placed in the store as is, as if it had
+// been extracted from a tarball. The original is moved unless keep is set, in which case it is copied.
+func (pm *PackageManager) Adopt(path string, opts PreinstallPackageOpts, keep bool) (*Package, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Error("adopt: failed to find the file to adopt", "path", path)
+		return nil, err
+	}
+
+	ppkg := &PreinstallPackage{Url: path}
+	if err := ppkg.setOpts(opts); err != nil {
+		return nil, err
+	}
+	pkg := &Package{
+		PreinstallPackage: ppkg,
+		FullPath:          filepath.Join(pm.StorePath, ppkg.Path),
+	}
+
+	dst := filepath.Join(pkg.FullPath, filepath.Base(path))
+	if !info.IsDir() {
+		dst = filepath.Join(pkg.FullPath, "bin", filepath.Base(path))
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		slog.Error("failed to create package directory", "path", filepath.Dir(dst))
+		return nil, err
+	}
+
+	if keep {
+		slog.Info("adopt: copying into the store", "from", path, "to", dst)
+		err = copyTree(path, dst)
+	} else {
+		slog.Info("adopt: moving into the store", "from", path, "to", dst)
+		err = moveTree(path, dst)
+	}
+	if err != nil {
+		os.RemoveAll(pkg.FullPath)
+		return nil, err
+	}
+
+	if pkg.Platform.IsHost() {
+		pm.warnShadowedCommands(pkg.FullPath, pkg.Layout)
+		if pkg.Links, err = pm.linkPackage(pkg.Name, pkg.Platform, pkg.FullPath, pkg.Layout); err != nil {
+			return nil, err
+		}
+		pkg.Symlinked = true
+	}
+
+	if err := pm.record(pkg); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+func actionAdopt(ctx context.Context, cmd *cli.Command) error {
+	path := cmd.Args().Get(0)
+	if path == "" {
+		return withKind(errUsage, errors.New(tr("A path to adopt is required. See --help adopt.")))
+	}
+	if cmd.String("name") == "" || cmd.String("version") == "" {
+		return withKind(errUsage, errors.New("--name and --version are required to adopt a package."))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if len(pm.db.Find(cmd.String("name"))) > 0 {
+		return withKind(errAlreadyInstalled, fmt.Errorf("package %s is already installed", cmd.String("name")))
+	}
+
+	// Record where the package really comes from, if known, so that it can be upgraded from there.
+	source := cmd.String("source")
+	if source == "" {
+		source = path
+	}
+
+	pkg, err := pm.Adopt(path, PreinstallPackageOpts{
+		Name:     cmd.String("name"),
+		Version:  cmd.String("version"),
+		Source:   source,
+		Platform: hostPlatform(),
+	}, cmd.Bool("copy"))
+	if err != nil {
+		return withPackage(err, cmd.String("name"), path)
+	}
+
+	fmt.Println(tr("Adopted %s %s into %s", pkg.Name, pkg.Version, pkg.FullPath))
+	if pkg.Symlinked {
+		printRehashHint()
+	}
+	return nil
+}
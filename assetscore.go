@@ -0,0 +1,237 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The weights scoreAsset gives to what an asset's name says about it. Matching the OS and architecture outweighs
+// everything else, and naming another OS or architecture, or being a checksum or signature, rules an asset out.
+const (
+	SCORE_OS_MATCH       = 100
+	SCORE_ARCH_MATCH     = 100
+	SCORE_MISMATCH       = -1000
+	SCORE_SIDECAR        = -2000
+	SCORE_ARCHIVE        = 20
+	SCORE_PACKAGE_FORMAT = -20
+	SCORE_PREFERENCE     = 10
+	SCORE_LIBC_MATCH     = 5
+)
+
+// assetOsKeywords, assetArchKeywords and assetLibcKeywords are the words release asset names commonly use for each
+// OS, architecture and C library, in GOOS/GOARCH terms. Keywords of three letters or fewer only match on their own,
+// not inside a longer word, so that e.g. win doesn't match darwin. See containsKeyword.
+var assetOsKeywords = map[string][]string{
+	"linux":   {"linux"},
+	"darwin":  {"darwin", "macos", "apple", "osx", "mac"},
+	"windows": {"windows", "win64", "win32", "win", "mingw", "msvc"},
+	"freebsd": {"freebsd"},
+	"openbsd": {"openbsd"},
+	"netbsd":  {"netbsd"},
+	"illumos": {"illumos"},
+	"solaris": {"solaris"},
+	"android": {"android"},
+}
+
+// assetOsOrder is the order assetOs checks operating systems in, so that one whose asset names often mention another,
+// e.g. aarch64-linux-android, is recognised first.
+var assetOsOrder = []string{"android", "darwin", "windows", "freebsd", "openbsd", "netbsd", "illumos", "solaris", "linux"}
+
+var assetArchKeywords = map[string][]string{
+	"amd64":   {"x86_64", "x86-64", "amd64", "x64"},
+	"arm64":   {"aarch64", "arm64", "armv8"},
+	"386":     {"i386", "i486", "i586", "i686", "386", "x86"},
+	"arm":     {"armv7", "armv6", "armv5", "armhf", "armel", "arm"},
+	"ppc64le": {"ppc64le", "powerpc64le"},
+	"ppc64":   {"ppc64", "powerpc64"},
+	"riscv64": {"riscv64"},
+	"s390x":   {"s390x"},
+	"loong64": {"loong64", "loongarch64"},
+	"mips64":  {"mips64"},
+	"mipsle":  {"mipsle", "mipsel"},
+}
+
+// assetArchOrder is the order assetArch checks architectures in, so that a keyword that is part of another
+// architecture's, e.g. x86 of x86_64 or ppc64 of ppc64le, is only checked once the longer one hasn't matched.
+var assetArchOrder = []string{"amd64", "arm64", "ppc64le", "ppc64", "riscv64", "s390x", "loong64", "mips64", "mipsle", "386", "arm"}
+
+var assetLibcKeywords = map[string][]string{
+	"musl": {"musl"},
+	"gnu":  {"gnu", "gnueabi", "gnueabihf", "glibc"},
+}
+
+// sidecarExtensions are the extensions of assets that describe another asset rather than being installable.
+var sidecarExtensions = []string{
+	".sha256", ".sha256sum", ".sha512", ".sha512sum", ".sha1", ".md5", ".md5sum", ".sig", ".asc", ".minisig", ".pem",
+	".pub", ".cert", ".crt", ".sbom", ".spdx", ".intoto.jsonl", ".bundle", ".txt", ".json",
+}
+
+// packageFormatExtensions are the extensions of system packages and installers, which infpm can't install. They
+// are ranked below archives, but still listed, since they are sometimes the only build for a platform.
+var packageFormatExtensions = []string{".deb", ".rpm", ".apk", ".msi", ".pkg", ".dmg", ".snap", ".flatpak", ".appimage"}
+
+// universalArchKeywords are found in the names of macOS assets built for every architecture.
+var universalArchKeywords = []string{"universal"}
+
+// rankedAsset is a release asset with how well it suits the platform. See scoreAsset.
+type rankedAsset struct {
+	*githubApiReleaseAsset
+	Score int
+}
+
+// rankAssets scores the assets for the platform and build preference and sorts them best first. Assets with the
+// same score keep their order in the release.
+func rankAssets(assets []*githubApiReleaseAsset, platform Platform, prefer string) []rankedAsset {
+	ranked := make([]rankedAsset, 0, len(assets))
+	for _, asset := range assets {
+		ranked = append(ranked, rankedAsset{githubApiReleaseAsset: asset, Score: scoreAsset(asset.Name, platform, prefer)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked
+}
+
+// assetMatchesPlatform returns whether an asset's name says it was built for the platform's OS and architecture.
+func assetMatchesPlatform(assetName string, platform Platform) bool {
+	name := strings.ToLower(assetName)
+	return assetOsScore(name, platform.OS) > 0 && assetArchScore(name, platform) > 0
+}
+
+// scoreAsset rates how likely an asset is to be the one to install for the platform: the OS and architecture must
+// match, archives beat system packages, the build preference and then the C library the machine uses break ties,
+// and checksums, signatures and SBOMs are never chosen over anything else.
+func scoreAsset(assetName string, platform Platform, prefer string) int {
+	name := strings.ToLower(assetName)
+	score := assetOsScore(name, platform.OS) + assetArchScore(name, platform)
+	switch {
+	case isArchiveAsset(name):
+		score += SCORE_ARCHIVE
+	case hasAnySuffix(name, packageFormatExtensions):
+		score += SCORE_PACKAGE_FORMAT
+	}
+	if prefer != "" && matchesPreference(name, prefer) {
+		score += SCORE_PREFERENCE
+	}
+	score += assetLibcScore(name, platform)
+	if hasAnySuffix(name, sidecarExtensions) || strings.Contains(name, "sbom") {
+		score += SCORE_SIDECAR
+	}
+	return score
+}
+
+// assetOsScore scores the OS named in an asset's lowercased name against os.
+func assetOsScore(name string, os string) int {
+	switch found := assetOs(name); {
+	case found == os:
+		return SCORE_OS_MATCH
+	case found != "":
+		return SCORE_MISMATCH
+	case len(assetOsKeywords[os]) == 0 && strings.Contains(name, os):
+		return SCORE_OS_MATCH
+	}
+	return 0
+}
+
+// assetOs returns the OS an asset's lowercased name says it was built for, in GOOS terms, or "".
+func assetOs(name string) string {
+	for _, os := range assetOsOrder {
+		if containsAny(name, assetOsKeywords[os]) {
+			return os
+		}
+	}
+	return ""
+}
+
+// assetArchScore scores the architecture named in an asset's lowercased name against the platform's, treating the
+// names vendors use for the same architecture, e.g. aarch64 and arm64, or i686 and 386, alike.
+func assetArchScore(name string, platform Platform) int {
+	switch arch := assetArch(name); {
+	case arch == platform.Arch:
+		return SCORE_ARCH_MATCH
+	case arch != "":
+		return SCORE_MISMATCH
+	case len(assetArchKeywords[platform.Arch]) == 0 && strings.Contains(name, platform.Arch):
+		return SCORE_ARCH_MATCH
+	case platform.OS == "darwin" && containsAny(name, universalArchKeywords):
+		return SCORE_ARCH_MATCH
+	}
+	return 0
+}
+
+// assetArch returns the architecture an asset's lowercased name says it was built for, in GOARCH terms, or "".
+func assetArch(name string) string {
+	// Check for longer keywords first, so that e.g. x86_64 isn't mistaken for x86.
+	for _, arch := range assetArchOrder {
+		if containsAny(name, assetArchKeywords[arch]) {
+			return arch
+		}
+	}
+	return ""
+}
+
+// assetLibcScore favours Linux assets linked against the C library this machine uses, and rules out ones linked
+// against glibc on a machine that only has musl. Assets for other machines aren't scored, since their C library
+// isn't known.
+func assetLibcScore(name string, platform Platform) int {
+	if platform.OS != "linux" || !platform.IsHost() {
+		return 0
+	}
+	libc := ""
+	if containsAny(name, assetLibcKeywords["musl"]) {
+		libc = "musl"
+	} else if containsAny(name, assetLibcKeywords["gnu"]) {
+		libc = "gnu"
+	}
+	switch {
+	case libc == "":
+		return 0
+	case libc == hostLibc():
+		return SCORE_LIBC_MATCH
+	case libc == "gnu":
+		return SCORE_MISMATCH
+	}
+	return 0
+}
+
+// hostLibc returns the C library this machine uses: musl if only its dynamic loader is installed, as on Alpine, and
+// gnu otherwise.
+var hostLibc = sync.OnceValue(func() string {
+	musl, _ := filepath.Glob("/lib/ld-musl-*.so.1")
+	glibc, _ := filepath.Glob("/lib*/ld-linux*.so.*")
+	if len(musl) > 0 && len(glibc) == 0 {
+		return "musl"
+	}
+	return "gnu"
+})
+
+// containsAny returns whether name contains any of the keywords. See containsKeyword.
+func containsAny(name string, keywords []string) bool {
+	return slices.ContainsFunc(keywords, func(kw string) bool { return containsKeyword(name, kw) })
+}
+
+// containsKeyword returns whether name contains kw. Keywords of three letters or fewer, e.g. win or arm, must not be
+// next to another letter, since they are often part of longer words, e.g. darwin or charm.
+func containsKeyword(name string, kw string) bool {
+	if len(kw) > 3 {
+		return strings.Contains(name, kw)
+	}
+	isLetter := func(c byte) bool { return c >= 'a' && c <= 'z' }
+	for i := 0; ; {
+		j := strings.Index(name[i:], kw)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(kw)
+		if (start == 0 || !isLetter(name[start-1])) && (end == len(name) || !isLetter(name[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+// hasAnySuffix returns whether s ends with any of the suffixes.
+func hasAnySuffix(s string, suffixes []string) bool {
+	return slices.ContainsFunc(suffixes, func(suffix string) bool { return strings.HasSuffix(s, suffix) })
+}
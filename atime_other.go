@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package main
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime returns the file's modification time, since access times aren't read on this platform.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// accessTime returns when the file was last accessed, or its modification time if that isn't known.
+func accessTime(info fs.FileInfo) time.Time {
+	if attrs, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, attrs.LastAccessTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// KEYCHAIN_SERVICE is the service name tokens are stored under in the OS keychain.
+const KEYCHAIN_SERVICE = "infpm"
+
+// githubOAuthClientId is the client ID of the OAuth app used for the GitHub device flow. It can be set at build time
+// with -ldflags "-X main.githubOAuthClientId=..." or overridden at runtime with INFPM_GITHUB_CLIENT_ID.
+var githubOAuthClientId = ""
+
+// supportedAuthProviders are the providers a token can be stored for.
+var supportedAuthProviders = []string{"github", "gitlab"}
+
+// errCredentialNotFound is returned by credential stores when no token is stored for a provider.
+var errCredentialNotFound = errors.New("no credential stored for this provider")
+
+// errKeychainUnsupported is returned by the keychain functions on platforms without a supported OS keychain.
+var errKeychainUnsupported = errors.New("the OS keychain is not supported on this platform")
+
+// credentialsFile is the plaintext fallback for tokens, stored in the config directory with 0600 permissions.
+type credentialsFile struct {
+	Tokens map[string]string `json:"tokens"`
+}
+
+// credentialsFilePath returns the location of the plaintext credentials file.
+func credentialsFilePath() (string, error) {
+	dir, err := infpmConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "credentials.json"), nil
+}
+
+func readCredentialsFile() (*credentialsFile, error) {
+	creds := &credentialsFile{Tokens: map[string]string{}}
+	fp, err := credentialsFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return creds, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(creds); err != nil {
+		slog.Error("failed to decode credentials file", "path", fp)
+		return nil, err
+	}
+	if creds.Tokens == nil {
+		creds.Tokens = map[string]string{}
+	}
+	return creds, nil
+}
+
+func writeCredentialsFile(creds *credentialsFile) error {
+	fp, err := credentialsFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fp), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fp, data, 0600)
+}
+
+// tokenEnvVars are the environment variables a provider's token is read from, in order of precedence. The
+// conventional names are honoured so that tokens CI already provides, e.g. in GitHub Actions, are picked up.
+var tokenEnvVars = map[string][]string{
+	"github": {"INFPM_GITHUB_TOKEN", "GITHUB_TOKEN"},
+	"gitlab": {"INFPM_GITLAB_TOKEN", "GITLAB_TOKEN"},
+}
+
+// tokenLookups holds the result of findToken for each supported provider, looked up once per run, since reading the
+// keychain runs secret-tool or security and a command can make many requests.
+var tokenLookups = func() map[string]func() (string, string) {
+	lookups := map[string]func() (string, string){}
+	for _, provider := range supportedAuthProviders {
+		lookups[provider] = sync.OnceValues(func() (string, string) { return findToken(provider) })
+	}
+	return lookups
+}()
+
+// lookupToken returns the token for the given provider and where it was found. See findToken.
+func lookupToken(provider string) (token string, source string) {
+	if lookup, ok := tokenLookups[provider]; ok {
+		return lookup()
+	}
+	return findToken(provider)
+}
+
+// findToken finds a token for the given provider, checking the environment first, then the OS keychain and then
+// the plaintext credentials file. Returns "" and the name of no source if none is configured.
+func findToken(provider string) (token string, source string) {
+	for _, name := range tokenEnvVars[provider] {
+		if token := strings.TrimSpace(os.Getenv(name)); token != "" {
+			return token, "$" + name
+		}
+	}
+
+	if token, err := keychainGet(provider); err == nil && token != "" {
+		return token, "keychain"
+	} else if err != nil && !errors.Is(err, errCredentialNotFound) && !errors.Is(err, errKeychainUnsupported) {
+		slog.Debug("failed to read token from keychain", "provider", provider, "err", err)
+	}
+
+	creds, err := readCredentialsFile()
+	if err != nil {
+		slog.Debug("failed to read credentials file", "err", err)
+		return "", ""
+	}
+	if token := creds.Tokens[provider]; token != "" {
+		return token, "file"
+	}
+	return "", ""
+}
+
+// saveToken stores the token for a provider, either in the OS keychain or the plaintext credentials file.
+func saveToken(provider, token string, useKeychain bool) error {
+	if useKeychain {
+		if err := keychainSet(provider, token); err != nil {
+			slog.Error("failed to store token in the OS keychain", "provider", provider)
+			return err
+		}
+
+		// Don't leave a stale plaintext copy around once the keychain holds the token.
+		if creds, err := readCredentialsFile(); err == nil {
+			if _, ok := creds.Tokens[provider]; ok {
+				delete(creds.Tokens, provider)
+				return writeCredentialsFile(creds)
+			}
+		}
+		return nil
+	}
+
+	creds, err := readCredentialsFile()
+	if err != nil {
+		return err
+	}
+	creds.Tokens[provider] = token
+	return writeCredentialsFile(creds)
+}
+
+// maskToken hides all but the last four characters of a token.
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return strings.Repeat("*", len(token))
+	}
+	return strings.Repeat("*", len(token)-4) + token[len(token)-4:]
+}
+
+func validateAuthProvider(provider string) error {
+	for _, p := range supportedAuthProviders {
+		if p == provider {
+			return nil
+		}
+	}
+	return withKind(errUsage, fmt.Errorf("unknown provider %q, expected one of: %s", provider, strings.Join(supportedAuthProviders, ", ")))
+}
+
+func actionAuthLogin(ctx context.Context, cmd *cli.Command) error {
+	provider := strings.ToLower(cmd.Args().Get(0))
+	if provider == "" {
+		return withKind(errUsage, errors.New(tr("A provider is required, e.g. infpm auth login github.")))
+	}
+	if err := validateAuthProvider(provider); err != nil {
+		return err
+	}
+
+	token := cmd.String("token")
+	if token == "" && ciMode {
+		return withKind(errUsage, errors.New("--token is required in CI mode"))
+	}
+	clientId := cmd.String("client-id")
+	if clientId == "" {
+		clientId = githubOAuthClientId
+	}
+	deviceFlow := token == "" && provider == "github" && !cmd.Bool("paste")
+	if deviceFlow && clientId == "" {
+		// Builds without a client ID can't use the device flow, so ask for a token as --paste does.
+		fmt.Fprintln(os.Stderr, tr("No GitHub OAuth client ID is configured, so infpm can't log in through the browser. Create a token at https://github.com/settings/tokens and paste it instead, or set --client-id or INFPM_GITHUB_CLIENT_ID to use the device flow."))
+		deviceFlow = false
+	}
+	if deviceFlow {
+		var err error
+		if token, err = githubDeviceFlow(ctx, clientId, cmd.String("scope")); err != nil {
+			return err
+		}
+	} else if token == "" {
+		// A token that isn't pasted is caught below.
+		token, _ = newPrompter(!nonInteractive).text(tr("Paste a %s token", provider), "", nil)
+	}
+	if token == "" {
+		return errors.New("no token was provided")
+	}
+
+	if err := saveToken(provider, token, cmd.Bool("keychain")); err != nil {
+		return err
+	}
+
+	if cmd.Bool("keychain") {
+		fmt.Println(tr("Saved %s token to the OS keychain.", provider))
+	} else {
+		fp, _ := credentialsFilePath()
+		fmt.Println(tr("Saved %s token to %s. Use --keychain to store it in the OS keychain instead.", provider, fp))
+	}
+	return nil
+}
+
+func actionAuthStatus(ctx context.Context, cmd *cli.Command) error {
+	for _, provider := range supportedAuthProviders {
+		token, source := lookupToken(provider)
+		if token == "" {
+			fmt.Println(tr("%s: not logged in", provider))
+			continue
+		}
+		fmt.Println(tr("%s: token %s (from %s)", provider, maskToken(token), source))
+	}
+	return nil
+}
+
+// githubDeviceCode is the response from GitHub's device code endpoint specified here:
+// https://docs.github.com/en/apps/oauth-apps/building-oauth-apps/authorizing-oauth-apps#device-flow
+type githubDeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationUri string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// githubAccessToken is the response from GitHub's access token endpoint while polling during the device flow.
+type githubAccessToken struct {
+	AccessToken string `json:"access_token"`
+	Scope       string `json:"scope"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+	Interval    int    `json:"interval"`
+}
+
+// postGithubForm POSTs a form to a github.com OAuth endpoint and decodes the JSON response into v.
+func postGithubForm(ctx context.Context, endpoint string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return withKind(errNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return withKind(errNetwork, fmt.Errorf("GitHub returned status %d from %s", resp.StatusCode, endpoint))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// githubDeviceFlow runs the OAuth device flow: it requests a user code, asks the user to enter it on github.com,
+// then polls until the user has authorised infpm and returns the resulting token.
+func githubDeviceFlow(ctx context.Context, clientId string, scope string) (string, error) {
+	var code githubDeviceCode
+	err := postGithubForm(ctx, "https://github.com/login/device/code", url.Values{
+		"client_id": {clientId},
+		"scope":     {scope},
+	}, &code)
+	if err != nil {
+		slog.Error("failed to request a device code from GitHub")
+		return "", err
+	}
+
+	fmt.Println(tr("Open %s and enter the code: %s", code.VerificationUri, code.UserCode))
+	fmt.Println(tr("Waiting for authorisation..."))
+
+	interval := time.Duration(code.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var tok githubAccessToken
+		err := postGithubForm(ctx, "https://github.com/login/oauth/access_token", url.Values{
+			"client_id":   {clientId},
+			"device_code": {code.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &tok)
+		if err != nil {
+			return "", err
+		}
+
+		switch tok.Error {
+		case "":
+			slog.Debug("device flow completed", "scope", tok.Scope)
+			return tok.AccessToken, nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			// GitHub tells us the new minimum interval; fall back to adding 5 seconds as the spec requires.
+			if tok.Interval > 0 {
+				interval = time.Duration(tok.Interval) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+		case "expired_token":
+			return "", errors.New("the device code expired before it was authorised, please try again")
+		case "access_denied":
+			return "", errors.New("authorisation was denied on GitHub")
+		default:
+			return "", fmt.Errorf("GitHub device flow failed: %s (%s)", tok.Error, tok.Description)
+		}
+	}
+
+	return "", errors.New("the device code expired before it was authorised, please try again")
+}
+
+// extraHeaders are sent with every request that isn't to a provider's API. Set with --header.
+var extraHeaders = http.Header{}
+
+// parseHeader parses a header given as "Name: value", as curl takes them.
+func parseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", withKind(errUsage, errors.New(tr("Invalid header %q. Give headers as 'Name: value'.", s)))
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// authorizeRequest adds the token for the provider a request is for, if there is one. Tokens are only ever sent to
+// the provider's API. Requests for GitHub release assets through the API also ask for the asset's contents rather
+// than its metadata.
+//
+// Other requests, e.g. to private artifact servers, get the headers from --header and those configured for their
+// host, and are otherwise authorised with the credentials for their host in ~/.netrc. None of these are sent on if
+// the request is redirected to another host, so credentials aren't leaked to e.g. a CDN; see checkRedirect.
+func authorizeRequest(req *http.Request) {
+	if req.URL.Scheme == "https" && req.URL.Hostname() == "api.github.com" {
+		if token, _ := lookupToken("github"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if strings.Contains(req.URL.Path, "/releases/assets/") {
+			req.Header.Set("Accept", "application/octet-stream")
+		}
+		return
+	}
+
+	for name, values := range extraHeaders {
+		req.Header[name] = values
+	}
+	for name, value := range config.Headers[req.URL.Hostname()] {
+		req.Header.Set(name, value)
+	}
+	if req.Header.Get("Authorization") == "" {
+		if login, password, ok := netrcCredentials(req.URL.Hostname()); ok {
+			req.SetBasicAuth(login, password)
+		}
+	}
+}
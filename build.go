@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// buildSystem is a way of building a project from source, recognised by a file at the root of the project.
+type buildSystem struct {
+	Name string
+	// Marker is the file whose presence at the root of the source identifies the build system.
+	Marker string
+	// Tool is the command that must be in PATH to build.
+	Tool string
+	// Steps returns the commands that build the source at src and install the results under prefix, with
+	// executables in prefix/bin.
+	Steps func(src string, prefix string) [][]string
+}
+
+// buildSystems are tried in order, so the most specific come first: many Go and Rust projects also have a Makefile,
+// which usually only wraps the native tool.
+var buildSystems = []buildSystem{
+	{
+		Name:   "go",
+		Marker: "go.mod",
+		Tool:   "go",
+		Steps: func(src string, prefix string) [][]string {
+			// Projects with several commands conventionally keep them in cmd/.
+			pkgs := "."
+			if info, err := os.Stat(filepath.Join(src, "cmd")); err == nil && info.IsDir() {
+				pkgs = "./cmd/..."
+			}
+			return [][]string{{"go", "build", "-trimpath", "-o", filepath.Join(prefix, "bin") + string(filepath.Separator), pkgs}}
+		},
+	},
+	{
+		Name:   "cargo",
+		Marker: "Cargo.toml",
+		Tool:   "cargo",
+		Steps: func(src string, prefix string) [][]string {
+			return [][]string{{"cargo", "install", "--locked", "--path", ".", "--root", prefix}}
+		},
+	},
+	{
+		Name:   "make",
+		Marker: "Makefile",
+		Tool:   "make",
+		Steps: func(src string, prefix string) [][]string {
+			return [][]string{{"make"}, {"make", "install", "PREFIX=" + prefix, "prefix=" + prefix}}
+		},
+	},
+}
+
+// detectBuildSystem returns the build system of the source at src, or nil if it isn't recognised.
+func detectBuildSystem(src string) *buildSystem {
+	for i, bs := range buildSystems {
+		if _, err := os.Stat(filepath.Join(src, bs.Marker)); err == nil {
+			return &buildSystems[i]
+		}
+	}
+	return nil
+}
+
+// sourceRoot returns the directory of an extracted source tarball that holds the project. GitHub's source tarballs
+// wrap everything in a single user-repo-commit directory.
+func sourceRoot(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err == nil && len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name())
+	}
+	return dir
+}
+
+// BuildFromSource installs a GitHub repository by building the source of a release, for when none of its assets
+// were built for this machine. The release is ropts.Tag, or the latest. Build output goes to stderr, so stdout stays
+// machine-readable.
+func (pm *PackageManager) BuildFromSource(reqPath string, opts PreinstallPackageOpts, ropts resolveOpts) (*Package, error) {
+	if ropts.Platform != (Platform{}) && !ropts.Platform.IsHost() {
+		return nil, withKind(errUsage, fmt.Errorf("can only build from source for this machine (%s), not %s", hostPlatform(), ropts.Platform))
+	}
+	repoPath, ok := githubRepoPath(reqPath)
+	if !ok {
+		return nil, withKind(errUsage, fmt.Errorf("can only build GitHub repositories from source, not %s", reqPath))
+	}
+
+	tag := ropts.Tag
+	if tag == "" {
+		release, err := fetchLatestRelease(repoPath, ropts.Prerelease || config.PrereleaseFor(filepath.Base(repoPath)))
+		if err != nil {
+			return nil, err
+		}
+		tag = release.TagName
+	}
+	if opts.Name == "" {
+		opts.Name = filepath.Base(repoPath)
+	}
+	opts.Version = tag
+	opts.Platform = hostPlatform()
+
+	tmp, err := tempDir()
+	if err != nil {
+		return nil, err
+	}
+	work, err := os.MkdirTemp(tmp, "infpm-build-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(work)
+
+	// The API's tarball endpoint works for private repositories too, unlike the archive links on github.com.
+	tarballUrl := "https://api.github.com/repos" + repoPath + "/tarball/" + tag
+	slog.Info("downloading source", "package", opts.Name, "release", tag, "url", tarballUrl)
+	body, _, err := openRemote(tarballUrl, opts.Name)
+	if err != nil {
+		return nil, withPackage(err, opts.Name, tarballUrl)
+	}
+	defer body.Close()
+	srcDir := filepath.Join(work, "src")
+	if err := tarExtract(body, srcDir); err != nil {
+		if dlErr := downloadError(body); dlErr != nil {
+			err = dlErr
+		}
+		return nil, withPackage(err, opts.Name, tarballUrl)
+	}
+	src := sourceRoot(srcDir)
+
+	bs := detectBuildSystem(src)
+	if bs == nil {
+		return nil, withKind(errNoMatchingAsset, fmt.Errorf("%s has no asset for this machine, and its source has no build system infpm recognises (go.mod, Cargo.toml or Makefile)", opts.Name))
+	}
+	if _, err := exec.LookPath(bs.Tool); err != nil {
+		return nil, withKind(errUsage, fmt.Errorf("building %s from source needs %s, which isn't in PATH", opts.Name, bs.Tool))
+	}
+
+	prefix := filepath.Join(work, opts.Name+"-"+tag)
+	fmt.Fprintln(os.Stderr, tr("Building %s %s from source with %s...", opts.Name, tag, bs.Name))
+	for _, step := range bs.Steps(src, prefix) {
+		slog.Info("running build step", "package", opts.Name, "command", strings.Join(step, " "))
+		cmd := exec.Command(step[0], step[1:]...)
+		cmd.Dir = src
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, withPackage(fmt.Errorf("build step %q failed: %w", strings.Join(step, " "), err), opts.Name, tarballUrl)
+		}
+	}
+	if _, err := os.Stat(prefix); err != nil {
+		return nil, withPackage(fmt.Errorf("building with %s didn't install anything into %s", bs.Name, prefix), opts.Name, tarballUrl)
+	}
+
+	pkg, err := pm.Adopt(prefix, opts, false)
+	if err != nil {
+		return nil, withPackage(err, opts.Name, tarballUrl)
+	}
+	for _, rec := range pm.db.Find(pkg.Name) {
+		if rec.Id == pkg.Id {
+			rec.Url = tarballUrl
+		}
+	}
+	if err := pm.db.Save(); err != nil {
+		return nil, err
+	}
+
+	slog.Info("done", "path", pkg.FullPath)
+	ciResult(CI_INSTALLED, pkg.Name, pkg.Version, pkg.FullPath)
+	progress.Emit(progressEvent{Event: PROGRESS_DONE, Package: pkg.Name, Version: pkg.Version, Path: pkg.FullPath})
+	return pkg, nil
+}
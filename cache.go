@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// cacheEntryPath returns where the tarball read from source, a URL or local file path, is kept in the cache.
+func cacheEntryPath(cachePath string, source string) string {
+	sum := sha256.Sum256([]byte(source))
+	name := filepath.Base(source)
+	if u, err := url.Parse(source); err == nil && u.Host != "" {
+		name = path.Base(u.Path)
+	}
+	return filepath.Join(cachePath, hex.EncodeToString(sum[:8]), name)
+}
+
+// cacheWriter writes a tarball into the cache as it is read during installation. The entry is written to a .part
+// file and only moved into place by Commit, so an interrupted install never leaves a truncated entry behind. The
+// .part file can be kept with Suspend instead, so that the download can be resumed; see resumeFromCache.
+type cacheWriter struct {
+	f    *os.File
+	w    io.Writer
+	dest string
+}
+
+// newCacheWriter creates the cache entry at dest. If skip is positive, the download is being resumed: the first skip
+// bytes are in the .part file already, so they aren't written again.
+func newCacheWriter(dest string, skip int64) (*cacheWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if skip > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(dest+".part", flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheWriter{f: f, w: &skipWriter{w: f, skip: skip}, dest: dest}, nil
+}
+
+func (c *cacheWriter) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+// Commit moves the entry into place once the whole tarball has been written, recording meta with it so that it can
+// be reused instead of downloading the tarball again. See openCached.
+func (c *cacheWriter) Commit(meta remoteMeta) error {
+	if err := c.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(c.f.Name() + DOWNLOAD_META_SUFFIX)
+	if err := os.Rename(c.f.Name(), c.dest); err != nil {
+		return err
+	}
+	if err := writeMeta(c.dest, meta); err != nil {
+		slog.Warn("failed to record what was cached, it won't be reused", "path", c.dest, "err", err)
+	}
+	return nil
+}
+
+// Abort deletes the partially written entry, and its directory if nothing else has been cached there.
+func (c *cacheWriter) Abort() {
+	c.f.Close()
+	removePartial(c.f.Name())
+	os.Remove(filepath.Dir(c.dest))
+}
+
+// Suspend keeps the partially written entry of a download that was cut off, so that it can be resumed from meta.
+// If it can't be resumed, it is deleted as with Abort.
+func (c *cacheWriter) Suspend(meta remoteMeta) {
+	c.f.Close()
+	if meta.validator() == "" || keepPartial(c.f.Name(), meta) != nil {
+		c.Abort()
+		return
+	}
+	slog.Info("kept partial download to resume later", "path", c.f.Name())
+}
+
+// teeToCache returns a reader which copies everything read from r into the cache entry for source, except for the
+// first skip bytes if the download was resumed; see newCacheWriter. If the entry can't be created, r is returned as
+// is, since caching is best-effort.
+func teeToCache(r io.Reader, cachePath string, source string, skip int64) (io.Reader, *cacheWriter) {
+	if cachePath == "" {
+		return r, nil
+	}
+
+	cache, err := newCacheWriter(cacheEntryPath(cachePath, source), skip)
+	if err != nil {
+		slog.Warn("failed to create cache entry, continuing without caching", "source", source, "err", err)
+		return r, nil
+	}
+	return io.TeeReader(r, cache), cache
+}
+
+// openCached opens the cached copy of the tarball at tarballUrl instead of downloading it again, if it is the same as
+// the remote file: either its digest is one the tarball must match, or the server says it hasn't changed since it was
+// cached. Returns whether it did. If the server sends the tarball again instead, that response is returned to be
+// read as the download.
+func (p *PreinstallPackage) openCached(tarballUrl string) (bool, *remoteBody, error) {
+	if p.CachePath == "" {
+		return false, nil, nil
+	}
+	entry := cacheEntryPath(p.CachePath, tarballUrl)
+	meta := readMeta(entry)
+	if meta == nil || meta.Url != tarballUrl {
+		return false, nil, nil
+	} else if _, err := os.Stat(entry); err != nil {
+		return false, nil, nil
+	}
+
+	known := meta.Sha256 != "" && slices.ContainsFunc(p.Checksums, func(c checksum) bool {
+		return c.Algo.Name == "sha256" && strings.EqualFold(c.Digest, meta.Sha256)
+	})
+	if !known {
+		if meta.ETag == "" && meta.LastModified == "" {
+			return false, nil, nil
+		}
+		body, err := openRemoteIfChanged(tarballUrl, p.Name, *meta)
+		if err != nil {
+			return false, nil, err
+		} else if body != nil {
+			slog.Info("cached tarball is out of date, downloading it again", "url", tarballUrl)
+			return false, body, nil
+		}
+	}
+
+	f, err := os.Open(entry)
+	if err != nil {
+		return false, nil, nil
+	}
+	slog.Info("using cached tarball", "url", tarballUrl, "path", entry)
+	p.tarballPath = entry
+	p.tarballReader = f
+	p.tarballSize = fileSize(entry)
+	p.cached = true
+	// The cache entry must outlive the install.
+	p.RetainTarball = true
+	return true, nil, nil
+}
+
+// cleanCache deletes the tarballs in the cache at cachePath, except those in keep, along with any partial downloads.
+// Returns how many tarballs were deleted and how much space that freed.
+func cleanCache(cachePath string, keep map[string]bool) (int, uint64, error) {
+	dirs, err := os.ReadDir(cachePath)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	removed := 0
+	var freed uint64
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		dirPath := filepath.Join(cachePath, dir.Name())
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			return removed, freed, err
+		}
+		for _, e := range entries {
+			fp := filepath.Join(dirPath, e.Name())
+			if base, ok := strings.CutSuffix(fp, DOWNLOAD_META_SUFFIX); ok {
+				// Metadata is deleted with what it describes, unless that is gone already.
+				if _, err := os.Stat(base); os.IsNotExist(err) {
+					os.Remove(fp)
+				}
+				continue
+			}
+			if keep[fp] {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			slog.Debug("removing cached tarball", "path", fp)
+			if err := os.Remove(fp); err != nil {
+				return removed, freed, err
+			}
+			os.Remove(fp + DOWNLOAD_META_SUFFIX)
+			removed++
+			freed += uint64(info.Size())
+		}
+		// Only succeeds if nothing is left in it.
+		os.Remove(dirPath)
+	}
+	return removed, freed, nil
+}
+
+func actionCacheClean(ctx context.Context, cmd *cli.Command) error {
+	cachePath := cmd.Root().String("cache-dir")
+	if cachePath == "" {
+		cachePath = config.Cache
+	}
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Tarballs of installed packages are kept to repair them with, and those of retired ones to roll back to them,
+	// unless everything is to go.
+	keep := map[string]bool{}
+	if !cmd.Bool("all") {
+		for _, rec := range append(slices.Clone(pm.db.Packages), pm.db.Retired...) {
+			if rec.Tarball != "" {
+				keep[filepath.Clean(rec.Tarball)] = true
+			}
+		}
+	}
+
+	removed, freed, err := cleanCache(cachePath, keep)
+	if err != nil {
+		slog.Error("failed to clean cache", "path", cachePath)
+		return err
+	}
+	fmt.Println(tr("Removed %d cached files, freeing %s.", removed, formatBytes(freed)))
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// checksumAlgo is a digest algorithm that downloads can be verified with.
+type checksumAlgo struct {
+	Name string
+	// Aliases are other names the algorithm goes by, e.g. in the names of checksum files.
+	Aliases []string
+	// Size is the length of a digest in bytes.
+	Size int
+	New  func() hash.Hash
+	// MultihashCode identifies the algorithm in a multihash. See https://github.com/multiformats/multicodec.
+	MultihashCode uint64
+}
+
+// checksumAlgos are the supported algorithms. Where digests of the same size are ambiguous, the most commonly
+// published algorithm comes first.
+var checksumAlgos = []checksumAlgo{
+	{Name: "sha256", Aliases: []string{"sha-256", "sha2-256"}, Size: sha256.Size, New: sha256.New, MultihashCode: 0x12},
+	{Name: "sha512", Aliases: []string{"sha-512", "sha2-512"}, Size: sha512.Size, New: sha512.New, MultihashCode: 0x13},
+	{Name: "sha384", Aliases: []string{"sha-384", "sha2-384"}, Size: sha512.Size384, New: sha512.New384, MultihashCode: 0x20},
+	{Name: "sha1", Aliases: []string{"sha-1"}, Size: sha1.Size, New: sha1.New, MultihashCode: 0x11},
+	{Name: "blake2b-256", Aliases: []string{"b2-256"}, Size: 32, New: newBlake2b(32), MultihashCode: 0xb220},
+	{Name: "blake2b", Aliases: []string{"blake2b-512", "b2", "blake2"}, Size: 64, New: newBlake2b(64), MultihashCode: 0xb240},
+	{Name: "blake3", Aliases: []string{"b3"}, Size: 32, New: func() hash.Hash { return blake3.New() }, MultihashCode: 0x1e},
+}
+
+func newBlake2b(size int) func() hash.Hash {
+	return func() hash.Hash {
+		// New only fails for invalid sizes or keys.
+		h, _ := blake2b.New(size, nil)
+		return h
+	}
+}
+
+// findChecksumAlgo returns the algorithm called name, or nil if it isn't supported.
+func findChecksumAlgo(name string) *checksumAlgo {
+	name = strings.ToLower(name)
+	for i, a := range checksumAlgos {
+		if a.Name == name {
+			return &checksumAlgos[i]
+		}
+		for _, alias := range a.Aliases {
+			if alias == name {
+				return &checksumAlgos[i]
+			}
+		}
+	}
+	return nil
+}
+
+// checksum is an expected digest of a download.
+type checksum struct {
+	Algo   *checksumAlgo
+	Digest string
+}
+
+func (c checksum) String() string {
+	return c.Algo.Name + ":" + c.Digest
+}
+
+// parseChecksum parses a checksum given as algo:hex, e.g. sha512:9b71..., or multihash:hex. Without an algorithm, it
+// is guessed from the digest; see guessChecksumAlgo.
+func parseChecksum(s string) (checksum, error) {
+	algoName, digest, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		algoName, digest = "", algoName
+	}
+	digest = strings.ToLower(digest)
+	if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+		return checksum{}, withKind(errUsage, fmt.Errorf("checksum %q isn't hex-encoded", s))
+	}
+
+	switch algoName {
+	case "multihash", "mh":
+		return parseMultihash(digest)
+	case "":
+		if c, err := parseMultihash(digest); err == nil {
+			return c, nil
+		}
+		algo := guessChecksumAlgo("", digest)
+		if algo == nil {
+			return checksum{}, withKind(errUsage, fmt.Errorf("can't tell which algorithm checksum %q is; give it as algo:hex, e.g. sha512:%s", s, digest))
+		}
+		return checksum{algo, digest}, nil
+	}
+
+	algo := findChecksumAlgo(algoName)
+	if algo == nil {
+		return checksum{}, withKind(errUsage, fmt.Errorf("unsupported checksum algorithm %q; use one of %s", algoName, strings.Join(checksumAlgoNames(), ", ")))
+	}
+	if len(digest) != algo.Size*2 {
+		return checksum{}, withKind(errUsage, fmt.Errorf("%s digests are %d hex characters long, but %q is %d", algo.Name, algo.Size*2, digest, len(digest)))
+	}
+	return checksum{algo, digest}, nil
+}
+
+// parseMultihash parses a hex-encoded multihash: the algorithm's code and the digest's length as varints, followed
+// by the digest.
+func parseMultihash(digest string) (checksum, error) {
+	b, err := hex.DecodeString(digest)
+	if err != nil {
+		return checksum{}, err
+	}
+	code, n := binary.Uvarint(b)
+	if n <= 0 {
+		return checksum{}, errors.New("invalid multihash")
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 || uint64(len(b[n+m:])) != size {
+		return checksum{}, errors.New("invalid multihash")
+	}
+	for i, a := range checksumAlgos {
+		if a.MultihashCode == code && uint64(a.Size) == size {
+			return checksum{&checksumAlgos[i], hex.EncodeToString(b[n+m:])}, nil
+		}
+	}
+	return checksum{}, withKind(errUsage, fmt.Errorf("unsupported multihash algorithm 0x%x", code))
+}
+
+// guessChecksumAlgo guesses the algorithm of a hex digest, first from the name of the file it was found in, e.g.
+// SHA512SUMS or foo.tar.gz.b3, then from its length.
+func guessChecksumAlgo(filename string, digest string) *checksumAlgo {
+	// Only whole parts of the name count, e.g. SHA512SUMS or foo.b3, so that names which happen to contain b2 or b3
+	// aren't mistaken for them.
+	parts := strings.FieldsFunc(strings.ToLower(filename), func(r rune) bool {
+		return r == '.' || r == '_' || r == ' ' || r == '/' || r == '\\'
+	})
+	for _, part := range parts {
+		part = strings.TrimSuffix(strings.TrimSuffix(part, "s"), "sum")
+		if algo := findChecksumAlgo(part); algo != nil && len(digest) == algo.Size*2 {
+			return algo
+		}
+	}
+
+	for i, a := range checksumAlgos {
+		if len(digest) == a.Size*2 {
+			return &checksumAlgos[i]
+		}
+	}
+	return nil
+}
+
+// checksumAlgoNames returns the names of the supported algorithms.
+func checksumAlgoNames() []string {
+	names := []string{}
+	for _, a := range checksumAlgos {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// checksumVerifier hashes everything written to it with each expected checksum's algorithm, and checks it against
+// the expected signature, if any.
+type checksumVerifier struct {
+	expected  []checksum
+	hashes    []hash.Hash
+	sig       *signatureCheck
+	signature signatureVerifier
+	// err is why the signature can't be checked, reported by Verify.
+	err error
+}
+
+func newChecksumVerifier(expected []checksum, sig *signatureCheck) *checksumVerifier {
+	v := &checksumVerifier{expected: expected, sig: sig}
+	for _, c := range expected {
+		v.hashes = append(v.hashes, c.Algo.New())
+	}
+	if sig != nil {
+		v.signature, v.err = newSignatureVerifier(sig)
+	}
+	return v
+}
+
+func (v *checksumVerifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+	if v.signature != nil {
+		return v.signature.Write(p)
+	}
+	return len(p), nil
+}
+
+// Verify checks the digests of what was written against every expected checksum, and the signature, returning the
+// verified digests keyed by algorithm and the ID of the key that made the signature keyed by its kind.
+func (v *checksumVerifier) Verify() (map[string]string, error) {
+	if v.err != nil {
+		return nil, v.err
+	}
+	verified := map[string]string{}
+	for i, c := range v.expected {
+		got := hex.EncodeToString(v.hashes[i].Sum(nil))
+		if got != c.Digest {
+			v.Close()
+			return nil, withKind(errVerificationFailed, fmt.Errorf("%s checksum mismatch: expected %s, got %s", c.Algo.Name, c.Digest, got))
+		}
+		verified[c.Algo.Name] = got
+	}
+	if v.signature != nil {
+		key, err := v.signature.Verify()
+		if err != nil {
+			return nil, err
+		}
+		verified[v.sig.Kind] = key
+	}
+	return verified, nil
+}
+
+// Close stops checking the signature, if the download is abandoned before it can be verified. Does nothing if v
+// is nil.
+func (v *checksumVerifier) Close() {
+	if v != nil && v.signature != nil {
+		v.signature.Close()
+	}
+}
+
+// verifyReader returns r hashed into a verifier for expected and sig, or r itself and nil if nothing is expected.
+// The verifier must be closed if Verify isn't called.
+func verifyReader(r io.Reader, expected []checksum, sig *signatureCheck) (io.Reader, *checksumVerifier) {
+	if len(expected) == 0 && sig == nil {
+		return r, nil
+	}
+	v := newChecksumVerifier(expected, sig)
+	return io.TeeReader(r, v), v
+}
+
+// checksumFlag is the flag read by checksumsFromCmd.
+func checksumFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "checksum",
+		Usage: "Verify the tarball against `ALGO:HEX`, e.g. sha512:9b71..., or a multihash as multihash:HEX. Repeatable. Supported: " + strings.Join(checksumAlgoNames(), ", ") + ".",
+	}
+}
+
+// sha256Flag is the flag read by checksumsFromCmd for the most common case, a SHA-256 digest without the algorithm.
+func sha256Flag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "sha256",
+		Usage: "Verify the tarball against the SHA-256 digest `HEX`. Shorthand for --checksum sha256:HEX.",
+	}
+}
+
+// checksumsFromCmd reads the expected checksums given with --checksum and --sha256.
+func checksumsFromCmd(cmd *cli.Command) ([]checksum, error) {
+	given := cmd.StringSlice("checksum")
+	if s := cmd.String("sha256"); s != "" {
+		given = append(given, "sha256:"+strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "sha256:"))
+	}
+
+	checksums := []checksum{}
+	for _, s := range given {
+		c, err := parseChecksum(s)
+		if err != nil {
+			return nil, err
+		}
+		checksums = append(checksums, c)
+	}
+	return checksums, nil
+}
+
+// MAX_CHECKSUM_FILE_SIZE is the most that is read of a checksum file published with a release.
+const MAX_CHECKSUM_FILE_SIZE = 1 << 20
+
+// checksumListKeywords are found in the names of release assets that list the checksums of the others, e.g.
+// SHA256SUMS, checksums.txt or tool_1.2.0_checksums.txt.
+var checksumListKeywords = []string{"checksums", "sums", "hashes"}
+
+// signatureExtensions are the extensions of signatures, which sometimes share a name with a checksum file.
+var signatureExtensions = []string{".sig", ".asc", ".minisig", ".pem", ".sigstore", ".bundle"}
+
+// checksumFilesFor returns the assets of a release that may hold the checksum of asset, best first: files for the
+// asset alone, e.g. tool.tar.gz.sha256, then lists of checksums for every asset.
+func checksumFilesFor(release *githubApiReleases, asset *githubApiReleaseAsset) []*githubApiReleaseAsset {
+	own := []*githubApiReleaseAsset{}
+	lists := []*githubApiReleaseAsset{}
+	for _, a := range release.Assets {
+		name := strings.ToLower(a.Name)
+		if a == asset || slices.ContainsFunc(signatureExtensions, func(ext string) bool { return strings.HasSuffix(name, ext) }) {
+			continue
+		}
+		if ext, ok := strings.CutPrefix(a.Name, asset.Name+"."); ok {
+			if findChecksumAlgo(strings.TrimSuffix(strings.ToLower(ext), "sum")) != nil {
+				own = append(own, a)
+			}
+			continue
+		}
+		if slices.ContainsFunc(checksumListKeywords, func(kw string) bool { return strings.Contains(name, kw) }) {
+			lists = append(lists, a)
+		}
+	}
+	return append(own, lists...)
+}
+
+// parseChecksumFile finds the checksum of the file called name in a checksum file. Both the format of sha256sum and
+// similar tools, "HEX  name", and the BSD format, "SHA256 (name) = HEX", are understood, as are files that hold
+// nothing but a digest. The algorithm is taken from the line if it says, and otherwise guessed from filename.
+func parseChecksumFile(filename string, data string, name string) (checksum, bool) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// BSD format.
+		if algoName, rest, ok := strings.Cut(line, " ("); ok {
+			if file, digest, ok := strings.Cut(rest, ") = "); ok && path.Base(file) == name {
+				if algo := findChecksumAlgo(algoName); algo != nil {
+					c, err := parseChecksum(algo.Name + ":" + digest)
+					return c, err == nil
+				}
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		digest := strings.ToLower(fields[0])
+		// The file name may be marked as binary with * or as a path relative to where the file was generated.
+		if len(fields) == 1 && len(lines) != 1 || len(fields) > 1 && path.Base(strings.TrimPrefix(strings.Join(fields[1:], " "), "*")) != name {
+			continue
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			continue
+		}
+		if algo := guessChecksumAlgo(filename, digest); algo != nil {
+			return checksum{algo, digest}, true
+		}
+	}
+	return checksum{}, false
+}
+
+// publishedChecksum is the checksum of an asset found in a checksum file published with its release.
+type publishedChecksum struct {
+	Checksum checksum
+	// File is the name of the checksum file, and Data its contents.
+	File string
+	Data string
+}
+
+// fetchPublishedChecksum downloads the checksum files published alongside asset and returns the checksum they
+// give for it. Returns nil if the release publishes none for it.
+func fetchPublishedChecksum(release *githubApiReleases, asset *githubApiReleaseAsset) *publishedChecksum {
+	for _, file := range checksumFilesFor(release, asset) {
+		data, err := fetchSmallAsset(file)
+		if err != nil {
+			slog.Warn("failed to download checksum file, continuing", "file", file.Name, "err", err)
+			continue
+		}
+		if c, ok := parseChecksumFile(file.Name, data, asset.Name); ok {
+			return &publishedChecksum{Checksum: c, File: file.Name, Data: data}
+		}
+		slog.Debug("checksum file doesn't list the asset", "file", file.Name, "asset", asset.Name)
+	}
+	return nil
+}
+
+// fetchSmallAsset downloads a release asset that is small enough to be held in memory, such as a checksum file.
+func fetchSmallAsset(asset *githubApiReleaseAsset) (string, error) {
+	return fetchSmallUrl(asset.downloadUrl())
+}
+
+// fetchSmallUrl downloads a file that is small enough to be held in memory, such as a checksum file.
+func fetchSmallUrl(fileUrl string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	authorizeRequest(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", withKind(errNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", withKind(errNetwork, fmt.Errorf("remote server returned status %d for %s", resp.StatusCode, fileUrl))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MAX_CHECKSUM_FILE_SIZE))
+	return string(data), err
+}
+
+// checkChecksumRequired fails if ropts.RequireChecksum is set but there are no checksums to verify the tarball from
+// url against.
+func checkChecksumRequired(ropts resolveOpts, checksums []checksum, url string) error {
+	if ropts.RequireChecksum && len(checksums) == 0 {
+		return withKind(errVerificationFailed, fmt.Errorf("no checksum was given or published for %s, and --require-checksum is set", url))
+	}
+	return nil
+}
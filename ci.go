@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ciMode is whether infpm is running unattended, set with --ci. In CI mode, infpm never prompts, failing instead
+// wherever it would have asked a question, logs only to stderr and prints a single line per package to stdout with
+// the result. See ciResult.
+var ciMode bool
+
+// nonInteractive is whether infpm must never prompt, set with --non-interactive or non_interactive in the config, or
+// implied by --ci. Commands with -y can also be made non-interactive individually.
+var nonInteractive bool
+
+// Results reported by ciResult.
+const (
+	CI_INSTALLED   = "installed"
+	CI_UNINSTALLED = "uninstalled"
+	CI_SKIPPED     = "skipped"
+	CI_FAILED      = "failed"
+)
+
+// ciResult prints the result of operating on a package in CI mode as a single tab-separated line:
+//
+//	<result>	<name>	<version>	<detail>
+//
+// where detail is the store path for installed packages or the error for failures. Fields are never empty; "-" is
+// printed instead. Does nothing outside CI mode.
+func ciResult(result string, name string, version string, detail string) {
+	if !ciMode {
+		return
+	}
+	fields := []string{result, name, version, detail}
+	for i, f := range fields {
+		// Keep each result to one line and its fields unambiguous.
+		f = strings.Join(strings.Fields(f), " ")
+		if f == "" {
+			f = "-"
+		}
+		fields[i] = f
+	}
+	fmt.Println(strings.Join(fields, "\t"))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// The completion scripts ask infpm itself for candidates by running the command line typed so far with
+// --generate-shell-completion appended, so they never go out of date as commands and flags change.
+
+const bashCompletion = `# bash completion for infpm. Load it with: source <(infpm completion bash)
+_infpm_complete() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local IFS=$'\n'
+	COMPREPLY=($(compgen -W "$("${COMP_WORDS[@]:0:COMP_CWORD}" ${cur:+"$cur"} --generate-shell-completion 2>/dev/null)" -- "$cur"))
+}
+complete -o bashdefault -o default -F _infpm_complete infpm
+`
+
+const zshCompletion = `#compdef infpm
+# zsh completion for infpm. Load it with: source <(infpm completion zsh)
+_infpm() {
+	local -a opts
+	local current=${words[-1]}
+	if [[ "$current" == "-"* ]]; then
+		opts=("${(@f)$(${words[@]:0:#words[@]-1} ${current} --generate-shell-completion 2>/dev/null)}")
+	else
+		opts=("${(@f)$(${words[@]:0:#words[@]-1} --generate-shell-completion 2>/dev/null)}")
+	fi
+	if [[ "${opts[1]}" != "" ]]; then
+		_describe 'values' opts
+	else
+		_files
+	fi
+}
+compdef _infpm infpm
+`
+
+// fishPackageCompletion completes installed package names for the commands that take them, on top of the static
+// completions generated from the command definitions.
+const fishPackageCompletion = `complete -c infpm -n '__fish_seen_subcommand_from %s' -f -a '(infpm (commandline -opc)[2..-1] --generate-shell-completion 2>/dev/null)'
+`
+
+// packageNameCommands are the commands whose arguments are the names of installed packages.
+var packageNameCommands = []string{"uninstall", "remove", "rm", "info", "show", "repair", "verify", "scripts"}
+
+// typedWord returns the word being completed: the last one before --generate-shell-completion. It is read from
+// os.Args, since a partly typed flag fails to parse and never makes it into the command's arguments.
+func typedWord() string {
+	if len(os.Args) < 3 {
+		return ""
+	}
+	return os.Args[len(os.Args)-2]
+}
+
+// completeFlags prints the flags of cmd, and the global flags, that start with the flag being typed.
+func completeFlags(cmd *cli.Command, typed string) {
+	flags := cmd.Flags
+	if cmd != cmd.Root() {
+		flags = append(slices.Clone(flags), cmd.Root().Flags...)
+	}
+	for _, flag := range flags {
+		for _, name := range flag.Names() {
+			dashes := "--"
+			if len(name) == 1 {
+				dashes = "-"
+			}
+			if strings.HasPrefix(dashes+name, typed) {
+				fmt.Fprintln(cmd.Root().Writer, dashes+name)
+			}
+		}
+	}
+}
+
+// completeCommandLine completes flags if one is being typed, and otherwise the subcommands of cmd. It is used by
+// every command without completions of its own.
+func completeCommandLine(ctx context.Context, cmd *cli.Command) {
+	if typed := typedWord(); strings.HasPrefix(typed, "-") {
+		completeFlags(cmd, typed)
+		return
+	}
+	for _, sub := range cmd.Commands {
+		if !sub.Hidden {
+			fmt.Fprintln(cmd.Root().Writer, sub.Name)
+		}
+	}
+}
+
+// setShellComplete makes completeCommandLine complete cmd and its subcommands, unless they complete themselves.
+func setShellComplete(cmd *cli.Command) {
+	if cmd.ShellComplete == nil {
+		cmd.ShellComplete = completeCommandLine
+	}
+	for _, sub := range cmd.Commands {
+		setShellComplete(sub)
+	}
+}
+
+// completePackageNames completes the names of installed packages, or flags if one is being typed. It runs without
+// the root command's Before, so it loads the config and opens the database itself, without creating anything.
+func completePackageNames(ctx context.Context, cmd *cli.Command) {
+	if typed := typedWord(); strings.HasPrefix(typed, "-") {
+		completeFlags(cmd, typed)
+		return
+	}
+	args := cmd.Args().Slice()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return
+	}
+	config = cfg
+	opts := packageManagerOptsFromCmd(cmd)
+	if err := opts.expandPaths(); err != nil {
+		return
+	}
+	db, err := openDatabase(opts.StorePath, opts.statePath(), nil)
+	if err != nil {
+		return
+	}
+	for _, name := range db.Names() {
+		if !slices.Contains(args, name) {
+			fmt.Fprintln(cmd.Root().Writer, name)
+		}
+	}
+}
+
+func actionCompletion(ctx context.Context, cmd *cli.Command) error {
+	switch shell := cmd.Args().Get(0); shell {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		script, err := cmd.Root().ToFishCompletion()
+		if err != nil {
+			return err
+		}
+		fmt.Print(script)
+		fmt.Printf(fishPackageCompletion, strings.Join(packageNameCommands, " "))
+	case "":
+		return withKind(errUsage, errors.New(tr("A shell is required: bash, zsh or fish.")))
+	default:
+		return withKind(errUsage, fmt.Errorf("completion isn't available for %s; use bash, zsh or fish", shell))
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CONFIG_FILENAME is the name of the config file within the infpm config directory.
+const CONFIG_FILENAME = "config.toml"
+
+// Build preferences, used to favour some release assets over others.
+const (
+	PREFER_STATIC  = "static"
+	PREFER_DYNAMIC = "dynamic"
+)
+
+// Release channels, which say whether the latest release of a package may be a prerelease.
+const (
+	CHANNEL_STABLE     = "stable"
+	CHANNEL_PRERELEASE = "prerelease"
+)
+
+// Config is the user's configuration, read from config.toml in the infpm config directory.
+type Config struct {
+	// Store is where packages are installed. Overridden by --store and INFPM_STORE.
+	Store string `toml:"store"`
+	// Root is the symlink root packages are linked into. Overridden by --root and INFPM_ROOT.
+	Root string `toml:"root"`
+	// Cache is where downloaded tarballs are kept. Overridden by --cache-dir and INFPM_CACHE.
+	Cache string `toml:"cache"`
+	// State is where the package database of the store is kept. A store selected with --store or INFPM_STORE keeps
+	// its database within itself instead.
+	State string `toml:"state"`
+	// NoCache is whether downloaded tarballs are discarded rather than kept in the cache. Overridden by --no-cache.
+	NoCache bool `toml:"no_cache"`
+	// NonInteractive is whether infpm never prompts, as if -y was given to every command.
+	NonInteractive bool `toml:"non_interactive"`
+	// LogLevel is the least severe level that is logged: debug, info, warn or error. Overridden by --log-level.
+	LogLevel string `toml:"log_level"`
+	// Prefer is the build preference for all packages: "static", "dynamic" or "" for no preference.
+	Prefer string `toml:"prefer"`
+	// Channel is the release channel for all packages: "stable", the default, or "prerelease" to install and upgrade
+	// to prereleases too.
+	Channel string `toml:"channel"`
+	// TempDir is where downloads are written while they are in progress, instead of the system temporary directory.
+	// Overridden by --tmpdir.
+	TempDir string `toml:"tmpdir"`
+	// Locale is the language messages are shown in, e.g. de. Defaults to the locale set in the environment.
+	Locale string `toml:"locale"`
+	// Keyring is a file of OpenPGP public keys that downloads must be signed by. If set, downloads without a valid
+	// detached signature are refused. Overridden by --keyring.
+	Keyring string `toml:"keyring"`
+	// MinisignKey is a minisign or signify public key, or a file containing one, that downloads must be signed by.
+	// Overridden by --minisign-key.
+	MinisignKey string `toml:"minisign_key"`
+	// ExternalTar is whether archives are extracted with the system tar rather than the built-in extractor.
+	ExternalTar bool `toml:"external_tar"`
+	// NoDedupe is whether files identical to ones of other packages in the store are kept as copies rather than
+	// replaced with hard links to them. See PackageManager.dedupeFiles.
+	NoDedupe bool `toml:"no_dedupe"`
+	// Retry is how requests that fail in a way that may be temporary, like server errors and timeouts, are retried:
+	//
+	//	[retry]
+	//	attempts = 4        # how many times a request is made in total; 1 disables retries
+	//	backoff = "1s"      # how long to wait before the first retry, doubled for each one after
+	//	max_backoff = "30s" # the longest to wait between attempts
+	//	jitter = 0.5        # the fraction of each wait that is randomised
+	//
+	// Attempts is overridden by --retries.
+	Retry RetryConfig `toml:"retry"`
+	// HTTP holds settings for the connections infpm makes, for networks that need them:
+	//
+	//	[http]
+	//	timeout = "30s"                      # how long to wait to connect and for a response
+	//	proxy = "socks5://proxy.corp:1080"   # instead of HTTP_PROXY and HTTPS_PROXY
+	//	ca_bundle = "~/corp-ca.pem"          # trusted in addition to the system's certificates
+	//	insecure = false                     # don't verify certificates at all
+	//
+	// Each is overridden by the flag of the same name.
+	HTTP HttpConfig `toml:"http"`
+	// Headers are sent with every request to a host, keyed by host, e.g. to authenticate with a private artifact
+	// server:
+	//
+	//	[headers."artifacts.example.com"]
+	//	Authorization = "Bearer ..."
+	//
+	// Credentials in ~/.netrc are used for hosts without an Authorization header.
+	Headers map[string]map[string]string `toml:"headers"`
+	// GiteaHosts are hosts running Gitea or Forgejo, in addition to codeberg.org and gitea.com, whose repositories'
+	// releases are installed like GitHub's, e.g. gitea_hosts = ["git.example.com"].
+	GiteaHosts []string `toml:"gitea_hosts"`
+	// Sources are packages from vendors that publish tarballs at predictable URLs rather than as GitHub releases,
+	// keyed by the name they are installed with, e.g. infpm install tool. See SourceTemplate.
+	Sources map[string]SourceTemplate `toml:"sources"`
+	// Links maps content types to the directories they are linked into instead of the symlink root, e.g.
+	//
+	//	[links]
+	//	bin = "~/.local/bin"
+	//	man = "~/.local/share/man"
+	//	zsh-completions = "~/.zsh/completions"
+	//
+	// See linkContentTypes for the types.
+	Links map[string]string `toml:"links"`
+	// Packages holds settings for individual packages, keyed by name.
+	Packages map[string]PackageConfig `toml:"packages"`
+}
+
+// PackageConfig holds settings for a single package, overriding the global ones.
+type PackageConfig struct {
+	Prefer string `toml:"prefer"`
+	// Channel is the release channel the package is installed and upgraded from. See Config.Channel.
+	Channel string `toml:"channel"`
+	// AutoUpgrade is whether watch upgrades the package when it sees a new release.
+	AutoUpgrade bool `toml:"auto_upgrade"`
+	// Keyring is the file of OpenPGP public keys the package's releases are signed with.
+	Keyring string `toml:"keyring"`
+	// MinisignKey is the minisign or signify public key the package's releases are signed with.
+	MinisignKey string `toml:"minisign_key"`
+}
+
+// RetryConfig holds the retry settings. Anything left unset keeps its default.
+type RetryConfig struct {
+	Attempts   int      `toml:"attempts"`
+	Backoff    string   `toml:"backoff"`
+	MaxBackoff string   `toml:"max_backoff"`
+	Jitter     *float64 `toml:"jitter"`
+}
+
+// policy returns the retry policy with these settings applied to the defaults.
+func (c RetryConfig) policy() (retryPolicy, error) {
+	p := retry
+	if c.Attempts < 0 {
+		return p, withKind(errUsage, fmt.Errorf("retry.attempts must be at least 1, not %d", c.Attempts))
+	} else if c.Attempts > 0 {
+		p.Attempts = c.Attempts
+	}
+	for _, d := range []struct {
+		val string
+		dst *time.Duration
+	}{{c.Backoff, &p.Backoff}, {c.MaxBackoff, &p.MaxBackoff}} {
+		if d.val == "" {
+			continue
+		}
+		var err error
+		if *d.dst, err = time.ParseDuration(d.val); err != nil || *d.dst < 0 {
+			return p, withKind(errUsage, fmt.Errorf("retry: invalid duration %q; use e.g. 500ms or 2s", d.val))
+		}
+	}
+	if c.Jitter != nil {
+		if *c.Jitter < 0 || *c.Jitter > 1 {
+			return p, withKind(errUsage, fmt.Errorf("retry.jitter must be between 0 and 1, not %g", *c.Jitter))
+		}
+		p.Jitter = *c.Jitter
+	}
+	return p, nil
+}
+
+// HttpConfig holds the http settings. See configureHttp.
+type HttpConfig struct {
+	Timeout  string `toml:"timeout"`
+	Proxy    string `toml:"proxy"`
+	CaBundle string `toml:"ca_bundle"`
+	Insecure bool   `toml:"insecure"`
+}
+
+// config is the loaded configuration. It is empty until loadConfig is called.
+var config = &Config{}
+
+// configFilePath returns the location of config.toml.
+func configFilePath() (string, error) {
+	dir, err := infpmConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, CONFIG_FILENAME), nil
+}
+
+// loadConfig reads config.toml, returning the defaults if it doesn't exist.
+func loadConfig() (*Config, error) {
+	cfg := &Config{}
+	fp, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := toml.DecodeFile(fp, cfg); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("failed to read config file", "path", fp)
+		return nil, err
+	}
+
+	if err := validatePrefer(cfg.Prefer); err != nil {
+		return nil, err
+	}
+	if err := validateChannel(cfg.Channel); err != nil {
+		return nil, err
+	}
+	if cfg.LogLevel != "" {
+		if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range []*string{&cfg.Store, &cfg.Root, &cfg.Cache, &cfg.State, &cfg.TempDir, &cfg.HTTP.CaBundle} {
+		if *path, err = expandHome(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.setDefaultPaths(); err != nil {
+		return nil, err
+	}
+	for ct, dir := range cfg.Links {
+		if !slices.ContainsFunc(linkContentTypes, func(t linkContentType) bool { return t.Type == ct }) {
+			return nil, withKind(errUsage, fmt.Errorf("links: unknown content type %q", ct))
+		}
+		if cfg.Links[ct], err = expandHome(dir); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := cfg.Retry.policy(); err != nil {
+		return nil, err
+	}
+	for name, t := range cfg.Sources {
+		if err := t.validate(name); err != nil {
+			return nil, err
+		}
+	}
+	for name, pkgCfg := range cfg.Packages {
+		if err := validatePrefer(pkgCfg.Prefer); err != nil {
+			return nil, fmt.Errorf("packages.%s: %w", name, err)
+		}
+		if err := validateChannel(pkgCfg.Channel); err != nil {
+			return nil, fmt.Errorf("packages.%s: %w", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// setConfigValue sets a top-level string setting in config.toml, creating the file if it doesn't exist. The rest of
+// the file, including comments, is left as it is.
+func setConfigValue(key string, value string) error {
+	fp, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fp)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	line := key + " = " + strconv.Quote(value)
+	lines := strings.Split(string(data), "\n")
+	replaced := false
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		// Top-level settings must come before the first table.
+		if strings.HasPrefix(trimmed, "[") {
+			break
+		}
+		if k, _, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(k) == key {
+			lines[i] = line
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		lines = append([]string{line}, lines...)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(fp, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		slog.Error("failed to write config file", "path", fp)
+		return err
+	}
+	return nil
+}
+
+// setDefaultPaths fills in the paths that aren't configured, following the XDG base directory specification: the
+// store in $XDG_DATA_HOME/infpm/store, the database in $XDG_STATE_HOME/infpm and the cache in the user cache
+// directory. Packages are linked into ~/.local, so that executables end up in ~/.local/bin.
+func (c *Config) setDefaultPaths() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		slog.Error("failed to find the home directory")
+		return err
+	}
+	if c.Store == "" {
+		c.Store = filepath.Join(xdgDir("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), "infpm", "store")
+	}
+	if c.State == "" {
+		c.State = filepath.Join(xdgDir("XDG_STATE_HOME", filepath.Join(home, ".local", "state")), "infpm")
+	}
+	if c.Root == "" {
+		c.Root = filepath.Join(home, ".local")
+	}
+	if c.Cache == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			dir = filepath.Join(home, ".cache")
+		}
+		c.Cache = filepath.Join(dir, "infpm")
+	}
+	return nil
+}
+
+// xdgDir returns the directory in the XDG environment variable env, or fallback if it isn't set. Relative paths are
+// ignored, as the specification requires.
+func xdgDir(env string, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return fallback
+}
+
+// CachePath returns where downloaded tarballs are kept, or "" if they aren't.
+func (c *Config) CachePath() string {
+	if c.NoCache {
+		return ""
+	}
+	return c.Cache
+}
+
+// parseLogLevel parses a log level given in the config or with --log-level.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, withKind(errUsage, fmt.Errorf("unknown log level %q; use debug, info, warn or error", s))
+	}
+	return level, nil
+}
+
+// PreferFor returns the build preference for the named package.
+func (c *Config) PreferFor(name string) string {
+	if pkgCfg, ok := c.Packages[name]; ok && pkgCfg.Prefer != "" {
+		return pkgCfg.Prefer
+	}
+	return c.Prefer
+}
+
+// PrereleaseFor returns whether the named package is on the prerelease channel.
+func (c *Config) PrereleaseFor(name string) bool {
+	if pkgCfg, ok := c.Packages[name]; ok && pkgCfg.Channel != "" {
+		return pkgCfg.Channel == CHANNEL_PRERELEASE
+	}
+	return c.Channel == CHANNEL_PRERELEASE
+}
+
+// KeyringFor returns the keyring to verify the package called name with.
+func (c *Config) KeyringFor(name string) string {
+	if pkgCfg, ok := c.Packages[name]; ok && pkgCfg.Keyring != "" {
+		return pkgCfg.Keyring
+	}
+	return c.Keyring
+}
+
+// MinisignKeyFor returns the minisign key to verify the package called name with.
+func (c *Config) MinisignKeyFor(name string) string {
+	if pkgCfg, ok := c.Packages[name]; ok && pkgCfg.MinisignKey != "" {
+		return pkgCfg.MinisignKey
+	}
+	return c.MinisignKey
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok || (rest != "" && rest[0] != '/' && rest[0] != filepath.Separator) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// tempDir returns the directory temporary files should be created in, creating it if it was configured but doesn't
+// exist yet. The system's temporary directory is often a small tmpfs, so it can be moved elsewhere with --tmpdir.
+func tempDir() (string, error) {
+	if config.TempDir == "" {
+		return os.TempDir(), nil
+	}
+	if err := os.MkdirAll(config.TempDir, 0755); err != nil {
+		slog.Error("failed to create temporary directory", "path", config.TempDir)
+		return "", err
+	}
+	return config.TempDir, nil
+}
+
+func validatePrefer(prefer string) error {
+	if prefer != "" && prefer != PREFER_STATIC && prefer != PREFER_DYNAMIC {
+		return withKind(errUsage, fmt.Errorf("prefer must be %q or %q, got %q", PREFER_STATIC, PREFER_DYNAMIC, prefer))
+	}
+	return nil
+}
+
+func validateChannel(channel string) error {
+	if channel != "" && channel != CHANNEL_STABLE && channel != CHANNEL_PRERELEASE {
+		return withKind(errUsage, fmt.Errorf("channel must be %q or %q, got %q", CHANNEL_STABLE, CHANNEL_PRERELEASE, channel))
+	}
+	return nil
+}
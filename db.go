@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// DB_FILENAME is the name of the package database, stored in the state directory or at the root of the store. See
+// PackageManagerOpts.StatePath.
+const DB_FILENAME = "db.json"
+
+// STORE_LAYOUT_VERSION is the current layout of the store. Bump it, and add a step to migrateStore, whenever the way
+// packages are laid out in the store changes.
+//
+//	0: packages at name/version/id, with no database
+//	1: a database recording each package's manifest
+//	2: packages separated by platform, at os-arch/name/version/id
+const STORE_LAYOUT_VERSION = 2
+
+// DB_SCHEMA_VERSION is the current format of the database itself. Bump it whenever a change to the records would
+// be lost or misread by an older infpm, which refuses to open databases with a newer schema.
+//
+//	0: before schemas were versioned
+//	1: records with manifests, links and tags
+//	2: pinned packages
+//	3: generations and retired records
+//	4: the linked version of each package in generations
+const DB_SCHEMA_VERSION = 4
+
+// PackageRecord is the persisted metadata of an installed package.
+type PackageRecord struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Id      string `json:"id"`
+	// Path is the location of the package relative to the store. See PreinstallPackage.Path.
+	Path string `json:"path"`
+	// Source is the URL or file the package was installed from.
+	Source string `json:"source"`
+	// Url is the tarball the package was extracted from. This differs from Source if it was resolved, e.g. from GitHub.
+	Url string `json:"url"`
+	// Platform is the OS and architecture the package was installed for.
+	Platform Platform `json:"platform"`
+	// Tarball is the cached copy of the tarball, if it was cached.
+	Tarball     string    `json:"tarball,omitempty"`
+	InstalledAt time.Time `json:"installedAt"`
+	// LastUsedAt is when one of the package's executables was last run, as far as infpm could tell. See
+	// PackageManager.sampleLastUsed.
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	// Files is the manifest of every file extracted into the store, recorded at install time.
+	Files []FileEntry `json:"files"`
+	// Links are the locations the package was linked to. They are recorded because the link roots can be
+	// reconfigured after the package is installed.
+	Links []string `json:"links,omitempty"`
+	// Checksums are the digests of the tarball that were verified at install time, keyed by algorithm.
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// Sha256 is the hex SHA-256 digest of the tarball the package was extracted from. See Lockfile.
+	Sha256 string `json:"sha256,omitempty"`
+	// Layout is the link layout the package was installed with, or nil if it was the default.
+	Layout *linkLayout `json:"layout,omitempty"`
+	// StripComponents and PostExtract are how the package was extracted, so that it can be extracted the same way
+	// again. See PreinstallPackageOpts.
+	StripComponents int      `json:"stripComponents,omitempty"`
+	PostExtract     []string `json:"postExtract,omitempty"`
+}
+
+// linkLayout returns the link layout the package was installed with.
+func (rec *PackageRecord) linkLayout() linkLayout {
+	if rec.Layout == nil {
+		return linkLayout{}
+	}
+	return *rec.Layout
+}
+
+// String identifies the record for humans, e.g. "ripgrep 14.1.0", including the platform if it isn't this machine's.
+func (rec *PackageRecord) String() string {
+	if !rec.Platform.IsHost() {
+		return rec.Name + " " + rec.Version + " (" + rec.Platform.String() + ")"
+	}
+	return rec.Name + " " + rec.Version
+}
+
+// Database is the set of installed packages, persisted as JSON in the store.
+type Database struct {
+	// Layout is the layout version of the store this database belongs to. See STORE_LAYOUT_VERSION.
+	Layout int `json:"layout"`
+	// Schema is the format of the database. See DB_SCHEMA_VERSION.
+	Schema int `json:"schema"`
+	// Revision is incremented by every save, so that a save can tell if another process saved in the meantime.
+	Revision int64            `json:"revision"`
+	Packages []*PackageRecord `json:"packages"`
+	// Tags maps package names to the tags the user has given them. Tags belong to the name rather than a record,
+	// so they carry over to new versions.
+	Tags map[string][]string `json:"tags,omitempty"`
+	// Pinned are the names of the packages held at their installed versions, which upgrade and sync leave alone.
+	// Like tags, pins belong to the name.
+	Pinned []string `json:"pinned,omitempty"`
+	// Generations are the sets of packages that were installed after each command that changed them. See Generation.
+	Generations []*Generation `json:"generations,omitempty"`
+	// Retired are the records of packages that have been removed or replaced, kept so that rollback can bring them
+	// back. Their directories may still be in the store, unlinked.
+	Retired []*PackageRecord `json:"retired,omitempty"`
+
+	path string
+	// storePath is the store the database belongs to.
+	storePath string
+	// lock is the store lock the database was read under, which must still be held to save it. See Save.
+	lock *storeLock
+	// generation is the generation made by this command, which later saves update. See recordGeneration.
+	generation *Generation
+	// readRevision is the Revision the database had when it was read.
+	readRevision int64
+}
+
+// openDatabase reads the package database of the store from statePath, returning an empty database if none exists
+// yet. The database's Layout is set to the layout the store actually uses, which may be older than STORE_LAYOUT_VERSION.
+// lock is the store lock held while reading it, which saving it requires; a database read without one, e.g. for
+// shell completion, can't be saved.
+func openDatabase(storePath string, statePath string, lock *storeLock) (*Database, error) {
+	db := &Database{path: filepath.Join(statePath, DB_FILENAME), storePath: storePath, lock: lock}
+
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// A store with packages but no database predates the database. The store lock and staging directory
+			// are made before the database, so they don't count.
+			entries, _ := os.ReadDir(storePath)
+			entries = slices.DeleteFunc(entries, func(e os.DirEntry) bool {
+				return e.Name() == STORE_LOCK_FILENAME || e.Name() == STAGING_DIR || e.Name() == HISTORY_FILENAME
+			})
+			if len(entries) == 0 {
+				db.Layout = STORE_LAYOUT_VERSION
+			}
+			return db, nil
+		}
+		slog.Error("failed to read package database", "path", db.path)
+		return nil, err
+	}
+
+	// Databases from before layouts were versioned don't record one.
+	db.Layout = 1
+	if err := json.Unmarshal(data, db); err != nil {
+		slog.Error("failed to decode package database", "path", db.path)
+		return nil, err
+	}
+	if db.Schema > DB_SCHEMA_VERSION {
+		return nil, withKind(errConflict, fmt.Errorf("the package database at %s was written by a newer version of infpm (schema %d, this version understands %d). Upgrade infpm to use this store.", db.path, db.Schema, DB_SCHEMA_VERSION))
+	}
+	db.readRevision = db.Revision
+
+	// Records from before platforms were recorded were always installed for this machine.
+	for _, rec := range db.Packages {
+		if rec.Platform == (Platform{}) {
+			rec.Platform = hostPlatform()
+		}
+	}
+	return db, nil
+}
+
+// Save writes the database back to the state directory. The file is replaced atomically so a crash can't leave it truncated.
+// The store lock the database was read under must still be held, so that no other process can save in between the
+// revision check and the rename. If another process has saved the database since it was read anyway, e.g. one that
+// ignored the lock, Save fails with errConflict instead of overwriting its changes.
+func (db *Database) Save() error {
+	if !db.lock.held() {
+		return fmt.Errorf("the package database at %s can't be saved without holding the store lock", db.path)
+	}
+	if rev, err := readRevision(db.path); err != nil {
+		return err
+	} else if rev != db.readRevision {
+		return withKind(errConflict, fmt.Errorf("the package database at %s was changed by another infpm process (revision %d, expected %d). Run the command again.", db.path, rev, db.readRevision))
+	}
+
+	db.recordGeneration()
+	db.Schema = DB_SCHEMA_VERSION
+	db.Revision++
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		db.Revision--
+		return err
+	}
+
+	// Each save writes its own temporary file, so concurrent saves can't interleave their writes.
+	f, err := os.CreateTemp(filepath.Dir(db.path), DB_FILENAME+".*.tmp")
+	if err != nil {
+		db.Revision--
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), db.path)
+	}
+	if err != nil {
+		slog.Error("failed to write package database", "path", db.path)
+		db.Revision--
+		return err
+	}
+	db.readRevision = db.Revision
+	return nil
+}
+
+// readRevision returns the revision of the database at path, or 0 if it doesn't exist.
+func readRevision(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var header struct {
+		Revision int64 `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		slog.Error("failed to decode package database", "path", path)
+		return 0, err
+	}
+	return header.Revision, nil
+}
+
+// Add records a newly installed package.
+func (db *Database) Add(rec *PackageRecord) {
+	db.Packages = append(db.Packages, rec)
+}
+
+// Find returns every installed record of the package with the given name, e.g. multiple versions.
+func (db *Database) Find(name string) []*PackageRecord {
+	var recs []*PackageRecord
+	for _, rec := range db.Packages {
+		if rec.Name == name {
+			recs = append(recs, rec)
+		}
+	}
+	return recs
+}
+
+// Remove deletes a package's record from the database. If a generation has it, it is kept in Retired.
+func (db *Database) Remove(rec *PackageRecord) {
+	for i, r := range db.Packages {
+		if r == rec {
+			db.Packages = append(db.Packages[:i], db.Packages[i+1:]...)
+			break
+		}
+	}
+	if db.referenced(rec.Id) {
+		db.Retired = append(db.Retired, rec)
+	}
+	if len(db.Find(rec.Name)) == 0 {
+		delete(db.Tags, rec.Name)
+		db.Unpin(rec.Name)
+	}
+}
+
+// Names returns the name of every installed package, in order of installation and without duplicates.
+func (db *Database) Names() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, rec := range db.Packages {
+		if !seen[rec.Name] {
+			seen[rec.Name] = true
+			names = append(names, rec.Name)
+		}
+	}
+	return names
+}
+
+// HasTag returns whether the named package has been given the tag.
+func (db *Database) HasTag(name string, tag string) bool {
+	return slices.Contains(db.Tags[name], tag)
+}
+
+// AddTag gives the named package a tag, returning false if it already had it.
+func (db *Database) AddTag(name string, tag string) bool {
+	if db.HasTag(name, tag) {
+		return false
+	}
+	if db.Tags == nil {
+		db.Tags = map[string][]string{}
+	}
+	db.Tags[name] = append(db.Tags[name], tag)
+	return true
+}
+
+// RemoveTag takes a tag away from the named package, returning false if it didn't have it.
+func (db *Database) RemoveTag(name string, tag string) bool {
+	i := slices.Index(db.Tags[name], tag)
+	if i < 0 {
+		return false
+	}
+	db.Tags[name] = slices.Delete(db.Tags[name], i, i+1)
+	if len(db.Tags[name]) == 0 {
+		delete(db.Tags, name)
+	}
+	return true
+}
+
+// NamesWithTag returns the name of every installed package with the tag.
+func (db *Database) NamesWithTag(tag string) []string {
+	names := []string{}
+	for _, name := range db.Names() {
+		if db.HasTag(name, tag) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsPinned returns whether the named package is held at its installed version.
+func (db *Database) IsPinned(name string) bool {
+	return slices.Contains(db.Pinned, name)
+}
+
+// Pin holds the named package at its installed version, returning false if it already was.
+func (db *Database) Pin(name string) bool {
+	if db.IsPinned(name) {
+		return false
+	}
+	db.Pinned = append(db.Pinned, name)
+	return true
+}
+
+// Unpin releases the named package, returning false if it wasn't pinned.
+func (db *Database) Unpin(name string) bool {
+	i := slices.Index(db.Pinned, name)
+	if i < 0 {
+		return false
+	}
+	db.Pinned = slices.Delete(db.Pinned, i, i+1)
+	return true
+}
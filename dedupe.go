@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// fileId is the device and inode of a file. See fileIdentity.
+type fileId struct {
+	Dev uint64
+	Ino uint64
+}
+
+// dedupeKey is what two files in the store must share for one to be replaced with a hard link to the other. The
+// mode has to match too, since hard links share it.
+type dedupeKey struct {
+	Sha256 string
+	Size   int64
+	Mode   fs.FileMode
+}
+
+var errNotIdentical = errors.New("the file has changed since it was recorded")
+
+// dedupeFiles replaces each regular file of the package at fullPath that is identical to a file of another package
+// in the store with a hard link to that file, so that e.g. the files an upgrade leaves unchanged are only stored once.
+// files is the package's manifest. Returns how much space this saved. Files that can't be linked, e.g. because the
+// store spans file systems, are left as they are. So are executables: files linked together share their access time,
+// which sampleLastUsed reads to tell when each package was last used.
+func (pm *PackageManager) dedupeFiles(fullPath string, files []FileEntry) uint64 {
+	if config.NoDedupe {
+		return 0
+	}
+
+	existing := map[dedupeKey]string{}
+	for _, rec := range append(slices.Clone(pm.db.Packages), pm.db.Retired...) {
+		recPath := filepath.Join(pm.StorePath, rec.Path)
+		if recPath == fullPath {
+			continue
+		}
+		for _, f := range rec.Files {
+			if dedupable(f) {
+				key := dedupeKey{f.Sha256, f.Size, f.Mode}
+				if _, ok := existing[key]; !ok {
+					existing[key] = filepath.Join(recPath, filepath.FromSlash(f.Path))
+				}
+			}
+		}
+	}
+
+	var saved uint64
+	for _, f := range files {
+		src, ok := existing[dedupeKey{f.Sha256, f.Size, f.Mode}]
+		if !ok || !dedupable(f) {
+			continue
+		}
+		dst := filepath.Join(fullPath, filepath.FromSlash(f.Path))
+		if err := hardlinkIdentical(src, dst, f); err != nil {
+			slog.Debug("not deduplicating file", "path", dst, "with", src, "err", err)
+			continue
+		}
+		saved += uint64(f.Size)
+	}
+	if saved > 0 {
+		slog.Info("deduplicated files shared with other packages", "path", fullPath, "saved", formatBytes(saved))
+	}
+	return saved
+}
+
+// dedupable returns whether a file in a manifest may be deduplicated: it must be a regular file that isn't empty or
+// executable.
+func dedupable(f FileEntry) bool {
+	return f.Mode.IsRegular() && f.Size > 0 && f.Mode&0111 == 0
+}
+
+// hardlinkIdentical replaces dst with a hard link to src, if src is still the file f describes.
+func hardlinkIdentical(src string, dst string, f FileEntry) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode() != f.Mode || info.Size() != f.Size {
+		return errNotIdentical
+	}
+	if dstInfo, err := os.Lstat(dst); err != nil {
+		return err
+	} else if os.SameFile(info, dstInfo) {
+		return nil
+	}
+	// Files in the store shouldn't change, but check the contents anyway rather than trust the manifest.
+	if sum, err := hashFile(src); err != nil {
+		return err
+	} else if sum != f.Sha256 {
+		return errNotIdentical
+	}
+
+	// Link beside dst and rename over it, so dst is never missing.
+	tmp := dst + ".infpm-dedupe"
+	os.Remove(tmp)
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EXTRACT_EXPANSION_FACTOR estimates how much larger a package is once extracted than its tarball. Compressed
+// tarballs of binaries typically expand 2-3x, so this errs on the side of caution.
+const EXTRACT_EXPANSION_FACTOR = 3
+
+// spaceRequirement is an amount of space some step of installation needs in a directory.
+type spaceRequirement struct {
+	Path  string
+	Bytes int64
+	// What describes the step, e.g. "download", for error messages.
+	What string
+}
+
+// checkFreeSpace verifies that every filesystem has enough free space for the requirements on it, failing early with
+// the required and available amounts. Requirements on the same filesystem are added together. Requirements of
+// unknown size (<= 0) and filesystems whose free space can't be determined are skipped.
+func checkFreeSpace(reqs ...spaceRequirement) error {
+	type fsNeed struct {
+		avail uint64
+		need  int64
+		paths []string
+		what  []string
+	}
+	needs := map[string]*fsNeed{}
+	order := []string{}
+
+	for _, req := range reqs {
+		if req.Bytes <= 0 || req.Path == "" {
+			continue
+		}
+
+		avail, fsid, err := diskFree(existingParent(req.Path))
+		if err != nil {
+			if !errors.Is(err, errors.ErrUnsupported) {
+				slog.Debug("failed to check free disk space, continuing", "path", req.Path, "err", err)
+			}
+			continue
+		}
+
+		n, ok := needs[fsid]
+		if !ok {
+			n = &fsNeed{avail: avail}
+			needs[fsid] = n
+			order = append(order, fsid)
+		}
+		n.need += req.Bytes
+		n.paths = append(n.paths, req.Path)
+		n.what = append(n.what, req.What)
+	}
+
+	for _, fsid := range order {
+		n := needs[fsid]
+		if uint64(n.need) > n.avail {
+			return withKind(errInsufficientSpace, fmt.Errorf("not enough free space for %s in %s: need %s, but only %s is available",
+				strings.Join(n.what, " and "), strings.Join(n.paths, ", "), formatBytes(uint64(n.need)), formatBytes(n.avail)))
+		}
+	}
+	return nil
+}
+
+// existingParent returns path if it exists, or else its closest ancestor that does, since directories like the store
+// may not have been created yet when space is checked.
+func existingParent(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// formatBytes formats a number of bytes for humans, e.g. 12.3 MiB.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
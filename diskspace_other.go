@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+func diskFree(path string) (uint64, string, error) {
+	return 0, "", errors.ErrUnsupported
+}
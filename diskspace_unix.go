@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskFree returns the space available to unprivileged users on the filesystem containing path, and an identifier
+// for that filesystem.
+func diskFree(path string) (uint64, string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, "", err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), fmt.Sprint(st.Fsid), nil
+}
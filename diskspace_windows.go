@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modKernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW = modKernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+// diskFree returns the space available to the current user on the volume containing path, and the volume's name.
+func diskFree(path string) (uint64, string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var avail uint64
+	ret, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0)
+	if ret == 0 {
+		return 0, "", err
+	}
+	return avail, filepath.VolumeName(path), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// How a doctor check turned out. Warnings are worth fixing, but don't stop infpm working.
+const (
+	DOCTOR_OK   = "ok"
+	DOCTOR_WARN = "warn"
+	DOCTOR_FAIL = "fail"
+)
+
+// doctorCheck is the result of one of doctor's checks, with how to fix it if it didn't pass.
+type doctorCheck struct {
+	Status string
+	Name   string
+	Detail string
+	Fix    string
+}
+
+// doctorTool is an external program infpm runs for some features.
+type doctorTool struct {
+	Name string
+	// For is what needs it.
+	For string
+	// Goos is the only OS it is used on, or "" for every OS.
+	Goos string
+}
+
+var doctorTools = []doctorTool{
+	{"tar", "external_tar in the config file", ""},
+	{"git", "taps", ""},
+	{"go", "--build-from-source for Go projects", ""},
+	{"cargo", "--build-from-source for Rust projects", ""},
+	{"make", "--build-from-source for projects with a Makefile", ""},
+	{"systemctl", "enabling the systemd user units packages ship", "linux"},
+	{"secret-tool", "keeping tokens in the keyring", "linux"},
+}
+
+// checkWritableDir checks that dir exists and that files can be created in it. infpm makes its directories when it
+// first needs them, so one that doesn't exist yet is only a warning.
+func checkWritableDir(name string, dir string) doctorCheck {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return doctorCheck{DOCTOR_WARN, name, tr("%s doesn't exist yet", dir), tr("Create it with mkdir -p %s, or run infpm setup.", dir)}
+	} else if err != nil {
+		return doctorCheck{DOCTOR_FAIL, name, err.Error(), ""}
+	} else if !info.IsDir() {
+		return doctorCheck{DOCTOR_FAIL, name, tr("%s isn't a directory", dir), tr("Move it out of the way, or choose another directory in the config file.")}
+	}
+
+	f, err := os.CreateTemp(dir, ".infpm-doctor-*")
+	if err != nil {
+		return doctorCheck{DOCTOR_FAIL, name, tr("%s isn't writable: %s", dir, err), tr("Fix its permissions, e.g. with chown -R $USER %s.", dir)}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return doctorCheck{DOCTOR_OK, name, dir, ""}
+}
+
+// checkPath checks that the directory executables are linked into is in PATH.
+func checkPath(binDir string) doctorCheck {
+	name := tr("bin directory in PATH")
+	if inPath(binDir) {
+		return doctorCheck{DOCTOR_OK, name, binDir, ""}
+	}
+	fix := tr("Run infpm setup, or add %s to PATH in your shell's startup file.", binDir)
+	if snippet := pathSnippet(detectShell(), binDir); snippet != "" {
+		fix = tr("Run infpm setup, or add this to your shell's startup file: %s", strings.TrimSpace(snippet))
+	}
+	return doctorCheck{DOCTOR_FAIL, name, tr("%s isn't in PATH, so installed commands can't be run by name", binDir), fix}
+}
+
+// checkLinks checks every package's links, and looks for dangling links into the store. See verifyLinks.
+func checkLinks(pm *PackageManager) doctorCheck {
+	name := tr("links")
+	broken := 0
+	for _, rec := range pm.db.Packages {
+		broken += len(pm.verifyLinks(rec))
+	}
+	dangling, err := pm.findDanglingLinks()
+	if err != nil {
+		return doctorCheck{DOCTOR_FAIL, name, err.Error(), ""}
+	}
+	if broken+len(dangling) == 0 {
+		return doctorCheck{DOCTOR_OK, name, tr("every package's links are in place"), ""}
+	}
+	return doctorCheck{DOCTOR_FAIL, name, tr("%d links are missing or point somewhere else, and %d are dangling", broken, len(dangling)), tr("Run infpm relink, and infpm verify to see which.")}
+}
+
+// checkTools checks that the external programs infpm uses are in PATH. None is needed for everything, so missing
+// ones are only warnings, except tar if external_tar is set.
+func checkTools() []doctorCheck {
+	checks := []doctorCheck{}
+	for _, tool := range doctorTools {
+		if tool.Goos != "" && tool.Goos != runtime.GOOS {
+			continue
+		}
+		name := tr("tool %s", tool.Name)
+		if path, err := exec.LookPath(tool.Name); err == nil {
+			checks = append(checks, doctorCheck{DOCTOR_OK, name, path, ""})
+		} else {
+			status := DOCTOR_WARN
+			if tool.Name == "tar" && config.ExternalTar {
+				status = DOCTOR_FAIL
+			}
+			checks = append(checks, doctorCheck{status, name, tr("not in PATH, so %s won't work", tool.For), tr("Install %s if you need it.", tool.Name)})
+		}
+	}
+	return checks
+}
+
+// checkGithub checks that the GitHub API can be reached, and whether a token for it is configured and accepted.
+func checkGithub() []doctorCheck {
+	tokenName := tr("GitHub token")
+	token, source := lookupToken("github")
+	tokenCheck := doctorCheck{DOCTOR_OK, tokenName, tr("from %s", source), ""}
+	if token == "" {
+		tokenCheck = doctorCheck{DOCTOR_WARN, tokenName, tr("none, so GitHub only allows 60 API requests an hour, and Actions artifacts can't be installed"), tr("Set GITHUB_TOKEN, or run infpm auth login github.")}
+	}
+
+	name := tr("GitHub API")
+	req, err := http.NewRequest(http.MethodGet, "https://api.github.com/rate_limit", nil)
+	if err != nil {
+		return []doctorCheck{{DOCTOR_FAIL, name, err.Error(), ""}, tokenCheck}
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	authorizeRequest(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return []doctorCheck{{DOCTOR_FAIL, name, err.Error(), tr("Check your network connection, and proxy and ca_bundle in the config file if you are behind a proxy.")}, tokenCheck}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		tokenCheck = doctorCheck{DOCTOR_FAIL, tokenName, tr("GitHub rejected the token from %s", source), tr("It may have expired. Create a new one and run infpm auth login github.")}
+		return []doctorCheck{{DOCTOR_OK, name, tr("reachable"), ""}, tokenCheck}
+	case resp.StatusCode != http.StatusOK:
+		return []doctorCheck{{DOCTOR_FAIL, name, tr("responded with %s", resp.Status), ""}, tokenCheck}
+	}
+	remaining := resp.Header.Get("X-RateLimit-Remaining")
+	api := doctorCheck{DOCTOR_OK, name, tr("reachable, %s of %s requests left this hour", remaining, resp.Header.Get("X-RateLimit-Limit")), ""}
+	if remaining == "0" {
+		api.Status = DOCTOR_WARN
+		api.Fix = tr("Wait for the rate limit to reset, or configure a token to get a higher one.")
+	}
+	return []doctorCheck{api, tokenCheck}
+}
+
+func actionDoctor(ctx context.Context, cmd *cli.Command) error {
+	opts := packageManagerOptsFromCmd(cmd)
+	if err := opts.expandPaths(); err != nil {
+		return err
+	}
+
+	// The directories are checked before the package manager is created, since creating it makes them.
+	checks := []doctorCheck{checkWritableDir(tr("store"), opts.StorePath)}
+	if opts.statePath() != opts.StorePath {
+		checks = append(checks, checkWritableDir(tr("state directory"), opts.statePath()))
+	}
+	binDir := opts.binDir()
+	checks = append(checks, checkWritableDir(tr("bin directory"), binDir), checkPath(binDir))
+
+	if pm, err := NewPackageManager(opts); err != nil {
+		checks = append(checks, doctorCheck{DOCTOR_FAIL, tr("database"), err.Error(), tr("If it is corrupt, restore %s from a backup.", filepath.Join(opts.statePath(), DB_FILENAME))})
+	} else {
+		checks = append(checks, doctorCheck{DOCTOR_OK, tr("database"), tr("%d packages installed", len(pm.db.Packages)), ""}, checkLinks(pm))
+	}
+	checks = append(checks, checkTools()...)
+	checks = append(checks, checkGithub()...)
+
+	failed := 0
+	for _, c := range checks {
+		fmt.Printf("[%-4s] %s: %s\n", c.Status, c.Name, c.Detail)
+		if c.Fix != "" {
+			fmt.Println("       " + c.Fix)
+		}
+		if c.Status == DOCTOR_FAIL {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	fmt.Println(tr("Everything looks fine."))
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/urfave/cli/v3"
+)
+
+// downloadTo downloads the tarball at tarballUrl into dir as filename, returning the path it was written to and its
+// sha256 digest. If filename is "", the last element of the URL is used. The file is written to a .part file and only
+// renamed once the download has completed and matched the expected checksums and signature. If the download is cut
+// off, the .part file is kept so that downloading it again resumes where it stopped, provided the remote file hasn't
+// changed in the meantime.
+func downloadTo(tarballUrl string, name string, filename string, dir string, expected []checksum, sig *signatureCheck) (string, string, error) {
+	if filename == "" {
+		u, err := url.Parse(tarballUrl)
+		if err != nil {
+			return "", "", err
+		}
+		filename = path.Base(u.Path)
+	}
+	dest := filepath.Join(dir, filepath.Base(filename))
+	part := filepath.Join(dir, "."+filepath.Base(dest)+".part")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Error("failed to create output directory", "path", dir)
+		return "", "", err
+	}
+
+	offset, meta := readPartial(part, tarballUrl)
+	validator := ""
+	if meta != nil {
+		validator = meta.validator()
+	}
+	body, resumed, err := openRemoteFrom(tarballUrl, name, offset, validator)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+
+	if err := checkFreeSpace(spaceRequirement{Path: dir, Bytes: body.expected, What: "download"}); err != nil {
+		return "", "", err
+	}
+
+	// The part already downloaded is read back as well, as the checksums cover the whole file.
+	var src io.Reader = body
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resumed {
+		prev, err := os.Open(part)
+		if err != nil {
+			return "", "", err
+		}
+		defer prev.Close()
+		slog.Info("resuming interrupted download", "url", tarballUrl, "from", offset)
+		src = io.MultiReader(io.LimitReader(prev, offset), body)
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		offset = 0
+		removePartial(part)
+	}
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	// Record what is being downloaded up front, so that the download can be resumed however it is stopped.
+	if err := keepPartial(part, body.meta); err != nil {
+		slog.Warn("failed to record partial download, it can't be resumed", "path", part, "err", err)
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			removePartial(part)
+		}
+	}()
+
+	h := sha256.New()
+	r, verifier := verifyReader(src, expected, sig)
+	defer verifier.Close()
+	if _, err := io.Copy(io.MultiWriter(&skipWriter{w: f, skip: offset}, h), r); err != nil {
+		// Only a download that was cut off can be resumed; a failure to write it can't.
+		keep = downloadError(body) != nil && body.meta.validator() != ""
+		slog.Error("failed to write download to disk", "path", part)
+		return "", "", withKind(errNetwork, err)
+	}
+	if verifier != nil {
+		if _, err := verifier.Verify(); err != nil {
+			return "", "", err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return "", "", err
+	}
+
+	return dest, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func actionDownload(ctx context.Context, cmd *cli.Command) error {
+	reqPath := cmd.Args().Get(0)
+	if reqPath == "" {
+		return withKind(errUsage, errors.New(tr("A package URL is required. See --help download.")))
+	}
+
+	ropts, err := resolveOptsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	expected, err := checksumsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	src, err := resolveSource(reqPath, ropts)
+	if err != nil {
+		return err
+	}
+	expected = append(expected, src.Checksums...)
+	if err := checkChecksumRequired(ropts, expected, src.Url); err != nil {
+		return withPackage(err, src.Name, src.Url)
+	}
+
+	slog.Info("downloading", "url", src.Url, "to", cmd.String("output"))
+	dest, digest, err := downloadTo(src.Url, src.Name, src.Filename, cmd.String("output"), expected, src.Signature)
+	if err != nil {
+		return withPackage(err, src.Name, src.Url)
+	}
+
+	fmt.Println(digest + "  " + dest)
+	progress.Emit(progressEvent{Event: PROGRESS_DONE, Package: src.Name, Version: src.Version, Url: src.Url, Path: dest})
+	return nil
+}
+
+func actionExtract(ctx context.Context, cmd *cli.Command) error {
+	reqPath := cmd.Args().Get(0)
+	if reqPath == "" {
+		return withKind(errUsage, errors.New(tr("A package URL or filepath (--file) is required. See --help extract.")))
+	}
+	to := cmd.String("to")
+
+	var r io.ReadCloser
+	var size int64 = -1
+	var name string
+	var expected []checksum
+	var sig *signatureCheck
+	if cmd.Bool("file") {
+		f, err := os.Open(reqPath)
+		if err != nil {
+			slog.Error("local file: failed to open", "path", reqPath)
+			return err
+		}
+		r = f
+		size = fileSize(reqPath)
+	} else {
+		ropts, err := resolveOptsFromCmd(cmd)
+		if err != nil {
+			return err
+		}
+		src, err := resolveSource(reqPath, ropts)
+		if err != nil {
+			return err
+		}
+		name = src.Name
+		expected, sig = src.Checksums, src.Signature
+		if err := checkChecksumRequired(ropts, expected, src.Url); err != nil {
+			return withPackage(err, src.Name, src.Url)
+		}
+		if r, size, err = openRemote(src.Url, src.Name); err != nil {
+			return withPackage(err, src.Name, src.Url)
+		}
+		reqPath = src.Url
+	}
+	defer r.Close()
+
+	if err := checkFreeSpace(spaceRequirement{Path: to, Bytes: size * EXTRACT_EXPANSION_FACTOR, What: "extraction"}); err != nil {
+		return withPackage(err, name, reqPath)
+	}
+	_, err := os.Stat(to)
+	created := os.IsNotExist(err)
+	if err := os.MkdirAll(to, 0755); err != nil {
+		slog.Error("failed to create output directory", "path", to)
+		return err
+	}
+
+	// Extract into a hidden directory within the output directory, and only move the files out of it once they have
+	// been verified, like installs do with the staging directory.
+	staging, err := os.MkdirTemp(to, ".infpm-extract-*")
+	if err != nil {
+		slog.Error("failed to create staging directory", "path", to)
+		return err
+	}
+	defer func() {
+		os.RemoveAll(staging)
+		// Don't leave behind an output directory that nothing was extracted into.
+		if created {
+			os.Remove(to)
+		}
+	}()
+
+	slog.Info("extracting archive", "from", reqPath, "to", staging)
+	vr, verifier := verifyReader(r, expected, sig)
+	defer verifier.Close()
+	if err := tarExtract(newProgressReader(vr, PROGRESS_EXTRACT, name, size), staging); err != nil {
+		if dlErr := downloadError(r); dlErr != nil {
+			err = dlErr
+		}
+		return withPackage(err, name, reqPath)
+	}
+	if verifier != nil {
+		_, err := io.Copy(io.Discard, vr)
+		if err == nil {
+			_, err = verifier.Verify()
+		}
+		if err != nil {
+			return withPackage(err, name, reqPath)
+		}
+	}
+
+	slog.Info("moving extracted files into place", "from", staging, "to", to)
+	if err := mergeTree(staging, to); err != nil {
+		slog.Error("failed to move extracted files into place", "path", to)
+		return err
+	}
+
+	progress.Emit(progressEvent{Event: PROGRESS_DONE, Package: name, Url: reqPath, Path: to})
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+// dryRun is what installing a package would do, found by inspecting its archive without installing it.
+type dryRun struct {
+	Name     string
+	Version  string
+	Platform Platform
+	Url      string
+	// FullPath is where the package would be stored.
+	FullPath string
+	Files    []FileEntry
+	Links    []plannedLink
+	// Verified are the digests of the tarball that were verified, keyed by algorithm.
+	Verified map[string]string
+	// PostExtract are the steps that run after extracting. They are run in the temporary directory too, so that the
+	// files and links are as they would be.
+	PostExtract []string
+}
+
+// DryRun extracts the package into a temporary directory, which is removed afterwards, to find the files it contains
+// and the links installing it would create. Nothing is written to the store, the cache or the symlink root, but the
+// package's post-extract steps are run in the temporary directory.
+func (ppkg *PreinstallPackage) DryRun(opts PackageManagerOpts) (*dryRun, error) {
+	dir, err := tempDir()
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(dir, "infpm-dry-run-")
+	if err != nil {
+		slog.Error("failed to create temporary directory for dry run", "dir", dir)
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	reader, verifier := verifyReader(ppkg.tarballReader, ppkg.Checksums, ppkg.Signature)
+	defer verifier.Close()
+	slog.Info("extracting archive to inspect it", "package", ppkg.Name, "path", tmp)
+	if err := tarExtractStrip(newProgressReader(reader, PROGRESS_EXTRACT, ppkg.Name, ppkg.tarballSize), tmp, ppkg.StripComponents); err != nil {
+		if dlErr := downloadError(ppkg.tarballReader); dlErr != nil {
+			return nil, dlErr
+		}
+		return nil, err
+	}
+
+	run := &dryRun{
+		Name:        ppkg.Name,
+		Version:     ppkg.Version,
+		Platform:    ppkg.Platform,
+		Url:         ppkg.Url,
+		FullPath:    filepath.Join(opts.StorePath, ppkg.Path),
+		PostExtract: ppkg.PostExtract,
+	}
+	if verifier != nil {
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return nil, err
+		}
+		if run.Verified, err = verifier.Verify(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := runPostExtract(tmp, ppkg.PostExtract, ppkg.Name, ppkg.Version, ppkg.Platform); err != nil {
+		return nil, err
+	}
+	if run.Files, err = buildManifest(tmp); err != nil {
+		return nil, err
+	}
+	if !ppkg.Platform.IsHost() && !opts.Portable {
+		return run, nil
+	}
+	links, err := opts.planLinks(tmp, ppkg.Layout)
+	if err != nil {
+		return nil, err
+	}
+	// The links were planned in the temporary directory, so point them at the store instead.
+	for _, l := range links {
+		rel, err := filepath.Rel(tmp, l.Src)
+		if err != nil {
+			return nil, err
+		}
+		run.Links = append(run.Links, plannedLink{Src: filepath.Join(run.FullPath, rel), Dst: l.Dst})
+	}
+	return run, nil
+}
+
+// dryRunSource resolves and inspects a package like InstallSource, printing what installing it would do instead.
+// The package manager isn't created, since that creates the store and symlink root.
+func dryRunSource(cmd *cli.Command, reqPath string, fromFile bool, opts PreinstallPackageOpts, ropts resolveOpts) error {
+	pmOpts := packageManagerOptsFromCmd(cmd)
+	if err := pmOpts.expandPaths(); err != nil {
+		return err
+	}
+	ppkg, downloadUrl, err := preparePackage(reqPath, fromFile, opts, ropts)
+	if err != nil {
+		return err
+	}
+	run, err := ppkg.DryRun(pmOpts)
+	ppkg.Cleanup()
+	if err != nil {
+		return withPackage(err, ppkg.Name, downloadUrl)
+	}
+	return run.print()
+}
+
+// print shows the dry run: the package, where it would be stored, its files and its links.
+func (run *dryRun) print() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s:\t%s\n", tr("Name"), run.Name)
+	fmt.Fprintf(w, "%s:\t%s\n", tr("Version"), run.Version)
+	fmt.Fprintf(w, "%s:\t%s\n", tr("Platform"), run.Platform)
+	fmt.Fprintf(w, "%s:\t%s\n", tr("Downloaded from"), run.Url)
+	fmt.Fprintf(w, "%s:\t%s\n", tr("Store path"), run.FullPath)
+	for _, algo := range slices.Sorted(maps.Keys(run.Verified)) {
+		fmt.Fprintf(w, "%s:\t%s\n", tr("Verified %s", algo), run.Verified[algo])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var size int64
+	for _, f := range run.Files {
+		size += f.Size
+	}
+	fmt.Println()
+	fmt.Println(tr("Files that would be extracted (%s in %d files):", formatBytes(uint64(size)), len(run.Files)))
+	for _, f := range run.Files {
+		if f.LinkTarget != "" {
+			fmt.Printf("  %s -> %s\n", f.Path, f.LinkTarget)
+		} else {
+			fmt.Printf("  %s\n", f.Path)
+		}
+	}
+	if len(run.PostExtract) > 0 {
+		fmt.Println()
+		fmt.Println(tr("Steps that would run after extracting:"))
+		for _, step := range run.PostExtract {
+			fmt.Printf("  %s\n", step)
+		}
+	}
+
+	fmt.Println()
+	if !run.Platform.IsHost() && len(run.Links) == 0 {
+		fmt.Println(tr("No links would be created, since the package is built for %s.", run.Platform))
+		return nil
+	}
+	if len(run.Links) == 0 {
+		fmt.Println(tr("No links would be created."))
+		return nil
+	}
+	fmt.Println(tr("Links that would be created:"))
+	for _, l := range run.Links {
+		note := ""
+		if _, err := os.Lstat(l.Dst); err == nil && !linkExists(l.Src, l.Dst) {
+			note = "  " + tr("(exists already, would be skipped)")
+		}
+		fmt.Printf("  %s -> %s%s\n", l.Dst, strings.TrimPrefix(l.Src, run.FullPath+string(filepath.Separator)), note)
+	}
+	return nil
+}
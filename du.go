@@ -0,0 +1,89 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+// diskUsage is how much space the store takes up, as shown by du --json.
+type diskUsage struct {
+	Packages []packageUsage `json:"packages"`
+	// Total is the size of every installed package, counting the files they share through deduplication once.
+	Total uint64 `json:"total"`
+	// Retired is the size of the retired versions kept in the store for rollback.
+	Retired uint64 `json:"retired"`
+	// Reclaimable is how much gc would free as it is, and ReclaimableKeepLast with --keep-last 1.
+	Reclaimable         uint64 `json:"reclaimable"`
+	ReclaimableKeepLast uint64 `json:"reclaimableKeepLast"`
+}
+
+type packageUsage struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	Size     uint64 `json:"size"`
+}
+
+// diskUsage measures the installed and retired packages in the store, and what gc could free.
+func (pm *PackageManager) diskUsage() (*diskUsage, error) {
+	du := &diskUsage{Packages: []packageUsage{}}
+	seen := map[fileId]bool{}
+	for _, rec := range pm.db.Packages {
+		fullPath := filepath.Join(pm.StorePath, rec.Path)
+		du.Packages = append(du.Packages, packageUsage{Name: rec.Name, Version: rec.Version, Platform: rec.Platform.String(), Size: dirSize(fullPath, nil)})
+		du.Total += dirSize(fullPath, seen)
+	}
+	slices.SortStableFunc(du.Packages, func(a, b packageUsage) int { return cmp.Compare(b.Size, a.Size) })
+	for _, rec := range pm.db.Retired {
+		du.Retired += dirSize(filepath.Join(pm.StorePath, rec.Path), seen)
+	}
+
+	plan, err := pm.planGc(0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	du.Reclaimable = plan.Size
+	if plan, err = pm.planGc(1, 0, 0); err != nil {
+		return nil, err
+	}
+	du.ReclaimableKeepLast = plan.Size
+	return du, nil
+}
+
+func actionDu(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	du, err := pm.diskUsage()
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(du)
+	}
+
+	if len(du.Packages) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, tr("NAME\tVERSION\tSIZE"))
+		for _, p := range du.Packages {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, p.Version, formatBytes(p.Size))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	fmt.Println(tr("Installed: %s in %d packages", formatBytes(du.Total), len(du.Packages)))
+	fmt.Println(tr("Kept for rollback: %s", formatBytes(du.Retired)))
+	fmt.Println(tr("Reclaimable with infpm gc: %s, or %s with --keep-last 1", formatBytes(du.Reclaimable), formatBytes(du.ReclaimableKeepLast)))
+	return nil
+}
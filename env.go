@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// envPath is a directory to put at the front of a search path variable, e.g. bin in PATH.
+type envPath struct {
+	Var string
+	Dir string
+	// KeepDefault is whether an empty element is kept at the end of the variable, which man treats as its default
+	// search path, so that setting MANPATH doesn't hide the system's manuals.
+	KeepDefault bool
+}
+
+// linkedDir returns where the directory rel within the symlink root, e.g. share/man, is linked to, taking the
+// configured link roots into account.
+func (opts PackageManagerOpts) linkedDir(rel string) string {
+	return filepath.Dir(routeLink(opts.SymlinkPath, opts.LinkRoots, filepath.Join(rel, "x")))
+}
+
+// envPaths returns the search paths the symlink root should be added to. Libraries are only added if lib is set,
+// since putting them in LD_LIBRARY_PATH affects every program that is run, not only the ones infpm installed.
+func (opts PackageManagerOpts) envPaths(lib bool) []envPath {
+	paths := []envPath{
+		{Var: "PATH", Dir: opts.linkedDir("bin")},
+		{Var: "MANPATH", Dir: opts.linkedDir("share/man"), KeepDefault: true},
+	}
+	if lib {
+		libVar := "LD_LIBRARY_PATH"
+		if runtime.GOOS == "darwin" {
+			libVar = "DYLD_LIBRARY_PATH"
+		}
+		paths = append(paths, envPath{Var: libVar, Dir: opts.linkedDir("lib")})
+	}
+	return paths
+}
+
+// envScript returns shell code that adds paths to the environment of shell. Directories already in a variable aren't
+// added again, so it can be evaluated more than once.
+func envScript(shell string, paths []envPath) (string, error) {
+	var b strings.Builder
+	switch shell {
+	case "fish":
+		for _, p := range paths {
+			if p.KeepDefault {
+				fmt.Fprintf(&b, "set -q %s; or set -gx %s ''\n", p.Var, p.Var)
+			}
+			fmt.Fprintf(&b, "contains -- %s $%s; or set -gx %s %s $%s\n", fishQuote(p.Dir), p.Var, p.Var, fishQuote(p.Dir), p.Var)
+		}
+	case "bash", "zsh", "sh", "dash", "ksh", "mksh":
+		for _, p := range paths {
+			rest := "\"${" + p.Var + ":+:$" + p.Var + "}\""
+			if p.KeepDefault {
+				rest = "\":${" + p.Var + ":-}\""
+			}
+			fmt.Fprintf(&b, "case \":${%s:-}:\" in *:%s:*) ;; *) export %s=%s%s ;; esac\n", p.Var, shQuote(p.Dir), p.Var, shQuote(p.Dir), rest)
+		}
+	default:
+		return "", withKind(errUsage, fmt.Errorf("infpm env doesn't support %s; use bash, zsh, sh or fish", shell))
+	}
+	return b.String(), nil
+}
+
+// shQuote quotes s for use as a single word in a POSIX shell.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes s for use as a single word in fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func actionEnv(ctx context.Context, cmd *cli.Command) error {
+	shell := cmd.String("shell")
+	if shell == "" {
+		if shell = detectShell(); shell == "" {
+			shell = "sh"
+		}
+	}
+
+	// The package manager isn't needed, and initialising it would log to stdout, which is evaluated by the shell.
+	opts := packageManagerOptsFromCmd(cmd)
+	if err := opts.expandPaths(); err != nil {
+		return err
+	}
+	script, err := envScript(shell, opts.envPaths(cmd.Bool("lib")))
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("rehash") {
+		script += rehashHook(shell)
+	}
+	fmt.Print(script)
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+)
+
+// Exit codes returned by infpm. Scripts depend on these, so existing codes must never be renumbered.
+const (
+	EXIT_OK                  = 0
+	EXIT_ERROR               = 1
+	EXIT_USAGE               = 2
+	EXIT_NO_MATCHING_ASSET   = 3
+	EXIT_NETWORK             = 4
+	EXIT_VERIFICATION_FAILED = 5
+	EXIT_CONFLICT            = 6
+	EXIT_ALREADY_INSTALLED   = 7
+	EXIT_NOTHING_TO_UPGRADE  = 8
+	EXIT_INSUFFICIENT_SPACE  = 9
+	EXIT_BUSY                = 10
+	// EXIT_INTERRUPTED follows the shell convention of 128 plus the number of SIGINT.
+	EXIT_INTERRUPTED = 130
+)
+
+// EXIT_CODES_HELP documents the exit codes in the CLI help.
+const EXIT_CODES_HELP = "EXIT CODES:\n" +
+	"   0  success\n" +
+	"   1  unspecified error\n" +
+	"   2  invalid usage, e.g. missing arguments\n" +
+	"   3  no release asset matched this platform\n" +
+	"   4  network error, including non-OK responses from a provider\n" +
+	"   5  checksum or signature verification failed\n" +
+	"   6  conflict with an existing file or package\n" +
+	"   7  the package is already installed\n" +
+	"   8  nothing to upgrade\n" +
+	"   9  not enough free disk space\n" +
+	"   10  another infpm process is using the store\n" +
+	"   130  interrupted, e.g. with Ctrl-C"
+
+// Error kinds. Tag errors with these using withKind so that the CLI layer can map them to exit codes.
+var (
+	errUsage              = errors.New("invalid usage")
+	errNoMatchingAsset    = errors.New("no matching asset")
+	errNetwork            = errors.New("network error")
+	errVerificationFailed = errors.New("verification failed")
+	errConflict           = errors.New("conflict")
+	errAlreadyInstalled   = errors.New("already installed")
+	errNothingToUpgrade   = errors.New("nothing to upgrade")
+	errInsufficientSpace  = errors.New("insufficient disk space")
+	errBusy               = errors.New("store busy")
+	errInterrupted        = errors.New("interrupted")
+)
+
+// kindError tags an error with one of the error kinds above without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// withKind tags err with kind, so that errors.Is(err, kind) is true. Returns nil if err is nil.
+func withKind(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{kind: kind, err: err}
+}
+
+// exitCodeFor maps an error returned by a command to the exit code infpm should exit with.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return EXIT_OK
+	}
+
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	// An interrupted command fails in all sorts of ways, e.g. with network errors when its downloads are cancelled.
+	case errors.Is(err, errInterrupted), errors.Is(err, context.Canceled):
+		return EXIT_INTERRUPTED
+	case errors.Is(err, errUsage):
+		return EXIT_USAGE
+	case errors.Is(err, errNoMatchingAsset):
+		return EXIT_NO_MATCHING_ASSET
+	case errors.Is(err, errNetwork), errors.As(err, &netErr), errors.As(err, &urlErr):
+		return EXIT_NETWORK
+	case errors.Is(err, errVerificationFailed):
+		return EXIT_VERIFICATION_FAILED
+	case errors.Is(err, errConflict):
+		return EXIT_CONFLICT
+	case errors.Is(err, errAlreadyInstalled):
+		return EXIT_ALREADY_INSTALLED
+	case errors.Is(err, errNothingToUpgrade):
+		return EXIT_NOTHING_TO_UPGRADE
+	case errors.Is(err, errInsufficientSpace):
+		return EXIT_INSUFFICIENT_SPACE
+	case errors.Is(err, errBusy):
+		return EXIT_BUSY
+	default:
+		return EXIT_ERROR
+	}
+}
+
+// packageError attaches the package and URL being operated on to an error without changing its message.
+type packageError struct {
+	pkg string
+	url string
+	err error
+}
+
+func (e *packageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *packageError) Unwrap() error {
+	return e.err
+}
+
+// withPackage attaches the package name and source URL to err for structured error output. Returns nil if err is nil.
+func withPackage(err error, pkg string, url string) error {
+	if err == nil {
+		return nil
+	}
+	return &packageError{pkg: pkg, url: url, err: err}
+}
+
+// jsonError is the structured error printed when --json is set and a command fails.
+type jsonError struct {
+	Code    int    `json:"code"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Package string `json:"package,omitempty"`
+	Url     string `json:"url,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// errorKindNames and errorKindHints describe each exit code for structured error output.
+var errorKindNames = map[int]string{
+	EXIT_ERROR:               "error",
+	EXIT_USAGE:               "usage",
+	EXIT_NO_MATCHING_ASSET:   "no_matching_asset",
+	EXIT_NETWORK:             "network",
+	EXIT_VERIFICATION_FAILED: "verification_failed",
+	EXIT_CONFLICT:            "conflict",
+	EXIT_ALREADY_INSTALLED:   "already_installed",
+	EXIT_NOTHING_TO_UPGRADE:  "nothing_to_upgrade",
+	EXIT_INSUFFICIENT_SPACE:  "insufficient_space",
+	EXIT_BUSY:                "busy",
+	EXIT_INTERRUPTED:         "interrupted",
+}
+
+var errorKindHints = map[int]string{
+	EXIT_USAGE:               "Run infpm --help to see how to use this command.",
+	EXIT_NO_MATCHING_ASSET:   "Provide the URL of the release asset you want to install directly, or build it from source with --build-from-source.",
+	EXIT_NETWORK:             "Check your connection. If you are being rate limited, set GITHUB_TOKEN or log in with infpm auth login github.",
+	EXIT_VERIFICATION_FAILED: "The download may be corrupted or tampered with. Try again, or check the expected checksum.",
+	EXIT_CONFLICT:            "Remove or rename the conflicting file, then try again.",
+	EXIT_ALREADY_INSTALLED:   "Nothing to do. Upgrade the package to install a newer version.",
+	EXIT_INSUFFICIENT_SPACE:  "Free up some disk space, or use a store, cache or temporary directory on a larger filesystem.",
+	EXIT_BUSY:                "Wait for the other infpm process to finish and try again, or run with --wait to wait for it.",
+	EXIT_INTERRUPTED:         "Changes that were in progress were rolled back. Run the command again to finish.",
+}
+
+// newJsonError builds the structured representation of err.
+func newJsonError(err error) *jsonError {
+	code := exitCodeFor(err)
+	jerr := &jsonError{
+		Code:    code,
+		Kind:    errorKindNames[code],
+		Message: err.Error(),
+		Hint:    tr(errorKindHints[code]),
+	}
+
+	var pkgErr *packageError
+	if errors.As(err, &pkgErr) {
+		jerr.Package = pkgErr.pkg
+		jerr.Url = pkgErr.url
+	}
+	return jerr
+}
+
+// writeJsonError writes err to w as a single line of JSON.
+func writeJsonError(w io.Writer, err error) error {
+	return json.NewEncoder(w).Encode(newJsonError(err))
+}
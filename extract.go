@@ -0,0 +1,473 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type compression struct {
+	name  string
+	magic []byte
+	// flag is the flag the external tar needs to decompress the stream. tar can't detect the compression of an
+	// archive read from stdin by itself.
+	flag      string
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressionMagic maps the magic bytes at the start of a compressed stream to how to decompress it. The compression
+// of an archive is always detected from its contents, since the names of release assets and URLs can't be relied on.
+var compressionMagic = []compression{
+	{"gzip", []byte{0x1f, 0x8b}, "-z", func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }},
+	{"bzip2", []byte("BZh"), "-j", func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(bzip2.NewReader(r)), nil }},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "-J", func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		return io.NopCloser(xr), err
+	}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, "--zstd", func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}},
+}
+
+// detectCompression returns the compression of the stream read by br, or nil if it isn't compressed.
+func detectCompression(br *bufio.Reader) *compression {
+	header, _ := br.Peek(6)
+	for i, c := range compressionMagic {
+		if bytes.HasPrefix(header, c.magic) {
+			return &compressionMagic[i]
+		}
+	}
+	return nil
+}
+
+// decompress returns the decompressed stream read by br.
+func decompress(br *bufio.Reader) (io.ReadCloser, error) {
+	c := detectCompression(br)
+	if c == nil {
+		return io.NopCloser(br), nil
+	}
+	slog.Debug("decompressing archive", "compression", c.name)
+	return c.newReader(br)
+}
+
+// tarExtract extracts the (optionally compressed) tarball read from from into the directory to. The system tar is
+// used instead of the built-in extractor if external_tar is set in the config.
+func tarExtract(from io.Reader, to string) error {
+	return tarExtractStrip(from, to, 0)
+}
+
+// tarExtractStrip is tarExtract, removing the first strip directories from the path of every entry like tar's
+// --strip-components. Entries that are no deeper than that are skipped. Zip archives are extracted too, see zipExtract.
+func tarExtractStrip(from io.Reader, to string, strip int) error {
+	br := bufio.NewReader(from)
+	if header, _ := br.Peek(len(zipMagic)); bytes.Equal(header, zipMagic) {
+		return zipExtract(br, to, strip)
+	}
+	if config.ExternalTar {
+		return externalTarExtract(br, to, strip)
+	}
+
+	r, err := decompress(br)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return extractTar(tar.NewReader(r), to, strip)
+}
+
+// externalTarExtract extracts the tarball read by br into the directory to with the system tar.
+func externalTarExtract(br *bufio.Reader, to string, strip int) error {
+	if _, err := exec.LookPath("tar"); err != nil {
+		return errors.New("extracting with the system tar needs tar, which isn't in PATH")
+	}
+
+	args := []string{"-x"}
+	if c := detectCompression(br); c != nil {
+		args = append(args, c.flag)
+	}
+	if strip > 0 {
+		args = append(args, "--strip-components="+strconv.Itoa(strip))
+	}
+
+	cmd := exec.Command("tar", append(args, "-C", to)...)
+	cmd.Stdin = br
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		slog.Error("failed to extract archive with tar", "args", cmd.Args)
+		return err
+	}
+
+	return nil
+}
+
+// extractTar extracts every entry of tr into the directory to, stripping strip directories from their paths. Entries
+// that would end up outside of to, whether by their name or by being written through a symlink extracted earlier,
+// are refused.
+func extractTar(tr *tar.Reader, to string, strip int) error {
+	// Directories are only given their permissions and times once everything is extracted, since a read-only
+	// directory couldn't have its contents written and writing contents changes its modification time.
+	type dirMeta struct {
+		path  string
+		mode  fs.FileMode
+		mtime time.Time
+	}
+	dirs := []dirMeta{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := stripComponents(hdr.Name, strip)
+		if !ok {
+			continue
+		}
+		dst, err := extractPath(to, name)
+		if err != nil {
+			return err
+		}
+		if dst == to {
+			continue
+		}
+		if err := ensureParentDirs(to, dst); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := extractDir(dst); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMeta{dst, mode, hdr.ModTime})
+		case tar.TypeReg:
+			if err := extractFile(tr, dst, mode); err != nil {
+				return err
+			}
+			if err := os.Chtimes(dst, hdr.AccessTime, hdr.ModTime); err != nil {
+				slog.Debug("failed to set modification time", "path", dst, "err", err)
+			}
+		case tar.TypeSymlink:
+			os.Remove(dst)
+			if err := os.Symlink(hdr.Linkname, dst); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkname, _ := stripComponents(hdr.Linkname, strip)
+			target, err := extractPath(to, linkname)
+			if err != nil {
+				return err
+			}
+			if err := checkLinkTarget(to, target); err != nil {
+				return err
+			}
+			os.Remove(dst)
+			if err := os.Link(target, dst); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			slog.Debug("skipping archive entry of unsupported type", "name", hdr.Name, "type", string(hdr.Typeflag))
+		}
+	}
+
+	// Children before their parents, so that a read-only parent doesn't stop its children from being changed.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		// A later entry may have replaced the directory with a symlink, which chmod would follow.
+		if info, err := os.Lstat(d.path); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return withKind(errVerificationFailed, fmt.Errorf("archive directory %s was replaced by a later entry", d.path))
+		}
+		if err := os.Chmod(d.path, d.mode|0700); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.path, time.Time{}, d.mtime); err != nil {
+			slog.Debug("failed to set modification time", "path", d.path, "err", err)
+		}
+	}
+	return nil
+}
+
+// stripComponents removes the first strip directories from the path of an archive entry, returning false if there is
+// nothing left.
+func stripComponents(name string, strip int) (string, bool) {
+	if strip == 0 {
+		return name, true
+	}
+	parts := strings.Split(strings.Trim(path.Clean(name), "/"), "/")
+	if len(parts) <= strip {
+		return "", false
+	}
+	return path.Join(parts[strip:]...), true
+}
+
+// extractPath returns where the archive entry called name is extracted to within the directory to, or an error if
+// it would be outside of it.
+func extractPath(to string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == "." {
+		return to, nil
+	}
+	if !filepath.IsLocal(clean) {
+		return "", withKind(errVerificationFailed, fmt.Errorf("archive entry %q would be extracted outside of %s", name, to))
+	}
+	return filepath.Join(to, clean), nil
+}
+
+// ensureParentDirs creates the directories between to and dst, refusing if any of them is a symlink, which could
+// point outside of to.
+func ensureParentDirs(to string, dst string) error {
+	rel, err := filepath.Rel(to, filepath.Dir(dst))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := to
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return withKind(errVerificationFailed, fmt.Errorf("archive entry %s would be extracted through the symlink %s", dst, dir))
+		} else if !info.IsDir() {
+			return fmt.Errorf("can't extract %s, since %s isn't a directory", dst, dir)
+		}
+	}
+	return nil
+}
+
+// extractDir creates the directory of an archive entry at dst, unless it exists already. Anything else there, such as
+// a symlink extracted earlier that could point outside of the extraction directory, is refused.
+func extractDir(dst string) error {
+	info, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return os.Mkdir(dst, 0755)
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return withKind(errVerificationFailed, fmt.Errorf("archive directory %s would be extracted through a symlink", dst))
+	} else if !info.IsDir() {
+		return fmt.Errorf("can't extract the directory %s, since a file is in the way", dst)
+	}
+	return nil
+}
+
+// checkLinkTarget checks that target, the file an archive entry is hard linked to, is a regular file already
+// extracted within to, and not reached through a symlink, which could point outside of it.
+func checkLinkTarget(to string, target string) error {
+	rel, err := filepath.Rel(to, target)
+	if err != nil {
+		return err
+	}
+	dir := to
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return withKind(errVerificationFailed, fmt.Errorf("archive entry would be hard linked to %s through the symlink %s", target, dir))
+		}
+		if dir != target && !info.IsDir() {
+			return fmt.Errorf("can't hard link to %s, since %s isn't a directory", target, dir)
+		}
+		if dir == target && !info.Mode().IsRegular() {
+			return withKind(errVerificationFailed, fmt.Errorf("archive entry would be hard linked to %s, which isn't a regular file", target))
+		}
+	}
+	return nil
+}
+
+// extractFile writes the contents of an archive entry, read from r, to dst with the given permissions.
+func extractFile(r io.Reader, dst string, mode fs.FileMode) error {
+	// Replace rather than write through whatever is there already, which may be a symlink.
+	os.Remove(dst)
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if mode&0200 == 0 {
+		return os.Chmod(dst, mode)
+	}
+	return nil
+}
+
+// zipMagic starts every zip archive, such as the artifacts of GitHub Actions workflows.
+var zipMagic = []byte("PK\x03\x04")
+
+// executableMagic are the starts of files that are executables: ELF, Mach-O (32 and 64-bit, either byte order, and
+// universal), PE and scripts.
+var executableMagic = [][]byte{
+	[]byte("\x7fELF"),
+	{0xfe, 0xed, 0xfa, 0xce}, {0xfe, 0xed, 0xfa, 0xcf}, {0xce, 0xfa, 0xed, 0xfe}, {0xcf, 0xfa, 0xed, 0xfe},
+	{0xca, 0xfe, 0xba, 0xbe},
+	[]byte("MZ"),
+	[]byte("#!"),
+}
+
+// zipExtract extracts the zip archive read from from into the directory to, stripping strip directories from the
+// paths of its entries. A zip can only be read from a file, so it is spooled to a temporary one first.
+//
+// Zips made without Unix permissions, such as GitHub Actions artifacts, lose the modes of executables, so files that
+// start like one are made executable. Artifacts often hold nothing but a tarball, to keep those modes, in which case
+// the tarball is extracted in its place.
+func zipExtract(from io.Reader, to string, strip int) error {
+	dir, err := tempDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "infpm-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, from)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+
+	files := []string{}
+	dirs := 0
+	for _, zf := range zr.File {
+		name, ok := stripComponents(zf.Name, strip)
+		if !ok {
+			continue
+		}
+		dst, err := extractPath(to, name)
+		if err != nil {
+			return err
+		}
+		if dst == to {
+			continue
+		}
+		if err := ensureParentDirs(to, dst); err != nil {
+			return err
+		}
+
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			if err := extractDir(dst); err != nil {
+				return err
+			}
+			dirs++
+		case mode&fs.ModeSymlink != 0:
+			if err := extractZipSymlink(zf, dst); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := extractZipFile(zf, dst); err != nil {
+				return err
+			}
+			files = append(files, dst)
+		default:
+			slog.Debug("skipping archive entry of unsupported type", "name", zf.Name, "mode", mode)
+		}
+	}
+
+	if len(files) == 1 && dirs == 0 && isArchiveAsset(files[0]) {
+		slog.Info("zip archive holds a tarball, extracting it", "tarball", filepath.Base(files[0]))
+		tarball, err := os.Open(files[0])
+		if err != nil {
+			return err
+		}
+		defer os.Remove(files[0])
+		defer tarball.Close()
+		return tarExtractStrip(tarball, to, 0)
+	}
+	return nil
+}
+
+// extractZipFile writes a file in a zip archive to dst, making it executable if it looks like an executable but has
+// no permission to be run.
+func extractZipFile(zf *zip.File, dst string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	mode := zf.Mode().Perm()
+	if mode&0111 == 0 {
+		header, _ := br.Peek(4)
+		for _, magic := range executableMagic {
+			if bytes.HasPrefix(header, magic) {
+				mode |= 0755
+				break
+			}
+		}
+	}
+	if err := extractFile(br, dst, mode|0400); err != nil {
+		return err
+	}
+	if err := os.Chtimes(dst, time.Time{}, zf.Modified); err != nil {
+		slog.Debug("failed to set modification time", "path", dst, "err", err)
+	}
+	return nil
+}
+
+// extractZipSymlink creates the symlink stored in a zip archive, whose contents are its target, at dst.
+func extractZipSymlink(zf *zip.File, dst string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	os.Remove(dst)
+	return os.Symlink(string(target), dst)
+}
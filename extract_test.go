@@ -0,0 +1,262 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is an entry of a tarball built for a test. Linkname may contain {outside}, which is replaced with a
+// directory outside of the one extracted to.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	body     string
+	linkname string
+}
+
+// buildTar returns a tarball of the entries.
+func buildTar(t *testing.T, entries []tarEntry, outside string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: e.mode, Linkname: e.linkname}
+		if hdr.Linkname == "{outside}" {
+			hdr.Linkname = outside
+		}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.body != "" {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		strip   int
+		wantErr bool
+		// check inspects the extraction directory and the directory outside of it.
+		check func(t *testing.T, to string, outside string)
+	}{
+		{
+			name:    "parent directory",
+			entries: []tarEntry{{name: "../escape", typeflag: tar.TypeReg, mode: 0644, body: "x"}},
+			wantErr: true,
+		},
+		{
+			name:    "parent directory within the path",
+			entries: []tarEntry{{name: "bin/../../escape", typeflag: tar.TypeReg, mode: 0644, body: "x"}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute path",
+			entries: []tarEntry{{name: "/tmp/escape", typeflag: tar.TypeReg, mode: 0644, body: "x"}},
+			wantErr: true,
+		},
+		{
+			name: "file through symlink",
+			entries: []tarEntry{
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "{outside}"},
+				{name: "x/escape", typeflag: tar.TypeReg, mode: 0644, body: "x"},
+			},
+			wantErr: true,
+			check: func(t *testing.T, to string, outside string) {
+				assertNotExist(t, filepath.Join(outside, "escape"))
+			},
+		},
+		{
+			name: "symlink then directory",
+			entries: []tarEntry{
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "{outside}"},
+				{name: "x/", typeflag: tar.TypeDir, mode: 0777},
+			},
+			wantErr: true,
+			check: func(t *testing.T, to string, outside string) {
+				assertMode(t, outside, 0700)
+			},
+		},
+		{
+			name: "directory replaced by symlink",
+			entries: []tarEntry{
+				{name: "x/", typeflag: tar.TypeDir, mode: 0777},
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "{outside}"},
+			},
+			wantErr: true,
+			check: func(t *testing.T, to string, outside string) {
+				assertMode(t, outside, 0700)
+			},
+		},
+		{
+			name: "symlink then hard link",
+			entries: []tarEntry{
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "{outside}"},
+				{name: "leak", typeflag: tar.TypeLink, linkname: "x/secret"},
+			},
+			wantErr: true,
+			check: func(t *testing.T, to string, outside string) {
+				assertNotExist(t, filepath.Join(to, "leak"))
+			},
+		},
+		{
+			name: "hard link to a symlink",
+			entries: []tarEntry{
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "{outside}/secret"},
+				{name: "leak", typeflag: tar.TypeLink, linkname: "x"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "hard link outside",
+			entries: []tarEntry{{name: "leak", typeflag: tar.TypeLink, linkname: "../secret"}},
+			wantErr: true,
+		},
+		{
+			name: "hard link",
+			entries: []tarEntry{
+				{name: "bin/tool", typeflag: tar.TypeReg, mode: 0755, body: "#!/bin/sh\n"},
+				{name: "bin/alias", typeflag: tar.TypeLink, linkname: "bin/tool"},
+			},
+			check: func(t *testing.T, to string, outside string) {
+				a, errA := os.Stat(filepath.Join(to, "bin", "tool"))
+				b, errB := os.Stat(filepath.Join(to, "bin", "alias"))
+				if errA != nil || errB != nil || !os.SameFile(a, b) {
+					t.Errorf("bin/alias isn't a hard link to bin/tool: %v, %v", errA, errB)
+				}
+			},
+		},
+		{
+			name: "symlink within",
+			entries: []tarEntry{
+				{name: "bin/tool", typeflag: tar.TypeReg, mode: 0755, body: "#!/bin/sh\n"},
+				{name: "bin/t", typeflag: tar.TypeSymlink, linkname: "tool"},
+			},
+			check: func(t *testing.T, to string, outside string) {
+				if target, err := os.Readlink(filepath.Join(to, "bin", "t")); err != nil || target != "tool" {
+					t.Errorf("bin/t links to %q, %v, want tool", target, err)
+				}
+			},
+		},
+		{
+			name: "strip components",
+			entries: []tarEntry{
+				{name: "tool-1.0/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "tool-1.0/bin/tool", typeflag: tar.TypeReg, mode: 0755, body: "#!/bin/sh\n"},
+				{name: "tool-1.0/README", typeflag: tar.TypeReg, mode: 0644, body: "hi"},
+				{name: "tool-1.0/lib/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "tool-1.0/lib/a/b", typeflag: tar.TypeLink, linkname: "tool-1.0/README"},
+			},
+			strip: 1,
+			check: func(t *testing.T, to string, outside string) {
+				assertContents(t, filepath.Join(to, "bin", "tool"), "#!/bin/sh\n")
+				assertContents(t, filepath.Join(to, "README"), "hi")
+				assertContents(t, filepath.Join(to, "lib", "a", "b"), "hi")
+				assertNotExist(t, filepath.Join(to, "tool-1.0"))
+			},
+		},
+		{
+			name: "strip components skips shallow entries",
+			entries: []tarEntry{
+				{name: "top", typeflag: tar.TypeReg, mode: 0644, body: "x"},
+				{name: "a/b/c", typeflag: tar.TypeReg, mode: 0644, body: "y"},
+			},
+			strip: 2,
+			check: func(t *testing.T, to string, outside string) {
+				assertNotExist(t, filepath.Join(to, "top"))
+				assertContents(t, filepath.Join(to, "c"), "y")
+			},
+		},
+		{
+			name: "modes",
+			entries: []tarEntry{
+				{name: "bin/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "bin/tool", typeflag: tar.TypeReg, mode: 0755, body: "#!/bin/sh\n"},
+				{name: "etc/", typeflag: tar.TypeDir, mode: 0555},
+				{name: "etc/conf", typeflag: tar.TypeReg, mode: 0644, body: "a=1"},
+				{name: "etc/ro", typeflag: tar.TypeReg, mode: 0444, body: "ro"},
+				{name: "setuid", typeflag: tar.TypeReg, mode: 04755, body: "x"},
+			},
+			check: func(t *testing.T, to string, outside string) {
+				assertMode(t, filepath.Join(to, "bin", "tool"), 0755)
+				assertMode(t, filepath.Join(to, "etc", "conf"), 0644)
+				assertMode(t, filepath.Join(to, "etc", "ro"), 0444)
+				// Directories stay writable by their owner, so that the package can be removed again.
+				assertMode(t, filepath.Join(to, "etc"), 0755)
+				// Only permission bits are kept.
+				if info, err := os.Stat(filepath.Join(to, "setuid")); err != nil || info.Mode()&fs.ModeSetuid != 0 {
+					t.Errorf("setuid has mode %v, %v, want no setuid bit", info.Mode(), err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			to, outside := filepath.Join(base, "to"), filepath.Join(base, "outside")
+			for _, dir := range []string{to, outside} {
+				if err := os.Mkdir(dir, 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := extractTar(tar.NewReader(buildTar(t, tt.entries, outside)), to, tt.strip)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractTar() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if info, err := os.Stat(filepath.Join(outside, "secret")); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("the file outside was changed: %v, %v", info.Mode(), err)
+			}
+			if tt.check != nil {
+				tt.check(t, to, outside)
+			}
+		})
+	}
+}
+
+func assertMode(t *testing.T, path string, want fs.FileMode) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+	} else if info.Mode().Perm() != want {
+		t.Errorf("%s has mode %v, want %v", path, info.Mode().Perm(), want)
+	}
+}
+
+func assertContents(t *testing.T, path string, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+	} else if string(data) != want {
+		t.Errorf("%s contains %q, want %q", path, data, want)
+	}
+}
+
+func assertNotExist(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("%s exists, but shouldn't", path)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v3"
+)
+
+// packageFile is a file of an installed package, as shown by files --json.
+type packageFile struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	// Link is where the file is linked in the link roots, if it is.
+	Link string `json:"link,omitempty"`
+}
+
+// packageFiles returns every file in a package's manifest, with where each is linked. A file is linked if a link
+// points to it, or to a directory it is in.
+func (pm *PackageManager) packageFiles(rec *PackageRecord) []packageFile {
+	fullPath := filepath.Join(pm.StorePath, rec.Path)
+	linkedAt := map[string]string{}
+	for _, dst := range rec.Links {
+		if target, err := os.Readlink(dst); err == nil && linksInto(dst, fullPath) {
+			linkedAt[filepath.Clean(target)] = dst
+		}
+	}
+
+	files := []packageFile{}
+	for _, f := range rec.Files {
+		path := filepath.Join(fullPath, filepath.FromSlash(f.Path))
+		file := packageFile{Package: rec.Name, Version: rec.Version, Path: path}
+		for dir := path; dir != fullPath && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if dst, ok := linkedAt[dir]; ok {
+				rel, _ := filepath.Rel(dir, path)
+				file.Link = filepath.Join(dst, rel)
+				break
+			}
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func actionFiles(ctx context.Context, cmd *cli.Command) error {
+	name := cmd.Args().Get(0)
+	if name == "" {
+		return withKind(errUsage, errors.New(tr("A package name is required. See --help files.")))
+	}
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	recs := pm.db.Find(name)
+	if len(recs) == 0 {
+		return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+	}
+
+	files := map[*PackageRecord][]packageFile{}
+	all := []packageFile{}
+	for _, rec := range recs {
+		for _, f := range pm.packageFiles(rec) {
+			if !cmd.Bool("linked") || f.Link != "" {
+				files[rec] = append(files[rec], f)
+			}
+		}
+		all = append(all, files[rec]...)
+	}
+	if cmd.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(all)
+	}
+
+	for i, rec := range recs {
+		// Versions are only told apart when there are several.
+		if len(recs) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Println(rec.String() + ":")
+		}
+		for _, f := range files[rec] {
+			if f.Link != "" {
+				fmt.Println(tr("%s (linked as %s)", f.Path, f.Link))
+			} else {
+				fmt.Println(f.Path)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// gcPlan is what gc removes: generations dropped by its policies, the retired records that only those generations
+// had, and directories in the store that no record has, e.g. left by a crash.
+type gcPlan struct {
+	Generations []*Generation
+	Records     []*PackageRecord
+	// Orphans are the paths, relative to the store, of directories no record has.
+	Orphans []string
+	// Size is how much space removing all of it frees.
+	Size uint64
+	// Unused are the installed packages that haven't been used for as long as gc was asked about. gc only suggests
+	// uninstalling them, and never removes them itself.
+	Unused []*PackageRecord
+}
+
+// planGc decides what gc removes. Generations other than the current one are dropped if they are neither among the
+// last keepLast nor newer than olderThan, for whichever of the two is set; with neither, every generation is kept.
+// If unusedFor is set, installed packages that haven't been used for that long are listed in the plan's Unused.
+func (pm *PackageManager) planGc(keepLast int, olderThan time.Duration, unusedFor time.Duration) (*gcPlan, error) {
+	plan := &gcPlan{}
+	if unusedFor > 0 {
+		cutoff := time.Now().Add(-unusedFor)
+		for _, rec := range pm.db.Packages {
+			if rec.unusedSince(cutoff) {
+				plan.Unused = append(plan.Unused, rec)
+			}
+		}
+		slices.SortStableFunc(plan.Unused, func(a, b *PackageRecord) int { return strings.Compare(a.Name, b.Name) })
+	}
+
+	kept := []*Generation{}
+	current := pm.db.lastGeneration()
+	for i, g := range pm.db.Generations {
+		drop := g != current && (keepLast > 0 || olderThan > 0)
+		if keepLast > 0 && i >= len(pm.db.Generations)-keepLast {
+			drop = false
+		}
+		if olderThan > 0 && time.Since(g.CreatedAt) < olderThan {
+			drop = false
+		}
+		if drop {
+			plan.Generations = append(plan.Generations, g)
+		} else {
+			kept = append(kept, g)
+		}
+	}
+
+	recorded := map[string]bool{}
+	for _, rec := range pm.db.Packages {
+		recorded[rec.Path] = true
+	}
+	for _, rec := range pm.db.Retired {
+		if slices.ContainsFunc(kept, func(g *Generation) bool {
+			return slices.ContainsFunc(g.Packages, func(p generationPackage) bool { return p.Id == rec.Id })
+		}) {
+			recorded[rec.Path] = true
+			continue
+		}
+		plan.Records = append(plan.Records, rec)
+	}
+
+	// Packages are stored at os-arch/name/version/id, so anything else at that depth is left over.
+	err := filepath.WalkDir(pm.StorePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(pm.StorePath, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+		if depth < 4 {
+			return nil
+		}
+		if !recorded[rel] && !slices.ContainsFunc(plan.Records, func(rec *PackageRecord) bool { return rec.Path == rel }) {
+			plan.Orphans = append(plan.Orphans, rel)
+		}
+		return fs.SkipDir
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Files deduplicated with ones that are kept aren't freed by removing them, so count the kept ones first.
+	if len(plan.Records)+len(plan.Orphans) > 0 {
+		seen := map[fileId]bool{}
+		for rel := range recorded {
+			dirSize(filepath.Join(pm.StorePath, rel), seen)
+		}
+		for _, rec := range plan.Records {
+			plan.Size += dirSize(filepath.Join(pm.StorePath, rec.Path), seen)
+		}
+		for _, rel := range plan.Orphans {
+			plan.Size += dirSize(filepath.Join(pm.StorePath, rel), seen)
+		}
+	}
+	return plan, nil
+}
+
+// dirSize returns the total size of the files in dir, or 0 if it doesn't exist. If seen is given, files hard linked
+// to ones already in it aren't counted again, and the files are added to it.
+func dirSize(dir string, seen map[fileId]bool) uint64 {
+	var size uint64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if id, ok := fileIdentity(info); ok && seen != nil {
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	return size
+}
+
+// collect removes everything in the plan from the store and the database.
+func (pm *PackageManager) collect(plan *gcPlan) error {
+	for _, rec := range plan.Records {
+		fullPath := filepath.Join(pm.StorePath, rec.Path)
+		slog.Info("removing retired package from the store", "package", rec.Name, "version", rec.Version, "path", fullPath)
+		if err := os.RemoveAll(fullPath); err != nil {
+			return err
+		}
+		removeEmptyParents(filepath.Dir(fullPath), pm.StorePath)
+	}
+	for _, rel := range plan.Orphans {
+		fullPath := filepath.Join(pm.StorePath, rel)
+		slog.Info("removing unrecorded directory from the store", "path", fullPath)
+		if err := os.RemoveAll(fullPath); err != nil {
+			return err
+		}
+		removeEmptyParents(filepath.Dir(fullPath), pm.StorePath)
+	}
+
+	pm.db.Generations = slices.DeleteFunc(pm.db.Generations, func(g *Generation) bool { return slices.Contains(plan.Generations, g) })
+	pm.db.Retired = slices.DeleteFunc(pm.db.Retired, func(rec *PackageRecord) bool { return slices.Contains(plan.Records, rec) })
+	if err := pm.db.Save(); err != nil {
+		return err
+	}
+	for _, rec := range plan.Records {
+		pm.logHistory(HISTORY_GC, rec)
+	}
+	return nil
+}
+
+func actionGc(ctx context.Context, cmd *cli.Command) error {
+	var olderThan time.Duration
+	if s := cmd.String("older-than"); s != "" {
+		var err error
+		if olderThan, err = parseAge(s); err != nil {
+			return err
+		}
+	}
+	var unusedFor time.Duration
+	if s := cmd.String("unused"); s != "" {
+		var err error
+		if unusedFor, err = parseAge(s); err != nil {
+			return err
+		}
+	}
+	keepLast := int(cmd.Int("keep-last"))
+	if keepLast < 0 {
+		return withKind(errUsage, fmt.Errorf("--keep-last must be at least 0, not %d", keepLast))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if unusedFor > 0 && pm.sampleLastUsed() {
+		if err := pm.db.Save(); err != nil {
+			return err
+		}
+	}
+	plan, err := pm.planGc(keepLast, olderThan, unusedFor)
+	if err != nil {
+		return err
+	}
+	defer printUnused(plan.Unused)
+
+	if cmd.Bool("dry-run") {
+		for _, g := range plan.Generations {
+			fmt.Println(tr("Would remove generation %d, made %s by %s", g.Number, g.CreatedAt.Local().Format(time.DateTime), g.Command))
+		}
+		for _, rec := range plan.Records {
+			fmt.Println(tr("Would remove %s from the store", rec))
+		}
+		for _, rel := range plan.Orphans {
+			fmt.Println(tr("Would remove %s, which no package has, from the store", filepath.Join(pm.StorePath, rel)))
+		}
+		fmt.Println(tr("This would free %s.", formatBytes(plan.Size)))
+		return nil
+	}
+
+	if err := pm.collect(plan); err != nil {
+		return err
+	}
+	fmt.Println(tr("Removed %d generations and %d directories from the store, freeing %s.", len(plan.Generations), len(plan.Records)+len(plan.Orphans), formatBytes(plan.Size)))
+	return nil
+}
+
+// printUnused suggests uninstalling the packages gc found unused.
+func printUnused(recs []*PackageRecord) {
+	if len(recs) == 0 {
+		return
+	}
+	fmt.Println(tr("These packages haven't been used for a while, and could be uninstalled with infpm uninstall:"))
+	for _, rec := range recs {
+		lastUsed := tr("never")
+		if rec.LastUsedAt != nil {
+			lastUsed = rec.LastUsedAt.Local().Format(time.DateOnly)
+		}
+		fmt.Println(tr("  %s, last used %s", rec, lastUsed))
+	}
+}
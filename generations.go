@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// invocation is the command line infpm was run with, after the global flags, e.g. "upgrade --all". It is recorded
+// with the generations it makes.
+var invocation string
+
+// Generation is the set of packages that were installed after a command changed them, numbered from 1 in the order
+// they were made. Each command that installs, upgrades, removes or switches packages makes one generation, which
+// rollback can return to.
+type Generation struct {
+	Number    int       `json:"number"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Command is what infpm was run with to make the generation. See invocation.
+	Command  string              `json:"command,omitempty"`
+	Packages []generationPackage `json:"packages"`
+	// Linked maps the name of each package that has a version linked to the id of that version, so that rollback
+	// restores the links use switched too. Generations made before it was recorded don't have it.
+	Linked map[string]string `json:"linked,omitempty"`
+}
+
+// generationPackage is an installed record in a generation.
+type generationPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Id      string `json:"id"`
+}
+
+// sameAs returns whether two generations have the same packages, with the same versions linked.
+func (g *Generation) sameAs(other *Generation) bool {
+	return slices.Equal(g.Packages, other.Packages) && maps.Equal(g.Linked, other.Linked)
+}
+
+// recordGeneration adds a generation for the installed packages, unless they haven't changed since the last one. All
+// saves by the same command update the one generation it made, so e.g. an upgrade that installs a version and then
+// removes the old one makes a single generation.
+func (db *Database) recordGeneration() {
+	gen := &Generation{Packages: []generationPackage{}, Linked: map[string]string{}}
+	for _, rec := range db.Packages {
+		gen.Packages = append(gen.Packages, generationPackage{Name: rec.Name, Version: rec.Version, Id: rec.Id})
+		if db.inUse(rec) {
+			gen.Linked[rec.Name] = rec.Id
+		}
+	}
+	slices.SortFunc(gen.Packages, func(a, b generationPackage) int {
+		return strings.Compare(a.Name+"\x00"+a.Id, b.Name+"\x00"+b.Id)
+	})
+
+	if db.generation != nil {
+		db.generation.Packages, db.generation.Linked = gen.Packages, gen.Linked
+		return
+	}
+	last := db.lastGeneration()
+	if last != nil && last.sameAs(gen) {
+		return
+	}
+	gen.Number = 1
+	if last != nil {
+		gen.Number = last.Number + 1
+	}
+	gen.CreatedAt, gen.Command = time.Now(), invocation
+	db.generation = gen
+	db.Generations = append(db.Generations, db.generation)
+}
+
+// lastGeneration returns the most recent generation, or nil if there are none yet.
+func (db *Database) lastGeneration() *Generation {
+	if len(db.Generations) == 0 {
+		return nil
+	}
+	return db.Generations[len(db.Generations)-1]
+}
+
+// findGeneration returns the generation with the given number, or nil if there is none.
+func (db *Database) findGeneration(number int) *Generation {
+	for _, g := range db.Generations {
+		if g.Number == number {
+			return g
+		}
+	}
+	return nil
+}
+
+// findId returns the installed record with the given id, or nil if there is none.
+func (db *Database) findId(id string) *PackageRecord {
+	for _, rec := range db.Packages {
+		if rec.Id == id {
+			return rec
+		}
+	}
+	return nil
+}
+
+// referenced returns whether any generation has the record with the given id.
+func (db *Database) referenced(id string) bool {
+	for _, g := range db.Generations {
+		if slices.ContainsFunc(g.Packages, func(p generationPackage) bool { return p.Id == id }) {
+			return true
+		}
+	}
+	return false
+}
+
+// unretire takes the retired record with the given id out of Retired, returning nil if there is none.
+func (db *Database) unretire(id string) *PackageRecord {
+	for i, rec := range db.Retired {
+		if rec.Id == id {
+			db.Retired = slices.Delete(db.Retired, i, i+1)
+			return rec
+		}
+	}
+	return nil
+}
+
+// Rollback makes the installed packages those of a generation. Records of the generation that have been removed since
+// are brought back, re-extracting them from their tarballs if they are no longer in the store, and the versions that
+// were linked then are linked in place of the other versions of their package; each link is switched in one step, as
+// by use. Packages that aren't in the generation are retired.
+func (pm *PackageManager) Rollback(gen *Generation) error {
+	// Check that every record can be brought back before changing anything.
+	for _, p := range gen.Packages {
+		if pm.db.findId(p.Id) == nil && !slices.ContainsFunc(pm.db.Retired, func(rec *PackageRecord) bool { return rec.Id == p.Id }) {
+			return withKind(errConflict, fmt.Errorf("%s %s from generation %d is no longer recorded, so it can't be brought back", p.Name, p.Version, gen.Number))
+		}
+	}
+
+	linked := map[string]*PackageRecord{}
+	for _, p := range gen.Packages {
+		rec := pm.db.findId(p.Id)
+		if rec == nil {
+			rec = pm.db.unretire(p.Id)
+			pm.db.Add(rec)
+			if _, err := os.Stat(filepath.Join(pm.StorePath, rec.Path)); os.IsNotExist(err) {
+				if err := pm.reextract(rec); err != nil {
+					return withPackage(err, rec.Name, rec.Url)
+				}
+			}
+		}
+		if !rec.Platform.IsHost() {
+			continue
+		}
+		if gen.Linked != nil {
+			if gen.Linked[rec.Name] == rec.Id {
+				linked[rec.Name] = rec
+			}
+		} else if linked[rec.Name] == nil || rec.InstalledAt.After(linked[rec.Name].InstalledAt) {
+			// Older generations don't say which version was linked, so link the last one installed, like upgrade.
+			linked[rec.Name] = rec
+		}
+	}
+	for _, rec := range linked {
+		if err := pm.Use(rec); err != nil {
+			return withPackage(err, rec.Name, rec.Source)
+		}
+	}
+
+	for _, rec := range slices.Clone(pm.db.Packages) {
+		if slices.ContainsFunc(gen.Packages, func(p generationPackage) bool { return p.Id == rec.Id }) {
+			continue
+		}
+		if err := pm.Retire(rec); err != nil {
+			return withPackage(err, rec.Name, rec.Source)
+		}
+	}
+	if err := pm.db.Save(); err != nil {
+		return err
+	}
+	pm.logHistory(HISTORY_ROLLBACK, nil)
+	return nil
+}
+
+func actionRollback(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("list") {
+		return printGenerations(pm.db)
+	}
+
+	current := pm.db.lastGeneration()
+	if current == nil {
+		return withKind(errUsage, errors.New(tr("There are no generations to roll back to yet.")))
+	}
+	var gen *Generation
+	if arg := cmd.Args().Get(0); arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return withKind(errUsage, fmt.Errorf("%s is not a generation number", arg))
+		}
+		if gen = pm.db.findGeneration(n); gen == nil {
+			return withKind(errUsage, fmt.Errorf("there is no generation %d. See infpm rollback --list", n))
+		}
+	} else if len(pm.db.Generations) > 1 {
+		gen = pm.db.Generations[len(pm.db.Generations)-2]
+	} else {
+		return withKind(errUsage, errors.New(tr("There are no generations to roll back to yet.")))
+	}
+	if current.sameAs(gen) {
+		fmt.Println(tr("The installed packages are already those of generation %d.", gen.Number))
+		return nil
+	}
+
+	if err := pm.Rollback(gen); err != nil {
+		return err
+	}
+	fmt.Println(tr("Rolled back to generation %d, as generation %d.", gen.Number, pm.db.lastGeneration().Number))
+	printRehashHint()
+	return nil
+}
+
+// printGenerations lists every generation, marking the current one.
+func printGenerations(db *Database) error {
+	if len(db.Generations) == 0 {
+		fmt.Println(tr("There are no generations yet."))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, tr("GENERATION\tCREATED\tPACKAGES\tCOMMAND"))
+	current := db.lastGeneration()
+	for _, g := range db.Generations {
+		number := strconv.Itoa(g.Number)
+		if g == current {
+			number += " " + tr("(current)")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", number, g.CreatedAt.Local().Format(time.DateTime), len(g.Packages), g.Command)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// DEFAULT_GITEA_HOSTS are the hosts known to run Gitea or Forgejo, whose releases are resolved like GitHub's. More
+// can be added with gitea_hosts in the config file.
+var DEFAULT_GITEA_HOSTS = []string{"codeberg.org", "gitea.com"}
+
+// isGiteaHost returns whether host runs Gitea or Forgejo, either as one of DEFAULT_GITEA_HOSTS or as configured.
+func isGiteaHost(host string) bool {
+	return slices.Contains(DEFAULT_GITEA_HOSTS, host) || slices.Contains(config.GiteaHosts, host)
+}
+
+// getRepoName returns the repository name if the URL is in the form github.com/user/repo, or the same on a Gitea
+// host, e.g. codeberg.org/user/repo. Otherwise, returns "".
+func getRepoName(u *url.URL) string {
+	if name := getGithubRepoName(u); name != "" {
+		return name
+	}
+	splitPath := strings.Split(u.Path, "/")
+	if isGiteaHost(u.Hostname()) && len(splitPath)-1 == 2 {
+		return splitPath[2]
+	}
+	return ""
+}
+
+// repoPathOf returns how the repository at u, as accepted by getRepoName, is identified in requests for its releases:
+// its path, /user/repo, on GitHub, or its host and path, e.g. codeberg.org/user/repo, on a Gitea host. See
+// repoApiUrl.
+func repoPathOf(u *url.URL) string {
+	if getGithubRepoName(u) != "" {
+		return u.Path
+	}
+	return u.Host + u.Path
+}
+
+// releaseRepoPath returns the repo path of a source if it is a repository on GitHub or a Gitea host. See repoPathOf.
+func releaseRepoPath(source string) (string, bool) {
+	u, err := parseSourceUrl(source)
+	if err != nil || getRepoName(u) == "" {
+		return "", false
+	}
+	return repoPathOf(u), true
+}
+
+// isGiteaRepoPath returns whether repoPath is a repository on a Gitea host rather than GitHub.
+func isGiteaRepoPath(repoPath string) bool {
+	return !strings.HasPrefix(repoPath, "/")
+}
+
+// repoApiUrl returns the API endpoint of the repository at repoPath. Gitea's releases API is modelled on GitHub's,
+// so the same requests and responses work for both.
+func repoApiUrl(repoPath string) *url.URL {
+	if !isGiteaRepoPath(repoPath) {
+		apiUrl, _ := url.Parse("https://api.github.com/repos")
+		return apiUrl.JoinPath(repoPath)
+	}
+	host, path, _ := strings.Cut(repoPath, "/")
+	return &url.URL{Scheme: "https", Host: host, Path: "/api/v1/repos/" + path}
+}
+
+// giteaApiError explains why a request to the API of a Gitea host for the repository at repoPath failed.
+func giteaApiError(resp *http.Response, repoPath string) error {
+	host, path, _ := strings.Cut(repoPath, "/")
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return withKind(errUsage, errors.New(tr("%s refused access to %s. If it is private, add a token for %s to ~/.netrc or pass it with --header.", host, path, host)))
+	case http.StatusNotFound:
+		return withKind(errUsage, errors.New(tr("The repository %s on %s doesn't exist, has no releases, or is private.", path, host)))
+	}
+	return withKind(errNetwork, fmt.Errorf("%s returned status %d for %s", host, resp.StatusCode, redactUrl(resp.Request.URL)))
+}
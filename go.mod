@@ -3,3 +3,18 @@ module github.com/alecks/infpm
 go 1.23.6
 
 require github.com/urfave/cli/v3 v3.0.0-beta1
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/klauspost/compress v1.17.11
+	github.com/ulikunitz/xz v0.5.12
+	github.com/zeebo/blake3 v0.2.4
+	golang.org/x/crypto v0.36.0
+)
+
+require (
+	github.com/cloudflare/circl v1.6.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+)
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// HISTORY_FILENAME is the log of every change to the installed packages, kept beside the database. It is only ever
+// appended to, one JSON object per line. See historyEntry.
+const HISTORY_FILENAME = "history.jsonl"
+
+// The operations recorded in the history.
+const (
+	HISTORY_INSTALL   = "install"
+	HISTORY_UNINSTALL = "uninstall"
+	// HISTORY_RETIRE is a version replaced by an upgrade or a rollback, which is kept in the store.
+	HISTORY_RETIRE   = "retire"
+	HISTORY_USE      = "use"
+	HISTORY_ROLLBACK = "rollback"
+	// HISTORY_GC is a retired version removed from the store by gc.
+	HISTORY_GC = "gc"
+)
+
+// historyEntry is one operation in the history.
+type historyEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Package   string    `json:"package,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Platform  string    `json:"platform,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Url       string    `json:"url,omitempty"`
+	// Generation is the generation the operation is part of. See Generation.
+	Generation int `json:"generation,omitempty"`
+	// Command is what infpm was run with. See invocation.
+	Command string `json:"command,omitempty"`
+	User    string `json:"user,omitempty"`
+}
+
+// historyUser returns who is running infpm, for the history.
+func historyUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// logHistory appends an operation on a package to the history. rec is nil for operations on no package in particular,
+// e.g. a rollback. The history is only a record, so failing to write it is logged rather than failing the operation.
+func (pm *PackageManager) logHistory(operation string, rec *PackageRecord) {
+	entry := historyEntry{Time: time.Now(), Operation: operation, Command: invocation, User: historyUser()}
+	if rec != nil {
+		entry.Package, entry.Version, entry.Platform = rec.Name, rec.Version, rec.Platform.String()
+		entry.Source, entry.Url = rec.Source, rec.Url
+	}
+	if g := pm.db.lastGeneration(); g != nil {
+		entry.Generation = g.Number
+	}
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		var f *os.File
+		f, err = os.OpenFile(filepath.Join(pm.statePath(), HISTORY_FILENAME), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		slog.Warn("failed to write to the history", "operation", operation, "err", err)
+	}
+}
+
+// readHistory returns every entry in the history at statePath, oldest first. A missing history is empty.
+func readHistory(statePath string) ([]historyEntry, error) {
+	f, err := os.Open(filepath.Join(statePath, HISTORY_FILENAME))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []historyEntry{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A line cut short by a crash shouldn't hide the rest of the history.
+			slog.Warn("skipping unreadable history entry", "path", f.Name(), "line", line, "err", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func actionHistory(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	entries, err := readHistory(pm.statePath())
+	if err != nil {
+		return err
+	}
+
+	name := cmd.Args().Get(0)
+	shown := []historyEntry{}
+	for _, entry := range entries {
+		if name == "" || entry.Package == name {
+			shown = append(shown, entry)
+		}
+	}
+	if limit := int(cmd.Int("limit")); limit > 0 && len(shown) > limit {
+		shown = shown[len(shown)-limit:]
+	}
+
+	if cmd.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(shown)
+	}
+	if len(shown) == 0 {
+		fmt.Println(tr("Nothing has been recorded yet."))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, tr("TIME\tGENERATION\tOPERATION\tPACKAGE\tUSER\tCOMMAND"))
+	for _, entry := range shown {
+		pkg := "-"
+		if entry.Package != "" {
+			pkg = entry.Package + " " + entry.Version
+		}
+		generation := "-"
+		if entry.Generation > 0 {
+			generation = strconv.Itoa(entry.Generation)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format(time.DateTime), generation, entry.Operation, pkg, entry.User, entry.Command)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// HTTP_MAX_REDIRECTS is how many redirects are followed before a request fails. Release assets are usually served
+// through one or two redirects to a CDN.
+const HTTP_MAX_REDIRECTS = 10
+
+// Defaults for how long requests may take, before --timeout or http.timeout in the config file are applied.
+const (
+	DEFAULT_CONNECT_TIMEOUT  = 30 * time.Second
+	DEFAULT_RESPONSE_TIMEOUT = 60 * time.Second
+)
+
+// httpTransport makes the connections for httpClient. It is configured from the config file and flags by
+// configureHttp before any request is made.
+var httpTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   DEFAULT_CONNECT_TIMEOUT,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: DEFAULT_RESPONSE_TIMEOUT,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// httpClient is used for every request infpm makes, so that connections are reused between API calls and downloads
+// and every request identifies itself. There is no overall timeout, since large downloads can take a long time;
+// instead, connecting and waiting for a response are bounded. Requests that fail in a way that may be temporary are
+// retried; see retryTransport.
+var httpClient = &http.Client{
+	Transport: &interruptTransport{base: &retryTransport{base: &userAgentTransport{
+		base: &debugTransport{base: httpTransport},
+	}}},
+	CheckRedirect: checkRedirect,
+}
+
+// configureHttp applies the http settings from the config file, with flags already applied to cfg, to httpTransport.
+// The proxy defaults to HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment, and CA certificates to the
+// system's, which SSL_CERT_FILE also overrides.
+func configureHttp(cfg HttpConfig) error {
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return withKind(errUsage, fmt.Errorf("invalid timeout %q; use e.g. 30s or 2m", cfg.Timeout))
+		}
+		httpTransport.DialContext = (&net.Dialer{Timeout: d, KeepAlive: 30 * time.Second}).DialContext
+		httpTransport.TLSHandshakeTimeout = d
+		httpTransport.ResponseHeaderTimeout = d
+	}
+
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" {
+			return withKind(errUsage, fmt.Errorf("invalid proxy URL %q", cfg.Proxy))
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return withKind(errUsage, fmt.Errorf("unsupported proxy scheme %q; use http, https or socks5", u.Scheme))
+		}
+		httpTransport.Proxy = http.ProxyURL(u)
+	}
+
+	if cfg.CaBundle == "" && !cfg.Insecure {
+		return nil
+	}
+	tlsConfig := &tls.Config{}
+	if cfg.CaBundle != "" {
+		pem, err := os.ReadFile(cfg.CaBundle)
+		if err != nil {
+			slog.Error("failed to read CA bundle", "path", cfg.CaBundle)
+			return err
+		}
+		// The bundle is added to the system's certificates, so that other hosts can still be reached.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return withKind(errUsage, fmt.Errorf("no PEM certificates found in CA bundle %s", cfg.CaBundle))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.Insecure {
+		slog.Warn("TLS certificates are not being verified, so downloads can be tampered with. Only use --insecure as a last resort")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	httpTransport.TLSClientConfig = tlsConfig
+	return nil
+}
+
+// userAgent identifies infpm to servers, e.g. "infpm/1.2.0 (linux/amd64)". GitHub rejects API requests without one,
+// and some hosts serve anonymous default clients differently.
+func userAgent() string {
+	return "infpm/" + version + " (" + runtime.GOOS + "/" + runtime.GOARCH + ")"
+}
+
+// userAgentTransport sets the User-Agent header on requests that don't already have one.
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		// RoundTrippers must not modify the request they are given.
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", userAgent())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// checkRedirect limits the number of redirects and refuses to be redirected from HTTPS to plain HTTP, which would
+// let anyone on the network tamper with the download. The client only drops the Authorization and Cookie headers
+// when it is redirected to another host, so the headers from --header and those configured for the original host
+// are dropped here too, as they may carry credentials for that host.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= HTTP_MAX_REDIRECTS {
+		return errors.New("stopped after too many redirects")
+	}
+	if via[len(via)-1].URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return errors.New("refusing to follow a redirect from HTTPS to " + req.URL.Redacted())
+	}
+	if from := via[0].URL; req.URL.Host != from.Host {
+		for name := range extraHeaders {
+			req.Header.Del(name)
+		}
+		for name := range config.Headers[from.Hostname()] {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// debugHttp is whether every HTTP request and response should be logged. Set with --debug-http.
+var debugHttp bool
+
+// sensitiveHeaders are never logged in full by --debug-http.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "Private-Token", "X-Api-Key"}
+
+// sensitiveParams are query parameters that carry credentials, e.g. in presigned asset URLs.
+var sensitiveParams = []string{"token", "access_token", "private_token", "signature", "x-amz-signature", "x-amz-credential", "sig"}
+
+// debugTransport logs each request and its response when debugHttp is set.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !debugHttp {
+		return t.base.RoundTrip(req)
+	}
+
+	slog.Debug("http request", "method", req.Method, "url", redactUrl(req.URL), "headers", redactHeaders(req.Header))
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		slog.Debug("http request failed", "method", req.Method, "url", redactUrl(req.URL), "elapsed", elapsed, "err", err)
+		return nil, err
+	}
+	slog.Debug("http response", "method", req.Method, "url", redactUrl(req.URL), "status", resp.StatusCode,
+		"proto", resp.Proto, "elapsed", elapsed, "headers", redactHeaders(resp.Header))
+	return resp, nil
+}
+
+// redactHeaders returns a copy of h with the values of sensitive headers replaced.
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range sensitiveHeaders {
+		vals := h.Values(name)
+		for i := range vals {
+			vals[i] = "REDACTED"
+		}
+	}
+	return h
+}
+
+// redactUrl returns u as a string with any password and credential-carrying query parameters replaced.
+func redactUrl(u *url.URL) string {
+	q := u.Query()
+	redacted := false
+	for key := range q {
+		for _, param := range sensitiveParams {
+			if strings.EqualFold(key, param) {
+				q.Set(key, "REDACTED")
+				redacted = true
+			}
+		}
+	}
+	if !redacted {
+		return u.Redacted()
+	}
+	cp := *u
+	cp.RawQuery = q.Encode()
+	return cp.Redacted()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Catalogues of translated messages, one TOML file per locale named after it, e.g. de.toml or pt_BR.toml. Each maps
+// an English message, as passed to tr, to its translation. Distributors can add locales without rebuilding infpm by
+// placing catalogues in the locales directory of the infpm config directory, which take precedence.
+//
+//go:embed locales/*.toml
+var bundledLocales embed.FS
+
+// messages is the catalogue for the selected locale. It is empty for English, or if no catalogue was found.
+var messages = map[string]string{}
+
+// tr translates a user-facing message into the selected locale, falling back to English if it hasn't been
+// translated. Like fmt.Sprintf, format may contain verbs, which are filled in with args.
+func tr(format string, args ...any) string {
+	if t, ok := messages[format]; ok && t != "" {
+		format = t
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// detectLocale returns the locale to use: the one configured, or else the one set in the environment, e.g. de_DE
+// for LANG=de_DE.UTF-8. Returns "" for the C/POSIX locale.
+func detectLocale(configured string) string {
+	locale := configured
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale != "" {
+			break
+		}
+		locale = os.Getenv(env)
+	}
+
+	// Strip the encoding and modifier, e.g. de_DE.UTF-8@euro.
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	if locale == "C" || locale == "POSIX" {
+		return ""
+	}
+	return locale
+}
+
+// setLocale loads the catalogue for locale, trying the language alone if there is none for its region, e.g. de for
+// de_AT. Missing catalogues aren't an error: messages are shown in English.
+func setLocale(locale string) error {
+	messages = map[string]string{}
+	if locale == "" {
+		return nil
+	}
+
+	candidates := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "_"); ok {
+		candidates = append(candidates, lang)
+	}
+	for _, c := range candidates {
+		cat, err := loadCatalogue(c)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		messages = cat
+		return nil
+	}
+	slog.Debug("no translations for locale, using English", "locale", locale)
+	return nil
+}
+
+// loadCatalogue reads the catalogue for locale from the config directory, or else from the bundled catalogues.
+func loadCatalogue(locale string) (map[string]string, error) {
+	cat := map[string]string{}
+	if dir, err := infpmConfigDir(); err == nil {
+		fp := filepath.Join(dir, "locales", locale+".toml")
+		_, err := toml.DecodeFile(fp, &cat)
+		if err == nil {
+			return cat, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("failed to read translations", "path", fp)
+			return nil, err
+		}
+	}
+
+	data, err := bundledLocales.ReadFile("locales/" + locale + ".toml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = toml.Decode(string(data), &cat)
+	return cat, err
+}
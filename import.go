@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// knownSources maps the names other package managers use for popular tools to where infpm can install them from.
+var knownSources = map[string]string{
+	"age":        "github.com/FiloSottile/age",
+	"bat":        "github.com/sharkdp/bat",
+	"bottom":     "github.com/ClementTsang/bottom",
+	"delta":      "github.com/dandavison/delta",
+	"git-delta":  "github.com/dandavison/delta",
+	"direnv":     "github.com/direnv/direnv",
+	"dust":       "github.com/bootandy/dust",
+	"eza":        "github.com/eza-community/eza",
+	"fd":         "github.com/sharkdp/fd",
+	"fzf":        "github.com/junegunn/fzf",
+	"gh":         "github.com/cli/cli",
+	"github-cli": "github.com/cli/cli",
+	"helm":       "github.com/helm/helm",
+	"hyperfine":  "github.com/sharkdp/hyperfine",
+	"jq":         "github.com/jqlang/jq",
+	"just":       "github.com/casey/just",
+	"k9s":        "github.com/derailed/k9s",
+	"lazygit":    "github.com/jesseduffield/lazygit",
+	"neovim":     "github.com/neovim/neovim",
+	"ripgrep":    "github.com/BurntSushi/ripgrep",
+	"rg":         "github.com/BurntSushi/ripgrep",
+	"shellcheck": "github.com/koalaman/shellcheck",
+	"starship":   "github.com/starship/starship",
+	"terraform":  "github.com/hashicorp/terraform",
+	"yq":         "github.com/mikefarah/yq",
+	"zoxide":     "github.com/ajeetdsouza/zoxide",
+}
+
+// importedPackage is a package installed by another package manager.
+type importedPackage struct {
+	Name    string
+	Version string
+	// Homepage is the package's homepage, if the other package manager knows it. GitHub homepages are used as the
+	// source if the package isn't in knownSources.
+	Homepage string
+}
+
+// importers read the packages installed by other package managers, keyed by the name given to import-from.
+var importers = map[string]func() ([]importedPackage, error){
+	"brew": importBrew,
+	"asdf": importAsdf,
+	"mise": importMise,
+}
+
+// sourceFor returns where infpm can install a package from, or "" if it isn't known.
+func sourceFor(pkg importedPackage) string {
+	if src, ok := knownSources[pkg.Name]; ok {
+		return src
+	}
+	// mise tools from the GitHub-based backends name their repository, e.g. ubi:BurntSushi/ripgrep.
+	for _, backend := range []string{"github:", "ubi:", "aqua:"} {
+		if repo, ok := strings.CutPrefix(pkg.Name, backend); ok && strings.Count(repo, "/") == 1 {
+			return "github.com/" + repo
+		}
+	}
+	if u, err := url.Parse(pkg.Homepage); err == nil && getGithubRepoName(u) != "" {
+		return u.Host + strings.TrimSuffix(u.Path, ".git")
+	}
+	return ""
+}
+
+// importedName returns the name a package from another package manager should have in infpm, e.g. ripgrep for
+// ubi:BurntSushi/ripgrep.
+func importedName(pkg importedPackage) string {
+	if i := strings.LastIndexAny(pkg.Name, ":/"); i >= 0 {
+		return pkg.Name[i+1:]
+	}
+	return pkg.Name
+}
+
+// importBrew lists the formulae installed with Homebrew. Casks are GUI apps, which infpm can't install.
+func importBrew() ([]importedPackage, error) {
+	out, err := exec.Command("brew", "info", "--json=v2", "--installed").Output()
+	if err != nil {
+		slog.Error("failed to list Homebrew formulae. Is brew installed and in PATH?")
+		return nil, err
+	}
+
+	var info struct {
+		Formulae []struct {
+			Name      string `json:"name"`
+			Homepage  string `json:"homepage"`
+			Installed []struct {
+				Version string `json:"version"`
+			} `json:"installed"`
+		} `json:"formulae"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, err
+	}
+
+	pkgs := []importedPackage{}
+	for _, f := range info.Formulae {
+		pkg := importedPackage{Name: f.Name, Homepage: f.Homepage}
+		if n := len(f.Installed); n > 0 {
+			// Homebrew appends _N to versions it has rebuilt, which isn't part of the upstream version.
+			pkg.Version, _, _ = strings.Cut(f.Installed[n-1].Version, "_")
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// importAsdf lists the tools installed with asdf, using the versions selected in ~/.tool-versions where there is
+// more than one.
+func importAsdf() ([]importedPackage, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	dataDir := os.Getenv("ASDF_DATA_DIR")
+	if dataDir == "" {
+		dataDir = filepath.Join(home, ".asdf")
+	}
+
+	selected := map[string]string{}
+	if tools, err := readToolVersions(filepath.Join(home, TOOL_VERSIONS_FILENAME)); err == nil {
+		for _, t := range tools {
+			selected[t.Name] = t.Version
+		}
+	}
+
+	plugins, err := os.ReadDir(filepath.Join(dataDir, "installs"))
+	if err != nil {
+		slog.Error("failed to list asdf installs. Is asdf installed?", "path", dataDir)
+		return nil, err
+	}
+
+	pkgs := []importedPackage{}
+	for _, plugin := range plugins {
+		versions, err := os.ReadDir(filepath.Join(dataDir, "installs", plugin.Name()))
+		if err != nil || len(versions) == 0 {
+			continue
+		}
+		pkg := importedPackage{Name: plugin.Name(), Version: versions[len(versions)-1].Name()}
+		if v, ok := selected[plugin.Name()]; ok && slices.ContainsFunc(versions, func(e os.DirEntry) bool { return e.Name() == v }) {
+			pkg.Version = v
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// importMise lists the tools installed with mise, using the active version where there is more than one.
+func importMise() ([]importedPackage, error) {
+	out, err := exec.Command("mise", "ls", "--installed", "--json").Output()
+	if err != nil {
+		slog.Error("failed to list mise tools. Is mise installed and in PATH?")
+		return nil, err
+	}
+
+	var tools map[string][]struct {
+		Version string `json:"version"`
+		Active  bool   `json:"active"`
+	}
+	if err := json.Unmarshal(out, &tools); err != nil {
+		return nil, err
+	}
+
+	pkgs := []importedPackage{}
+	for name, versions := range tools {
+		if len(versions) == 0 {
+			continue
+		}
+		pkg := importedPackage{Name: name, Version: versions[len(versions)-1].Version}
+		for _, v := range versions {
+			if v.Active {
+				pkg.Version = v.Version
+			}
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	slices.SortFunc(pkgs, func(a, b importedPackage) int { return strings.Compare(a.Name, b.Name) })
+	return pkgs, nil
+}
+
+func actionImportFrom(ctx context.Context, cmd *cli.Command) error {
+	from := cmd.Args().Get(0)
+	importer, ok := importers[from]
+	if !ok {
+		return withKind(errUsage, errors.New(tr("A package manager to import from is required: brew, asdf or mise. See --help import-from.")))
+	}
+
+	pkgs, err := importer()
+	if err != nil {
+		return err
+	}
+
+	output := cmd.String("output")
+	pf, err := readPackagesFile(output)
+	if err != nil {
+		return err
+	}
+
+	skipped := []string{}
+	imported := []string{}
+	for _, pkg := range pkgs {
+		src := sourceFor(pkg)
+		if src == "" {
+			skipped = append(skipped, pkg.Name)
+			continue
+		}
+		name := importedName(pkg)
+		if _, exists := pf.Packages[name]; exists {
+			slog.Info("package is already in the packages file, leaving it as is", "package", name, "path", output)
+			continue
+		}
+		pf.Packages[name] = PackageSpec{Source: src, Version: pkg.Version}
+		imported = append(imported, name)
+	}
+
+	if err := pf.Save(output); err != nil {
+		return err
+	}
+	fmt.Println(tr("Imported %d packages from %s into %s.", len(imported), from, output))
+	if len(skipped) > 0 {
+		fmt.Println(tr("infpm doesn't know where to install these from, so they were skipped: %s", strings.Join(skipped, ", ")))
+	}
+
+	if !cmd.Bool("install") || len(imported) == 0 {
+		return nil
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	ropts := resolveOpts{Platform: hostPlatform(), NonInteractive: nonInteractive}
+	failed := []string{}
+	for _, name := range imported {
+		if err := interrupted(); err != nil {
+			return err
+		}
+		if len(pm.db.Find(name)) > 0 {
+			slog.Info("package is already installed, skipping", "package", name)
+			ciResult(CI_SKIPPED, name, pf.Packages[name].Version, "already installed")
+			continue
+		}
+		spec := pf.Packages[name]
+		opts := PreinstallPackageOpts{Name: name, Version: spec.Version, Source: spec.Source, Platform: ropts.Platform}
+		if _, err := pm.InstallSource(spec.Source, false, opts, ropts); err != nil {
+			slog.Error("failed to install imported package", "package", name, "err", err)
+			ciResult(CI_FAILED, name, spec.Version, err.Error())
+			failed = append(failed, name)
+		}
+	}
+	printRehashHint()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to install %d imported packages: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// packageInfo is everything info shows about an installed package.
+type packageInfo struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Platform    string            `json:"platform"`
+	Source      string            `json:"source"`
+	Url         string            `json:"url"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	Files       int               `json:"files"`
+	InstalledAt time.Time         `json:"installedAt"`
+	LastUsedAt  *time.Time        `json:"lastUsedAt,omitempty"`
+	Commands    []string          `json:"commands"`
+	Links       []string          `json:"links"`
+	Checksums   map[string]string `json:"checksums,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Pinned      bool              `json:"pinned,omitempty"`
+}
+
+// packageInfo gathers the information info shows about a record.
+func (pm *PackageManager) packageInfo(rec *PackageRecord) packageInfo {
+	info := packageInfo{
+		Name:        rec.Name,
+		Version:     rec.Version,
+		Platform:    rec.Platform.String(),
+		Source:      rec.Source,
+		Url:         rec.Url,
+		Path:        filepath.Join(pm.StorePath, rec.Path),
+		Files:       len(rec.Files),
+		InstalledAt: rec.InstalledAt,
+		LastUsedAt:  rec.LastUsedAt,
+		Commands:    []string{},
+		Links:       []string{},
+		Checksums:   rec.Checksums,
+		Tags:        pm.db.Tags[rec.Name],
+		Pinned:      pm.db.IsPinned(rec.Name),
+	}
+	for _, f := range rec.Files {
+		info.Size += f.Size
+	}
+
+	binDir := pm.binDir()
+	for _, l := range rec.Links {
+		if filepath.Dir(l) == filepath.Clean(binDir) {
+			info.Commands = append(info.Commands, filepath.Base(l))
+		}
+		info.Links = append(info.Links, l)
+	}
+	slices.Sort(info.Commands)
+	return info
+}
+
+func actionInfo(ctx context.Context, cmd *cli.Command) error {
+	name := cmd.Args().Get(0)
+	if name == "" {
+		return withKind(errUsage, errors.New(tr("A package name is required. See --help info.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	recs := pm.db.Find(name)
+	if len(recs) == 0 {
+		return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+	}
+	if pm.sampleLastUsed() {
+		if err := pm.db.Save(); err != nil {
+			return err
+		}
+	}
+
+	infos := []packageInfo{}
+	for _, rec := range recs {
+		infos = append(infos, pm.packageInfo(rec))
+	}
+	if cmd.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(infos)
+	}
+
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		row := func(key string, value string) {
+			if value != "" {
+				fmt.Fprintf(w, "%s:\t%s\n", key, value)
+			}
+		}
+		row(tr("Name"), info.Name)
+		row(tr("Version"), info.Version)
+		row(tr("Platform"), info.Platform)
+		row(tr("Source"), info.Source)
+		if info.Url != info.Source {
+			row(tr("Downloaded from"), info.Url)
+		}
+		row(tr("Store path"), info.Path)
+		row(tr("Installed size"), tr("%s in %d files", formatBytes(uint64(info.Size)), info.Files))
+		row(tr("Installed"), info.InstalledAt.Local().Format(time.DateTime))
+		if info.LastUsedAt != nil {
+			row(tr("Last used"), info.LastUsedAt.Local().Format(time.DateTime))
+		} else {
+			row(tr("Last used"), tr("never"))
+		}
+		row(tr("Commands"), strings.Join(info.Commands, " "))
+		if others := len(info.Links) - len(info.Commands); others > 0 {
+			row(tr("Other links"), strconv.Itoa(others))
+		}
+		for _, algo := range slices.Sorted(maps.Keys(info.Checksums)) {
+			row(tr("Verified %s", algo), info.Checksums[algo])
+		}
+		row(tr("Tags"), strings.Join(info.Tags, ", "))
+		if info.Pinned {
+			row(tr("Pinned"), tr("yes"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
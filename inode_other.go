@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "io/fs"
+
+// fileIdentity can't identify files on this platform, so hard links are counted like copies.
+func fileIdentity(info fs.FileInfo) (fileId, bool) {
+	return fileId{}, false
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileIdentity identifies the file behind info, so that hard links to the same file can be told apart from copies.
+func fileIdentity(info fs.FileInfo) (fileId, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileId{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+	}
+	return fileId{}, false
+}
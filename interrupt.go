@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runCtx is cancelled when infpm is interrupted with Ctrl-C or terminated. Commands are run with it, and it is kept
+// here as well so that requests and installs deep within a command are cancelled without passing it to every call.
+var runCtx = context.Background()
+
+// interruptContext returns a context that is cancelled by the first SIGINT or SIGTERM, so that infpm can stop what
+// it is doing and clean up after itself. The signals are only caught once: a second Ctrl-C kills infpm straight away.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		slog.Warn("interrupted, cleaning up. Interrupt again to quit straight away")
+	}()
+	return ctx
+}
+
+// interrupted returns an error if infpm has been interrupted. Commands that work through several packages check it
+// between them, so that they stop once the package in progress has been dealt with.
+func interrupted() error {
+	if err := runCtx.Err(); err != nil {
+		return withKind(errInterrupted, err)
+	}
+	return nil
+}
+
+// interruptTransport cancels requests made without a context of their own when runCtx is cancelled, stopping
+// downloads part way through.
+type interruptTransport struct {
+	base http.RoundTripper
+}
+
+func (t *interruptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Context() == context.Background() {
+		req = req.WithContext(runCtx)
+	}
+	return t.base.RoundTrip(req)
+}
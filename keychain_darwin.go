@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keychainGet reads a provider's token from the macOS Keychain using the security tool.
+func keychainGet(provider string) (string, error) {
+	cmd := exec.Command("security", "find-generic-password", "-s", KEYCHAIN_SERVICE, "-a", provider, "-w")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		// security exits with 44 when the item could not be found.
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+			return "", errCredentialNotFound
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// keychainSet stores a provider's token in the macOS Keychain, replacing any existing item.
+// Giving the token to -w as an argument would show it in the process list, and security only prompts for it on a
+// terminal, so the command is written to security's interactive mode on stdin instead.
+func keychainSet(provider, token string) error {
+	line := strings.Join([]string{"add-generic-password", "-U", "-s", securityQuote(KEYCHAIN_SERVICE), "-a", securityQuote(provider), "-w", securityQuote(token)}, " ")
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(line + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	// In interactive mode, security exits successfully even if the command failed, so look for its complaints.
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for a command line read by security -i.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keychainGet reads a provider's token from the Secret Service (libsecret) using secret-tool.
+func keychainGet(provider string) (string, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", errKeychainUnsupported
+	}
+
+	cmd := exec.Command("secret-tool", "lookup", "service", KEYCHAIN_SERVICE, "provider", provider)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		// secret-tool exits with 1 and prints nothing when no item matches.
+		if errors.As(err, &exitErr) && stdout.Len() == 0 {
+			return "", errCredentialNotFound
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// keychainSet stores a provider's token in the Secret Service (libsecret) using secret-tool.
+// The token is passed on stdin so that it doesn't show up in the process list.
+func keychainSet(provider, token string) error {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return errors.New("secret-tool was not found; install libsecret-tools to use the keychain")
+	}
+
+	cmd := exec.Command("secret-tool", "store", "--label=infpm "+provider+" token", "service", KEYCHAIN_SERVICE, "provider", provider)
+	cmd.Stdin = strings.NewReader(token)
+	return cmd.Run()
+}
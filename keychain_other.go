@@ -0,0 +1,11 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+func keychainGet(provider string) (string, error) {
+	return "", errKeychainUnsupported
+}
+
+func keychainSet(provider, token string) error {
+	return errKeychainUnsupported
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modAdvapi32    = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = modAdvapi32.NewProc("CredReadW")
+	procCredWriteW = modAdvapi32.NewProc("CredWriteW")
+	procCredFree   = modAdvapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric      = 1
+	credPersistLocalUser = 2
+	errorNotFound        = syscall.Errno(1168)
+)
+
+// winCredential mirrors the CREDENTIALW struct used by the Windows Credential Manager.
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credentialTarget(provider string) string {
+	return KEYCHAIN_SERVICE + ":" + provider
+}
+
+// keychainGet reads a provider's token from the Windows Credential Manager.
+func keychainGet(provider string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(credentialTarget(provider))
+	if err != nil {
+		return "", err
+	}
+
+	var cred *winCredential
+	ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if errors.Is(err, errorNotFound) {
+			return "", errCredentialNotFound
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// keychainSet stores a provider's token in the Windows Credential Manager, replacing any existing credential.
+func keychainSet(provider, token string) error {
+	target, err := syscall.UTF16PtrFromString(credentialTarget(provider))
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(provider)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(token)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalUser,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	ret, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
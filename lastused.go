@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LAST_USED_GRACE is how long after installation accesses are ignored when sampling last-used times, since
+// installing a package reads every file to build its manifest.
+const LAST_USED_GRACE = time.Minute
+
+// sampleLastUsed updates the last-used time of every package from the access times of its executables, returning
+// whether any changed. Access times are only as precise as the filesystem keeps them: with relatime (the default on
+// Linux) they are updated at most daily, and with noatime never, in which case packages appear unused. Commands that
+// read every file, like verify --deep, also count as a use.
+func (pm *PackageManager) sampleLastUsed() bool {
+	changed := false
+	for _, rec := range pm.db.Packages {
+		fullPath := filepath.Join(pm.StorePath, rec.Path)
+		for _, f := range rec.Files {
+			if !f.Mode.IsRegular() || f.Mode&0111 == 0 {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(fullPath, filepath.FromSlash(f.Path)))
+			if err != nil {
+				continue
+			}
+			at := accessTime(info)
+			if !at.After(rec.InstalledAt.Add(LAST_USED_GRACE)) {
+				continue
+			}
+			if rec.LastUsedAt == nil || at.After(*rec.LastUsedAt) {
+				rec.LastUsedAt = &at
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// unusedSince reports whether the package was installed before cutoff and hasn't been used since, as far as
+// infpm can tell.
+func (rec *PackageRecord) unusedSince(cutoff time.Time) bool {
+	return rec.InstalledAt.Before(cutoff) && (rec.LastUsedAt == nil || rec.LastUsedAt.Before(cutoff))
+}
+
+// parseAge parses a duration like 90d, 2w or 36h. Days and weeks are accepted in addition to the units understood by
+// time.ParseDuration.
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+				return time.Duration(i) * unit, nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, withKind(errUsage, fmt.Errorf("invalid duration %q; use e.g. 90d, 2w or 36h", s))
+	}
+	return d, nil
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// plannedLink is a single link from a file in the store into the symlink root.
+type plannedLink struct {
+	Src string
+	Dst string
+}
+
+type linkContentType struct {
+	Type   string
+	Prefix string
+}
+
+// linkContentTypes are the kinds of content a package can link, which can each be linked into their own root with
+// the links table of the config file. They are identified by where they are found relative to the symlink root.
+// More specific types come first, so that e.g. man pages can be routed separately from the rest of share.
+var linkContentTypes = []linkContentType{
+	{"man", "share/man/"},
+	{"bash-completions", "share/bash-completion/completions/"},
+	{"zsh-completions", "share/zsh/site-functions/"},
+	{"zsh-completions", "share/zsh/vendor-completions/"},
+	{"fish-completions", "share/fish/vendor_completions.d/"},
+	{"fish-completions", "share/fish/completions/"},
+	{"bin", "bin/"},
+	{"lib", "lib/"},
+	{"share", "share/"},
+}
+
+// routeLink returns where a file that would be linked at rel, relative to symlinkPath, should be linked, using the
+// first configured root whose content type matches.
+func routeLink(symlinkPath string, roots map[string]string, rel string) string {
+	slashRel := filepath.ToSlash(rel)
+	for _, ct := range linkContentTypes {
+		root, ok := roots[ct.Type]
+		if !ok || root == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(slashRel, ct.Prefix); ok {
+			return filepath.Join(root, filepath.FromSlash(rest))
+		}
+	}
+	return filepath.Join(symlinkPath, rel)
+}
+
+// defaultLinkDirs are the directories that mark the top of a package's linkable tree, unless a layout says otherwise.
+var defaultLinkDirs = []string{"bin", "lib", "share"}
+
+// linkLayout overrides how planDefaultLinks finds the files of a package to link, for packages laid out in ways the
+// defaults miss. It is set per install or per recipe and recorded with the package, so it is used again on relinking.
+type linkLayout struct {
+	// Dirs are the names of the directories that mark the top of the package's linkable tree, e.g. sbin or libexec.
+	// Defaults to defaultLinkDirs.
+	Dirs []string `json:"dirs,omitempty"`
+	// BinFrom are globs, relative to the package, of the directories whose executables are linked into bin when the
+	// package has no linkable tree. Subdirectories aren't searched. Defaults to the whole package.
+	BinFrom []string `json:"binFrom,omitempty"`
+	// Bins are the paths, relative to the package, of the only executables to link, each into bin under its own
+	// name. If set, Dirs and BinFrom are ignored.
+	Bins []string `json:"bins,omitempty"`
+}
+
+// isDefault returns whether the layout changes nothing.
+func (l linkLayout) isDefault() bool {
+	return len(l.Dirs) == 0 && len(l.BinFrom) == 0 && len(l.Bins) == 0
+}
+
+// dirs returns the linkable tree's marker directories.
+func (l linkLayout) dirs() []string {
+	if len(l.Dirs) == 0 {
+		return defaultLinkDirs
+	}
+	return l.Dirs
+}
+
+// planLinks decides which files of a package at fullPath in the store should be linked, and where. See
+// planDefaultLinks; each link is then routed to the root configured for its content type, if there is one.
+func (opts PackageManagerOpts) planLinks(fullPath string, layout linkLayout) ([]plannedLink, error) {
+	links, err := planDefaultLinks(fullPath, opts.SymlinkPath, layout)
+	if err != nil || len(opts.LinkRoots) == 0 {
+		return links, err
+	}
+
+	for i, l := range links {
+		rel, err := filepath.Rel(opts.SymlinkPath, l.Dst)
+		if err != nil {
+			return nil, err
+		}
+		links[i].Dst = routeLink(opts.SymlinkPath, opts.LinkRoots, rel)
+	}
+	return links, nil
+}
+
+// binDir returns the directory executables are linked into.
+func (opts PackageManagerOpts) binDir() string {
+	return routeLink(opts.SymlinkPath, opts.LinkRoots, "bin/")
+}
+
+// planDefaultLinks decides which files of a package at fullPath in the store should be linked into symlinkPath. If the
+// package has a bin, lib or share directory (or one of the layout's), everything beside it is linked recursively;
+// otherwise, every executable is linked into the bin directory.
+func planDefaultLinks(fullPath string, symlinkPath string, layout linkLayout) ([]plannedLink, error) {
+	if len(layout.Bins) > 0 {
+		return planBinLinks(fullPath, symlinkPath, layout.Bins)
+	}
+	linkDirs := layout.dirs()
+	topLevel := ""
+	executables := []string{}
+	dirs := []string{}
+
+	slog.Info("walking package dir to find relevant files", "path", fullPath)
+	err := filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			// check if executable
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			if info.Mode()&0111 != 0 {
+				slog.Info("found an executable", "path", path)
+				executables = append(executables, path)
+			}
+			return nil
+		}
+
+		dirname := d.Name()
+		if dirname == UNITS_DIR {
+			return filepath.SkipDir
+		}
+		if topLevel != "" {
+			// We've already found a bin/lib/share dir, so add to the list of other dirs.
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		} else if slices.Contains(linkDirs, dirname) {
+			topLevel = filepath.Dir(path)
+			slog.Info("found a linkable directory, using new base dir", "path", topLevel, "dir", dirname)
+
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		}
+
+		// We haven't yet found a bin/lib/share dir, so continue until we do.
+		return nil
+	})
+	if err != nil {
+		slog.Error("failed to walk package directory", "path", fullPath)
+		return nil, err
+	}
+
+	links := []plannedLink{}
+	if topLevel == "" {
+		if len(layout.BinFrom) > 0 {
+			if executables, err = findExecutablesIn(fullPath, layout.BinFrom); err != nil {
+				return nil, err
+			}
+		}
+		for _, e := range executables {
+			links = append(links, plannedLink{Src: e, Dst: filepath.Join(symlinkPath, "bin", filepath.Base(e))})
+		}
+		return links, nil
+	}
+
+	for _, srcBase := range dirs {
+		err := filepath.WalkDir(srcBase, func(src string, info fs.DirEntry, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			relPath, err := filepath.Rel(topLevel, src)
+			if err != nil {
+				return err
+			}
+			links = append(links, plannedLink{Src: src, Dst: filepath.Join(symlinkPath, relPath)})
+			return nil
+		})
+		if err != nil {
+			slog.Error("failed to walk directory, continuing", "path", srcBase, "err", err)
+		}
+	}
+	return links, nil
+}
+
+// planBinLinks links only the executables at bins, relative to fullPath, into the bin directory. A missing one is an
+// error, as it means the package isn't laid out the way whoever listed them expected.
+func planBinLinks(fullPath string, symlinkPath string, bins []string) ([]plannedLink, error) {
+	links := []plannedLink{}
+	for _, b := range bins {
+		src := filepath.Join(fullPath, filepath.FromSlash(b))
+		if !filepath.IsLocal(filepath.FromSlash(b)) {
+			return nil, withKind(errUsage, fmt.Errorf("bin %q is outside of the package", b))
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, withKind(errUsage, fmt.Errorf("bin %q isn't in the package", b))
+		}
+		if info.IsDir() {
+			return nil, withKind(errUsage, fmt.Errorf("bin %q is a directory", b))
+		}
+		links = append(links, plannedLink{Src: src, Dst: filepath.Join(symlinkPath, "bin", filepath.Base(src))})
+	}
+	return links, nil
+}
+
+// findExecutablesIn returns the executables directly inside the directories of fullPath matched by globs. Globs may
+// also match executables themselves.
+func findExecutablesIn(fullPath string, globs []string) ([]string, error) {
+	executables := []string{}
+	add := func(path string, info fs.FileInfo) {
+		if info.Mode().IsRegular() && info.Mode()&0111 != 0 && !slices.Contains(executables, path) {
+			slog.Info("found an executable", "path", path)
+			executables = append(executables, path)
+		}
+	}
+
+	for _, g := range globs {
+		matches, err := filepath.Glob(filepath.Join(fullPath, filepath.FromSlash(g)))
+		if err != nil {
+			return nil, withKind(errUsage, fmt.Errorf("invalid glob %q: %w", g, err))
+		}
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(m, info)
+				continue
+			}
+
+			entries, err := os.ReadDir(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if info, err := e.Info(); err == nil {
+					add(filepath.Join(m, e.Name()), info)
+				}
+			}
+		}
+	}
+	return executables, nil
+}
+
+// linkPackage symlinks the relevant files of a package at fullPath in the store into the link roots, returning the
+// location of every link that now points into the package. See planLinks. Systemd user units the package ships are
+// linked too; see linkUnits.
+// Links that already exist and point to the right place are left alone, so this can be used to repair a package's
+// links. Packages built for a platform other than this machine's are refused.
+func (opts PackageManagerOpts) linkPackage(name string, platform Platform, fullPath string, layout linkLayout) ([]string, error) {
+	if !platform.IsHost() {
+		return nil, withKind(errConflict, fmt.Errorf("refusing to link %s: it was installed for %s, but this machine is %s", name, platform, hostPlatform()))
+	}
+
+	links, err := opts.planLinks(fullPath, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := []string{}
+	// created are the links made by this call, which are removed again if linking fails part way through.
+	created := []string{}
+	for _, l := range links {
+		if linkExists(l.Src, l.Dst) {
+			linked = append(linked, l.Dst)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(l.Dst), 0755); err != nil {
+			unlinkInto(created, fullPath)
+			return nil, err
+		}
+
+		if err := os.Symlink(l.Src, l.Dst); err != nil {
+			if linksInto(l.Dst, opts.versionsPath(name, platform)) {
+				slog.Info("another version of the package is linked here, leaving it; switch with infpm use", "path", l.Dst)
+			} else {
+				slog.Error("failed to link, continuing", "from", l.Src, "to", l.Dst, "err", err)
+			}
+		} else {
+			slog.Debug("linked file", "from", l.Src, "to", l.Dst)
+			progress.Emit(progressEvent{Event: PROGRESS_LINK, Package: name, Path: l.Dst, Target: l.Src})
+			linked = append(linked, l.Dst)
+			created = append(created, l.Dst)
+		}
+	}
+
+	units, err := linkUnits(fullPath, links, opts.binDir())
+	if err != nil {
+		unlinkInto(created, fullPath)
+		return nil, err
+	}
+	return append(linked, units...), nil
+}
+
+// checkLinkConflicts returns an errConflict error naming the files in the link roots that would stop a package at
+// fullPath in the store from being linked. Links into other versions of the same package aren't conflicts: they are
+// left for use to switch.
+func (opts PackageManagerOpts) checkLinkConflicts(name string, platform Platform, fullPath string, layout linkLayout) error {
+	links, err := opts.planLinks(fullPath, layout)
+	if err != nil {
+		return err
+	}
+	conflicts := []string{}
+	for _, l := range links {
+		if _, err := os.Lstat(l.Dst); os.IsNotExist(err) || linkExists(l.Src, l.Dst) || linksInto(l.Dst, opts.versionsPath(name, platform)) {
+			continue
+		}
+		conflicts = append(conflicts, l.Dst)
+	}
+	if len(conflicts) > 0 {
+		return withKind(errConflict, fmt.Errorf("%s can't be linked, because these already exist and aren't links into it: %s", name, strings.Join(conflicts, ", ")))
+	}
+	return nil
+}
+
+// versionsPath returns the directory in the store that every version of a package for platform is kept in.
+func (opts PackageManagerOpts) versionsPath(name string, platform Platform) string {
+	return filepath.Join(opts.StorePath, platform.Dir(), name)
+}
+
+// mergeLinks adds the links in more to links, without duplicates.
+func mergeLinks(links []string, more []string) []string {
+	for _, l := range more {
+		if !slices.Contains(links, l) {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// linksInto returns whether dst is a symlink pointing to somewhere within fullPath.
+func linksInto(dst string, fullPath string) bool {
+	target, err := os.Readlink(dst)
+	return err == nil && (target == fullPath || strings.HasPrefix(target, fullPath+string(filepath.Separator)))
+}
+
+// linkExists returns whether dst is already a symlink to src.
+func linkExists(src string, dst string) bool {
+	target, err := os.Readlink(dst)
+	return err == nil && target == src
+}
+
+// writeWrappers is the portable alternative to linkPackage. Instead of symlinking, each executable that would be
+// linked into bin gets a shell script which finds the executable relative to its own location, so the whole
+// symlinkPath directory can be moved or copied to another machine.
+func writeWrappers(name string, fullPath string, symlinkPath string, layout linkLayout) error {
+	links, err := planDefaultLinks(fullPath, symlinkPath, layout)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(symlinkPath, "bin")
+	for _, l := range links {
+		if filepath.Dir(l.Dst) != binDir {
+			slog.Debug("portable: not linking non-executable, it is available in the package directory", "path", l.Src)
+			continue
+		}
+
+		rel, err := filepath.Rel(binDir, l.Src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(binDir, 0755); err != nil {
+			return err
+		}
+
+		script := "#!/bin/sh\n" +
+			"# Generated by infpm for " + name + ". Runs the package's executable relative to this script.\n" +
+			"here=\"$(cd \"$(dirname \"$0\")\" && pwd)\"\n" +
+			"exec \"$here/" + strings.ReplaceAll(filepath.ToSlash(rel), "\"", "\\\"") + "\" \"$@\"\n"
+		if err := os.WriteFile(l.Dst, []byte(script), 0755); err != nil {
+			slog.Error("failed to write wrapper, continuing", "to", l.Dst, "err", err)
+			continue
+		}
+		progress.Emit(progressEvent{Event: PROGRESS_LINK, Package: name, Path: l.Dst, Target: l.Src})
+	}
+	return nil
+}
+
+// shadowedCommand is an executable that a package links into bin, which also exists elsewhere in PATH.
+type shadowedCommand struct {
+	Name string
+	// Others are the other copies found in PATH, in PATH order.
+	Others []string
+	// Wins is whether infpm's copy comes first in PATH, i.e. whether it is the one that will be run.
+	Wins bool
+	// OnPath is whether infpm's bin directory is in PATH at all.
+	OnPath bool
+}
+
+// findShadowedCommands checks whether any of the executables a package would link into bin already exist in
+// other PATH directories, e.g. from an older manual install.
+func findShadowedCommands(links []plannedLink, binDir string) []shadowedCommand {
+	linkDir := filepath.Clean(binDir)
+	binDir, err := filepath.Abs(binDir)
+	if err != nil {
+		return nil
+	}
+	pathDirs := filepath.SplitList(os.Getenv("PATH"))
+
+	shadowed := []shadowedCommand{}
+	for _, l := range links {
+		if filepath.Dir(l.Dst) != linkDir {
+			continue
+		}
+
+		cmd := shadowedCommand{Name: filepath.Base(l.Dst)}
+		for _, dir := range pathDirs {
+			absDir, err := filepath.Abs(dir)
+			if err != nil {
+				continue
+			}
+			if absDir == binDir {
+				cmd.OnPath = true
+				cmd.Wins = len(cmd.Others) == 0
+				continue
+			}
+			if found := findExecutable(absDir, cmd.Name); found != "" && !slices.Contains(cmd.Others, found) {
+				cmd.Others = append(cmd.Others, found)
+			}
+		}
+
+		if len(cmd.Others) > 0 {
+			shadowed = append(shadowed, cmd)
+		}
+	}
+	return shadowed
+}
+
+// findExecutable returns the path of the executable called name in dir, or "" if there isn't one.
+func findExecutable(dir string, name string) string {
+	candidates := []string{filepath.Join(dir, name)}
+	if runtime.GOOS == "windows" {
+		for _, ext := range filepath.SplitList(os.Getenv("PATHEXT")) {
+			candidates = append(candidates, filepath.Join(dir, name+strings.ToLower(ext)))
+		}
+	}
+
+	for _, c := range candidates {
+		info, err := os.Stat(c)
+		if err == nil && !info.IsDir() && (runtime.GOOS == "windows" || info.Mode()&0111 != 0) {
+			return c
+		}
+	}
+	return ""
+}
+
+// warnShadowedCommands logs a warning for every executable of the package at fullPath that also exists elsewhere in
+// PATH, saying which copy will be run.
+func (opts PackageManagerOpts) warnShadowedCommands(fullPath string, layout linkLayout) {
+	links, err := opts.planLinks(fullPath, layout)
+	if err != nil {
+		return
+	}
+
+	for _, cmd := range findShadowedCommands(links, opts.binDir()) {
+		switch {
+		case !cmd.OnPath:
+			slog.Warn("command exists elsewhere in PATH, and infpm's bin directory isn't in PATH, so the other copy will be run",
+				"command", cmd.Name, "others", cmd.Others)
+		case cmd.Wins:
+			slog.Warn("command also exists elsewhere in PATH; infpm's copy comes first and will be run",
+				"command", cmd.Name, "others", cmd.Others)
+		default:
+			slog.Warn("command exists earlier in PATH, so that copy will be run instead of infpm's",
+				"command", cmd.Name, "winner", cmd.Others[0], "others", cmd.Others)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// listedPackage is a package as shown by list --json.
+type listedPackage struct {
+	Name        string     `json:"name"`
+	Version     string     `json:"version"`
+	Platform    string     `json:"platform"`
+	Source      string     `json:"source"`
+	Path        string     `json:"path"`
+	InstalledAt time.Time  `json:"installedAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	Pinned      bool       `json:"pinned,omitempty"`
+	InUse       bool       `json:"inUse"`
+}
+
+func actionList(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	if pm.sampleLastUsed() {
+		if err := pm.db.Save(); err != nil {
+			return err
+		}
+	}
+
+	var cutoff time.Time
+	if unused := cmd.String("unused"); unused != "" {
+		age, err := parseAge(unused)
+		if err != nil {
+			return err
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	tag := cmd.String("tag")
+	tagged := pm.db.NamesWithTag(tag)
+	recs := []*PackageRecord{}
+	for _, rec := range pm.db.Packages {
+		if tag != "" && !slices.Contains(tagged, rec.Name) {
+			continue
+		}
+		if !cutoff.IsZero() && !rec.unusedSince(cutoff) {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	slices.SortStableFunc(recs, func(a, b *PackageRecord) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return a.InstalledAt.Compare(b.InstalledAt)
+	})
+
+	if cmd.Bool("json") {
+		listed := []listedPackage{}
+		for _, rec := range recs {
+			listed = append(listed, listedPackage{
+				Name:        rec.Name,
+				Version:     rec.Version,
+				Platform:    rec.Platform.String(),
+				Source:      rec.Source,
+				Path:        filepath.Join(pm.StorePath, rec.Path),
+				InstalledAt: rec.InstalledAt,
+				LastUsedAt:  rec.LastUsedAt,
+				Pinned:      pm.db.IsPinned(rec.Name),
+				InUse:       pm.db.inUse(rec),
+			})
+		}
+		return json.NewEncoder(os.Stdout).Encode(listed)
+	}
+
+	if len(recs) == 0 {
+		if tag != "" {
+			fmt.Println(tr("No installed packages are tagged %s.", tag))
+		} else if cutoff.IsZero() {
+			fmt.Println(tr("No packages are installed."))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, tr("NAME\tVERSION\tINSTALLED\tLAST USED\tPATH"))
+	for _, rec := range recs {
+		name := rec.Name
+		if !rec.Platform.IsHost() {
+			name += " (" + rec.Platform.String() + ")"
+		}
+		if pm.db.IsPinned(rec.Name) {
+			name += " " + tr("(pinned)")
+		}
+		if len(pm.db.Find(rec.Name)) > 1 && pm.db.inUse(rec) {
+			name += " " + tr("(in use)")
+		}
+		lastUsed := "-"
+		if rec.LastUsedAt != nil {
+			lastUsed = rec.LastUsedAt.Local().Format(time.DateOnly)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, rec.Version, rec.InstalledAt.Local().Format(time.DateOnly), lastUsed, filepath.Join(pm.StorePath, rec.Path))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// STORE_LOCK_FILENAME is the name of the file, kept beside the package database, that infpm processes lock while they
+// use the store. It contains the PID of the process holding the lock.
+const STORE_LOCK_FILENAME = "store.lock"
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// storeLock is an exclusive lock on a store, held so that two infpm processes can't change the symlink tree and
+// database at the same time. The operating system releases it if infpm exits without unlocking.
+type storeLock struct {
+	f *os.File
+}
+
+// lockStore takes the lock on the store whose database is kept in statePath. If another process holds it, this
+// waits for it to be released if wait is set, and fails otherwise.
+func lockStore(statePath string, wait bool) (*storeLock, error) {
+	if err := os.MkdirAll(statePath, 0755); err != nil {
+		slog.Error("failed to create state directory, do we have permission?", "statePath", statePath)
+		return nil, err
+	}
+	fp := filepath.Join(statePath, STORE_LOCK_FILENAME)
+	f, err := os.OpenFile(fp, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		slog.Error("failed to open store lock", "path", fp)
+		return nil, err
+	}
+
+	err = lockFile(f, false)
+	if errors.Is(err, errLocked) {
+		holder := lockHolder(f)
+		if !wait {
+			f.Close()
+			return nil, withKind(errBusy, fmt.Errorf("another infpm process%s is using the store. Wait for it to finish, or run again with --wait.", holder))
+		}
+		slog.Warn("another infpm process" + holder + " is using the store, waiting for it to finish")
+		err = lockFile(f, true)
+	}
+	if err != nil {
+		f.Close()
+		slog.Error("failed to lock the store", "path", fp)
+		return nil, err
+	}
+
+	// Record who holds the lock, for the message shown to processes that have to wait.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	slog.Debug("locked the store", "path", fp)
+	return &storeLock{f: f}, nil
+}
+
+// lockHolder describes the process holding the lock on f, e.g. " (PID 1234)", or returns "" if it isn't known.
+func lockHolder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(" (PID %d)", pid)
+}
+
+// held returns whether the lock is still held. It is safe to call on a nil lock.
+func (l *storeLock) held() bool {
+	return l != nil && l.f != nil
+}
+
+// Unlock releases the lock. It is safe to call on a nil lock.
+func (l *storeLock) Unlock() {
+	if l == nil || l.f == nil {
+		return
+	}
+	unlockFile(l.f)
+	l.f.Close()
+	l.f = nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing where file locking isn't supported, so concurrent infpm processes aren't prevented.
+func lockFile(f *os.File, block bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = modKernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modKernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	LOCKFILE_FAIL_IMMEDIATELY = 0x1
+	LOCKFILE_EXCLUSIVE_LOCK   = 0x2
+	ERROR_LOCK_VIOLATION      = syscall.Errno(33)
+)
+
+// lockFile takes an exclusive lock on f, returning errLocked if another process holds it, unless block is set.
+func lockFile(f *os.File, block bool) error {
+	flags := uintptr(LOCKFILE_EXCLUSIVE_LOCK)
+	if !block {
+		flags |= LOCKFILE_FAIL_IMMEDIATELY
+	}
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == ERROR_LOCK_VIOLATION {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v3"
+)
+
+// LOCK_FILENAME is the default name of a lockfile, which records exactly what was installed so that it can be
+// installed again elsewhere.
+const LOCK_FILENAME = "infpm.lock"
+
+// Lockfile records the exact tarball each package was installed from, so that the same bytes can be installed again
+// on another machine or later on. It is generated by infpm lock and read by infpm install --locked:
+//
+//	[[package]]
+//	name = "ripgrep"
+//	source = "github.com/BurntSushi/ripgrep"
+//	version = "14.1.0"
+//	platform = "linux/amd64"
+//	url = "https://github.com/BurntSushi/ripgrep/releases/download/14.1.0/ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz"
+//	sha256 = "..."
+type Lockfile struct {
+	Packages []LockedPackage `toml:"package"`
+}
+
+// LockedPackage is a single package in a lockfile.
+type LockedPackage struct {
+	Name string `toml:"name"`
+	// Source is the URL the package was installed from, as it was given to infpm install.
+	Source  string `toml:"source"`
+	Version string `toml:"version"`
+	// Platform is the OS and architecture the tarball was built for, e.g. linux/amd64.
+	Platform string `toml:"platform"`
+	// Url is the tarball the package was resolved to and extracted from.
+	Url string `toml:"url"`
+	// Sha256 is the hex SHA-256 digest the tarball must have.
+	Sha256 string `toml:"sha256"`
+}
+
+// readLockfile reads the lockfile at fp, returning an empty one if it doesn't exist.
+func readLockfile(fp string) (*Lockfile, error) {
+	lf := &Lockfile{}
+	if _, err := toml.DecodeFile(fp, lf); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return lf, nil
+		}
+		slog.Error("failed to read lockfile", "path", fp)
+		return nil, err
+	}
+	return lf, nil
+}
+
+// Save writes the lockfile to fp.
+func (lf *Lockfile) Save(fp string) error {
+	f, err := os.Create(fp)
+	if err != nil {
+		slog.Error("failed to write lockfile", "path", fp)
+		return err
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, "# This file is generated by infpm lock. Install exactly what it records with infpm install --locked."); err != nil {
+		return err
+	}
+	if err := toml.NewEncoder(f).Encode(lf); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// set adds a locked package, replacing the one with the same name and platform if there is one.
+func (lf *Lockfile) set(pkg LockedPackage) {
+	i := slices.IndexFunc(lf.Packages, func(p LockedPackage) bool {
+		return p.Name == pkg.Name && p.Platform == pkg.Platform
+	})
+	if i >= 0 {
+		lf.Packages[i] = pkg
+	} else {
+		lf.Packages = append(lf.Packages, pkg)
+	}
+	slices.SortFunc(lf.Packages, func(a LockedPackage, b LockedPackage) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Platform, b.Platform)
+	})
+}
+
+// lockedPackage returns the lockfile entry for an installed package. Packages installed before digests were recorded
+// are hashed from their cached tarball, if it is still there.
+func lockedPackage(rec *PackageRecord) (LockedPackage, error) {
+	digest := rec.Sha256
+	if digest == "" && rec.Tarball != "" {
+		var err error
+		if digest, err = hashFile(rec.Tarball); err != nil {
+			slog.Debug("failed to hash cached tarball", "path", rec.Tarball, "err", err)
+		}
+	}
+	if digest == "" {
+		return LockedPackage{}, fmt.Errorf("the digest of %s's tarball wasn't recorded and it isn't cached; reinstall it to lock it", rec.Name)
+	}
+	if !strings.Contains(rec.Url, "://") {
+		return LockedPackage{}, fmt.Errorf("%s was installed from a local file, which can't be locked", rec.Name)
+	}
+	return LockedPackage{
+		Name:     rec.Name,
+		Source:   rec.Source,
+		Version:  rec.Version,
+		Platform: rec.Platform.String(),
+		Url:      rec.Url,
+		Sha256:   digest,
+	}, nil
+}
+
+func actionLock(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	fp := cmd.String("output")
+	lf, err := readLockfile(fp)
+	if err != nil {
+		return err
+	}
+
+	// Entries for other platforms are kept, so that one lockfile can be generated from several machines.
+	locked := 0
+	for _, name := range pm.db.Names() {
+		// With several versions installed, the most recently installed one is locked.
+		var latest *PackageRecord
+		for _, rec := range pm.db.Find(name) {
+			if latest == nil || rec.InstalledAt.After(latest.InstalledAt) {
+				latest = rec
+			}
+		}
+		pkg, err := lockedPackage(latest)
+		if err != nil {
+			slog.Warn("not locking package", "package", name, "err", err)
+			continue
+		}
+		lf.set(pkg)
+		locked++
+	}
+
+	if err := lf.Save(fp); err != nil {
+		return err
+	}
+	fmt.Println(tr("Locked %d packages in %s.", locked, fp))
+	return nil
+}
+
+// installLocked installs the packages in the lockfile at fp built for ropts.Platform, or only the named ones if names
+// isn't empty. Each tarball is downloaded from its locked URL and must match its locked digest.
+func (pm *PackageManager) installLocked(fp string, names []string, ropts resolveOpts) error {
+	lf, err := readLockfile(fp)
+	if err != nil {
+		return err
+	}
+
+	// The locked digests pin the exact bytes, which were verified when the lockfile was generated.
+	ropts.NoVerify = true
+	platform := ropts.Platform.String()
+	entries := []LockedPackage{}
+	for _, entry := range lf.Packages {
+		if entry.Platform == platform && (len(names) == 0 || slices.Contains(names, entry.Name)) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return withKind(errUsage, errors.New(tr("No packages for %s are locked in %s. Generate it with infpm lock.", platform, fp)))
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(entries, func(e LockedPackage) bool { return e.Name == name }) {
+			return withKind(errUsage, fmt.Errorf("%s isn't locked for %s in %s", name, platform, fp))
+		}
+	}
+
+	linked := false
+	failed := []string{}
+	for _, entry := range entries {
+		if err := interrupted(); err != nil {
+			return err
+		}
+		current := false
+		for _, rec := range pm.db.Find(entry.Name) {
+			if rec.Platform.String() != platform {
+				continue
+			}
+			if rec.Version == entry.Version && rec.Sha256 == entry.Sha256 {
+				current = true
+				continue
+			}
+			// Anything else, including the same version from another tarball, would hold on to the links.
+			slog.Info("removing package that differs from the lockfile", "package", entry.Name, "version", rec.Version)
+			if err := pm.Uninstall(rec); err != nil {
+				return withPackage(err, entry.Name, entry.Url)
+			}
+		}
+		if current {
+			slog.Info("locked version is already installed", "package", entry.Name, "version", entry.Version)
+			ciResult(CI_SKIPPED, entry.Name, entry.Version, "already installed")
+			continue
+		}
+
+		digest, err := parseChecksum("sha256:" + entry.Sha256)
+		if err != nil {
+			return withPackage(err, entry.Name, entry.Url)
+		}
+		opts := PreinstallPackageOpts{
+			Name:      entry.Name,
+			Version:   entry.Version,
+			Source:    entry.Source,
+			Platform:  ropts.Platform,
+			Checksums: []checksum{digest},
+		}
+		// The locked URL is the tarball itself, so it is downloaded as is rather than resolved again.
+		pkg, err := pm.InstallSource(entry.Url, false, opts, ropts)
+		if err != nil {
+			slog.Error("failed to install locked package", "package", entry.Name, "version", entry.Version, "err", err)
+			ciResult(CI_FAILED, entry.Name, entry.Version, err.Error())
+			failed = append(failed, entry.Name)
+			continue
+		}
+		linked = linked || pkg.Symlinked
+	}
+
+	if linked && !pm.Portable {
+		printRehashHint()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to install %d locked packages: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -3,120 +3,1244 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/urfave/cli/v3"
 )
 
-const (
-	DEFAULT_STORE_PATH   = "./test/infpm/store"
-	DEFAULT_SYMLINK_PATH = "./test/infpm/root"
-)
-
-// TODO: See if there's any more of these to add.
-var alternativeArchKeywords = map[string]string{"darwin": "macos", "amd64": "x86"}
+// logLevel is the least severe level that is logged, set with --log-level or log_level in the config.
+var logLevel = &slog.LevelVar{}
 
 func main() {
 	slogHdl := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
+		Level: logLevel,
 	})
 	slog.SetDefault(slog.New(slogHdl))
 
 	cmd := &cli.Command{
-		Name:  "infpm",
-		Usage: "A minimal rootless package manager",
+		Name:                  "infpm",
+		Usage:                 "A minimal rootless package manager",
+		Description:           EXIT_CODES_HELP,
+		EnableShellCompletion: true,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Log as JSON and print errors as structured JSON objects to stderr. Commands like list and search print their results as JSON too.",
+			},
+			&cli.StringFlag{
+				Name:  "progress",
+				Usage: "Emit progress events to stdout in the given format. Only json (newline-delimited) is supported.",
+			},
+			&cli.StringFlag{
+				Name:    "tmpdir",
+				Usage:   "Download into `DIR` rather than the system temporary directory. Use a directory on the same filesystem as the store for atomic installs.",
+				Sources: cli.EnvVars("INFPM_TMPDIR"),
+			},
+			&cli.BoolFlag{
+				Name:    "ci",
+				Usage:   "Run unattended: never prompt, fail on ambiguity, log to stderr and print one result line per package.",
+				Sources: cli.EnvVars("INFPM_CI"),
+			},
+			&cli.BoolFlag{
+				Name:    "debug-http",
+				Usage:   "Log every HTTP request and response, with credentials redacted.",
+				Sources: cli.EnvVars("INFPM_DEBUG_HTTP"),
+			},
+			&cli.StringFlag{
+				Name:    "store",
+				Usage:   "Install packages into `DIR`, e.g. to prepare packages for another machine with --os and --arch. Overrides store in the config file.",
+				Sources: cli.EnvVars("INFPM_STORE"),
+			},
+			&cli.StringFlag{
+				Name:    "root",
+				Usage:   "Link packages into bin, lib and share within `DIR`. Overrides root in the config file.",
+				Sources: cli.EnvVars("INFPM_ROOT"),
+			},
+			&cli.StringFlag{
+				Name:    "cache-dir",
+				Usage:   "Keep downloaded tarballs in `DIR`. Overrides cache in the config file.",
+				Sources: cli.EnvVars("INFPM_CACHE"),
+			},
+			&cli.BoolFlag{
+				Name:    "no-cache",
+				Usage:   "Don't keep downloaded tarballs once they are installed.",
+				Sources: cli.EnvVars("INFPM_NO_CACHE"),
+			},
+			&cli.BoolFlag{
+				Name:    "non-interactive",
+				Usage:   "Never prompt, as if -y was given: pick the best matching asset, failing if several match equally well.",
+				Sources: cli.EnvVars("INFPM_NON_INTERACTIVE"),
+			},
+			&cli.BoolFlag{
+				Name:    "wait",
+				Usage:   "If another infpm process is using the store, wait for it to finish instead of failing.",
+				Sources: cli.EnvVars("INFPM_WAIT"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Usage:   "Make each request up to `N` times if it fails with a server error or times out. Overrides retry.attempts in the config file.",
+				Sources: cli.EnvVars("INFPM_RETRIES"),
+			},
+			&cli.StringFlag{
+				Name:    "timeout",
+				Usage:   "Wait at most `DURATION`, e.g. 30s, to connect and for a server to respond. Downloads can take longer once they have started.",
+				Sources: cli.EnvVars("INFPM_TIMEOUT"),
+			},
+			&cli.StringFlag{
+				Name:    "proxy",
+				Usage:   "Connect through the proxy at `URL`: http://, https:// or socks5://. Defaults to HTTP_PROXY and HTTPS_PROXY.",
+				Sources: cli.EnvVars("INFPM_PROXY"),
+			},
+			&cli.StringFlag{
+				Name:    "ca-bundle",
+				Usage:   "Also trust the PEM certificates in `FILE`, e.g. a corporate CA that intercepts TLS.",
+				Sources: cli.EnvVars("INFPM_CA_BUNDLE"),
+			},
+			&cli.BoolFlag{
+				Name:    "insecure",
+				Usage:   "Don't verify TLS certificates. Anyone on the network can then tamper with downloads, so prefer --ca-bundle.",
+				Sources: cli.EnvVars("INFPM_INSECURE"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "header",
+				Aliases: []string{"H"},
+				Usage:   "Send the header `'NAME: VALUE'` when downloading, e.g. 'Authorization: Bearer TOKEN' for a private server. Can be given more than once.",
+				Sources: cli.EnvVars("INFPM_HEADER"),
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Usage:   "Only log messages at `LEVEL` or above: debug, info, warn or error. Overrides log_level in the config file.",
+				Sources: cli.EnvVars("INFPM_LOG_LEVEL"),
+			},
+		},
+		Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+			// Keep stdout clean for machine-readable output.
+			if cmd.Bool("json") {
+				slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+					Level: logLevel,
+				})))
+			} else if cmd.String("progress") != "" || cmd.Bool("ci") {
+				slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+					Level: logLevel,
+				})))
+			}
+
+			invocation = strings.Join(cmd.Args().Slice(), " ")
+			debugHttp = cmd.Bool("debug-http")
+			ciMode = cmd.Bool("ci")
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return ctx, err
+			}
+			config = cfg
+			if err := setLocale(detectLocale(config.Locale)); err != nil {
+				return ctx, err
+			}
+			if dir := cmd.String("tmpdir"); dir != "" {
+				config.TempDir = dir
+			}
+			if level := cmd.String("log-level"); level != "" {
+				config.LogLevel = level
+			}
+			if config.LogLevel != "" {
+				level, err := parseLogLevel(config.LogLevel)
+				if err != nil {
+					return ctx, err
+				}
+				logLevel.Set(level)
+			}
+			nonInteractive = ciMode || config.NonInteractive || cmd.Bool("non-interactive")
+			if retry, err = config.Retry.policy(); err != nil {
+				return ctx, err
+			}
+			for flag, dst := range map[string]*string{"timeout": &config.HTTP.Timeout, "proxy": &config.HTTP.Proxy, "ca-bundle": &config.HTTP.CaBundle} {
+				if v := cmd.String(flag); v != "" {
+					*dst = v
+				}
+			}
+			config.HTTP.Insecure = config.HTTP.Insecure || cmd.Bool("insecure")
+			if err := configureHttp(config.HTTP); err != nil {
+				return ctx, err
+			}
+			for _, h := range cmd.StringSlice("header") {
+				name, value, err := parseHeader(h)
+				if err != nil {
+					return ctx, err
+				}
+				extraHeaders.Add(name, value)
+			}
+			if cmd.IsSet("retries") {
+				n := cmd.Int("retries")
+				if n < 1 {
+					return ctx, withKind(errUsage, errors.New(tr("--retries must be at least 1.")))
+				}
+				retry.Attempts = int(n)
+			}
+
+			switch cmd.String("progress") {
+			case "":
+			case "json":
+				progress = newProgressEmitter(os.Stdout)
+			default:
+				return ctx, withKind(errUsage, errors.New("--progress only supports json"))
+			}
+			return ctx, nil
+		},
 		Commands: []*cli.Command{
+			{
+				Name: "setup",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Don't ask anything: keep the configured symlink root and print what to add to your shell's startup file.",
+					},
+				},
+				Usage: "Set up infpm for the first time",
+				Description: "Creates the store, asks where packages should be linked, e.g. ~/.local or ~/.infpm/root, and saves the\n" +
+					"choice to the config file. If the bin directory isn't in PATH, offers to add it in your shell's startup file.",
+				Action: actionSetup,
+			},
+			{
+				Name:      "completion",
+				ArgsUsage: "<bash|zsh|fish>",
+				Usage:     "Print a shell completion script for infpm",
+				Description: "Completes commands, flags and the names of installed packages. Load it in your shell's startup file:\n" +
+					"source <(infpm completion bash), source <(infpm completion zsh), or infpm completion fish | source.",
+				Action: actionCompletion,
+			},
+			{
+				Name: "env",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "shell",
+						Usage: "Print code for `SHELL`: bash, zsh, sh or fish. Defaults to the shell infpm was run from.",
+					},
+					&cli.BoolFlag{
+						Name:  "lib",
+						Usage: "Add the linked libraries to LD_LIBRARY_PATH too (DYLD_LIBRARY_PATH on macOS).",
+					},
+					&cli.BoolFlag{
+						Name:  "rehash",
+						Usage: "Also make the shell forget cached command locations before every prompt, so new commands are found straight away.",
+					},
+				},
+				Usage: "Print shell code that adds the symlink root to PATH and MANPATH",
+				Description: "Prints export lines for the linked bin and man directories, taking configured link roots into account.\n" +
+					"Add eval \"$(infpm env)\" to your shell's startup file, or infpm env --shell fish | source for fish.\n" +
+					"Directories already in a variable aren't added again.",
+				Action: actionEnv,
+			},
 			{
 				Name:      "install",
 				Aliases:   []string{"i"},
-				ArgsUsage: "<url|filepath>",
-				Flags: []cli.Flag{
+				ArgsUsage: "<url|filepath>...",
+				Flags: append([]cli.Flag{
 					&cli.BoolFlag{
 						Name:    "file",
 						Aliases: []string{"f"},
-						Usage:   "Install a package from a local file.",
+						Usage:   "Install a package from a local file. Files that exist are installed from anyway, unless they are recipes.",
 					},
 					&cli.StringFlag{
 						Name:    "name",
 						Aliases: []string{"n"},
-						Usage:   "Set the name of this package. Required if not using GitHub.",
+						Usage:   "Set the name of this package. Required if not using GitHub, unless the tarball's name has a version in it, e.g. tool-1.2.0.tar.gz.",
 					},
 					&cli.StringFlag{
 						Name:    "version",
 						Aliases: []string{"v"},
-						Usage:   "Set the version of this package. Required if not using GitHub.",
+						Usage:   "Set the version of this package. Required if not using GitHub, unless the tarball's name has it. For GitHub, installs the release with this tag, like --release.",
 					},
-				},
-				Usage: "Install a package",
+					&cli.BoolFlag{
+						Name:  "portable",
+						Usage: "Install into a self-contained, relocatable directory given by --to, using wrapper scripts instead of symlinks.",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "The directory to install into with --portable.",
+					},
+					checksumFlag(),
+					sha256Flag(),
+					&cli.BoolFlag{
+						Name:  "build-from-source",
+						Usage: "If a GitHub release has no asset for this machine, build its source with Go, Cargo or Make instead.",
+					},
+					&cli.StringSliceFlag{
+						Name:  "link-dir",
+						Usage: "Treat directories called `NAME`, e.g. sbin or libexec, as the top of the package's linkable tree instead of bin, lib and share. Repeatable.",
+					},
+					&cli.StringSliceFlag{
+						Name:  "bin-from",
+						Usage: "Only link executables found directly in the directories matching `GLOB`, relative to the package, e.g. . for its top level or '*/bin'. Repeatable.",
+					},
+					&cli.BoolFlag{
+						Name:  "locked",
+						Usage: "Install exactly the tarballs recorded in the lockfile, or only the named packages, failing if any digest differs.",
+					},
+					&cli.StringFlag{
+						Name:  "lockfile",
+						Value: LOCK_FILENAME,
+						Usage: "The lockfile to read with --locked.",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Resolve and inspect the package, then print where it would be stored, its files and the links that would be created, without installing it.",
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Install the package even if the same version is already installed, replacing it.",
+					},
+					&cli.BoolFlag{
+						Name:    "stream",
+						Usage:   "Extract downloads as they arrive without keeping a copy in the cache, so large packages need only the space they take up once extracted.",
+						Sources: cli.EnvVars("INFPM_STREAM"),
+					},
+					&cli.IntFlag{
+						Name:    "jobs",
+						Aliases: []string{"j"},
+						Value:   1,
+						Usage:   "When installing several packages, download and extract up to `N` at once. Asset choices aren't asked then; the best match is used.",
+						Sources: cli.EnvVars("INFPM_JOBS"),
+					},
+				}, resolveFlags()...),
+				Usage: "Install packages",
 				Description: "Installs a package from the given remote/local tarball or GitHub repository.\n" +
+					"Given several, installs each in turn, carrying on if one fails, then summarises which were installed.\n" +
 					"If this is a GitHub URL in the form https://github.com/user/repo, infpm will use the GitHub API to list the latest assets.\n" +
-					"Otherwise, it will download a tarball directly from the given URL, or use a local file if -f is set.",
+					"owner/repo is shorthand for https://github.com/owner/repo.\n" +
+					"Repositories on Codeberg, gitea.com and the Gitea or Forgejo hosts in gitea_hosts in the config file work the same way.\n" +
+					"A recipe file, e.g. ./ripgrep.toml, installs the package it describes, as does the name of a recipe in a tap. See infpm recipe new and infpm tap.\n" +
+					"A source in the config file can be installed by name, and a URL with {version}, {os} and {arch} placeholders is filled in for --version and the platform.\n" +
+					"Otherwise, it will download a tarball directly from the given URL, or use a local file if -f is set.\n" +
+					"With --locked, the packages in infpm.lock are installed from their recorded URLs instead of being resolved again.",
 				Action: actionInstall,
 			},
+			{
+				Name:      "upgrade",
+				ArgsUsage: "[name...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Upgrade every installed package.",
+					},
+					&cli.StringFlag{
+						Name:  "tag",
+						Usage: "Upgrade every package with this tag.",
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Never prompt: pick the best matching asset automatically.",
+					},
+					&cli.BoolFlag{
+						Name:  "pre",
+						Usage: "Upgrade to prereleases too. Overrides channel in the config file.",
+					},
+				},
+				Usage: "Upgrade packages to their latest versions",
+				Description: "Installs the latest version of each of the given packages, of every package tagged with --tag, or of every\n" +
+					"package with --all, from the source it was installed from, then removes the versions it replaces. A failure\n" +
+					"doesn't stop the rest from being upgraded. Pinned packages, and packages whose source has no latest version, e.g. a plain URL, are skipped.\n" +
+					"Prereleases are only upgraded to with --pre, or for packages on the prerelease channel in the config file.\n" +
+					"Finishes with a table of what became of each package, or a JSON array with --json.",
+				Action:        actionUpgrade,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:      "search",
+				ArgsUsage: "<query>",
+				Flags: append([]cli.Flag{
+					&cli.IntFlag{
+						Name:  "limit",
+						Value: SEARCH_LIMIT,
+						Usage: "List at most `N` repositories, up to 100.",
+					},
+				}, resolveFlags()...),
+				Usage: "Search GitHub for repositories to install from",
+				Description: "Lists the repositories on GitHub matching the query, best match first, with their stars, descriptions and\n" +
+					"whether their latest release has binaries for this machine, or the platform given with --os and --arch.\n" +
+					"GitHub's search qualifiers work, e.g. infpm search 'grep language:rust'.\n" +
+					"In a terminal, choose a result to install it straight away.",
+				Action: actionSearch,
+			},
+			{
+				Name:      "adopt",
+				ArgsUsage: "<path>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "name",
+						Aliases: []string{"n"},
+						Usage:   "Set the name of this package. Required.",
+					},
+					&cli.StringFlag{
+						Name:    "version",
+						Aliases: []string{"v"},
+						Usage:   "Set the version of this package. Required.",
+					},
+					&cli.StringFlag{
+						Name:  "source",
+						Usage: "Where the package comes from, e.g. https://github.com/user/repo, so that it can be upgraded later.",
+					},
+					&cli.BoolFlag{
+						Name:  "copy",
+						Usage: "Copy the file or directory into the store instead of moving it.",
+					},
+				},
+				Usage: "Bring a binary or directory installed by hand under infpm's management",
+				Description: "Moves the given file or directory into the store, links it and records it in the package database, as if it had\n" +
+					"been installed by infpm. A single file is treated as an executable and linked into bin.",
+				Action: actionAdopt,
+			},
+			{
+				Name:      "uninstall",
+				Aliases:   []string{"remove", "rm"},
+				ArgsUsage: "<name>...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "tag",
+						Usage: "Uninstall every package with this tag.",
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Don't ask for confirmation before uninstalling.",
+					},
+				},
+				Usage:         "Uninstall packages",
+				Description:   "Removes every installed version of the given packages from the store, along with their links.\nAsks for confirmation first when run in a terminal, unless -y is given.",
+				Action:        actionUninstall,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:      "download",
+				ArgsUsage: "<url>",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   ".",
+						Usage:   "The directory to download the tarball into.",
+					},
+					checksumFlag(),
+					sha256Flag(),
+				}, resolveFlags()...),
+				Usage: "Download a package's tarball without installing it",
+				Description: "Resolves the tarball to download in the same way as install, e.g. picking an asset from the latest GitHub release,\n" +
+					"then downloads it into the output directory and prints its path and sha256 digest.",
+				Action: actionDownload,
+			},
+			{
+				Name:      "extract",
+				ArgsUsage: "<url|filepath>",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:    "file",
+						Aliases: []string{"f"},
+						Usage:   "Extract a local file.",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Value: ".",
+						Usage: "The directory to extract the package into.",
+					},
+				}, resolveFlags()...),
+				Usage: "Extract a package into a directory without installing it",
+				Description: "Resolves and downloads a package in the same way as install, but extracts it into the given directory\n" +
+					"instead of the store. Nothing is linked or recorded in the package database.\n" +
+					"The files are only moved into the directory once the tarball has been verified.",
+				Action: actionExtract,
+			},
+			{
+				Name:      "verify",
+				ArgsUsage: "[name]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "deep",
+						Usage: "Re-hash every file instead of only comparing sizes and modes.",
+					},
+				},
+				Usage: "Check installed packages against the manifest recorded at install time",
+				Description: "Compares the files of every installed package, or only the given package, against the manifest recorded at install time,\n" +
+					"reporting modified, missing and extraneous files. With --deep, every file is re-hashed to detect modified contents.\n" +
+					"The package's links are checked too, reporting ones that are gone or now point somewhere else. Checking every package\n" +
+					"also looks for dangling links into the store in the link roots. Exits non-zero if anything is wrong.",
+				Action:        actionVerify,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:      "repair",
+				ArgsUsage: "[name]",
+				Usage:     "Restore packages' files from their cached tarballs, and their links",
+				Description: "Re-extracts every installed version of the package, or of every package, that fails verify --deep from the cached\n" +
+					"tarball, replacing its directory in the store, then re-creates missing links like relink. The tarball is downloaded\n" +
+					"again only if the cache entry is gone.",
+				Action:        actionRepair,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:  "du",
+				Usage: "Show how much space packages take up in the store",
+				Description: "Lists the size of every installed package in the store, largest first, with the total, the size of the\n" +
+					"versions kept for rollback, and how much infpm gc would free. See infpm info for a single package.",
+				Action: actionDu,
+			},
+			{
+				Name:      "files",
+				ArgsUsage: "<name>",
+				Usage:     "List the files of an installed package",
+				Description: "Prints every file of every installed version of the package from the manifest recorded at install time, with\n" +
+					"where each is linked in the link roots if it is. With --json, the files are printed as a JSON array.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "linked",
+						Usage: "Only list the files that are linked.",
+					},
+				},
+				Action:        actionFiles,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:      "which",
+				Aliases:   []string{"owner"},
+				ArgsUsage: "<command|file>...",
+				Usage:     "Show which installed package provides a command or file",
+				Description: "Finds each command in the bin directory, or else in PATH, and follows its link back into the store to tell\n" +
+					"which installed package and version it belongs to. Paths to other files in the link roots or the store work too.\n" +
+					"Exits non-zero if any isn't provided by an installed package.",
+				Action: actionWhich,
+			},
+			{
+				Name:  "doctor",
+				Usage: "Check that infpm is set up correctly",
+				Description: "Checks that the store and bin directories exist and are writable, that the bin directory is in PATH, that the\n" +
+					"package database can be read, that every package's links are in place, which external tools are available, and\n" +
+					"that the GitHub API can be reached and whether a token is configured. Prints how to fix each problem, and exits\n" +
+					"non-zero if any check failed.",
+				Action: actionDoctor,
+			},
+			{
+				Name:      "relink",
+				ArgsUsage: "[name]...",
+				Usage:     "Re-create packages' missing links",
+				Description: "Re-creates the links of the given packages, or of every package, that are missing from the link roots, along with\n" +
+					"the directories they go in, e.g. after files in ~/.local/bin were deleted by hand. Without names, links into the\n" +
+					"store whose targets no longer exist are removed too. Links that now point somewhere else are left alone.",
+				Action:        actionRelink,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "unused",
+						Usage: "Only list packages that haven't been used for `AGE`, e.g. 90d.",
+					},
+					&cli.StringFlag{
+						Name:  "tag",
+						Usage: "Only list packages with this tag.",
+					},
+				},
+				Usage: "List installed packages",
+				Description: "Lists installed packages with their version, install date, when they were last used and where they are in\n" +
+					"the store. Usage is sampled from the access times of each package's executables, so it is unavailable on\n" +
+					"filesystems mounted with noatime. With --json, the list is printed as a JSON array.",
+				Action: actionList,
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the cache of downloaded tarballs",
+				Commands: []*cli.Command{
+					{
+						Name: "clean",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "Also remove the tarballs of installed packages. repair then downloads them again.",
+							},
+						},
+						Usage: "Remove cached tarballs that aren't needed",
+						Description: "Removes cached tarballs that no installed package was installed from, and partial downloads left by\n" +
+							"interrupted installs. Tarballs of installed packages are kept to repair them with, unless --all is given.",
+						Action: actionCacheClean,
+					},
+				},
+			},
+			{
+				Name:      "info",
+				Aliases:   []string{"show"},
+				ArgsUsage: "<name>",
+				Usage:     "Show details of an installed package",
+				Description: "Prints the version, source, store path, installed size, linked commands and install time of every installed\n" +
+					"version of a package. With --json, the details are printed as a JSON array.",
+				Action:        actionInfo,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name: "watch",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "interval",
+						Usage: "Poll every `INTERVAL`, e.g. 6h or 1d.",
+						Value: "6h",
+					},
+					&cli.BoolFlag{
+						Name:  "once",
+						Usage: "Poll once and exit, e.g. when run from a timer.",
+					},
+					&cli.StringFlag{
+						Name:  "feed",
+						Usage: "Append new releases to `FILE` instead of feed.jsonl next to the package database.",
+					},
+				},
+				Usage: "Watch installed packages for new releases",
+				Description: "Polls the GitHub repository of every installed package and appends newly observed releases to a feed\n" +
+					"file, one JSON object per line. Packages with auto_upgrade = true in their config section are upgraded.",
+				Action: actionWatch,
+			},
+			{
+				Name:  "tag",
+				Usage: "Group packages with tags",
+				Commands: []*cli.Command{
+					{
+						Name:      "add",
+						ArgsUsage: "<name>... <tag>",
+						Usage:     "Tag packages",
+						Action:    actionTagAdd,
+					},
+					{
+						Name:      "remove",
+						Aliases:   []string{"rm"},
+						ArgsUsage: "<name>... <tag>",
+						Usage:     "Remove a tag from packages",
+						Action:    actionTagRemove,
+					},
+					{
+						Name:      "list",
+						ArgsUsage: "[name]",
+						Usage:     "Show the tags of every package, or only the given package",
+						Action:    actionTagList,
+					},
+				},
+			},
+			{
+				Name:      "pin",
+				ArgsUsage: "<name>...",
+				Usage:     "Hold packages at their installed versions",
+				Description: "Pinned packages are skipped by upgrade, including upgrade --all, by sync and by watch's automatic upgrades,\n" +
+					"until they are unpinned. They can still be uninstalled.",
+				Action:        actionPin,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:          "unpin",
+				ArgsUsage:     "<name>...",
+				Usage:         "Let pinned packages be upgraded again",
+				Action:        actionUnpin,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:      "rollback",
+				ArgsUsage: "[generation]",
+				Usage:     "Return the installed packages to an earlier generation",
+				Description: "Every command that installs, upgrades, removes or switches packages records the packages installed\n" +
+					"afterwards, and which versions were linked, as a numbered generation. rollback returns to the given generation,\n" +
+					"or the one before the current one, linking the versions it had linked in place of the current ones. Upgraded versions stay in the store\n" +
+					"until infpm gc removes them, so this doesn't need to download anything; uninstalled packages are extracted\n" +
+					"again from their tarballs.\n" +
+					"Rolling back makes a new generation, so a rollback can be undone too.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "list",
+						Usage: "List the generations instead of rolling back.",
+					},
+				},
+				Action: actionRollback,
+			},
+			{
+				Name:      "history",
+				ArgsUsage: "[name]",
+				Usage:     "Show the log of installs, uninstalls, upgrades and rollbacks",
+				Description: "Every change to the installed packages is appended to a log beside the package database, with when it\n" +
+					"happened, who ran infpm, the command, and the generation it made. Given a name, only shows that package's\n" +
+					"history. See infpm rollback to return to a generation.",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "limit",
+						Aliases: []string{"n"},
+						Usage:   "Only show the last `N` entries.",
+					},
+				},
+				Action:        actionHistory,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:  "gc",
+				Usage: "Remove versions kept in the store that no generation needs",
+				Description: "Upgraded and uninstalled versions are kept for rollback. gc removes the ones that no remaining generation\n" +
+					"has from the store, along with directories in the store that no package has. Generations are only removed\n" +
+					"with --keep-last or --older-than; given both, a generation is kept if either would keep it. The current\n" +
+					"generation is always kept. With --unused, installed packages that haven't been used for a while are\n" +
+					"listed as candidates for uninstalling, but gc never uninstalls them itself. See list for how usage is\n" +
+					"sampled.",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "keep-last",
+						Usage: "Keep only the last `N` generations.",
+					},
+					&cli.StringFlag{
+						Name:  "older-than",
+						Usage: "Remove generations older than `AGE`, e.g. 30d or 2w.",
+					},
+					&cli.StringFlag{
+						Name:  "unused",
+						Usage: "Also list installed packages that haven't been used for `AGE`, e.g. 90d, as candidates for uninstalling.",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Show what would be removed, and how much space that would free, without removing anything.",
+					},
+				},
+				Action: actionGc,
+			},
+			{
+				Name:      "use",
+				ArgsUsage: "<name> <version>",
+				Usage:     "Switch a package's links to another of its installed versions",
+				Description: "Versions of a package can be installed side by side, but only one is linked at a time. use links\n" +
+					"another installed version in its place without reinstalling anything; each link is switched in one step.",
+				Action:        actionUse,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name:  "notes",
+				Usage: "Keep notes about installed packages",
+				Commands: []*cli.Command{
+					{
+						Name:      "edit",
+						ArgsUsage: "<name>",
+						Usage:     "Edit a package's notes with $EDITOR",
+						Action:    actionNotesEdit,
+					},
+					{
+						Name:      "show",
+						ArgsUsage: "<name>",
+						Usage:     "Print a package's notes",
+						Action:    actionNotesShow,
+					},
+				},
+			},
+			{
+				Name:      "import-from",
+				ArgsUsage: "<brew|asdf|mise>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   PACKAGES_FILENAME,
+						Usage:   "The packages file to add the imported packages to.",
+					},
+					&cli.BoolFlag{
+						Name:  "install",
+						Usage: "Install the imported packages straight away.",
+					},
+				},
+				Usage: "Import the packages installed by another package manager",
+				Description: "Reads the packages installed with Homebrew, asdf or mise and adds the ones infpm knows how to install to a\n" +
+					"packages file. Packages are installed from GitHub releases, so with --install, the latest release is installed\n" +
+					"rather than the exact version the other package manager has.",
+				Action: actionImportFrom,
+			},
+			{
+				Name: "sync",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Aliases: []string{"f"},
+						Value:   PACKAGES_FILENAME,
+						Usage:   "The packages file to sync from.",
+					},
+					&cli.BoolFlag{
+						Name:  "prune",
+						Usage: "Uninstall packages that aren't in the packages file.",
+					},
+					&cli.BoolFlag{
+						Name:  "tool-versions",
+						Usage: "Install the tools pinned in .tool-versions in the current directory instead.",
+					},
+					&cli.StringFlag{
+						Name:  "root",
+						Value: PROJECT_ROOT,
+						Usage: "The project-local root to install into with --tool-versions.",
+					},
+				},
+				Usage: "Install the packages a project declares",
+				Description: "Makes the installed packages match the packages file, infpm.toml: packages that aren't installed are\n" +
+					"installed, and packages at a version the file doesn't allow are replaced with the newest release it does. A\n" +
+					"version constrains only the components it gives, so version = \"1.4\" allows 1.4.0 and 1.4.2, and ranges such\n" +
+					"as \"^1.4\", \"~1.4.2\" and \">=0.9 <2\" work for repositories. With --prune, installed packages that aren't in\n" +
+					"the file are uninstalled.\n" +
+					"With --tool-versions, installs the tools pinned in the asdf/mise .tool-versions file in the current directory\n" +
+					"at the pinned versions, into a project-local root. Tools infpm doesn't know how to install are skipped.\n" +
+					"Packages pinned with infpm pin are left alone.",
+				Action: actionSync,
+			},
+			{
+				Name: "lock",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   LOCK_FILENAME,
+						Usage:   "Where to write the lockfile.",
+					},
+				},
+				Usage: "Record exactly what is installed in a lockfile",
+				Description: "Writes the resolved URL, version and SHA-256 digest of the tarball of every installed package to infpm.lock,\n" +
+					"so that infpm install --locked can install the same bytes elsewhere. Packages locked for other platforms\n" +
+					"are kept, so the lockfile can be generated on each platform in turn.",
+				Action: actionLock,
+			},
+			{
+				Name:  "recipe",
+				Usage: "Work with recipes, which describe how to install a package",
+				Commands: []*cli.Command{
+					{
+						Name:      "new",
+						ArgsUsage: "<github.com/user/repo>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Usage:   "Where to write the recipe. Defaults to <name>.toml.",
+							},
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "Overwrite the recipe if it already exists.",
+							},
+							&cli.StringFlag{
+								Name:  "minisign-key",
+								Usage: "The minisign public key `KEY` the project signs its releases with, or a file containing it.",
+							},
+						},
+						Usage: "Create a recipe from a repository's latest release",
+						Description: "Inspects the latest release of a GitHub repository, infers which asset to install on each platform and\n" +
+							"downloads one to work out its layout, then writes a recipe ready to be checked over and edited.\n" +
+							"Install the package from it with infpm install <name>.toml.",
+						Action: actionRecipeNew,
+					},
+				},
+			},
+			{
+				Name:  "tap",
+				Usage: "Manage taps, git repositories of recipes whose packages can be installed by name",
+				Commands: []*cli.Command{
+					{
+						Name:      "add",
+						ArgsUsage: "<git-url>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "The `NAME` to add the tap as. Defaults to the last part of the URL.",
+							},
+						},
+						Usage: "Add a tap",
+						Description: "Clones the repository, after which the recipes in it, either in a recipes directory or at its root,\n" +
+							"can be installed by name, e.g. infpm install ripgrep for ripgrep.toml. If more than one tap has a recipe,\n" +
+							"the first tap by name is used.",
+						Action: actionTapAdd,
+					},
+					{
+						Name:      "remove",
+						Aliases:   []string{"rm"},
+						ArgsUsage: "<name>...",
+						Usage:     "Remove taps",
+						Action:    actionTapRemove,
+					},
+					{
+						Name:   "list",
+						Usage:  "List the taps that have been added",
+						Action: actionTapList,
+					},
+					{
+						Name:      "update",
+						ArgsUsage: "[name...]",
+						Usage:     "Fetch the latest recipes for taps, or every tap",
+						Action:    actionTapUpdate,
+					},
+				},
+			},
+			{
+				Name:      "scripts",
+				ArgsUsage: "<name>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "show",
+						Usage: "Print the contents of each script.",
+					},
+				},
+				Usage: "List the install scripts a package ships",
+				Description: "Some packages ship scripts meant to be run when they are installed or removed, e.g. postinst or install.sh.\n" +
+					"infpm never runs them, so use this to see what manual steps a package expects.",
+				Action:        actionScripts,
+				ShellComplete: completePackageNames,
+			},
+			{
+				Name: "migrate",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "no-backup",
+						Usage: "Don't copy the store before migrating it.",
+					},
+				},
+				Usage: "Upgrade the store to the current layout",
+				Description: "Converts a store created by an older version of infpm to the current layout in place, after backing it up\n" +
+					"next to the store. infpm refuses to use a store until it has been migrated.",
+				Action: actionMigrate,
+			},
+			{
+				Name:  "auth",
+				Usage: "Manage tokens for package providers",
+				Commands: []*cli.Command{
+					{
+						Name:      "login",
+						ArgsUsage: "<github|gitlab>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "token",
+								Usage: "The token to store. If unset, infpm will ask for it.",
+							},
+							&cli.BoolFlag{
+								Name:  "keychain",
+								Usage: "Store the token in the OS keychain instead of a plaintext file.",
+							},
+							&cli.BoolFlag{
+								Name:  "paste",
+								Usage: "Paste a GitHub token instead of using the device flow.",
+							},
+							&cli.StringFlag{
+								Name:    "client-id",
+								Usage:   "The OAuth app client ID used for the GitHub device flow.",
+								Sources: cli.EnvVars("INFPM_GITHUB_CLIENT_ID"),
+							},
+							&cli.StringFlag{
+								Name:  "scope",
+								Value: "repo",
+								Usage: "The OAuth scopes requested during the GitHub device flow. repo is needed for private releases.",
+							},
+						},
+						Usage: "Store a token for a provider",
+						Description: "Stores a token used to authenticate with the given provider, e.g. to install from private repositories or avoid rate limits.\n" +
+							"For GitHub, infpm runs the OAuth device flow unless --token or --paste is given, or no OAuth client ID is configured.\n" +
+							"By default, tokens are stored in a plaintext file in the infpm config directory. With --keychain, the macOS Keychain,\n" +
+							"Secret Service (libsecret) or Windows Credential Manager is used instead.\n" +
+							"A token in INFPM_GITHUB_TOKEN or GITHUB_TOKEN (INFPM_GITLAB_TOKEN or GITLAB_TOKEN for GitLab) takes precedence over stored ones.",
+						Action: actionAuthLogin,
+					},
+					{
+						Name:   "status",
+						Usage:  "Show which providers have a token configured",
+						Action: actionAuthStatus,
+					},
+				},
+			},
+			{
+				Name:  "version",
+				Usage: "Show the version of infpm and how it was built",
+				Description: "Prints the version, commit, build date, Go version and platform of this build of infpm.\n" +
+					"With --json, prints them as a JSON object, e.g. for bug reports.",
+				Action: actionVersion,
+			},
 		},
 	}
+	setShellComplete(cmd)
+
+	runCtx = interruptContext()
+	if err := cmd.Run(runCtx, os.Args); err != nil {
+		if runCtx.Err() != nil {
+			err = withKind(errInterrupted, err)
+		}
+		if cmd.Bool("json") {
+			writeJsonError(os.Stderr, err)
+		} else {
+			slog.Error(err.Error())
+		}
+		os.Exit(exitCodeFor(err))
+	}
+}
+
+// packageManagerOptsFromCmd returns the options for the PackageManager used by a command.
+func packageManagerOptsFromCmd(cmd *cli.Command) PackageManagerOpts {
+	if cmd.Bool("portable") {
+		to := cmd.String("to")
+		return PackageManagerOpts{
+			StorePath:   filepath.Join(to, "pkgs"),
+			SymlinkPath: to,
+			Interactive: !nonInteractive && !cmd.Bool("yes"),
+			Portable:    true,
+			WaitForLock: cmd.Root().Bool("wait"),
+		}
+	}
+
+	storePath := storePathFromCmd(cmd)
+	statePath := ""
+	if storePath == config.Store {
+		statePath = config.State
+	}
+
+	return PackageManagerOpts{
+		StorePath:   storePath,
+		StatePath:   statePath,
+		SymlinkPath: rootPathFromCmd(cmd),
+		LinkRoots:   config.Links,
+		CachePath:   cachePathFromCmd(cmd),
+		Interactive: !nonInteractive && !cmd.Bool("yes"),
+		WaitForLock: cmd.Root().Bool("wait"),
+	}
+}
+
+// storePathFromCmd returns the store selected with --store or INFPM_STORE, or the configured one. The global flags
+// are read from the root command, since some commands have flags of their own with the same names.
+func storePathFromCmd(cmd *cli.Command) string {
+	if store := cmd.Root().String("store"); store != "" {
+		return store
+	}
+	return config.Store
+}
+
+// rootPathFromCmd returns the symlink root selected with --root or INFPM_ROOT, or the configured one.
+func rootPathFromCmd(cmd *cli.Command) string {
+	if root := cmd.Root().String("root"); root != "" {
+		return root
+	}
+	return config.Root
+}
+
+// cachePathFromCmd returns the cache selected with --cache-dir or INFPM_CACHE, or the configured one, or "" if
+// tarballs aren't cached.
+func cachePathFromCmd(cmd *cli.Command) string {
+	if cmd.Root().Bool("no-cache") {
+		return ""
+	}
+	if cache := cmd.Root().String("cache-dir"); cache != "" {
+		return cache
+	}
+	return config.CachePath()
+}
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
-		slog.Error(err.Error())
+// packageManagerFromCmd creates the PackageManager used by a command.
+func packageManagerFromCmd(cmd *cli.Command) (*PackageManager, error) {
+	if cmd.Bool("portable") && cmd.String("to") == "" {
+		return nil, withKind(errUsage, errors.New("--portable requires a directory to install into with --to."))
 	}
+	return NewPackageManager(packageManagerOptsFromCmd(cmd))
 }
 
 func actionInstall(ctx context.Context, cmd *cli.Command) error {
-	reqPath := cmd.Args().Get(0)
-	if reqPath == "" {
-		return errors.New("A package URL or filepath (--file) is required. See --help install.")
+	if cmd.Bool("locked") {
+		return actionInstallLocked(ctx, cmd)
 	}
 
-	pm, err := NewPackageManager(PackageManagerOpts{
-		StorePath:   DEFAULT_STORE_PATH,
-		SymlinkPath: DEFAULT_SYMLINK_PATH,
-		Interactive: true,
-	})
+	reqPaths := cmd.Args().Slice()
+	if len(reqPaths) == 0 {
+		return withKind(errUsage, errors.New(tr("A package URL or filepath (--file) is required. See --help install.")))
+	}
+	if len(reqPaths) > 1 && (cmd.String("name") != "" || cmd.String("version") != "" || len(cmd.StringSlice("checksum")) > 0 || cmd.String("sha256") != "") {
+		return withKind(errUsage, errors.New(tr("--name, --version and checksums describe a single package, so they can't be used when installing several.")))
+	}
+
+	ropts, err := resolveOptsFromCmd(cmd)
 	if err != nil {
 		return err
 	}
 
-	opts := PreinstallPackageOpts{
-		Name:    cmd.String("name"),
-		Version: cmd.String("version"),
+	// A version for a GitHub repository can only mean the release to install.
+	if ropts.Tag == "" {
+		ropts.Tag = cmd.String("version")
+	}
+	// Record the workflow as the source, so that upgrades keep installing its builds.
+	if ropts.Workflow != "" {
+		for i, reqPath := range reqPaths {
+			w, err := workflowSourceFor(reqPath, ropts)
+			if err != nil {
+				return err
+			}
+			reqPaths[i] = w.String()
+		}
 	}
-	downloadUrl := reqPath
-	var ppkg *PreinstallPackage
 
-	if cmd.Bool("file") {
-		opts.RetainTarball = true
-		if ppkg, err = NewPackageFromFile(reqPath, opts); err != nil {
-			ppkg.Cleanup()
-			return err
+	opts := PreinstallPackageOpts{
+		Name:     cmd.String("name"),
+		Version:  cmd.String("version"),
+		Platform: ropts.Platform,
+		Layout:   linkLayout{Dirs: cmd.StringSlice("link-dir"), BinFrom: cmd.StringSlice("bin-from")},
+		Stream:   cmd.Bool("stream"),
+		Force:    cmd.Bool("force"),
+	}
+	if opts.Checksums, err = checksumsFromCmd(cmd); err != nil {
+		return err
+	}
+	if cmd.Bool("dry-run") {
+		for _, reqPath := range reqPaths {
+			opts.Source = reqPath
+			if err := dryRunSource(cmd, reqPath, installFromFile(cmd, reqPath), opts, ropts); err != nil {
+				return err
+			}
 		}
-	} else {
-		userUrl, err := url.ParseRequestURI(reqPath)
+		return nil
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	if len(reqPaths) == 1 {
+		pkg, err := pm.installArg(cmd, reqPaths[0], opts, ropts)
 		if err != nil {
-			slog.Error("The URL provided was invalid.")
 			return err
 		}
-		if userUrl.Scheme != "http" && userUrl.Scheme != "https" {
-			return errors.New("A non-http URL was provided. Please provide a URL with the scheme http:// or https://.")
+		if pkg.Symlinked && !pm.Portable {
+			printRehashHint()
 		}
+		return nil
+	}
+	return pm.installArgs(cmd, reqPaths, opts, ropts)
+}
 
-		if getGithubRepoName(userUrl) != "" {
-			asset, err := fetchLatestGithubAsset(userUrl)
-			if err != nil {
-				slog.Error("failed to find asset from GitHub", "url", reqPath)
-				return err
-			}
+// installFromFile returns whether the package given as reqPath is installed from a local tarball: if --file is set,
+// or if a file exists at reqPath and it isn't a recipe.
+func installFromFile(cmd *cli.Command, reqPath string) bool {
+	if cmd.Bool("file") {
+		return true
+	}
+	if info, err := os.Stat(reqPath); err != nil || !info.Mode().IsRegular() || isRecipePath(reqPath) {
+		return false
+	}
+	if repo, ok := expandRepoShorthand(reqPath); ok {
+		// owner/repo could also be a relative path, and a file that exists is more likely what was meant.
+		slog.Warn("installing the local file rather than the repository it could be shorthand for; give the repository's full URL to install it instead", "path", reqPath, "repository", repo)
+	}
+	return true
+}
 
-			opts.Name = asset.Name
-			opts.Version = asset.Version
-			downloadUrl = asset.Url
+// installArg installs one of the packages given to install, falling back to building it from source if there is no
+// asset for it and --build-from-source is set.
+func (pm *PackageManager) installArg(cmd *cli.Command, reqPath string, opts PreinstallPackageOpts, ropts resolveOpts) (*Package, error) {
+	opts.Source = reqPath
+	pkg, err := pm.InstallSource(reqPath, installFromFile(cmd, reqPath), opts, ropts)
+	if errors.Is(err, errNoMatchingAsset) && cmd.Bool("build-from-source") {
+		slog.Warn("no asset matched, building from source", "err", err)
+		// Building adopts the result, which commits it, so builds can't run in parallel with other installs' commits.
+		pm.commitMu.Lock()
+		pkg, err = pm.BuildFromSource(reqPath, opts, ropts)
+		pm.commitMu.Unlock()
+	}
+	if err != nil {
+		name := opts.Name
+		if name == "" {
+			name = reqPath
 		}
+		ciResult(CI_FAILED, name, opts.Version, err.Error())
+		return nil, err
+	}
+	return pkg, nil
+}
 
-		if ppkg, err = NewPackageFromRemote(downloadUrl, opts); err != nil {
-			ppkg.Cleanup()
-			return err
+// installArgs installs several packages given to install, carrying on past failures, then summarises which were
+// installed. Fails if any of them did. With --jobs, up to that many are resolved, downloaded and extracted at once,
+// though they are still moved into the store and linked one at a time. Nothing can be asked then, as the questions
+// would be interleaved, so the best matching assets are chosen.
+func (pm *PackageManager) installArgs(cmd *cli.Command, reqPaths []string, opts PreinstallPackageOpts, ropts resolveOpts) error {
+	jobs := int(cmd.Int("jobs"))
+	if jobs < 1 {
+		return withKind(errUsage, errors.New(tr("--jobs must be at least 1.")))
+	}
+	if jobs > 1 {
+		ropts.NonInteractive = true
+		pm.Interactive = false
+		downloadBars = false
+	}
+
+	pkgs := make([]*Package, len(reqPaths))
+	errs := make([]error, len(reqPaths))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, reqPath := range reqPaths {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if errs[i] = interrupted(); errs[i] != nil {
+				return
+			}
+			if pkgs[i], errs[i] = pm.installArg(cmd, reqPath, opts, ropts); errs[i] != nil {
+				slog.Error("failed to install package, continuing", "package", reqPath, "err", errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return summariseInstalls(reqPaths, pkgs, errs, pm.Portable)
+}
+
+// summariseInstalls prints whether each of the packages given to install was installed, and returns an error naming
+// the ones that weren't.
+func summariseInstalls(reqPaths []string, pkgs []*Package, errs []error, portable bool) error {
+	failed := []string{}
+	linked := false
+	fmt.Println()
+	for i, reqPath := range reqPaths {
+		if errs[i] != nil {
+			failed = append(failed, reqPath)
+			fmt.Println("  " + tr("%s: failed: %v", reqPath, errs[i]))
+			continue
 		}
+		fmt.Println("  " + tr("%s: installed %s %s", reqPath, pkgs[i].Name, pkgs[i].Version))
+		linked = linked || pkgs[i].Symlinked
+	}
+	fmt.Println(tr("Installed %d of %d packages.", len(reqPaths)-len(failed), len(reqPaths)))
+	if linked && !portable {
+		printRehashHint()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to install %d packages: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// actionInstallLocked installs the packages in the lockfile, or only the ones named in the arguments.
+func actionInstallLocked(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Bool("file") || cmd.String("name") != "" || cmd.String("version") != "" {
+		return withKind(errUsage, errors.New(tr("--locked installs what the lockfile records, so it can't be combined with --file, --name or --version.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	ropts, err := resolveOptsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	return pm.installLocked(cmd.String("lockfile"), cmd.Args().Slice(), ropts)
+}
+
+// InstallSource installs a package from a URL given by the user, resolving it to a tarball first, or from a local
+// tarball if fromFile is set.
+func (pm *PackageManager) InstallSource(reqPath string, fromFile bool, opts PreinstallPackageOpts, ropts resolveOpts) (*Package, error) {
+	opts.CachePath = pm.CachePath
+	ppkg, downloadUrl, err := preparePackage(reqPath, fromFile, opts, ropts)
+	if err != nil {
+		return nil, err
 	}
 
 	pkg, err := pm.Install(ppkg)
@@ -125,9 +1249,73 @@ func actionInstall(ctx context.Context, cmd *cli.Command) error {
 	ppkg.Cleanup()
 	if err != nil {
 		slog.Error("installation failed", "package", ppkg.Name, "from", downloadUrl)
-		return err
+		return nil, withPackage(err, ppkg.Name, downloadUrl)
 	}
 
 	slog.Info("done", "path", pkg.FullPath)
-	return nil
+	ciResult(CI_INSTALLED, pkg.Name, pkg.Version, pkg.FullPath)
+	pm.commitMu.Lock()
+	pm.warnInstallScripts(pkg)
+	pm.offerUnits(pkg)
+	pm.commitMu.Unlock()
+	progress.Emit(progressEvent{Event: PROGRESS_DONE, Package: pkg.Name, Version: pkg.Version, Path: pkg.FullPath})
+	return pkg, nil
+}
+
+// preparePackage resolves a URL given by the user to a tarball and opens it, or opens a local tarball if fromFile is
+// set. Also returns the URL or path the tarball is read from. The caller must run Cleanup on the package.
+func preparePackage(reqPath string, fromFile bool, opts PreinstallPackageOpts, ropts resolveOpts) (*PreinstallPackage, string, error) {
+	downloadUrl := reqPath
+	var ppkg *PreinstallPackage
+	var err error
+
+	if fromFile {
+		opts.RetainTarball = true
+		fillNameVersion(&opts, reqPath)
+		if opts.Name == "" || opts.Version == "" {
+			return nil, "", withKind(errUsage, errors.New(tr("Couldn't tell the name and version of %s. Give them with --name and --version.", reqPath)))
+		}
+		if err := checkChecksumRequired(ropts, opts.Checksums, reqPath); err != nil {
+			return nil, "", withPackage(err, opts.Name, reqPath)
+		}
+		if ppkg, err = NewPackageFromFile(reqPath, opts); err != nil {
+			ppkg.Cleanup()
+			return nil, "", withPackage(err, opts.Name, reqPath)
+		}
+	} else {
+		src, err := resolveSource(reqPath, ropts)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if src.Name != "" {
+			opts.Name = src.Name
+			opts.Version = src.Version
+		} else if u, err := url.Parse(src.Url); err == nil {
+			fillNameVersion(&opts, u.Path)
+		}
+		if opts.Name == "" || opts.Version == "" {
+			return nil, "", withKind(errUsage, errors.New(tr("Couldn't tell the name and version of %s. Give them with --name and --version.", src.Url)))
+		}
+		if src.Recipe != nil {
+			opts.Source = src.Recipe.path
+			opts.StripComponents = src.Recipe.StripComponents
+			opts.PostExtract = src.Recipe.PostExtract
+			if opts.Layout.isDefault() {
+				opts.Layout = src.Recipe.layout()
+			}
+		}
+		downloadUrl = src.Url
+		opts.Checksums = append(opts.Checksums, src.Checksums...)
+		opts.Signature = src.Signature
+		if err := checkChecksumRequired(ropts, opts.Checksums, downloadUrl); err != nil {
+			return nil, "", withPackage(err, opts.Name, downloadUrl)
+		}
+
+		if ppkg, err = NewPackageFromRemote(downloadUrl, opts); err != nil {
+			ppkg.Cleanup()
+			return nil, "", withPackage(err, opts.Name, downloadUrl)
+		}
+	}
+	return ppkg, downloadUrl, nil
 }
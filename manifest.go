@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileEntry records a single file of an installed package, relative to the package's directory in the store.
+type FileEntry struct {
+	Path string      `json:"path"`
+	Mode fs.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+	// Sha256 is the hex digest of a regular file's contents. Empty for symlinks.
+	Sha256 string `json:"sha256,omitempty"`
+	// LinkTarget is the target of a symlink shipped inside the package. Empty for regular files.
+	LinkTarget string `json:"linkTarget,omitempty"`
+}
+
+// hashFile returns the hex sha256 digest of the file at fp.
+func hashFile(fp string) (string, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildManifest walks root and records every regular file and symlink beneath it. Directories are implied by the
+// paths of the files they contain.
+func buildManifest(root string) ([]FileEntry, error) {
+	entries := []FileEntry{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		entry, err := manifestEntry(root, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// manifestEntry records the file at path, which must be beneath root.
+func manifestEntry(root string, path string) (FileEntry, error) {
+	relPath, err := filepath.Rel(root, path)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return FileEntry{}, err
+	}
+
+	entry := FileEntry{
+		Path: filepath.ToSlash(relPath),
+		Mode: info.Mode(),
+		Size: info.Size(),
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		entry.LinkTarget, err = os.Readlink(path)
+		return entry, err
+	}
+	if info.Mode().IsRegular() {
+		entry.Sha256, err = hashFile(path)
+	}
+	return entry, err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// migrateStore upgrades the store at opts.StorePath to the current layout one version at a time. Unless backup is
+// false, the whole store is first copied next to itself.
+func migrateStore(opts PackageManagerOpts, backup bool) error {
+	lock, err := lockStore(opts.statePath(), opts.WaitForLock)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	db, err := openDatabase(opts.StorePath, opts.statePath(), lock)
+	if err != nil {
+		return err
+	}
+	if db.Layout >= STORE_LAYOUT_VERSION {
+		fmt.Println(tr("The store at %s is already using the current layout.", opts.StorePath))
+		return nil
+	}
+
+	if backup {
+		backupPath := filepath.Clean(opts.StorePath) + ".backup-" + time.Now().Format("20060102-150405")
+		slog.Info("backing up the store before migrating", "path", backupPath)
+		if err := copyTree(opts.StorePath, backupPath); err != nil {
+			slog.Error("failed to back up the store, nothing has been migrated", "path", backupPath)
+			return err
+		}
+		// Keep the database with the backup, so that it can be used as a store of its own.
+		if opts.StatePath != "" {
+			if err := copyFile(db.path, filepath.Join(backupPath, DB_FILENAME), 0644); err != nil && !os.IsNotExist(err) {
+				slog.Error("failed to back up the package database, nothing has been migrated", "path", db.path)
+				return err
+			}
+		}
+		fmt.Println(tr("Backed up the store to %s", backupPath))
+	}
+
+	steps := []func(*Database, PackageManagerOpts) error{
+		0: migrateImportUnrecorded,
+		1: migrateSeparatePlatforms,
+	}
+	for db.Layout < STORE_LAYOUT_VERSION {
+		slog.Info("migrating store", "from", db.Layout, "to", db.Layout+1)
+		if err := steps[db.Layout](db, opts); err != nil {
+			slog.Error("migration failed; restore the backup if the store is unusable", "layout", db.Layout)
+			return err
+		}
+
+		// Save after every step, so a failure part way through can be resumed.
+		db.Layout++
+		if err := db.Save(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(tr("Migrated the store at %s to layout version %d.", opts.StorePath, STORE_LAYOUT_VERSION))
+	return nil
+}
+
+// migrateImportUnrecorded creates database records for packages installed before the database existed, at
+// name/version/id in the store. Their source is unknown, so they can't be repaired from a tarball.
+func migrateImportUnrecorded(db *Database, opts PackageManagerOpts) error {
+	dirs, err := filepath.Glob(filepath.Join(opts.StorePath, "*", "*", "*"))
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		relPath, err := filepath.Rel(opts.StorePath, dir)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(relPath), "/")
+
+		files, err := buildManifest(dir)
+		if err != nil {
+			return err
+		}
+		slog.Info("recording package installed before the database existed", "path", dir)
+		db.Add(&PackageRecord{
+			Name:        parts[0],
+			Version:     parts[1],
+			Id:          parts[2],
+			Path:        relPath,
+			Platform:    hostPlatform(),
+			InstalledAt: info.ModTime(),
+			Files:       files,
+		})
+	}
+	return nil
+}
+
+// migrateSeparatePlatforms moves every package into the directory of its platform, re-creating its links.
+func migrateSeparatePlatforms(db *Database, opts PackageManagerOpts) error {
+	for _, rec := range db.Packages {
+		platformDir := rec.Platform.Dir()
+		if strings.HasPrefix(filepath.ToSlash(rec.Path), platformDir+"/") {
+			continue
+		}
+
+		oldPath := filepath.Join(opts.StorePath, rec.Path)
+		newRelPath := filepath.Join(platformDir, rec.Path)
+		newPath := filepath.Join(opts.StorePath, newRelPath)
+
+		// Links point at the old location, so they have to be removed before it moves.
+		links, err := opts.planLinks(oldPath, rec.linkLayout())
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if linkExists(l.Src, l.Dst) {
+				os.Remove(l.Dst)
+			}
+		}
+		for _, dst := range rec.Links {
+			if linksInto(dst, oldPath) {
+				os.Remove(dst)
+			}
+		}
+
+		slog.Info("moving package into its platform's directory", "from", oldPath, "to", newPath)
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+		removeEmptyParents(filepath.Dir(oldPath), opts.StorePath)
+		rec.Path = newRelPath
+
+		if rec.Platform.IsHost() {
+			if rec.Links, err = opts.linkPackage(rec.Name, rec.Platform, newPath, rec.linkLayout()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func actionMigrate(ctx context.Context, cmd *cli.Command) error {
+	// Don't use packageManagerFromCmd: the package manager refuses to open stores with an old layout.
+	return migrateStore(packageManagerOptsFromCmd(cmd), !cmd.Bool("no-backup"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// netrcEntry is a machine's credentials in a .netrc file. An entry without a machine is the default, used for any
+// machine without an entry of its own.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+var (
+	netrcOnce    sync.Once
+	netrcEntries []netrcEntry
+)
+
+// netrcPath returns the location of the .netrc file: NETRC if it is set, otherwise ~/.netrc, or ~/_netrc on Windows,
+// as curl and git do.
+func netrcPath() string {
+	if fp := os.Getenv("NETRC"); fp != "" {
+		return fp
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc parses the contents of a .netrc file. Macro definitions are skipped, as infpm has no use for them.
+func parseNetrc(data string) []netrcEntry {
+	entries := []netrcEntry{}
+	var cur *netrcEntry
+	lines := strings.Split(data, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			if strings.HasPrefix(fields[j], "#") {
+				break
+			}
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+			switch fields[j] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next()})
+				cur = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, netrcEntry{})
+				cur = &entries[len(entries)-1]
+			case "login":
+				if cur != nil {
+					cur.login = next()
+				}
+			case "password":
+				if cur != nil {
+					cur.password = next()
+				}
+			case "account":
+				next()
+			case "macdef":
+				// A macro runs until the next empty line.
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	return entries
+}
+
+// netrcCredentials returns the login and password for host from the .netrc file, if it has any.
+func netrcCredentials(host string) (string, string, bool) {
+	netrcOnce.Do(func() {
+		fp := netrcPath()
+		if fp == "" {
+			return
+		}
+		data, err := os.ReadFile(fp)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				slog.Warn("failed to read netrc file, continuing without it", "path", fp, "err", err)
+			}
+			return
+		}
+		netrcEntries = parseNetrc(string(data))
+	})
+
+	var def *netrcEntry
+	for i, e := range netrcEntries {
+		if e.machine == host {
+			return e.login, e.password, e.login != "" || e.password != ""
+		} else if e.machine == "" && def == nil {
+			def = &netrcEntries[i]
+		}
+	}
+	if def != nil {
+		return def.login, def.password, def.login != "" || def.password != ""
+	}
+	return "", "", false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// notesPath returns the location of a package's notes. Notes are kept per package name rather than per installed
+// version, so they survive upgrades.
+func (pm *PackageManager) notesPath(name string) string {
+	return filepath.Join(pm.StorePath, "notes", name+".txt")
+}
+
+// Notes returns the user's notes for a package, or "" if there are none.
+func (pm *PackageManager) Notes(name string) (string, error) {
+	data, err := os.ReadFile(pm.notesPath(name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// editorCommand returns the user's preferred editor from $VISUAL or $EDITOR, split into its arguments.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// notesPackageFromCmd returns the package name given to a notes command, checking that it is installed.
+func notesPackageFromCmd(cmd *cli.Command) (*PackageManager, string, error) {
+	name := cmd.Args().Get(0)
+	if name == "" {
+		return nil, "", withKind(errUsage, errors.New(tr("A package name is required. See --help notes.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pm.db.Find(name)) == 0 {
+		return nil, "", withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+	}
+	return pm, name, nil
+}
+
+func actionNotesEdit(ctx context.Context, cmd *cli.Command) error {
+	pm, name, err := notesPackageFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	fp := pm.notesPath(name)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+
+	editor := editorCommand()
+	editCmd := exec.CommandContext(ctx, editor[0], append(editor[1:], fp)...)
+	editCmd.Stdin = os.Stdin
+	editCmd.Stdout = os.Stdout
+	editCmd.Stderr = os.Stderr
+	if err := editCmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", editor[0], err)
+	}
+
+	// Don't keep empty notes files around if the user cleared them.
+	if notes, err := pm.Notes(name); err == nil && notes == "" {
+		os.Remove(fp)
+	}
+	return nil
+}
+
+func actionNotesShow(ctx context.Context, cmd *cli.Command) error {
+	pm, name, err := notesPackageFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	notes, err := pm.Notes(name)
+	if err != nil {
+		return err
+	}
+	if notes == "" {
+		fmt.Println(tr("No notes for %s. Add some with infpm notes edit %s.", name, name))
+		return nil
+	}
+	fmt.Println(notes)
+	return nil
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
-	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 // PreinstallPackage represents a package which has not yet been installed.
@@ -17,27 +22,59 @@ type PreinstallPackage struct {
 	PreinstallPackageOpts
 	// Id does NOT uniquely identify the package (it might, but it might not). Use FullPath instead.
 	Id string
-	// Path is the result of joining the Name, Version and Id with filepath. Can be used to uniquely identify the package.
+	// Path is the result of joining the Platform, Name, Version and Id with filepath. Can be used to uniquely identify the package.
 	// TODO: this is quite intuitive, but might seem a bit weird.
 	Path        string
 	Initialised bool
+	// Url is where the tarball is read from: a remote URL or a local file path.
+	Url string
 
 	// tarballPath is the location of the tarball either provided or downloaded remotely.
 	tarballPath string
 	// tarballReader is the byte reader for the downloaded tarball.
 	tarballReader io.ReadCloser
+	// tarballSize is the size of the tarball in bytes, or -1 if it isn't known before reading, e.g. when streaming.
+	tarballSize int64
+	// cached is whether the tarball is read from the cache rather than downloaded, in which case tarballPath is the
+	// cache entry.
+	cached bool
 }
 
 // PreinstallPackageOpts specifies the required options to initialise a PreinstallPackage.
 type PreinstallPackageOpts struct {
 	Name    string
 	Version string
-	// UseDisk determines whether the archive will be downloaded to a temp file, then extracted, or downloaded and extracted in-memory.
-	// Recommended to set to false unless you have limited RAM.
+	// Source is the URL or file the package is installed from, recorded in the package database.
+	Source string
+	// Platform is the OS and architecture the package is built for. Defaults to this machine's. Packages for another
+	// platform are kept in their own part of the store and are never linked, unless the install is portable.
+	Platform Platform
+	// UseDisk determines whether the archive will be downloaded to a temp file, then extracted, or streamed from the
+	// response straight into the extractor. Recommended to set to false, since it needs room for the whole tarball.
 	UseDisk bool
+	// Stream is whether a downloaded tarball is only read through the extractor, without keeping a copy in the cache,
+	// so that installing a large package needs no more space than it takes up once extracted. Its digest is still
+	// computed and verified as it is read, but the package can't be repaired from the cache, and an interrupted
+	// download starts again from the beginning. A tarball that is already cached is still used. Overrides UseDisk.
+	Stream bool
 	// RetainTarball specifies whether the tarball used during installation is kept afterwards.
 	// You likely want to set this to true if installing from a local file.
 	RetainTarball bool
+	// Layout overrides how the package's files to link are found.
+	Layout linkLayout
+	// StripComponents is how many leading directories are removed from the paths in the tarball when extracting it.
+	StripComponents int
+	// PostExtract are shell commands run in the package's directory once it is extracted. See runPostExtract.
+	PostExtract []string
+	// Checksums are the digests the tarball must match. The install fails if any of them doesn't.
+	Checksums []checksum
+	// Signature is the detached signature the tarball must match, or nil.
+	Signature *signatureCheck
+	// CachePath is where downloaded tarballs are cached. A cached copy of the tarball is used instead of downloading
+	// it again if it is still the same as the remote file. See openCached.
+	CachePath string
+	// Force installs the package even if the same version is already installed for the platform, replacing it.
+	Force bool
 }
 
 // setOpts finalises a package's metadata, preparing it for installation.
@@ -47,9 +84,13 @@ func (p *PreinstallPackage) setOpts(opts PreinstallPackageOpts) error {
 		return errors.New("name and version must be non-empty to initialise PreinstallPackage")
 	}
 
+	if opts.Platform == (Platform{}) {
+		opts.Platform = hostPlatform()
+	}
+
 	p.PreinstallPackageOpts = opts
 	p.Id = generateId()
-	p.Path = filepath.Join(p.Name, p.Version, p.Id)
+	p.Path = filepath.Join(p.Platform.Dir(), p.Name, p.Version, p.Id)
 
 	return nil
 }
@@ -57,12 +98,23 @@ func (p *PreinstallPackage) setOpts(opts PreinstallPackageOpts) error {
 // NewPackageFromRemote downloads a tarball from a remote URL and finalises its metadata, preparing it for installation.
 // The caller should always run Cleanup to delete the tarball AFTER installation.
 func NewPackageFromRemote(tarballUrl string, opts PreinstallPackageOpts) (*PreinstallPackage, error) {
-	p := &PreinstallPackage{}
+	p := &PreinstallPackage{Url: tarballUrl}
 	if err := p.setOpts(opts); err != nil {
 		return nil, err
 	}
 
-	if opts.UseDisk {
+	cached, body, err := p.openCached(tarballUrl)
+	if err != nil {
+		return nil, err
+	} else if cached {
+		p.Initialised = true
+		return p, nil
+	}
+
+	if opts.UseDisk && !opts.Stream {
+		if body != nil {
+			body.Close()
+		}
 		if !p.RetainTarball {
 			slog.Warn("downloading to disk and NOT deleting the temporary archive after installation", "url", tarballUrl)
 		}
@@ -74,6 +126,7 @@ func NewPackageFromRemote(tarballUrl string, opts PreinstallPackageOpts) (*Prein
 		}
 
 		p.tarballPath = tarballPath
+		p.tarballSize = fileSize(tarballPath)
 		reader, err := os.Open(tarballPath)
 		if err != nil {
 			slog.Error("failed to open reader for tarball on disk")
@@ -83,12 +136,17 @@ func NewPackageFromRemote(tarballUrl string, opts PreinstallPackageOpts) (*Prein
 
 		slog.Debug("temp file reader set up, ready for initialisation", "tarballPath", tarballPath)
 	} else {
-		slog.Info("remote download: reading archive into memory", "url", tarballUrl)
-		reader, err := p.readRemote(tarballUrl)
-		if err != nil {
-			return nil, err
+		slog.Info("remote download: streaming archive into the extractor", "url", tarballUrl)
+		if body != nil {
+			p.tarballReader, p.tarballSize = body, body.size
+		} else {
+			reader, size, err := p.readRemote(tarballUrl)
+			if err != nil {
+				return nil, err
+			}
+			p.tarballReader = reader
+			p.tarballSize = size
 		}
-		p.tarballReader = reader
 
 		slog.Debug("remote reader set up, ready for initialisation")
 	}
@@ -99,7 +157,7 @@ func NewPackageFromRemote(tarballUrl string, opts PreinstallPackageOpts) (*Prein
 }
 
 func NewPackageFromFile(fp string, opts PreinstallPackageOpts) (*PreinstallPackage, error) {
-	p := &PreinstallPackage{}
+	p := &PreinstallPackage{Url: fp}
 	if err := p.setOpts(opts); err != nil {
 		return nil, err
 	}
@@ -121,6 +179,7 @@ func NewPackageFromFile(fp string, opts PreinstallPackageOpts) (*PreinstallPacka
 
 	p.tarballPath = fp
 	p.tarballReader = reader
+	p.tarballSize = fileSize(fp)
 	p.Initialised = true
 	return p, nil
 }
@@ -128,6 +187,9 @@ func NewPackageFromFile(fp string, opts PreinstallPackageOpts) (*PreinstallPacka
 // Cleanup should always be called after installation, or on error. Deletes the tarball used during installation
 // and closes any readers. See PreinstallPackage.RetainTarball.
 func (p *PreinstallPackage) Cleanup() {
+	if p == nil {
+		return
+	}
 	slog.Info("post-installation cleanup", "package", p.Name)
 	if !p.RetainTarball {
 		os.Remove(p.tarballPath)
@@ -139,36 +201,193 @@ func (p *PreinstallPackage) Cleanup() {
 
 // downloadRemote downloads a tarball from a remote URL and returns the path to the temporary file.
 func (p *PreinstallPackage) downloadRemote(tarballUrl string) (string, error) {
-	tempFile, err := os.CreateTemp("", generateId()+path.Base(tarballUrl))
+	dir, err := tempDir()
 	if err != nil {
-		slog.Error("failed to create temporary file for remote download")
 		return "", err
 	}
+	tempFile, err := os.CreateTemp(dir, generateId()+path.Base(tarballUrl))
+	if err != nil {
+		slog.Error("failed to create temporary file for remote download", "dir", dir)
+		return "", err
+	}
+	defer tempFile.Close()
 
-	body, err := p.readRemote(tarballUrl)
+	body, size, err := p.readRemote(tarballUrl)
 	if err != nil {
+		os.Remove(tempFile.Name())
 		return "", err
 	}
 	defer body.Close()
 
+	if err := checkFreeSpace(spaceRequirement{Path: tempFile.Name(), Bytes: size, What: "download"}); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+
 	_, err = io.Copy(tempFile, body)
 	if err != nil {
 		slog.Error("failed to write tarball to a temporary file")
+		os.Remove(tempFile.Name())
 		return "", err
 	}
 
 	return tempFile.Name(), nil
 }
 
-// readRemote GETs the tarball from the remote URL and returns the Body as a ReadCloser.
-func (p *PreinstallPackage) readRemote(tarballUrl string) (io.ReadCloser, error) {
-	resp, err := http.Get(tarballUrl)
+// readRemote GETs the tarball from the remote URL and returns the Body as a ReadCloser. See openRemote.
+func (p *PreinstallPackage) readRemote(tarballUrl string) (io.ReadCloser, int64, error) {
+	return openRemote(tarballUrl, p.Name)
+}
+
+// openRemote GETs a tarball from a remote URL and returns the Body as a ReadCloser, reporting download progress
+// for the named package. Also returns the size of the tarball from Content-Length, or -1 if it is unknown.
+func openRemote(tarballUrl string, name string) (io.ReadCloser, int64, error) {
+	body, _, err := openRemoteFrom(tarballUrl, name, 0, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, body.size, nil
+}
+
+// openRemoteFrom is openRemote, resuming from offset if it is positive. validator is the ETag or Last-Modified date
+// of the partial download; if the remote file has changed since, or the server can't resume, the whole file is
+// returned instead. Returns whether the download was resumed.
+func openRemoteFrom(tarballUrl string, name string, offset int64, validator string) (*remoteBody, bool, error) {
+	req, err := newRemoteRequest(tarballUrl)
+	if err != nil {
+		return nil, false, err
+	}
+	if offset > 0 && validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	return openRemoteResponse(req, name, offset)
+}
+
+// openRemoteIfChanged is openRemote, unless the remote file is still the one described by cached, the metadata of a
+// cached copy of it. Returns a nil body if it is.
+func openRemoteIfChanged(tarballUrl string, name string, cached remoteMeta) (*remoteBody, error) {
+	req, err := newRemoteRequest(tarballUrl)
 	if err != nil {
-		slog.Error("failed to GET tarball from remote server")
 		return nil, err
 	}
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+	body, _, err := openRemoteResponse(req, name, 0)
+	return body, err
+}
+
+// newRemoteRequest returns a GET request for a tarball.
+func newRemoteRequest(tarballUrl string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, tarballUrl, nil)
+	if err != nil {
+		return nil, withKind(errUsage, err)
+	}
+	// GitHub's release asset API needs a token for private repositories.
+	authorizeRequest(req)
+	return req, nil
+}
+
+// openRemoteResponse makes a request for a tarball built by openRemoteFrom or openRemoteIfChanged. A 304 Not
+// Modified response, which is only sent to conditional requests, returns a nil body.
+func openRemoteResponse(req *http.Request, name string, offset int64) (*remoteBody, bool, error) {
+	tarballUrl := req.URL.String()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		slog.Error("failed to GET tarball from remote server")
+		return nil, false, withKind(errNetwork, err)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, false, nil
+	}
+	resumed := resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp) == offset
+	if resp.StatusCode != http.StatusOK && !resumed {
+		resp.Body.Close()
+		return nil, false, withKind(errNetwork, fmt.Errorf("remote server returned status %d for %s", resp.StatusCode, tarballUrl))
+	}
+	if !resumed {
+		offset = 0
+	}
 
-	return resp.Body, nil
+	size := int64(-1)
+	if resp.ContentLength >= 0 {
+		size = offset + resp.ContentLength
+	}
+	label := name
+	if label == "" {
+		label = path.Base(resp.Request.URL.Path)
+	}
+	body := &remoteBody{
+		r:        newProgressReader(newDownloadReporter(resp.Body, label, offset, size), PROGRESS_DOWNLOAD, name, resp.ContentLength),
+		closer:   resp.Body,
+		url:      tarballUrl,
+		expected: resp.ContentLength,
+		offset:   offset,
+		size:     size,
+		meta:     remoteMeta{Url: tarballUrl, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")},
+	}
+	return body, resumed, nil
+}
+
+// remoteBody is the body of a download. It checks that as many bytes were received as the server promised with
+// Content-Length, so that a dropped connection is reported as a network error rather than as a corrupt archive.
+type remoteBody struct {
+	r      io.Reader
+	closer io.Closer
+	url    string
+	// expected is the Content-Length of the response, or -1 if it is unknown.
+	expected int64
+	received int64
+	err      error
+	// offset is where in the tarball the response starts, if the download was resumed, and size is the size of the
+	// whole tarball, or -1 if it is unknown.
+	offset int64
+	size   int64
+	// meta identifies the version of the remote file being downloaded, so that the download can be resumed.
+	meta remoteMeta
+}
+
+func (b *remoteBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.r.Read(p)
+	b.received += int64(n)
+	if err == io.EOF && b.expected >= 0 && b.received < b.expected {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		if b.expected >= 0 {
+			err = fmt.Errorf("download of %s was cut off after %d of %d bytes: %w", b.url, b.received, b.expected, err)
+		} else {
+			err = fmt.Errorf("download of %s was cut off after %d bytes: %w", b.url, b.received, err)
+		}
+		b.err = withKind(errNetwork, err)
+		return n, b.err
+	}
+	return n, err
+}
+
+func (b *remoteBody) Close() error {
+	return b.closer.Close()
+}
+
+// downloadError returns the error that interrupted the download of r, if r is a download that was interrupted.
+// Consumers like tar fail with their own, less helpful, errors when their input is cut short, so check this first.
+func downloadError(r io.Reader) error {
+	switch b := r.(type) {
+	case *remoteBody:
+		return b.err
+	case *resumedBody:
+		return b.body.err
+	}
+	return nil
 }
 
 // Package represents a package that is installed.
@@ -178,12 +397,21 @@ type Package struct {
 	FullPath string
 	// Symlinked is whether the package has been symlinked from the store to ~/.local, etc.
 	Symlinked bool
+	// Tarball is the copy of the tarball kept in the cache, or "" if it couldn't be cached.
+	Tarball string
+	// Links are the locations the package was linked to.
+	Links []string
+	// Verified are the digests of the tarball that were verified, keyed by algorithm.
+	Verified map[string]string
+	// Sha256 is the hex SHA-256 digest of the tarball, or "" if it couldn't be read in full.
+	Sha256 string
 }
 
-// Install installs a package to the given storePath. If interactive is false, this will skip printing
-// some information and won't ask questions.
-// This should not usually be called directly. Instead, use PackageManager.Install.
-func (ppkg *PreinstallPackage) Install(opts PackageManagerOpts) (*Package, error) {
+// extract extracts a package into tx's staging directory, verifies it and runs its post-extract steps, the first half
+// of installing it. Only the staging directory and the cache are written to, so several packages can be extracted at
+// once. If this fails, the caller must roll tx back. This should not usually be called directly. Instead, use
+// PackageManager.Install.
+func (ppkg *PreinstallPackage) extract(opts PackageManagerOpts, tx *installTx) (*Package, error) {
 	if !ppkg.Initialised {
 		return nil, errors.New("package is not initialised; has Init been called?")
 	}
@@ -193,124 +421,217 @@ func (ppkg *PreinstallPackage) Install(opts PackageManagerOpts) (*Package, error
 		FullPath:          filepath.Join(opts.StorePath, ppkg.Path),
 		Symlinked:         false,
 	}
-	if err := os.MkdirAll(pkg.FullPath, 0755); err != nil {
-		slog.Error("failed to create package directory", "path", pkg.FullPath)
+
+	// A streamed tarball is never written anywhere, so it only needs room to be extracted.
+	cachePath := opts.CachePath
+	if ppkg.Stream {
+		cachePath = ""
+	}
+	err := checkFreeSpace(
+		spaceRequirement{Path: tx.Staging, Bytes: pkg.tarballSize * EXTRACT_EXPANSION_FACTOR, What: "extraction"},
+		spaceRequirement{Path: cachePath, Bytes: pkg.tarballSize, What: "cache"},
+	)
+	if err != nil {
 		return nil, err
 	}
 
-	slog.Info("extracting archive", "package", pkg.Name, "path", pkg.FullPath)
-	if err := tarExtract(pkg.tarballReader, pkg.FullPath); err != nil {
+	var reader io.Reader = pkg.tarballReader
+	var cache *cacheWriter
+	if ppkg.cached {
+		pkg.Tarball = ppkg.tarballPath
+	} else if cachePath != "" {
+		// An interrupted download of the same tarball is resumed from the partial cache entry it left behind.
+		resumed := ppkg.resumeFromCache(cachePath)
+		reader, cache = teeToCache(pkg.tarballReader, cachePath, pkg.Url, resumed)
+	}
+	reader, verifier := verifyReader(reader, pkg.Checksums, pkg.Signature)
+	defer verifier.Close()
+	digest := sha256.New()
+	reader = io.TeeReader(reader, digest)
+	slog.Info("extracting archive", "package", pkg.Name, "path", tx.Staging)
+	// A download that was cut off is kept in the cache to be resumed, but a tarball that is corrupt is not.
+	stopCaching := func(cutOff bool) {
+		if ppkg.cached && !cutOff {
+			slog.Warn("cached tarball is corrupt, removing it", "path", ppkg.tarballPath)
+			os.Remove(ppkg.tarballPath)
+			os.Remove(ppkg.tarballPath + DOWNLOAD_META_SUFFIX)
+		}
+		if cache == nil {
+			return
+		}
+		if cutOff {
+			cache.Suspend(downloadMeta(pkg.tarballReader))
+		} else {
+			cache.Abort()
+		}
+	}
+	if err := tarExtractStrip(newProgressReader(reader, PROGRESS_EXTRACT, pkg.Name, pkg.tarballSize), tx.Staging, pkg.StripComponents); err != nil {
+		// Interrupting the install closes the download, which tar reports as a corrupt archive.
+		if intErr := tx.checkInterrupted(); intErr != nil {
+			stopCaching(true)
+			return nil, intErr
+		}
+		if dlErr := downloadError(pkg.tarballReader); dlErr != nil {
+			stopCaching(true)
+			return nil, dlErr
+		}
+		stopCaching(false)
 		return nil, err
 	}
-	ppkg.Cleanup()
-
-	topLevel := ""
-	executables := []string{}
-	dirs := []string{}
 
-	slog.Info("walking package dir to find relevant files", "path", pkg.FullPath)
-	err := filepath.WalkDir(pkg.FullPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	// tar stops reading at the end-of-archive marker, so make sure any trailing bytes make it into the cache and
+	// the checksums.
+	_, drainErr := io.Copy(io.Discard, reader)
+	err = downloadError(pkg.tarballReader)
+	if err == nil && drainErr == nil {
+		pkg.Sha256 = hex.EncodeToString(digest.Sum(nil))
+	}
+	if err == nil && verifier != nil {
+		// A checksum can't be verified without every byte, so failing to read them all fails the install.
+		if err = drainErr; err == nil {
+			pkg.Verified, err = verifier.Verify()
 		}
+	}
+	cutOff := downloadError(pkg.tarballReader) != nil
+	if intErr := tx.checkInterrupted(); intErr != nil {
+		err = intErr
+		cutOff = true
+	}
+	if err != nil {
+		stopCaching(cutOff)
+		return nil, err
+	}
 
-		if !d.IsDir() {
-			// check if executable
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
-
-			if info.Mode()&0111 != 0 {
-				slog.Info("found an executable", "path", path)
-				executables = append(executables, path)
-			}
-			return nil
+	// The tarball has been verified, so it is kept in the cache even if the install is rolled back later.
+	if cache != nil {
+		if drainErr == nil {
+			meta := downloadMeta(pkg.tarballReader)
+			meta.Url, meta.Sha256 = pkg.Url, pkg.Sha256
+			drainErr = cache.Commit(meta)
 		}
-
-		dirname := d.Name()
-		if topLevel != "" {
-			// We've already found a bin/lib/share dir, so add to the list of other dirs.
-			dirs = append(dirs, path)
-			return filepath.SkipDir
-		} else if dirname == "bin" || dirname == "lib" || dirname == "share" {
-			topLevel = filepath.Dir(path)
-			slog.Info("found a bin, lib or share directory, using new base dir", "path", topLevel)
-
-			dirs = append(dirs, path)
-			return filepath.SkipDir
+		if drainErr != nil {
+			cache.Abort()
+			slog.Warn("failed to cache tarball, continuing", "err", drainErr)
+		} else {
+			pkg.Tarball = cache.dest
 		}
+	}
+	ppkg.Cleanup()
 
-		// We haven't yet found a bin/lib/share dir, so continue until we do.
-		return nil
-	})
-	if err != nil {
-		slog.Error("failed to walk package directory", "path", pkg.FullPath)
+	if err := runPostExtract(tx.Staging, pkg.PostExtract, pkg.Name, pkg.Version, pkg.Platform); err != nil {
 		return nil, err
 	}
+	return pkg, nil
+}
 
-	if topLevel != "" {
-		for _, srcBase := range dirs {
-			err := filepath.WalkDir(srcBase, func(src string, info fs.DirEntry, err error) error {
-				if err != nil {
-					return err
-				}
-
-				relPath, err := filepath.Rel(topLevel, src)
-				if err != nil {
-					return err
-				}
-				dst := filepath.Join(opts.SymlinkPath, relPath)
-				if info.IsDir() {
-					return os.MkdirAll(dst, 0755)
-				}
-
-				if err := os.Symlink(src, dst); err != nil {
-					slog.Error("failed to link, continuing", "from", src, "to", dst, "err", err)
-				}
-				return nil
-			})
+// commit moves a package extracted by extract into the store and links it, the second half of installing it. Only one
+// package may be committed to a store at a time. If this fails part way through, the caller must roll tx back.
+func (pkg *Package) commit(opts PackageManagerOpts, tx *installTx) error {
+	if err := tx.commitDir(pkg.FullPath); err != nil {
+		return err
+	}
 
-			if err != nil {
-				slog.Error("failed to walk and link directory, continuing", "path", srcBase, "err", err)
-			} else {
-				slog.Info("linked directory recursively", "path", srcBase)
-			}
+	// Portable installs are meant to be carried to another machine, so they can always be linked.
+	if !pkg.Platform.IsHost() && !opts.Portable {
+		slog.Info("not linking package built for another platform", "package", pkg.Name, "platform", pkg.Platform)
+		return nil
+	}
+
+	if err := tx.checkInterrupted(); err != nil {
+		return err
+	}
+	var err error
+	if opts.Portable {
+		if err := tx.backupWrappers(pkg.FullPath, opts.SymlinkPath, pkg.Layout); err != nil {
+			return err
 		}
+		err = writeWrappers(pkg.Name, pkg.FullPath, opts.SymlinkPath, pkg.Layout)
 	} else {
-		for _, e := range executables {
-			dest := filepath.Join(opts.SymlinkPath, "bin", filepath.Base(e))
-			if err := os.Symlink(e, dest); err != nil {
-				slog.Error("failed to link an executable", "from", e, "to", dest, "err", err)
-			} else {
-				slog.Info("linked executable", "from", e, "to", dest)
-			}
+		if err := opts.checkLinkConflicts(pkg.Name, pkg.Platform, pkg.FullPath, pkg.Layout); err != nil {
+			return err
 		}
+		opts.warnShadowedCommands(pkg.FullPath, pkg.Layout)
+		pkg.Links, err = opts.linkPackage(pkg.Name, pkg.Platform, pkg.FullPath, pkg.Layout)
+		tx.onRollback(func() { unlinkInto(pkg.Links, pkg.FullPath) })
+	}
+	if err != nil {
+		return err
 	}
+	pkg.Symlinked = true
 
 	// TODO: deal with remaining files; option to delete them from the store, or symlink them
 
-	return pkg, nil
+	return nil
+}
+
+// portableSource returns source as is if it is a URL, or only the file name if it's a local path.
+func portableSource(source string) string {
+	if strings.Contains(source, "://") {
+		return source
+	}
+	return filepath.Base(source)
 }
 
 type PackageManager struct {
 	PackageManagerOpts
 	Initialised bool
+
+	db   *Database
+	lock *storeLock
+	// commitMu serialises moving packages into the store, linking them and recording them in the database, which
+	// packages installed in parallel mustn't do at once. See Install.
+	commitMu sync.Mutex
 }
 
 type PackageManagerOpts struct {
 	// StorePath is the place where installed packages are stored before they are symlinked.
-	// E.g. ~/.infpm/store.
+	// E.g. ~/.local/share/infpm/store.
 	StorePath string
+	// StatePath is where the package database is kept, e.g. ~/.local/state/infpm. If empty, it is kept in the store.
+	StatePath string
 	// SymlinkPath is the place where installed packages are linked to, e.g. ~/.local or ~/.infpm/root.
 	SymlinkPath string
+	// LinkRoots maps content types (see linkContentTypes) to the directories they are linked into instead of
+	// SymlinkPath, e.g. bin to ~/bin.
+	LinkRoots map[string]string
+	// CachePath is where tarballs are kept after installation, so that packages can be repaired without downloading
+	// them again. Caching is disabled if this is empty.
+	CachePath   string
 	Interactive bool
+	// Portable makes installs self-contained: executables get relative wrapper scripts instead of symlinks, and no
+	// paths outside the store and symlink root are recorded, so that both can be carried to another machine.
+	Portable bool
+	// WaitForLock is whether to wait for another infpm process using the store to finish, rather than failing.
+	WaitForLock bool
+}
+
+// expandPaths expands a leading ~ in the paths, since paths from environment variables and flags given as
+// --store=~/... aren't expanded by the shell.
+func (opts *PackageManagerOpts) expandPaths() error {
+	for _, path := range []*string{&opts.StorePath, &opts.StatePath, &opts.SymlinkPath, &opts.CachePath} {
+		var err error
+		if *path, err = expandHome(*path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statePath returns where the package database is kept.
+func (opts PackageManagerOpts) statePath() string {
+	if opts.StatePath != "" {
+		return opts.StatePath
+	}
+	return opts.StorePath
 }
 
 func NewPackageManager(opts PackageManagerOpts) (*PackageManager, error) {
 	if opts.SymlinkPath == "" || opts.StorePath == "" {
 		return nil, errors.New("a StorePath and SymlinkPath must be provided to create a new package manager")
 	}
+	if err := opts.expandPaths(); err != nil {
+		return nil, err
+	}
 
 	pm := &PackageManager{
 		PackageManagerOpts: opts,
@@ -335,14 +656,175 @@ func (pm *PackageManager) Init() error {
 		return err
 	}
 
+	if err := os.MkdirAll(pm.statePath(), 0755); err != nil {
+		slog.Error("failed to create state directory, do we have permission?", "statePath", pm.statePath())
+		return err
+	}
+
+	lock, err := lockStore(pm.statePath(), pm.WaitForLock)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(pm.StorePath, pm.statePath(), lock)
+	if err != nil {
+		lock.Unlock()
+		return err
+	}
+	if db.Layout < STORE_LAYOUT_VERSION {
+		lock.Unlock()
+		return withKind(errUsage, fmt.Errorf("the store at %s uses an old layout (version %d, current is %d). Run infpm migrate to upgrade it.", pm.StorePath, db.Layout, STORE_LAYOUT_VERSION))
+	}
+	pm.db = db
+	pm.lock = lock
+
 	slog.Info("package manager has been initialised", "storePath", pm.StorePath, "symlinkPath", pm.SymlinkPath)
 	pm.Initialised = true
 	return nil
 }
 
+// unlockStore releases the store lock, letting other infpm processes use the store. The package manager mustn't be
+// used again until relockStore is called.
+func (pm *PackageManager) unlockStore() {
+	pm.lock.Unlock()
+	pm.lock = nil
+}
+
+// relockStore takes the store lock again after unlockStore, waiting for other processes to finish, and rereads the
+// database, which they may have changed in the meantime.
+func (pm *PackageManager) relockStore() error {
+	lock, err := lockStore(pm.statePath(), true)
+	if err != nil {
+		return err
+	}
+	db, err := openDatabase(pm.StorePath, pm.statePath(), lock)
+	if err != nil {
+		lock.Unlock()
+		return err
+	}
+	pm.db = db
+	pm.lock = lock
+	return nil
+}
+
+// Install extracts a package into the store, links it and records it. Fails with errAlreadyInstalled if the same
+// version is already installed for the package's platform, unless Force is set, in which case the installed copies
+// are retired and the new one takes over their links.
 func (pm *PackageManager) Install(ppkg *PreinstallPackage) (*Package, error) {
 	if !pm.Initialised {
 		return nil, errors.New("package manager was not initialised. was Init called?")
 	}
-	return ppkg.Install(pm.PackageManagerOpts)
+	dups := pm.installedCopies(ppkg.Name, ppkg.Version, ppkg.Platform)
+	if len(dups) > 0 && !ppkg.Force {
+		return nil, withKind(errAlreadyInstalled, fmt.Errorf("%s is already installed. Use --force to install it again", dups[0]))
+	}
+
+	tx, err := beginInstall(pm.StorePath, ppkg.tarballReader)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.end()
+
+	pkg, err := ppkg.extract(pm.PackageManagerOpts, tx)
+	if err != nil {
+		tx.rollback()
+		return nil, err
+	}
+
+	pm.commitMu.Lock()
+	defer pm.commitMu.Unlock()
+	err = pkg.commit(pm.PackageManagerOpts, tx)
+	if err == nil {
+		// Recording the package commits the install, so this is the last chance to roll it back.
+		if err = tx.checkInterrupted(); err == nil {
+			err = pm.record(pkg)
+		}
+	}
+	if err != nil {
+		tx.rollback()
+		return nil, err
+	}
+	if err := pm.replaceCopies(pkg, dups); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// installedCopies returns the installed records of a package's version for platform.
+func (pm *PackageManager) installedCopies(name string, version string, platform Platform) []*PackageRecord {
+	copies := []*PackageRecord{}
+	for _, rec := range pm.db.Find(name) {
+		if rec.Version == version && rec.Platform == platform {
+			copies = append(copies, rec)
+		}
+	}
+	return copies
+}
+
+// replaceCopies retires the copies of a package that it was installed again over with --force. The new copy couldn't
+// take the links of the ones in use while they held them, so it is linked again once they are gone.
+func (pm *PackageManager) replaceCopies(pkg *Package, copies []*PackageRecord) error {
+	relink := false
+	for _, rec := range copies {
+		relink = relink || pm.db.inUse(rec)
+		slog.Info("retiring the copy installed again", "package", rec.Name, "version", rec.Version, "path", rec.Path)
+		if err := pm.Retire(rec); err != nil {
+			return err
+		}
+	}
+	if !relink || !pkg.Symlinked || pm.Portable {
+		return nil
+	}
+	links, err := pm.linkPackage(pkg.Name, pkg.Platform, pkg.FullPath, pkg.Layout)
+	if err != nil {
+		return err
+	}
+	pkg.Links = mergeLinks(pkg.Links, links)
+	if rec := pm.db.findId(pkg.Id); rec != nil {
+		rec.Links = pkg.Links
+	}
+	return pm.db.Save()
+}
+
+// record builds the manifest of a package that has been placed in the store and adds it to the database. Files it
+// shares with other packages are deduplicated with hard links first.
+func (pm *PackageManager) record(pkg *Package) error {
+	slog.Info("recording package manifest", "package", pkg.Name)
+	files, err := buildManifest(pkg.FullPath)
+	if err != nil {
+		slog.Error("failed to record package manifest", "path", pkg.FullPath)
+		return err
+	}
+	pm.dedupeFiles(pkg.FullPath, files)
+
+	rec := &PackageRecord{
+		Name:        pkg.Name,
+		Version:     pkg.Version,
+		Id:          pkg.Id,
+		Path:        pkg.Path,
+		Source:      pkg.Source,
+		Url:         pkg.Url,
+		Platform:    pkg.Platform,
+		Tarball:     pkg.Tarball,
+		InstalledAt: time.Now(),
+		Files:       files,
+		Links:       pkg.Links,
+		Checksums:   pkg.Verified,
+		Sha256:      pkg.Sha256,
+	}
+	if !pkg.Layout.isDefault() {
+		rec.Layout = &pkg.Layout
+	}
+	rec.StripComponents, rec.PostExtract = pkg.StripComponents, pkg.PostExtract
+	if pm.Portable {
+		// Don't leave references to this machine in a portable install.
+		rec.Source = portableSource(rec.Source)
+		rec.Url = portableSource(rec.Url)
+	}
+
+	pm.db.Add(rec)
+	if err := pm.db.Save(); err != nil {
+		return err
+	}
+	pm.logHistory(HISTORY_INSTALL, rec)
+	return nil
 }
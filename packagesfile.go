@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+)
+
+// PACKAGES_FILENAME is the default name of a packages file, which declares a set of packages to install.
+const PACKAGES_FILENAME = "infpm.toml"
+
+// PackagesFile declares a set of packages, e.g. to be shared between machines or kept with a project:
+//
+//	[packages.ripgrep]
+//	source = "github.com/BurntSushi/ripgrep"
+//	version = "14.1.0"
+type PackagesFile struct {
+	Packages map[string]PackageSpec `toml:"packages"`
+}
+
+// PackageSpec declares a single package in a packages file.
+type PackageSpec struct {
+	// Source is the URL the package is installed from, as it would be given to infpm install.
+	Source string `toml:"source"`
+	// Version is the version the package must be at, or a range of versions such as ^1.4 or >=0.9 <2, or "" for
+	// any, in which case the latest is installed. See versionConstraint.
+	Version string `toml:"version,omitempty"`
+}
+
+// satisfiesVersion returns whether an installed version satisfies the version of a package spec. A version
+// constrains only the components it gives, so 1.4 is satisfied by 1.4, 1.4.0 and 1.4.2, but not 1.40 or 1.5, while a
+// range like ^1.4 is satisfied by every version in it.
+func (spec PackageSpec) satisfiesVersion(version string) bool {
+	c, err := parseVersionConstraint(spec.Version)
+	return err == nil && c.satisfies(version)
+}
+
+// installedFrom returns whether rec is an installed copy of the package declared as name. Packages installed from
+// GitHub or a Gitea host are named after their repository rather than as declared, so they are matched by source too.
+func (spec PackageSpec) installedFrom(name string, rec *PackageRecord) bool {
+	if rec.Name == name {
+		return true
+	}
+	_, fromRepo := releaseRepoPath(spec.Source)
+	return fromRepo && rec.Source == spec.Source
+}
+
+// readPackagesFile reads the packages file at fp, returning an empty one if it doesn't exist.
+func readPackagesFile(fp string) (*PackagesFile, error) {
+	pf := &PackagesFile{Packages: map[string]PackageSpec{}}
+	if _, err := toml.DecodeFile(fp, pf); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return pf, nil
+		}
+		slog.Error("failed to read packages file", "path", fp)
+		return nil, err
+	}
+	if pf.Packages == nil {
+		pf.Packages = map[string]PackageSpec{}
+	}
+	for name, spec := range pf.Packages {
+		if _, err := parseVersionConstraint(spec.Version); err != nil {
+			return nil, fmt.Errorf("packages file %s: package %s: %w", fp, name, err)
+		}
+	}
+	return pf, nil
+}
+
+// Save writes the packages file to fp.
+func (pf *PackagesFile) Save(fp string) error {
+	f, err := os.Create(fp)
+	if err != nil {
+		slog.Error("failed to write packages file", "path", fp)
+		return err
+	}
+	defer f.Close()
+
+	if err := toml.NewEncoder(f).Encode(pf); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Names returns the names of the declared packages in alphabetical order.
+func (pf *PackagesFile) Names() []string {
+	names := make([]string, 0, len(pf.Packages))
+	for name := range pf.Packages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
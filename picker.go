@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PICKER_ROWS is the most items the picker shows at once. The list scrolls to show the rest.
+const PICKER_ROWS = 10
+
+// errPickerUnavailable is returned by pick if stdin or stdout isn't a terminal that can be put into raw mode.
+// Callers fall back to a plain prompt.
+var errPickerUnavailable = errors.New("interactive picker unavailable")
+
+// errPickerCancelled is returned by pick if the user cancels with Esc.
+var errPickerCancelled = errors.New("cancelled")
+
+// pickerItem is one of the choices offered by pick. Label is what the filter matches, and Detail is shown after it,
+// e.g. an asset's size.
+type pickerItem struct {
+	Label  string
+	Detail string
+}
+
+// picker is the state of a pick in progress.
+type picker struct {
+	title string
+	items []pickerItem
+	query []rune
+	// matches are the indices of the items matching the query, in order, and cursor is the selected one of them.
+	matches []int
+	cursor  int
+	// offset is the first match shown, and drawn is how many lines were drawn last time, to be cleared next time.
+	offset int
+	drawn  int
+	out    io.Writer
+	width  int
+}
+
+// pick shows the items in a picker on the terminal and returns the index of the one chosen. Typing filters the items
+// by fuzzy matching their labels, the arrow keys move between them, Enter chooses one and Esc cancels. Until the user
+// types, the items are shown in the order given, so callers put the best first, with def selected unless it is -1.
+func pick(title string, items []pickerItem, def int) (int, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return -1, errPickerUnavailable
+	}
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		slog.Debug("failed to put the terminal into raw mode, falling back to a prompt", "err", err)
+		return -1, errPickerUnavailable
+	}
+	defer restore()
+
+	p := &picker{title: title, items: items, out: os.Stdout, width: terminalWidth(os.Stdout)}
+	fmt.Fprint(p.out, "\x1b[?25l")
+	defer fmt.Fprint(p.out, "\x1b[?25h")
+	p.filter()
+	if def > 0 {
+		p.move(def)
+	}
+
+	buf := make([]byte, 64)
+	for {
+		p.draw()
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			p.clear()
+			return -1, err
+		}
+		switch key := string(buf[:n]); key {
+		case "\r", "\n":
+			if len(p.matches) == 0 {
+				continue
+			}
+			p.clear()
+			chosen := p.matches[p.cursor]
+			fmt.Fprintf(p.out, "%s %s\r\n", p.title, items[chosen].Label)
+			return chosen, nil
+		case "\x1b":
+			p.clear()
+			return -1, errPickerCancelled
+		case "\x03":
+			p.clear()
+			return -1, withKind(errInterrupted, errors.New("interrupted"))
+		case "\x1b[A", "\x1bOA", "\x10":
+			p.move(-1)
+		case "\x1b[B", "\x1bOB", "\x0e":
+			p.move(1)
+		case "\x1b[5~":
+			p.move(-PICKER_ROWS)
+		case "\x1b[6~":
+			p.move(PICKER_ROWS)
+		case "\x7f", "\b":
+			if len(p.query) > 0 {
+				p.query = p.query[:len(p.query)-1]
+				p.filter()
+			}
+		case "\x15":
+			p.query = nil
+			p.filter()
+		default:
+			if strings.HasPrefix(key, "\x1b") {
+				// Ignore the keys without a use here, e.g. left and right.
+				continue
+			}
+			for _, r := range key {
+				if r != utf8.RuneError && unicode.IsPrint(r) {
+					p.query = append(p.query, r)
+				}
+			}
+			p.filter()
+		}
+	}
+}
+
+// filter finds the items matching the query, closest first, and selects the first.
+func (p *picker) filter() {
+	p.matches = p.matches[:0]
+	scores := map[int]int{}
+	for i, item := range p.items {
+		if score, ok := fuzzyMatch(item.Label, string(p.query)); ok {
+			p.matches = append(p.matches, i)
+			scores[i] = score
+		}
+	}
+	sort.SliceStable(p.matches, func(i, j int) bool { return scores[p.matches[i]] < scores[p.matches[j]] })
+	p.cursor, p.offset = 0, 0
+}
+
+// move moves the selection by delta matches, scrolling to keep it in view.
+func (p *picker) move(delta int) {
+	if len(p.matches) == 0 {
+		return
+	}
+	p.cursor = max(0, min(len(p.matches)-1, p.cursor+delta))
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	} else if p.cursor >= p.offset+PICKER_ROWS {
+		p.offset = p.cursor - PICKER_ROWS + 1
+	}
+}
+
+// draw replaces what was drawn last time with the title, query and the matches in view. Lines are cut to the width
+// of the terminal, since a wrapped line would throw off clearing them next time.
+func (p *picker) draw() {
+	lines := []string{p.title, "> " + string(p.query)}
+	for i := p.offset; i < len(p.matches) && i < p.offset+PICKER_ROWS; i++ {
+		item := p.items[p.matches[i]]
+		line := "  " + item.Label
+		if i == p.cursor {
+			line = "> " + item.Label
+		}
+		if item.Detail != "" {
+			line += "  " + item.Detail
+		}
+		lines = append(lines, line)
+	}
+	if len(p.matches) == 0 {
+		lines = append(lines, "  "+tr("Nothing matches."))
+	}
+	lines = append(lines, tr("%d of %d. Type to filter, ↑/↓ to move, Enter to choose, Esc to cancel.", len(p.matches), len(p.items)))
+
+	p.clear()
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) >= p.width {
+			line = string(runes[:max(p.width-1, 0)])
+		}
+		if i > 0 {
+			fmt.Fprint(p.out, "\r\n")
+		}
+		fmt.Fprint(p.out, line)
+	}
+	p.drawn = len(lines)
+}
+
+// clear erases what was drawn last time, leaving the cursor where it began.
+func (p *picker) clear() {
+	if p.drawn > 1 {
+		fmt.Fprintf(p.out, "\x1b[%dA", p.drawn-1)
+	}
+	fmt.Fprint(p.out, "\r\x1b[J")
+	p.drawn = 0
+}
+
+// fuzzyMatch returns whether the characters of query appear in s in order, ignoring case, e.g. lxmusl matches
+// tool-linux-x86_64-musl.tar.gz, and how closely, lower being closer: s containing query scores lowest, the
+// earlier the better, and otherwise the fewer characters between the first and last matched, the better. Spaces
+// in query are ignored.
+func fuzzyMatch(s string, query string) (int, bool) {
+	s = strings.ToLower(s)
+	query = strings.ReplaceAll(strings.ToLower(query), " ", "")
+	if i := strings.Index(s, query); i >= 0 {
+		return i, true
+	}
+	start, end := -1, 0
+	for _, r := range query {
+		i := strings.IndexRune(s[end:], r)
+		if i < 0 {
+			return 0, false
+		}
+		if start < 0 {
+			start = end + i
+		}
+		end += i + utf8.RuneLen(r)
+	}
+	return len(s) + end - start, true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+)
+
+func actionPin(ctx context.Context, cmd *cli.Command) error {
+	return editPin(cmd, true)
+}
+
+func actionUnpin(ctx context.Context, cmd *cli.Command) error {
+	return editPin(cmd, false)
+}
+
+// editPin pins or unpins every package given as an argument.
+func editPin(cmd *cli.Command, pin bool) error {
+	names := cmd.Args().Slice()
+	if len(names) == 0 {
+		return withKind(errUsage, errors.New(tr("A package name is required, e.g. infpm pin ripgrep.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if len(pm.db.Find(name)) == 0 {
+			return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+		}
+
+		if pin && !pm.db.Pin(name) {
+			fmt.Println(tr("%s is already pinned", name))
+		} else if !pin && !pm.db.Unpin(name) {
+			fmt.Println(tr("%s is not pinned", name))
+		}
+	}
+	return pm.db.Save()
+}
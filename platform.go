@@ -0,0 +1,59 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Platform is an operating system and architecture that packages can be installed for, using Go's naming,
+// e.g. linux/arm64.
+type Platform struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// hostPlatform returns the platform infpm is running on.
+func hostPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+}
+
+// IsHost returns whether binaries for this platform can run on this machine.
+func (p Platform) IsHost() bool {
+	return p == hostPlatform()
+}
+
+func (p Platform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+// Dir returns the name of the directory packages for this platform are kept in within the store, e.g. linux-arm64.
+func (p Platform) Dir() string {
+	return p.OS + "-" + p.Arch
+}
+
+// platformFromCmd returns the platform selected with --os and --arch, defaulting to the host's.
+func platformFromCmd(cmd *cli.Command) Platform {
+	p := hostPlatform()
+	if os := cmd.String("os"); os != "" {
+		p.OS = os
+	}
+	if arch := cmd.String("arch"); arch != "" {
+		p.Arch = arch
+	}
+	return p
+}
+
+// platformFlags are the flags read by platformFromCmd.
+func platformFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "os",
+			Usage: "Resolve packages for another operating system, e.g. linux or darwin. Defaults to this machine's.",
+		},
+		&cli.StringFlag{
+			Name:  "arch",
+			Usage: "Resolve packages for another architecture, e.g. amd64 or arm64. Defaults to this machine's.",
+		},
+	}
+}
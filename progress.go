@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress event names emitted with --progress json.
+const (
+	PROGRESS_RESOLVE  = "resolve"
+	PROGRESS_DOWNLOAD = "download"
+	PROGRESS_EXTRACT  = "extract"
+	PROGRESS_LINK     = "link"
+	PROGRESS_DONE     = "done"
+)
+
+// progressEvent is a single line of output with --progress json.
+type progressEvent struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Package string    `json:"package,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Url     string    `json:"url,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	// Total is the expected number of bytes, or omitted if it is unknown.
+	Total   int64   `json:"total,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+}
+
+// progressEmitter writes progress events as newline-delimited JSON. A nil *progressEmitter discards all events,
+// so callers don't need to check whether progress output is enabled.
+type progressEmitter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// progress is the emitter used throughout infpm. It is nil unless --progress json is set.
+var progress *progressEmitter
+
+func newProgressEmitter(w io.Writer) *progressEmitter {
+	return &progressEmitter{enc: json.NewEncoder(w)}
+}
+
+// Emit writes a single event, filling in its timestamp.
+func (p *progressEmitter) Emit(ev progressEvent) {
+	if p == nil {
+		return
+	}
+	ev.Time = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.enc.Encode(ev)
+}
+
+// progressReader wraps a reader, emitting an event every whole percent (or every PROGRESS_UNKNOWN_STEP bytes if the
+// total size is unknown) as it is read.
+type progressReader struct {
+	r       io.Reader
+	event   string
+	pkg     string
+	total   int64
+	read    int64
+	lastPct int
+	lastAt  int64
+}
+
+// PROGRESS_UNKNOWN_STEP is how many bytes are read between events when the total size is unknown.
+const PROGRESS_UNKNOWN_STEP = 1 << 20
+
+// newProgressReader wraps r so that reading from it emits events of the given kind. total should be -1 if unknown.
+// If progress output is disabled, r is returned as is.
+func newProgressReader(r io.Reader, event string, pkg string, total int64) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &progressReader{r: r, event: event, pkg: pkg, total: total, lastPct: -1}
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.read += int64(n)
+
+	ev := progressEvent{Event: pr.event, Package: pr.pkg, Bytes: pr.read}
+	if pr.total > 0 {
+		pct := int(pr.read * 100 / pr.total)
+		if pct != pr.lastPct {
+			pr.lastPct = pct
+			ev.Total = pr.total
+			ev.Percent = float64(pr.read) * 100 / float64(pr.total)
+			progress.Emit(ev)
+		}
+	} else if pr.read-pr.lastAt >= PROGRESS_UNKNOWN_STEP || (err == io.EOF && pr.read != pr.lastAt) {
+		pr.lastAt = pr.read
+		progress.Emit(ev)
+	}
+
+	return n, err
+}
+
+// Download reports shown to the user, as opposed to the events emitted with --progress json.
+const (
+	// DOWNLOAD_BAR_INTERVAL is how often the progress bar is redrawn.
+	DOWNLOAD_BAR_INTERVAL = 100 * time.Millisecond
+	// DOWNLOAD_LOG_INTERVAL is how often progress is logged instead when there is nobody to show a bar to.
+	DOWNLOAD_LOG_INTERVAL = 5 * time.Second
+	// DOWNLOAD_BAR_WIDTH is the number of characters in the bar itself.
+	DOWNLOAD_BAR_WIDTH = 30
+)
+
+// downloadBars is whether downloads may draw progress bars. Parallel installs turn it off, as their bars would be
+// drawn over each other.
+var downloadBars = true
+
+// downloadReporter wraps the body of a download, drawing a progress bar on stderr if it is a terminal and infpm is
+// interactive, and otherwise logging the progress of long downloads every DOWNLOAD_LOG_INTERVAL.
+type downloadReporter struct {
+	r     io.Reader
+	name  string
+	total int64
+	read  int64
+	// offset is how much of the tarball had been downloaded already, if the download was resumed.
+	offset int64
+	bar    bool
+	start  time.Time
+	// lastAt is when progress was last shown. drawn is whether the bar has been drawn at all.
+	lastAt time.Time
+	drawn  bool
+	done   bool
+}
+
+// newDownloadReporter wraps r, the body of a download of the named package, which starts offset bytes into the
+// tarball if the download was resumed. total should be -1 if unknown.
+func newDownloadReporter(r io.Reader, name string, offset int64, total int64) io.Reader {
+	now := time.Now()
+	return &downloadReporter{
+		r:      r,
+		name:   name,
+		total:  total,
+		offset: offset,
+		read:   offset,
+		bar:    downloadBars && !nonInteractive && isTerminal(os.Stderr),
+		start:  now,
+		lastAt: now,
+	}
+}
+
+func (d *downloadReporter) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	d.read += int64(n)
+	if d.done {
+		return n, err
+	}
+
+	now := time.Now()
+	if err != nil {
+		d.done = true
+		// A download that was cut short also ends with io.EOF.
+		d.finish(now, err == io.EOF && (d.total < 0 || d.read >= d.total))
+		return n, err
+	}
+	interval := DOWNLOAD_LOG_INTERVAL
+	if d.bar {
+		interval = DOWNLOAD_BAR_INTERVAL
+	}
+	if now.Sub(d.lastAt) >= interval {
+		d.lastAt = now
+		d.report(now)
+	}
+	return n, err
+}
+
+// speed returns the average speed of the download so far, in bytes per second.
+func (d *downloadReporter) speed(now time.Time) float64 {
+	elapsed := now.Sub(d.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(d.read-d.offset) / elapsed
+}
+
+// eta returns how long the rest of the download should take, or 0 if that isn't known.
+func (d *downloadReporter) eta(now time.Time) time.Duration {
+	speed := d.speed(now)
+	if d.total <= 0 || speed <= 0 || d.read >= d.total {
+		return 0
+	}
+	return time.Duration(float64(d.total-d.read) / speed * float64(time.Second)).Round(time.Second)
+}
+
+func (d *downloadReporter) report(now time.Time) {
+	speed := formatBytes(uint64(d.speed(now))) + "/s"
+	if !d.bar {
+		if d.total > 0 {
+			slog.Info("downloading", "package", d.name, "received", formatBytes(uint64(d.read)), "total", formatBytes(uint64(d.total)), "speed", speed, "eta", d.eta(now))
+		} else {
+			slog.Info("downloading", "package", d.name, "received", formatBytes(uint64(d.read)), "speed", speed)
+		}
+		return
+	}
+
+	d.drawn = true
+	if d.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%s  %s  %s\x1b[K", d.name, formatBytes(uint64(d.read)), speed)
+		return
+	}
+	filled := int(min(d.read, d.total) * DOWNLOAD_BAR_WIDTH / d.total)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", DOWNLOAD_BAR_WIDTH-filled)
+	line := fmt.Sprintf("\r%s  [%s] %3d%%  %s / %s  %s", d.name, bar, min(d.read, d.total)*100/d.total, formatBytes(uint64(d.read)), formatBytes(uint64(d.total)), speed)
+	if eta := d.eta(now); eta > 0 {
+		line += "  " + tr("%s left", eta)
+	}
+	fmt.Fprint(os.Stderr, line+"\x1b[K")
+}
+
+// finish shows the final state of the download once it has ended, successfully if complete is set.
+func (d *downloadReporter) finish(now time.Time, complete bool) {
+	if d.bar {
+		if !d.drawn && !complete {
+			return
+		}
+		if complete {
+			d.report(now)
+		}
+		fmt.Fprintln(os.Stderr)
+		return
+	}
+	if complete && now.Sub(d.start) >= DOWNLOAD_LOG_INTERVAL {
+		slog.Info("downloaded", "package", d.name, "size", formatBytes(uint64(d.read-d.offset)), "took", now.Sub(d.start).Round(time.Second))
+	}
+}
+
+// isTerminal returns whether f is a terminal, rather than e.g. a pipe or file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// fileSize returns the size of the file at fp, or -1 if it can't be determined.
+func fileSize(fp string) int64 {
+	info, err := os.Stat(fp)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
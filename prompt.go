@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errNoAnswer is returned by a prompter if a question wasn't answered, because the user cancelled, input ended or
+// infpm isn't interactive, and it has no default.
+var errNoAnswer = errors.New("no answer was given")
+
+// stdinReader reads answers from stdin. It is shared by every prompt, so that input read ahead by one, e.g. answers
+// piped in, is there for the next.
+var stdinReader = sync.OnceValue(func() *bufio.Reader { return bufio.NewReader(os.Stdin) })
+
+// prompter asks the user questions. If it isn't interactive, e.g. with --non-interactive or -y, nothing is asked and
+// questions are answered with their defaults, as are questions asked once input has ended.
+type prompter struct {
+	interactive bool
+}
+
+func newPrompter(interactive bool) *prompter {
+	return &prompter{interactive: interactive}
+}
+
+// readLine prints question and returns the trimmed line answering it, or false if input has ended.
+func (p *prompter) readLine(question string) (string, bool) {
+	fmt.Print(question)
+	line, err := stdinReader().ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		return "", false
+	}
+	return strings.TrimSpace(line), true
+}
+
+// confirm asks a yes or no question, returning def if it isn't answered.
+func (p *prompter) confirm(question string, def bool) bool {
+	if !p.interactive {
+		return def
+	}
+	hint := " [y/N] "
+	if def {
+		hint = " [Y/n] "
+	}
+	for {
+		answer, ok := p.readLine(question + hint)
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "":
+			return def
+		}
+		if !ok {
+			return def
+		}
+		fmt.Println(tr("Answer y or n."))
+	}
+}
+
+// text asks for a line of text, returning def if it isn't answered. Answers that validate rejects are asked for
+// again, saying why, unless input has ended.
+func (p *prompter) text(question string, def string, validate func(string) error) (string, error) {
+	if !p.interactive {
+		if def == "" {
+			return "", errNoAnswer
+		}
+		return def, nil
+	}
+	if def != "" {
+		question += " [" + def + "]"
+	}
+	for {
+		answer, ok := p.readLine(question + ": ")
+		if answer == "" && def != "" {
+			answer = def
+		} else if answer == "" && !ok {
+			return "", errNoAnswer
+		} else if answer == "" {
+			continue
+		}
+		if validate != nil {
+			if err := validate(answer); err != nil {
+				fmt.Println(err)
+				if !ok {
+					return "", err
+				}
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// choose asks which of the items to choose and returns its index, or def if it isn't answered and def isn't -1. The
+// items are offered in a picker if the terminal can show one, or else listed and chosen by number.
+func (p *prompter) choose(title string, items []pickerItem, def int) (int, error) {
+	noAnswer := func() (int, error) {
+		if def >= 0 {
+			return def, nil
+		}
+		return -1, errNoAnswer
+	}
+	if !p.interactive {
+		return noAnswer()
+	}
+
+	i, err := pick(title, items, def)
+	if errors.Is(err, errPickerCancelled) {
+		return -1, errNoAnswer
+	}
+	if !errors.Is(err, errPickerUnavailable) {
+		return i, err
+	}
+
+	fmt.Println(title)
+	for i, item := range items {
+		if item.Detail != "" {
+			fmt.Printf("  [%d] %s (%s)\n", i+1, item.Label, item.Detail)
+		} else {
+			fmt.Printf("  [%d] %s\n", i+1, item.Label)
+		}
+	}
+	question := tr("Choice: ")
+	if def >= 0 {
+		question = tr("Choice [%d]: ", def+1)
+	}
+	for {
+		answer, ok := p.readLine(question)
+		if answer == "" && (def >= 0 || !ok) {
+			return noAnswer()
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(items) {
+			return n - 1, nil
+		}
+		if !ok {
+			return noAnswer()
+		}
+		fmt.Println(tr("Enter a number from the list."))
+	}
+}
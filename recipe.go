@@ -0,0 +1,446 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v3"
+)
+
+// RECIPE_EXTENSION is the extension of recipe files.
+const RECIPE_EXTENSION = ".toml"
+
+// Recipe describes how to install a package, so that packages with awkward releases can be installed reproducibly:
+//
+//	name = "ripgrep"
+//	source = "github.com/BurntSushi/ripgrep"
+//	strip-components = 1
+//	bins = ["rg"]
+//
+//	[assets]
+//	"linux/amd64" = "ripgrep-*-x86_64-unknown-linux-musl.tar.gz"
+//	"darwin/arm64" = "ripgrep-*-aarch64-apple-darwin.tar.gz"
+//
+// Packages that aren't released on GitHub or a Gitea host can be installed from a URL template, with checksums and
+// steps to tidy the package up after it is extracted:
+//
+//	name = "tool"
+//	source = "https://example.com/tool/{version}/tool-{os}-{arch}.tar.gz"
+//	version = "1.2.0"
+//	arch = { amd64 = "x86_64" }
+//	post-extract = ["mv tool-* tool"]
+//
+//	[checksums]
+//	"linux/amd64" = "sha256:..."
+type Recipe struct {
+	Name string `toml:"name"`
+	// Source is where releases are found: a repository on GitHub or a Gitea host, the URL of a tarball, or a URL
+	// template like the sources in the config file. See SourceTemplate.
+	Source string `toml:"source"`
+	// Version is the release to install, or "" for the latest. It is required if Source is the URL of a tarball. If
+	// Source is a repository, it can be a range of versions instead, e.g. ^1.4, to install the newest release in it.
+	// See versionConstraint.
+	Version string `toml:"version,omitempty"`
+	// Latest, LatestField and LatestPattern find the latest version when Source is a URL template, and OS and Arch
+	// map platforms to the names in its URLs, as for a source in the config file.
+	Latest        string            `toml:"latest,omitempty"`
+	LatestField   string            `toml:"latest-field,omitempty"`
+	LatestPattern string            `toml:"latest-pattern,omitempty"`
+	OS            map[string]string `toml:"os,omitempty"`
+	Arch          map[string]string `toml:"arch,omitempty"`
+	// StripComponents is how many leading directories to remove from the paths in the archive, like tar's
+	// --strip-components.
+	StripComponents int `toml:"strip-components,omitempty"`
+	// Bins are the executables to link, relative to the archive after stripping components.
+	Bins []string `toml:"bins,omitempty"`
+	// LinkDirs and BinFrom override how the files to link are found. See linkLayout.
+	LinkDirs []string `toml:"link-dirs,omitempty"`
+	BinFrom  []string `toml:"bin-from,omitempty"`
+	// MinisignKey is the minisign public key the project signs its releases with, so that installs from the recipe
+	// are verified. See parseMinisignKey.
+	MinisignKey string `toml:"minisign-key,omitempty"`
+	// PostExtract are shell commands run in the package's directory after it is extracted, e.g. to rename a file.
+	// See runPostExtract.
+	PostExtract []string `toml:"post-extract,omitempty"`
+	// Assets maps platforms (os/arch) to a glob matching the name of the release asset for that platform.
+	Assets map[string]string `toml:"assets"`
+	// Checksums maps platforms to the checksum of the tarball for that platform, e.g. "sha256:...". They can only be
+	// given with a Version, since they are different for every release.
+	Checksums map[string]string `toml:"checksums,omitempty"`
+
+	// path is the absolute path the recipe was read from, which packages installed from it record as their source.
+	path string
+}
+
+// layout returns the link layout the recipe asks for.
+func (r *Recipe) layout() linkLayout {
+	return linkLayout{Dirs: r.LinkDirs, BinFrom: r.BinFrom, Bins: r.Bins}
+}
+
+// isRecipePath returns whether a source given by the user is a recipe file rather than a URL.
+func isRecipePath(source string) bool {
+	if !strings.HasSuffix(source, RECIPE_EXTENSION) || strings.Contains(source, "://") {
+		return false
+	}
+	info, err := os.Stat(source)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// readRecipe reads the recipe at fp.
+func readRecipe(fp string) (*Recipe, error) {
+	r := &Recipe{}
+	if _, err := toml.DecodeFile(fp, r); err != nil {
+		slog.Error("failed to read recipe", "path", fp)
+		return nil, err
+	}
+	if r.Name == "" || r.Source == "" {
+		return nil, withKind(errUsage, fmt.Errorf("recipe %s must have a name and a source", fp))
+	}
+	if t, ok := r.template(); ok {
+		if err := t.validate(r.Name); err != nil {
+			return nil, fmt.Errorf("recipe %s: %w", fp, err)
+		}
+	}
+	c, err := parseVersionConstraint(r.Version)
+	if err != nil {
+		return nil, fmt.Errorf("recipe %s: %w", fp, err)
+	}
+	_, isExact := c.exact()
+	if len(r.Checksums) > 0 && !isExact {
+		return nil, withKind(errUsage, fmt.Errorf("recipe %s has checksums, so it must also have the exact version they are for", fp))
+	}
+	if _, ok := releaseRepoPath(r.Source); !ok && !isExact && !c.isAny() {
+		return nil, withKind(errUsage, fmt.Errorf("recipe %s has a range of versions, which can only be used with a repository on GitHub or a Gitea host", fp))
+	}
+	for platform, c := range r.Checksums {
+		if _, err := parseChecksum(c); err != nil {
+			return nil, fmt.Errorf("recipe %s: checksum for %s: %w", fp, platform, err)
+		}
+	}
+	if r.path, err = filepath.Abs(fp); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// template returns the recipe's source as a URL template, if it is one.
+func (r *Recipe) template() (*SourceTemplate, bool) {
+	if !isTemplateUrl(r.Source) {
+		return nil, false
+	}
+	return &SourceTemplate{
+		Url:           r.Source,
+		Latest:        r.Latest,
+		LatestField:   r.LatestField,
+		LatestPattern: r.LatestPattern,
+		OS:            r.OS,
+		Arch:          r.Arch,
+	}, true
+}
+
+// canFindLatest returns whether the recipe installs the latest version of a source whose latest version can be
+// found, rather than a fixed one.
+func (r *Recipe) canFindLatest() bool {
+	if _, _, ok := r.versionRange(); ok {
+		return true
+	}
+	if r.Version != "" {
+		return false
+	}
+	if t, ok := r.template(); ok {
+		return t.Latest != ""
+	}
+	return canFindLatest(r.Source)
+}
+
+// versionRange returns the recipe's version if it is a range of versions rather than one, e.g. ^1.4, along with the
+// repository whose releases it selects from.
+func (r *Recipe) versionRange() (versionConstraint, string, bool) {
+	c, err := parseVersionConstraint(r.Version)
+	if _, isExact := c.exact(); err != nil || isExact || c.isAny() {
+		return c, "", false
+	}
+	repoPath, ok := releaseRepoPath(r.Source)
+	return c, repoPath, ok
+}
+
+// latestRelease returns the newest release in the recipe's range of versions.
+func (r *Recipe) latestRelease() (*githubApiReleases, error) {
+	c, repoPath, _ := r.versionRange()
+	release, err := findReleaseSatisfying(repoPath, c)
+	if errors.Is(err, errReleaseNotFound) {
+		return nil, withKind(errUsage, fmt.Errorf("no release of %s satisfies %s, the version of recipe %s", r.Source, r.Version, r.path))
+	}
+	return release, err
+}
+
+// resolve finds the tarball to download for the recipe: the asset matching its glob for the platform from a
+// repository's release, or its URL template filled in. The recipe's version and key are used unless opts set their
+// own.
+func (r *Recipe) resolve(opts resolveOpts) (*resolvedSource, error) {
+	if _, _, ok := r.versionRange(); ok && opts.Tag == "" {
+		release, err := r.latestRelease()
+		if err != nil {
+			return nil, err
+		}
+		opts.Tag = release.TagName
+	} else if opts.Tag == "" {
+		opts.Tag = r.Version
+	}
+	if opts.MinisignKey == "" {
+		opts.MinisignKey = r.MinisignKey
+	}
+
+	var src *resolvedSource
+	var err error
+	if t, ok := r.template(); ok {
+		src, err = resolveTemplate(t, r.Name, opts)
+	} else {
+		if glob, ok := r.Assets[opts.Platform.String()]; ok && opts.Asset == "" {
+			// The recipe says which asset to install, so there is nothing to ask.
+			opts.Asset, opts.NonInteractive = glob, true
+		}
+		src, err = resolveSource(r.Source, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	src.Name = r.Name
+	if src.Version == "" {
+		if src.Version = opts.Tag; src.Version == "" {
+			return nil, withKind(errUsage, fmt.Errorf("recipe %s installs a tarball from a URL, so it must have a version", r.path))
+		}
+	}
+	if c, ok := r.Checksums[opts.Platform.String()]; ok && opts.Tag == r.Version {
+		sum, _ := parseChecksum(c)
+		src.Checksums = append(src.Checksums, sum)
+	}
+	src.Recipe = r
+	return src, nil
+}
+
+// runPostExtract runs steps, the shell commands a recipe asks for, in dir, where a package has just been extracted.
+// The package's name, version and platform are passed to them as INFPM_PACKAGE, INFPM_VERSION, INFPM_OS and
+// INFPM_ARCH. The first step that fails fails the install.
+func runPostExtract(dir string, steps []string, name string, version string, platform Platform) error {
+	for _, step := range steps {
+		slog.Info("running post-extract step", "package", name, "command", step)
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", step)
+		} else {
+			cmd = exec.Command("sh", "-c", step)
+		}
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "INFPM_PACKAGE="+name, "INFPM_VERSION="+version, "INFPM_OS="+platform.OS, "INFPM_ARCH="+platform.Arch)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("post-extract step %q failed: %w", step, err)
+		}
+	}
+	return nil
+}
+
+// Save writes the recipe to fp.
+func (r *Recipe) Save(fp string) error {
+	f, err := os.Create(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := toml.NewEncoder(f).Encode(r); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// archiveExtensions are the extensions of release assets infpm can install.
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.bz2", ".tbz", ".tbz2", ".tar.zst", ".tzst", ".tar.zstd", ".tar"}
+
+// isArchiveAsset returns whether an asset's name suggests it is an archive infpm can install, rather than e.g. a
+// checksum or signature.
+func isArchiveAsset(name string) bool {
+	name = strings.ToLower(name)
+	return slices.ContainsFunc(archiveExtensions, func(ext string) bool { return strings.HasSuffix(name, ext) })
+}
+
+// assetPlatform guesses the platform an asset was built for from its name.
+func assetPlatform(name string) (Platform, bool) {
+	name = strings.ToLower(name)
+	p := Platform{}
+	p.OS, p.Arch = assetOs(name), assetArch(name)
+	return p, p.OS != "" && p.Arch != ""
+}
+
+// assetGlob turns an asset's name into a glob that should match the same asset in future releases, by replacing
+// the version with *.
+func assetGlob(name string, tag string) string {
+	for _, v := range []string{tag, strings.TrimPrefix(tag, "v")} {
+		if v != "" && strings.Contains(name, v) {
+			return strings.ReplaceAll(name, v, "*")
+		}
+	}
+	return name
+}
+
+// archiveLayout inspects an archive and returns how many leading directories should be stripped, i.e. 1 if
+// everything is inside a single top-level directory, and the executables it contains after stripping.
+func archiveLayout(r io.Reader) (int, []string, error) {
+	dr, err := decompress(bufio.NewReader(r))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer dr.Close()
+	tr := tar.NewReader(dr)
+
+	topDirs := map[string]bool{}
+	executables := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		if name == "." {
+			continue
+		}
+		top, _, nested := strings.Cut(name, "/")
+		if !nested && hdr.Typeflag != tar.TypeDir {
+			// A file at the root means there's nothing to strip.
+			topDirs[""] = true
+		}
+		topDirs[top] = true
+		if hdr.Typeflag == tar.TypeReg && hdr.Mode&0111 != 0 {
+			executables = append(executables, name)
+		}
+	}
+
+	strip := 0
+	if len(topDirs) == 1 && !topDirs[""] {
+		strip = 1
+	}
+	bins := []string{}
+	for _, e := range executables {
+		if strip == 1 {
+			_, e, _ = strings.Cut(e, "/")
+		}
+		bins = append(bins, e)
+	}
+	return strip, bins, nil
+}
+
+// scaffoldRecipe creates a recipe for a GitHub repository from its latest release: an asset glob for each platform
+// it publishes an archive for, and the layout of the archive for this machine (or any platform, if there isn't one).
+func scaffoldRecipe(reqPath string) (*Recipe, error) {
+	u, err := parseSourceUrl(reqPath)
+	if err != nil {
+		return nil, err
+	}
+	name := getRepoName(u)
+	if name == "" {
+		return nil, withKind(errUsage, errors.New(tr("Recipes can only be created for repositories on GitHub or a Gitea host, e.g. github.com/user/tool.")))
+	}
+
+	release, err := fetchGithubRelease(repoPathOf(u), "releases/latest")
+	if err != nil {
+		return nil, err
+	}
+
+	recipe := &Recipe{Name: name, Source: u.Host + u.Path, Assets: map[string]string{}}
+	var sample *githubApiReleaseAsset
+	for _, asset := range release.Assets {
+		if !isArchiveAsset(asset.Name) {
+			continue
+		}
+		p, ok := assetPlatform(asset.Name)
+		if !ok {
+			slog.Debug("can't tell which platform asset is for, skipping", "asset", asset.Name)
+			continue
+		}
+		if _, exists := recipe.Assets[p.String()]; exists {
+			slog.Info("more than one asset for platform, keeping the first; edit the recipe to choose another",
+				"platform", p, "asset", asset.Name)
+			continue
+		}
+		recipe.Assets[p.String()] = assetGlob(asset.Name, release.TagName)
+		if sample == nil || p.IsHost() {
+			sample = asset
+		}
+	}
+	if sample == nil {
+		return nil, withKind(errNoMatchingAsset, fmt.Errorf("no archives in release %s could be matched to a platform", release.TagName))
+	}
+
+	slog.Info("downloading asset to inspect its layout", "asset", sample.Name)
+	body, _, err := openRemote(sample.downloadUrl(), name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	strip, bins, err := archiveLayout(body)
+	if errors.Is(err, errors.ErrUnsupported) {
+		slog.Warn("can't inspect archives with this compression, fill in strip-components and bins yourself", "asset", sample.Name)
+		return recipe, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	recipe.StripComponents = strip
+	recipe.Bins = bins
+	return recipe, nil
+}
+
+func actionRecipeNew(ctx context.Context, cmd *cli.Command) error {
+	reqPath := cmd.Args().Get(0)
+	if reqPath == "" {
+		return withKind(errUsage, errors.New(tr("A GitHub repository is required, e.g. infpm recipe new github.com/user/tool.")))
+	}
+
+	recipe, err := scaffoldRecipe(reqPath)
+	if err != nil {
+		return withPackage(err, "", reqPath)
+	}
+	if key := cmd.String("minisign-key"); key != "" {
+		k, err := parseMinisignKey(key)
+		if err != nil {
+			return err
+		}
+		// Store the key itself rather than a path, so that the recipe can be shared.
+		recipe.MinisignKey = k.encode()
+	}
+
+	output := cmd.String("output")
+	if output == "" {
+		output = recipe.Name + RECIPE_EXTENSION
+	}
+	if _, err := os.Stat(output); err == nil && !cmd.Bool("force") {
+		if !newPrompter(!nonInteractive).confirm(tr("%s already exists. Overwrite it?", output), false) {
+			return withKind(errConflict, fmt.Errorf("%s already exists; use --force to overwrite it", output))
+		}
+	}
+	if err := recipe.Save(output); err != nil {
+		return err
+	}
+	fmt.Println(tr("Wrote a recipe for %s to %s. Check it over before using it.", recipe.Name, output))
+	return nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v3"
+)
+
+// openTarball opens the tarball a package was installed from, preferring the cached copy. If the cache entry is gone,
+// the tarball is read from its original location again and re-cached as it is read.
+func (pm *PackageManager) openTarball(rec *PackageRecord) (io.ReadCloser, *cacheWriter, error) {
+	if rec.Tarball != "" {
+		f, err := os.Open(rec.Tarball)
+		if err == nil {
+			slog.Info("using cached tarball", "package", rec.Name, "path", rec.Tarball)
+			return f, nil, nil
+		}
+		slog.Warn("cached tarball is unavailable, fetching it again", "package", rec.Name, "path", rec.Tarball, "err", err)
+	}
+
+	var r io.ReadCloser
+	if u, err := url.Parse(rec.Url); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if r, _, err = openRemote(rec.Url, rec.Name); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		f, err := os.Open(rec.Url)
+		if err != nil {
+			slog.Error("the tarball this package was installed from no longer exists", "path", rec.Url)
+			return nil, nil, err
+		}
+		r = f
+	}
+
+	tee, cache := teeToCache(r, pm.CachePath, rec.Url, 0)
+	return struct {
+		io.Reader
+		io.Closer
+	}{tee, r}, cache, nil
+}
+
+// Repair re-extracts a package from its tarball and replaces its directory in the store, restoring the contents
+// recorded in its manifest, then re-creates any missing links.
+func (pm *PackageManager) Repair(rec *PackageRecord) error {
+	if err := pm.reextract(rec); err != nil {
+		return err
+	}
+	if rec.Platform.IsHost() {
+		links, err := pm.linkPackage(rec.Name, rec.Platform, filepath.Join(pm.StorePath, rec.Path), rec.linkLayout())
+		if err != nil {
+			return err
+		}
+		rec.Links = mergeLinks(rec.Links, links)
+	}
+	return pm.db.Save()
+}
+
+// reextract extracts a package from its tarball again into its directory in the store, replacing whatever is there,
+// and checks the result against its manifest.
+func (pm *PackageManager) reextract(rec *PackageRecord) error {
+	fullPath := filepath.Join(pm.StorePath, rec.Path)
+	staging := fullPath + ".repair"
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	r, cache, err := pm.openTarball(rec)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	slog.Info("re-extracting archive", "package", rec.Name, "path", staging)
+	if err := tarExtractStrip(r, staging, rec.StripComponents); err != nil {
+		if cache != nil {
+			cache.Abort()
+		}
+		return err
+	}
+	if cache != nil {
+		if _, err := io.Copy(io.Discard, r); err == nil && cache.Commit(remoteMeta{Url: rec.Url, Sha256: rec.Sha256}) == nil {
+			rec.Tarball = cache.dest
+		} else {
+			cache.Abort()
+		}
+	}
+
+	if err := runPostExtract(staging, rec.PostExtract, rec.Name, rec.Version, rec.Platform); err != nil {
+		return err
+	}
+
+	// Never replace the package with something other than what was originally installed.
+	problems, err := verifyFiles(staging, rec.Files, true)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return withKind(errVerificationFailed, errors.New("the tarball no longer matches the manifest recorded at install time"))
+	}
+
+	old := fullPath + ".old"
+	if err := os.Rename(fullPath, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(staging, fullPath); err != nil {
+		os.Rename(old, fullPath)
+		return err
+	}
+	if err := os.RemoveAll(old); err != nil {
+		slog.Warn("failed to remove the old package directory", "path", old, "err", err)
+	}
+	return nil
+}
+
+// linkedRecord returns the version of a package that should be linked: the last installed of those with recorded
+// links, since use and upgrade take the links away from the others, or else the last installed. Returns nil if no
+// version is installed for this machine.
+func (pm *PackageManager) linkedRecord(name string) *PackageRecord {
+	var linked, latest *PackageRecord
+	for _, rec := range pm.db.Find(name) {
+		if !rec.Platform.IsHost() {
+			continue
+		}
+		if latest == nil || rec.InstalledAt.After(latest.InstalledAt) {
+			latest = rec
+		}
+		if len(rec.Links) > 0 && (linked == nil || rec.InstalledAt.After(linked.InstalledAt)) {
+			linked = rec
+		}
+	}
+	if linked != nil {
+		return linked
+	}
+	return latest
+}
+
+// Relink re-creates the missing links of the linked version of each named package, along with the directories they
+// are in, returning how many links were re-created. Links that now point somewhere else are left alone.
+func (pm *PackageManager) Relink(names []string) (int, error) {
+	created := 0
+	for _, name := range names {
+		rec := pm.linkedRecord(name)
+		if rec == nil {
+			continue
+		}
+		fullPath := filepath.Join(pm.StorePath, rec.Path)
+		planned, err := pm.planLinks(fullPath, rec.linkLayout())
+		if err != nil {
+			return created, withPackage(err, rec.Name, rec.Source)
+		}
+		missing := []plannedLink{}
+		for _, l := range planned {
+			if _, err := os.Lstat(l.Dst); os.IsNotExist(err) {
+				missing = append(missing, l)
+			}
+		}
+
+		links, err := pm.linkPackage(rec.Name, rec.Platform, fullPath, rec.linkLayout())
+		if err != nil {
+			return created, withPackage(err, rec.Name, rec.Source)
+		}
+		for _, l := range missing {
+			if linkExists(l.Src, l.Dst) {
+				slog.Info("re-created link", "package", rec.Name, "path", l.Dst)
+				created++
+			}
+		}
+		rec.Links = mergeLinks(rec.Links, links)
+	}
+	return created, pm.db.Save()
+}
+
+// removeDanglingLinks removes the links in the link roots into the store whose targets don't exist, returning how
+// many were removed. See findDanglingLinks.
+func (pm *PackageManager) removeDanglingLinks() (int, error) {
+	dangling, err := pm.findDanglingLinks()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range dangling {
+		slog.Info("removing dangling link", "path", p.Path)
+		if err := os.Remove(p.Path); err != nil {
+			return 0, err
+		}
+	}
+	return len(dangling), nil
+}
+
+func actionRelink(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	names := cmd.Args().Slice()
+	for _, name := range names {
+		if len(pm.db.Find(name)) == 0 {
+			return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+		}
+	}
+	return relink(pm, names)
+}
+
+// relink re-creates the missing links of the named packages, or of every package if none are named, in which case
+// dangling links into the store are removed too.
+func relink(pm *PackageManager, names []string) error {
+	all := len(names) == 0
+	if all {
+		names = pm.db.Names()
+	}
+	created, err := pm.Relink(names)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	if all {
+		if removed, err = pm.removeDanglingLinks(); err != nil {
+			return err
+		}
+	}
+	fmt.Println(tr("Re-created %d links and removed %d dangling links.", created, removed))
+	return nil
+}
+
+func actionRepair(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	name := cmd.Args().Get(0)
+	recs := pm.db.Packages
+	if name != "" {
+		if recs = pm.db.Find(name); len(recs) == 0 {
+			return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+		}
+	}
+
+	for _, rec := range recs {
+		problems, err := pm.verifyPackage(rec, true)
+		if err != nil {
+			return withPackage(err, rec.Name, rec.Url)
+		}
+		if len(problems) == 0 {
+			fmt.Println(tr("%s: ok, nothing to repair", rec))
+			continue
+		}
+
+		slog.Info("repairing package", "package", rec.Name, "version", rec.Version, "problems", len(problems))
+		if err := pm.Repair(rec); err != nil {
+			slog.Error("repair failed", "package", rec.Name, "version", rec.Version)
+			return withPackage(err, rec.Name, rec.Url)
+		}
+		fmt.Println(tr("%s: repaired", rec))
+	}
+
+	if name != "" {
+		return relink(pm, []string{name})
+	}
+	return relink(pm, nil)
+}
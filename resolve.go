@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// resolvedSource is the tarball that a URL given by the user resolves to. Name and Version are only set if they could
+// be determined from the source, e.g. from a GitHub release.
+type resolvedSource struct {
+	Name    string
+	Version string
+	Url     string
+	// Filename is the name the tarball should be saved as, or "" to use the last element of Url.
+	Filename string
+	// Checksums are the checksums published for the tarball, e.g. in the SHA256SUMS of a GitHub release.
+	Checksums []checksum
+	// Signature is the tarball's detached signature, if it must be verified against one.
+	Signature *signatureCheck
+	// Recipe is the recipe the source was resolved from, if it was one, which says how to extract and link it.
+	Recipe *Recipe
+}
+
+// resolveOpts controls how a source is resolved to a tarball.
+type resolveOpts struct {
+	// Platform is the OS and architecture to find an asset for.
+	Platform Platform
+	// Prefer is the build preference, see Config.Prefer. If empty, the configured preference for the package is used.
+	Prefer string
+	// Tag is the release to install, e.g. v1.2.0 or 1.2.0. If empty, the latest release is used.
+	Tag string
+	// Workflow is the GitHub Actions workflow, e.g. nightly.yml, whose artifacts are installed instead of a release,
+	// from its latest successful run on Branch, or on any branch if that is empty. See workflowSource.
+	Workflow string
+	Branch   string
+	// Prerelease is whether the latest release may be a prerelease. If unset, the channel configured for the package
+	// decides. See Config.Channel.
+	Prerelease bool
+	// Asset is a glob, or a regular expression wrapped in slashes, that the name of the asset to install must match.
+	// See matchAssetPattern.
+	Asset string
+	// NonInteractive is whether resolution must not prompt. If a choice is ambiguous, it fails instead.
+	NonInteractive bool
+	// RequireChecksum is whether a tarball must be verified against a checksum, either given by the user or
+	// published with the release.
+	RequireChecksum bool
+	// Keyring is the file of OpenPGP public keys that the tarball's signature must be made by. If empty, the keyring
+	// configured for the package is used, and if there is none, signatures aren't verified.
+	Keyring string
+	// MinisignKey is the minisign or signify public key that the tarball's signature must be made by, or a file
+	// containing it. If empty, the key configured for the package is used. See parseMinisignKey.
+	MinisignKey string
+	// NoVerify is whether signatures aren't verified, even if a key is configured.
+	NoVerify bool
+}
+
+// resolveFlags are the flags read by resolveOptsFromCmd.
+func resolveFlags() []cli.Flag {
+	return append(platformFlags(), &cli.StringFlag{
+		Name:  "prefer",
+		Usage: "Favour static or dynamic builds when choosing a release asset. Overrides prefer in the config file.",
+	}, &cli.BoolFlag{
+		Name:    "yes",
+		Aliases: []string{"y"},
+		Usage:   "Never prompt: pick the best matching asset automatically, failing if several match equally well.",
+	}, &cli.StringFlag{
+		Name:  "asset",
+		Usage: "Only consider release assets whose name matches `PATTERN`: a glob, e.g. '*linux-musl*.tar.gz', or a regular expression in slashes, e.g. '/linux-(musl|gnu)/'.",
+	}, &cli.StringFlag{
+		Name:  "release",
+		Usage: "Use the GitHub release tagged `TAG`, e.g. v1.2.0, instead of the latest. The v prefix is optional.",
+	}, &cli.StringFlag{
+		Name:  "workflow",
+		Usage: "Install the artifacts of the latest successful run of the GitHub Actions workflow `FILE`, e.g. nightly.yml, instead of a release. Needs a GitHub token.",
+	}, &cli.StringFlag{
+		Name:  "branch",
+		Usage: "With --workflow, only use runs on `BRANCH`.",
+	}, &cli.BoolFlag{
+		Name:  "pre",
+		Usage: "Consider prereleases when finding the latest release. Overrides channel in the config file.",
+	}, &cli.BoolFlag{
+		Name:  "require-checksum",
+		Usage: "Fail unless the tarball can be verified against a checksum given with --checksum or published with the release.",
+	}, &cli.StringFlag{
+		Name:  "keyring",
+		Usage: "Verify the tarball's detached signature (.asc or .sig) with the OpenPGP public keys in `FILE`. Overrides keyring in the config file.",
+	}, &cli.StringFlag{
+		Name:  "minisign-key",
+		Usage: "Verify the tarball's minisign or signify signature (.minisig or .sig) with the public key `KEY`, e.g. RWQf6LRC..., or the key in a file. Overrides minisign_key in the config file.",
+	}, &cli.BoolFlag{
+		Name:  "no-verify",
+		Usage: "Don't verify signatures, even if a key is configured.",
+	})
+}
+
+// resolveOptsFromCmd reads the resolution options shared by commands that resolve sources.
+func resolveOptsFromCmd(cmd *cli.Command) (resolveOpts, error) {
+	opts := resolveOpts{
+		Platform:        platformFromCmd(cmd),
+		Prefer:          cmd.String("prefer"),
+		Tag:             cmd.String("release"),
+		Workflow:        cmd.String("workflow"),
+		Branch:          cmd.String("branch"),
+		Prerelease:      cmd.Bool("pre"),
+		Asset:           cmd.String("asset"),
+		NonInteractive:  nonInteractive || cmd.Bool("yes"),
+		RequireChecksum: cmd.Bool("require-checksum"),
+		Keyring:         cmd.String("keyring"),
+		MinisignKey:     cmd.String("minisign-key"),
+		NoVerify:        cmd.Bool("no-verify"),
+	}
+	if err := validateAssetPattern(opts.Asset); err != nil {
+		return opts, err
+	}
+	if opts.Branch != "" && opts.Workflow == "" {
+		return opts, withKind(errUsage, errors.New(tr("--branch chooses the runs of a workflow, so it needs --workflow.")))
+	}
+	return opts, validatePrefer(opts.Prefer)
+}
+
+// parseSourceUrl parses a URL given by the user. URLs without a scheme, e.g. github.com/user/repo, are assumed to be https.
+func parseSourceUrl(reqPath string) (*url.URL, error) {
+	if repo, ok := expandRepoShorthand(reqPath); ok {
+		reqPath = repo
+	}
+	if !strings.Contains(reqPath, "://") {
+		reqPath = "https://" + reqPath
+	}
+
+	userUrl, err := url.ParseRequestURI(reqPath)
+	if err != nil {
+		slog.Error("The URL provided was invalid.")
+		return nil, withKind(errUsage, err)
+	}
+	if userUrl.Scheme != "http" && userUrl.Scheme != "https" {
+		return nil, withKind(errUsage, errors.New(tr("A non-http URL was provided. Please provide a URL with the scheme http:// or https://.")))
+	}
+	return userUrl, nil
+}
+
+// repoShorthandPattern matches owner/repo, the shorthand for a repository on GitHub. GitHub's user and organisation
+// names can't contain dots, so anything with a dot before the slash is a host instead.
+var repoShorthandPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*/[A-Za-z0-9._-]+$`)
+
+// expandRepoShorthand returns the URL of the GitHub repository that reqPath is shorthand for, e.g.
+// github.com/sharkdp/fd for sharkdp/fd. Names that look like a tarball or recipe, e.g. dist/tool.tar.gz, are taken
+// to be paths instead.
+func expandRepoShorthand(reqPath string) (string, bool) {
+	if !repoShorthandPattern.MatchString(reqPath) || isArchiveAsset(reqPath) || strings.HasSuffix(reqPath, RECIPE_EXTENSION) {
+		return "", false
+	}
+	return "github.com/" + reqPath, true
+}
+
+// tarballVersionPattern matches the version in the name of a tarball, e.g. -1.2.0 or _v14.1.0-rc1, up to the next
+// separator.
+var tarballVersionPattern = regexp.MustCompile(`[-_]v?(\d+(?:\.\d+)+(?:-(?:alpha|beta|rc|pre)[.\d]*)?)(?:[-_.]|$)`)
+
+// guessNameVersion guesses the name and version of a package from the file name of its tarball, e.g. ripgrep and
+// 14.1.0 from ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz. Returns false if the name has no version in it.
+func guessNameVersion(filename string) (string, string, bool) {
+	base := path.Base(filepath.ToSlash(filename))
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(base), ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	m := tarballVersionPattern.FindStringSubmatchIndex(base)
+	if m == nil || m[0] == 0 {
+		return "", "", false
+	}
+	return base[:m[0]], base[m[2]:m[3]], true
+}
+
+// fillNameVersion sets the name and version of opts that weren't given from the file name of the tarball, if they
+// can be guessed from it. See guessNameVersion.
+func fillNameVersion(opts *PreinstallPackageOpts, filename string) {
+	if opts.Name != "" && opts.Version != "" {
+		return
+	}
+	name, version, ok := guessNameVersion(filename)
+	if !ok {
+		return
+	}
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	if opts.Version == "" {
+		opts.Version = version
+	}
+	slog.Info("guessed name and version from the tarball's name", "name", opts.Name, "version", opts.Version, "file", filename)
+}
+
+// resolveSource finds the tarball to download for a URL given by the user. URLs of repositories on GitHub or a Gitea
+// host are resolved to an asset of the latest (or tagged) release built for the platform, or to an artifact of a
+// workflow's latest run if opts.Workflow is set or the URL is a workflow's, see workflowSource; any other URL is assumed to
+// point to a tarball directly. Sources in the config file and URLs with placeholders are expanded for the version and
+// platform, see SourceTemplate, and recipe files, or the names of recipes in a tap, are resolved as they describe,
+// see Recipe.
+func resolveSource(reqPath string, opts resolveOpts) (*resolvedSource, error) {
+	if isRecipePath(reqPath) {
+		r, err := readRecipe(reqPath)
+		if err != nil {
+			return nil, err
+		}
+		return r.resolve(opts)
+	}
+	if t, name, ok := lookupSourceTemplate(reqPath); ok {
+		return resolveTemplate(t, name, opts)
+	}
+	if fp, ok := findTapRecipe(reqPath); ok {
+		r, err := readRecipe(fp)
+		if err != nil {
+			return nil, err
+		}
+		return r.resolve(opts)
+	}
+	if tapNamePattern.MatchString(reqPath) && !strings.Contains(reqPath, ".") {
+		// Without a dot it can't be a host either, so it must have been meant as the name of a recipe.
+		return nil, withKind(errUsage, errors.New(tr("No tap has a recipe called %s. Add a tap with infpm tap add, or give a URL.", reqPath)))
+	}
+
+	userUrl, err := parseSourceUrl(reqPath)
+	if err != nil {
+		return nil, err
+	}
+	if w, ok := parseWorkflowSource(userUrl); ok {
+		return resolveWorkflow(w, opts)
+	} else if opts.Workflow != "" {
+		w, err := workflowSourceFor(reqPath, opts)
+		if err != nil {
+			return nil, err
+		}
+		return resolveWorkflow(w, opts)
+	}
+
+	if opts.Keyring == "" {
+		opts.Keyring = config.KeyringFor(getRepoName(userUrl))
+	}
+	if opts.MinisignKey == "" {
+		opts.MinisignKey = config.MinisignKeyFor(getRepoName(userUrl))
+	}
+
+	if getRepoName(userUrl) == "" {
+		src := &resolvedSource{Url: userUrl.String()}
+		keys, err := verifyKeysFor(opts)
+		if err != nil {
+			return nil, err
+		}
+		if keys != nil {
+			if src.Signature = fetchSignature(src.Url, keys); src.Signature == nil {
+				return nil, withKind(errVerificationFailed, fmt.Errorf("no signature was found for %s; pass --no-verify to install it anyway", src.Url))
+			}
+		}
+		return src, nil
+	}
+
+	if opts.Prefer == "" {
+		opts.Prefer = config.PreferFor(getRepoName(userUrl))
+	}
+	opts.Prerelease = opts.Prerelease || config.PrereleaseFor(getRepoName(userUrl))
+
+	asset, err := fetchGithubAsset(userUrl, opts)
+	if err != nil {
+		slog.Error("failed to find asset from release", "url", reqPath)
+		return nil, withPackage(err, getRepoName(userUrl), reqPath)
+	}
+
+	progress.Emit(progressEvent{Event: PROGRESS_RESOLVE, Package: asset.Name, Version: asset.Version, Url: asset.Url})
+	return &resolvedSource{Name: asset.Name, Version: asset.Version, Url: asset.Url, Filename: asset.Filename, Checksums: asset.Checksums, Signature: asset.Signature}, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DOWNLOAD_META_SUFFIX is appended to the path of a download, partial or cached, for the file recording which remote
+// file it is, so that it is only resumed or reused if the remote file is unchanged.
+const DOWNLOAD_META_SUFFIX = ".meta"
+
+// remoteMeta identifies a version of a remote file.
+type remoteMeta struct {
+	Url          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Sha256 is the hex SHA-256 digest of the whole file, once it has been downloaded.
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// validator returns what to send in If-Range to resume the download only if the remote file hasn't changed, or "" if
+// it can't be resumed safely. Weak ETags can't be used with If-Range.
+func (m remoteMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// readMeta reads what the download at fp is, or returns nil if that wasn't recorded.
+func readMeta(fp string) *remoteMeta {
+	data, err := os.ReadFile(fp + DOWNLOAD_META_SUFFIX)
+	if err != nil {
+		return nil
+	}
+	meta := &remoteMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil
+	}
+	return meta
+}
+
+// writeMeta records what the download at fp is.
+func writeMeta(fp string, meta remoteMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fp+DOWNLOAD_META_SUFFIX, data, 0644)
+}
+
+// readPartial returns the size of the partial download at part and what it is part of, or 0 and nil if there is no
+// partial download there that can be resumed from url.
+func readPartial(part string, url string) (int64, *remoteMeta) {
+	meta := readMeta(part)
+	if meta == nil || meta.Url != url || meta.validator() == "" {
+		return 0, nil
+	}
+	info, err := os.Stat(part)
+	if err != nil || info.Size() == 0 {
+		return 0, nil
+	}
+	return info.Size(), meta
+}
+
+// keepPartial records what the partial download at part is part of, so that it can be resumed later. Nothing is
+// recorded if the server gave no way of telling whether the remote file changes.
+func keepPartial(part string, meta remoteMeta) error {
+	if meta.validator() == "" {
+		return nil
+	}
+	return writeMeta(part, meta)
+}
+
+// removePartial deletes the partial download at part and its metadata.
+func removePartial(part string) {
+	os.Remove(part)
+	os.Remove(part + DOWNLOAD_META_SUFFIX)
+}
+
+// contentRangeStart returns the first byte of the range in a 206 response, or -1 if it can't be parsed.
+func contentRangeStart(resp *http.Response) int64 {
+	rest, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// skipWriter discards the first skip bytes written to it, then writes the rest to w. It is used to write a resumed
+// download after the part that was already on disk, when the whole tarball is read again to hash it.
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (s *skipWriter) Write(b []byte) (int, error) {
+	if s.skip >= int64(len(b)) {
+		s.skip -= int64(len(b))
+		return len(b), nil
+	}
+	n, err := s.w.Write(b[s.skip:])
+	n += int(s.skip)
+	s.skip = 0
+	return n, err
+}
+
+// downloadMeta returns what identifies the remote file r is the download of, or nothing if r isn't a download.
+func downloadMeta(r io.Reader) remoteMeta {
+	switch b := r.(type) {
+	case *remoteBody:
+		return b.meta
+	case *resumedBody:
+		return b.body.meta
+	}
+	return remoteMeta{}
+}
+
+// resumedBody is a resumed download: the partial download on disk, followed by the rest of it from the server.
+type resumedBody struct {
+	io.Reader
+	part *os.File
+	body *remoteBody
+}
+
+func (r *resumedBody) Close() error {
+	r.part.Close()
+	return r.body.Close()
+}
+
+// resumeFromCache resumes the download of the package from the partial cache entry left by an interrupted install, if
+// there is one for the same remote file. The tarball is then read from the partial entry, followed by the rest of
+// the download. Returns how many bytes were already downloaded, or 0 if the download wasn't resumed.
+func (ppkg *PreinstallPackage) resumeFromCache(cachePath string) int64 {
+	body, ok := ppkg.tarballReader.(*remoteBody)
+	if cachePath == "" || !ok || body.received > 0 {
+		return 0
+	}
+	part := cacheEntryPath(cachePath, ppkg.Url) + ".part"
+	offset, meta := readPartial(part, ppkg.Url)
+	if meta == nil || (body.size >= 0 && offset >= body.size) {
+		return 0
+	}
+	// The download has been started already, so only resume if the file hasn't changed since the partial one.
+	if body.meta.validator() != meta.validator() {
+		slog.Info("remote file changed since the interrupted download, starting again", "url", ppkg.Url)
+		removePartial(part)
+		return 0
+	}
+
+	f, err := os.Open(part)
+	if err != nil {
+		return 0
+	}
+	rest, resumed, err := openRemoteFrom(ppkg.Url, ppkg.Name, offset, meta.validator())
+	if err != nil || !resumed {
+		f.Close()
+		if rest != nil {
+			rest.Close()
+		}
+		return 0
+	}
+
+	slog.Info("resuming interrupted download", "url", ppkg.Url, "from", offset)
+	body.Close()
+	ppkg.tarballReader = &resumedBody{Reader: io.MultiReader(io.LimitReader(f, offset), rest), part: f, body: rest}
+	ppkg.tarballSize = rest.size
+	return offset
+}
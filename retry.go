@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults for retrying failed requests, used for anything not set in the retry section of the config file.
+const (
+	DEFAULT_RETRY_ATTEMPTS    = 4
+	DEFAULT_RETRY_BACKOFF     = time.Second
+	DEFAULT_RETRY_MAX_BACKOFF = 30 * time.Second
+	DEFAULT_RETRY_JITTER      = 0.5
+)
+
+// retryPolicy is how requests that fail in a way that may not happen again are retried. Each retry waits twice as
+// long as the one before, up to MaxBackoff, with Jitter of the delay randomised so that many clients failing at
+// once don't all retry at once.
+type retryPolicy struct {
+	// Attempts is how many times a request is made in total before giving up. 1 disables retries.
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each delay, from 0 to 1, that is randomised.
+	Jitter float64
+}
+
+// retry is the policy used for every request. It is set from the config file and --retries.
+var retry = retryPolicy{
+	Attempts:   DEFAULT_RETRY_ATTEMPTS,
+	Backoff:    DEFAULT_RETRY_BACKOFF,
+	MaxBackoff: DEFAULT_RETRY_MAX_BACKOFF,
+	Jitter:     DEFAULT_RETRY_JITTER,
+}
+
+// delay returns how long to wait before the attempt after the given one, which is numbered from 1.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryableStatus returns whether a response with the given status may succeed if the request is made again. Server
+// errors and rate limiting are usually temporary, but other client errors, like 404 or 403, won't go away by
+// themselves.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500 && code != http.StatusNotImplemented && code != http.StatusHTTPVersionNotSupported
+}
+
+// retryableError returns whether a request that failed with err may succeed if it is made again: timeouts, and
+// connections that were refused, reset or closed before a response was received.
+func retryableError(err error) bool {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	case errors.As(err, &opErr) && connectionFailed(opErr.Err):
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns how long the server asked to wait before retrying with the Retry-After header, or 0 if it
+// didn't. Only a number of seconds is understood, which is what GitHub and most CDNs send.
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// retryTransport makes requests again according to retry when they fail in a way that may be temporary. Only
+// requests without a body are retried, which covers the GitHub API calls and downloads. A download that is cut off
+// once the response has started isn't retried here, but can be resumed by running the command again.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= retry.Attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait := retry.delay(attempt)
+		var reason string
+		if err != nil {
+			if !retryableError(err) {
+				return nil, err
+			}
+			reason = err.Error()
+		} else {
+			if !retryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+			reason = fmt.Sprintf("status %d", resp.StatusCode)
+			if after := retryAfter(resp); after > 0 {
+				// Don't wait indefinitely if the server asks for it.
+				wait = min(after, retry.MaxBackoff)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		slog.Warn("request failed, retrying", "url", redactUrl(req.URL), "reason", reason, "attempt", attempt, "of", retry.Attempts, "in", wait.Round(time.Millisecond))
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package main
+
+// connectionFailed can't tell why a connection failed on this platform, so such failures are only retried if they
+// are timeouts.
+func connectionFailed(err error) bool {
+	return false
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// connectionFailed returns whether err, from a network operation, is the connection being refused, reset or
+// aborted.
+func connectionFailed(err error) bool {
+	var sysErr *os.SyscallError
+	if !errors.As(err, &sysErr) {
+		return false
+	}
+	switch sysErr.Err {
+	case syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED:
+		return true
+	}
+	return false
+}
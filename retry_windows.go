@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const (
+	WSAECONNABORTED          = syscall.Errno(10053)
+	WSAECONNRESET            = syscall.Errno(10054)
+	WSAECONNREFUSED          = syscall.Errno(10061)
+	ERROR_CONNECTION_REFUSED = syscall.Errno(1225)
+)
+
+// connectionFailed returns whether err, from a network operation, is the connection being refused, reset or
+// aborted. Winsock reports these with its own error numbers rather than the ones in syscall.
+func connectionFailed(err error) bool {
+	var sysErr *os.SyscallError
+	if !errors.As(err, &sysErr) {
+		return false
+	}
+	switch sysErr.Err {
+	case WSAECONNABORTED, WSAECONNRESET, WSAECONNREFUSED, ERROR_CONNECTION_REFUSED:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// installScriptNames are the names of scripts that packages ship to be run at install or removal time, e.g. Debian
+// maintainer scripts or an install.sh at the root of a tarball. infpm never runs them.
+var installScriptNames = []string{
+	"install", "install.sh", "install.bash", "install.ps1", "install.bat", "install.cmd",
+	"setup.sh", "postinst", "preinst", "postrm", "prerm", "postinstall", "preinstall",
+	"post-install", "post-install.sh", "post_install", "post_install.sh", "postinstall.sh", ".install",
+}
+
+// scriptExtensions are the extensions of files that are scripts even without a shebang.
+var scriptExtensions = []string{".sh", ".bash", ".ps1", ".bat", ".cmd"}
+
+// installScripts returns the paths, relative to root, of the install scripts among a package's files. Files are
+// only considered scripts if they look like one, so that a real binary called e.g. "install" isn't reported.
+func installScripts(root string, files []FileEntry) []string {
+	scripts := []string{}
+	for _, f := range files {
+		if !f.Mode.IsRegular() || !isInstallScriptName(path.Base(f.Path)) {
+			continue
+		}
+		if isScript(filepath.Join(root, filepath.FromSlash(f.Path))) {
+			scripts = append(scripts, f.Path)
+		}
+	}
+	return scripts
+}
+
+func isInstallScriptName(name string) bool {
+	name = strings.ToLower(name)
+	for _, n := range installScriptNames {
+		if name == n || (n[0] == '.' && strings.HasSuffix(name, n)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isScript returns whether the file at fp is a script, judging by its extension or a #! line.
+func isScript(fp string) bool {
+	ext := strings.ToLower(filepath.Ext(fp))
+	for _, e := range scriptExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, []byte("#!"))
+}
+
+// warnInstallScripts tells the user about any install scripts in a package that was just installed, since the
+// package may not work until the steps they would have performed are done by hand.
+func (pm *PackageManager) warnInstallScripts(pkg *Package) {
+	for _, rec := range pm.db.Find(pkg.Name) {
+		if rec.Id != pkg.Id {
+			continue
+		}
+		if scripts := installScripts(pkg.FullPath, rec.Files); len(scripts) > 0 {
+			slog.Warn("package ships install scripts, which infpm does not run. See what they would have done with infpm scripts --show "+pkg.Name,
+				"package", pkg.Name, "scripts", scripts)
+		}
+	}
+}
+
+func actionScripts(ctx context.Context, cmd *cli.Command) error {
+	name := cmd.Args().Get(0)
+	if name == "" {
+		return withKind(errUsage, errors.New(tr("A package name is required. See --help scripts.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	recs := pm.db.Find(name)
+	if len(recs) == 0 {
+		return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+	}
+
+	for _, rec := range recs {
+		fullPath := filepath.Join(pm.StorePath, rec.Path)
+		scripts := installScripts(fullPath, rec.Files)
+		if len(scripts) == 0 {
+			fmt.Println(tr("%s has no install scripts.", rec))
+			continue
+		}
+
+		fmt.Println(tr("%s has install scripts that were not run:", rec))
+		for _, script := range scripts {
+			fp := filepath.Join(fullPath, filepath.FromSlash(script))
+			if !cmd.Bool("show") {
+				fmt.Println("  " + fp)
+				continue
+			}
+
+			data, err := os.ReadFile(fp)
+			if err != nil {
+				return err
+			}
+			fmt.Println("\n==> " + fp + " <==")
+			fmt.Println(strings.TrimRight(string(data), "\n"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+// SEARCH_LIMIT is how many repositories search lists unless --limit is given. Each needs a request of its own to see
+// whether its latest release has binaries, so this is kept low to stay under GitHub's rate limits.
+const SEARCH_LIMIT = 10
+
+// What a repository's latest release has for the platform, as reported by search.
+const (
+	RELEASE_BINARIES        = "binaries"
+	RELEASE_OTHER_PLATFORMS = "other-platforms"
+	RELEASE_NONE            = "none"
+	RELEASE_UNKNOWN         = "unknown"
+)
+
+// githubSearchResults represents the response from the GitHub API specified here:
+// https://docs.github.com/en/rest/search/search?apiVersion=2022-11-28#search-repositories
+type githubSearchResults struct {
+	TotalCount int           `json:"total_count"`
+	Items      []*githubRepo `json:"items"`
+}
+
+type githubRepo struct {
+	FullName    string `json:"full_name"`
+	Description string `json:"description"`
+	HtmlUrl     string `json:"html_url"`
+	Stars       int    `json:"stargazers_count"`
+	Archived    bool   `json:"archived"`
+}
+
+// searchResult is a repository found by search, with what its latest release has for the platform: one of the
+// RELEASE_ constants.
+type searchResult struct {
+	*githubRepo
+	Releases string `json:"releases"`
+	Latest   string `json:"latest,omitempty"`
+}
+
+// searchGithub searches GitHub for repositories matching query, best match first, returning at most limit of them.
+// The query is passed to GitHub as is, so its qualifiers work, e.g. language:go.
+func searchGithub(query string, limit int) ([]*githubRepo, error) {
+	apiUrl, _ := url.Parse("https://api.github.com/search/repositories")
+	apiUrl.RawQuery = url.Values{"q": {query}, "per_page": {strconv.Itoa(limit)}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, apiUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	authorizeRequest(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, withKind(errNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return nil, withKind(errUsage, errors.New(tr("GitHub couldn't search for %q. Check the query's syntax.", query)))
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, githubApiError(resp, "")
+	}
+
+	results := &githubSearchResults{}
+	if err := json.NewDecoder(resp.Body).Decode(results); err != nil {
+		slog.Error("failed to decode search API response")
+		return nil, err
+	}
+	return results.Items, nil
+}
+
+// checkReleases finds out what the latest release of each repository has for the platform, a few at a time.
+func checkReleases(repos []*githubRepo, opts resolveOpts) []*searchResult {
+	results := make([]*searchResult, len(repos))
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		results[i] = &searchResult{githubRepo: repo, Releases: RELEASE_UNKNOWN}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			release, err := fetchLatestRelease("/"+repo.FullName, opts.Prerelease)
+			switch {
+			case errors.Is(err, errUsage):
+				// GitHub answers 404 for the latest release of a repository without any.
+				results[i].Releases = RELEASE_NONE
+			case err != nil:
+				slog.Debug("failed to check the latest release", "repo", repo.FullName, "err", err)
+			case len(matchingAssets(release, opts)) > 0:
+				results[i].Releases, results[i].Latest = RELEASE_BINARIES, release.TagName
+			default:
+				results[i].Releases, results[i].Latest = RELEASE_OTHER_PLATFORMS, release.TagName
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// describeReleases says what a search result's latest release has for the platform.
+func (r *searchResult) describeReleases(platform Platform) string {
+	switch r.Releases {
+	case RELEASE_BINARIES:
+		return tr("%s for %s", r.Latest, platform)
+	case RELEASE_OTHER_PLATFORMS:
+		return tr("%s, none for %s", r.Latest, platform)
+	case RELEASE_NONE:
+		return tr("no releases")
+	}
+	return "?"
+}
+
+func actionSearch(ctx context.Context, cmd *cli.Command) error {
+	query := strings.Join(cmd.Args().Slice(), " ")
+	if query == "" {
+		return withKind(errUsage, errors.New(tr("Something to search for is required, e.g. infpm search ripgrep.")))
+	}
+	limit := int(cmd.Int("limit"))
+	if limit < 1 || limit > 100 {
+		return withKind(errUsage, errors.New(tr("--limit must be from 1 to 100.")))
+	}
+	ropts, err := resolveOptsFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	repos, err := searchGithub(query, limit)
+	if err != nil {
+		return err
+	}
+	results := checkReleases(repos, ropts)
+	if cmd.Root().Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(results)
+	}
+	if len(results) == 0 {
+		fmt.Println(tr("No repositories on GitHub match %q.", query))
+		return nil
+	}
+
+	// Results are only offered to install from when someone is there to choose one.
+	if ropts.NonInteractive || !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, tr("REPOSITORY\tSTARS\tRELEASES\tDESCRIPTION"))
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.FullName, r.Stars, r.describeReleases(ropts.Platform), r.Description)
+		}
+		return w.Flush()
+	}
+
+	items := []pickerItem{}
+	for _, r := range results {
+		detail := []string{tr("%d stars", r.Stars), r.describeReleases(ropts.Platform)}
+		if r.Archived {
+			detail = append(detail, tr("archived"))
+		}
+		if r.Description != "" {
+			detail = append(detail, r.Description)
+		}
+		items = append(items, pickerItem{Label: r.FullName, Detail: strings.Join(detail, ", ")})
+	}
+	i, err := newPrompter(true).choose(tr("Choose a repository to install from:"), items, -1)
+	if errors.Is(err, errNoAnswer) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	source := "https://github.com/" + results[i].FullName
+	pkg, err := pm.installArg(cmd, source, PreinstallPackageOpts{Platform: ropts.Platform}, ropts)
+	if err != nil {
+		return err
+	}
+	if pkg.Symlinked && !pm.Portable {
+		printRehashHint()
+	}
+	return nil
+}
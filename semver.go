@@ -0,0 +1,361 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// semVersion is a version parsed from a tag or version string, e.g. v1.2.3, 1.2.3-rc1 or a date like 2024-01-15. It
+// is looser than semantic versioning, as projects tag their releases in all sorts of ways.
+type semVersion struct {
+	// Nums are the numeric components, e.g. [1 2 3] for 1.2.3, or [2024 1 15] for 2024-01-15.
+	Nums []int
+	// Pre is the prerelease, e.g. rc1 for 1.2.3-rc1, or "" for a release. Build metadata after a + is dropped.
+	Pre string
+}
+
+// datePattern matches versions that are dates, whose components are separated by dashes rather than dots.
+var datePattern = regexp.MustCompile(`^(\d{4})[-.](\d{2})[-.](\d{2})`)
+
+// parseVersion parses a version or tag. Anything before the first digit is skipped, so that v1.2.3, jq-1.7.1 and
+// cli/v1.2.0, as monorepos tag their components, all parse. Returns false if s has no version in it.
+func parseVersion(s string) (semVersion, bool) {
+	s = s[strings.LastIndex(s, "/")+1:]
+	i := strings.IndexFunc(s, unicode.IsDigit)
+	if i < 0 {
+		return semVersion{}, false
+	}
+	s = s[i:]
+
+	v := semVersion{}
+	var rest string
+	if m := datePattern.FindStringSubmatch(s); m != nil {
+		for _, part := range m[1:] {
+			n, _ := strconv.Atoi(part)
+			v.Nums = append(v.Nums, n)
+		}
+		rest = s[len(m[0]):]
+	} else {
+		end := strings.IndexFunc(s, func(r rune) bool { return r != '.' && !unicode.IsDigit(r) })
+		if end < 0 {
+			end = len(s)
+		}
+		core := strings.TrimRight(s[:end], ".")
+		for _, part := range strings.Split(core, ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return semVersion{}, false
+			}
+			v.Nums = append(v.Nums, n)
+		}
+		rest = s[len(core):]
+	}
+	rest, _, _ = strings.Cut(rest, "+")
+	v.Pre = strings.TrimLeft(rest, "-_.")
+	return v, true
+}
+
+// num returns the i-th numeric component of v. Missing components are 0, so 1.4 and 1.4.0 are the same version.
+func (v semVersion) num(i int) int {
+	if i < len(v.Nums) {
+		return v.Nums[i]
+	}
+	return 0
+}
+
+// sameRelease returns whether v and w have the same numeric components, whatever their prereleases.
+func (v semVersion) sameRelease(w semVersion) bool {
+	for i := range max(len(v.Nums), len(w.Nums)) {
+		if v.num(i) != w.num(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders v and w: by their numeric components, then with prereleases before the release they lead up to.
+func (v semVersion) compare(w semVersion) int {
+	for i := range max(len(v.Nums), len(w.Nums)) {
+		if c := cmp.Compare(v.num(i), w.num(i)); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case v.Pre == w.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case w.Pre == "":
+		return -1
+	}
+	return comparePrerelease(v.Pre, w.Pre)
+}
+
+// comparePrerelease orders prereleases naturally, comparing runs of digits as numbers, so rc10 comes after rc9 and
+// beta.2 after beta.
+func comparePrerelease(a string, b string) int {
+	for a != "" && b != "" {
+		var ca, cb string
+		ca, a = nextVersionChunk(a)
+		cb, b = nextVersionChunk(b)
+		aNum, bNum := unicode.IsDigit(rune(ca[0])), unicode.IsDigit(rune(cb[0]))
+		if aNum && bNum {
+			ca, cb = strings.TrimLeft(ca, "0"), strings.TrimLeft(cb, "0")
+			if c := cmp.Compare(len(ca), len(cb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(strings.ToLower(ca), strings.ToLower(cb)); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// nextVersionChunk splits the leading run of digits, or of anything else, off s.
+func nextVersionChunk(s string) (string, string) {
+	digit := unicode.IsDigit(rune(s[0]))
+	end := strings.IndexFunc(s, func(r rune) bool { return unicode.IsDigit(r) != digit })
+	if end < 0 {
+		end = len(s)
+	}
+	return s[:end], s[end:]
+}
+
+// compareVersions orders two versions, returning false if either can't be parsed. See parseVersion.
+func compareVersions(a string, b string) (int, bool) {
+	va, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+	return va.compare(vb), true
+}
+
+// isNewerVersion returns whether version is newer than installed, so that upgrading to it isn't a downgrade.
+// Versions that can't be compared are taken to be newer if they differ at all.
+func isNewerVersion(version string, installed string) bool {
+	if c, ok := compareVersions(version, installed); ok {
+		return c > 0
+	}
+	return !sameVersion(version, installed)
+}
+
+// versionComparator is a single condition of a versionConstraint, e.g. >=1.4.
+type versionComparator struct {
+	// Op is one of =, !=, >, >=, < and <=, or "" for a version that constrains only the components it gives, so 1.4
+	// matches 1.4.0 and 1.4.2 but not 1.40 or 1.5.
+	Op      string
+	Version semVersion
+}
+
+// matches returns whether v meets the condition.
+func (c versionComparator) matches(v semVersion) bool {
+	if c.Op == "" {
+		for i, n := range c.Version.Nums {
+			if v.num(i) != n {
+				return false
+			}
+		}
+		return c.Version.Pre == "" || c.Version.Pre == v.Pre
+	}
+	r := v.compare(c.Version)
+	switch c.Op {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	default:
+		return r <= 0
+	}
+}
+
+// versionConstraint is a set of versions, as a packages file or recipe gives them:
+//
+//	1.4          1.4.0, 1.4.2 and so on: only the components given are constrained
+//	=1.4.2       exactly 1.4.2
+//	^1.4         compatible with 1.4, i.e. >=1.4 <2; ^0.9 means >=0.9 <0.10
+//	~1.4.2       patches of 1.4.2, i.e. >=1.4.2 <1.5
+//	1.4.x        the same as 1.4; * or x alone allows any version
+//	>=0.9 <2     every condition must hold, and they may also be separated by commas
+//	^1 || ^2     either of the alternatives
+//
+// Prereleases only match a constraint that mentions a prerelease of the same version, so ^1.4 doesn't match
+// 2.0.0-rc1. Anything that isn't a constraint, e.g. nightly, only matches itself.
+type versionConstraint struct {
+	// Alternatives are the sets of comparators, any one of which must match in full.
+	Alternatives [][]versionComparator
+	// Literal is set instead if the constraint is a version that can't be parsed, which only matches itself.
+	Literal string
+
+	raw string
+}
+
+// versionOps are the operators a comparator can start with, longest first so that >= isn't read as >.
+var versionOps = []string{">=", "<=", "!=", ">", "<", "=", "^", "~"}
+
+// versionWildcards stand for any value of a component, e.g. 1.4.x.
+var versionWildcards = []string{"*", "x", "X"}
+
+// isVersionWildcard returns whether s is a wildcard, or ends in one, e.g. 1.4.x.
+func isVersionWildcard(s string) bool {
+	if slices.Contains(versionWildcards, s) {
+		return true
+	}
+	i := strings.LastIndex(s, ".")
+	return i >= 0 && slices.Contains(versionWildcards, s[i+1:])
+}
+
+// parseVersionConstraint parses a constraint. "" allows any version.
+func parseVersionConstraint(s string) (versionConstraint, error) {
+	s = strings.TrimSpace(s)
+	c := versionConstraint{raw: s}
+	if s == "" {
+		return c, nil
+	}
+	if !strings.ContainsAny(s, "<>=!^~|, ") && !isVersionWildcard(s) {
+		if _, ok := parseVersion(s); !ok {
+			c.Literal = s
+			return c, nil
+		}
+	}
+
+	for _, alt := range strings.Split(s, "||") {
+		// Operators may be separated from their versions, e.g. >= 1.2.
+		fields := strings.Fields(strings.ReplaceAll(alt, ",", " "))
+		comparators := []versionComparator{}
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if slices.Contains(versionOps, field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			cs, err := parseVersionComparator(field)
+			if err != nil {
+				return versionConstraint{}, withKind(errUsage, fmt.Errorf("invalid version constraint %q: %w", s, err))
+			}
+			comparators = append(comparators, cs...)
+		}
+		if len(fields) == 0 {
+			return versionConstraint{}, withKind(errUsage, fmt.Errorf("invalid version constraint %q: an alternative is empty", s))
+		}
+		c.Alternatives = append(c.Alternatives, comparators)
+	}
+	return c, nil
+}
+
+// parseVersionComparator parses one condition of a constraint, expanding ^ and ~ into the bounds they stand for.
+func parseVersionComparator(s string) ([]versionComparator, error) {
+	op := ""
+	for _, o := range versionOps {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	rest := strings.TrimPrefix(s, op)
+	if slices.Contains(versionWildcards, rest) {
+		if op != "" {
+			return nil, fmt.Errorf("%s can't be used with a wildcard", op)
+		}
+		return []versionComparator{{Op: ">=", Version: semVersion{Nums: []int{0}, Pre: "0"}}}, nil
+	}
+	wildcard := isVersionWildcard(rest)
+	if wildcard {
+		rest = rest[:len(rest)-2]
+	}
+	if wildcard && op != "" {
+		return nil, fmt.Errorf("%s can't be used with a wildcard", op)
+	}
+	v, ok := parseVersion(rest)
+	if !ok || op != "" && !unicode.IsDigit(rune(strings.TrimPrefix(rest, "v")[0])) {
+		return nil, fmt.Errorf("%q isn't a version", rest)
+	}
+
+	switch op {
+	case "^":
+		// Everything up to the next change of the first non-zero component, or of the last component given.
+		i := slices.IndexFunc(v.Nums, func(n int) bool { return n != 0 })
+		if i < 0 {
+			i = len(v.Nums) - 1
+		}
+		return []versionComparator{{Op: ">=", Version: v}, {Op: "<", Version: bumpVersion(v, i)}}, nil
+	case "~":
+		return []versionComparator{{Op: ">=", Version: v}, {Op: "<", Version: bumpVersion(v, min(1, len(v.Nums)-1))}}, nil
+	}
+	return []versionComparator{{Op: op, Version: v}}, nil
+}
+
+// bumpVersion returns the lowest version after every version that starts with the first i+1 components of v, e.g. 2
+// for 1.4 and i = 0. Prereleases of it are below it, so they are excluded from a range it is the upper bound of.
+func bumpVersion(v semVersion, i int) semVersion {
+	nums := slices.Clone(v.Nums[:i+1])
+	nums[i]++
+	return semVersion{Nums: nums, Pre: "0"}
+}
+
+// exact returns the version the constraint names if it is a single version rather than a range, e.g. 1.4 or =1.4.2,
+// so that it can be installed directly from a source whose releases can't be listed.
+func (c versionConstraint) exact() (string, bool) {
+	if c.Literal != "" {
+		return c.Literal, true
+	}
+	if len(c.Alternatives) != 1 || len(c.Alternatives[0]) != 1 {
+		return "", false
+	}
+	if op := c.Alternatives[0][0].Op; op != "" && op != "=" || isVersionWildcard(c.raw) {
+		return "", false
+	}
+	return strings.TrimPrefix(c.raw, "="), true
+}
+
+// String returns the constraint as it was given.
+func (c versionConstraint) String() string {
+	return c.raw
+}
+
+// isAny returns whether the constraint allows any version.
+func (c versionConstraint) isAny() bool {
+	return c.Literal == "" && len(c.Alternatives) == 0
+}
+
+// satisfies returns whether version meets the constraint.
+func (c versionConstraint) satisfies(version string) bool {
+	if c.isAny() {
+		return true
+	}
+	if c.Literal != "" {
+		return sameVersion(c.Literal, version)
+	}
+	v, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	for _, alt := range c.Alternatives {
+		matched := true
+		// A prerelease can only be chosen deliberately, by naming a prerelease of the same version.
+		preAllowed := v.Pre == ""
+		for _, comp := range alt {
+			matched = matched && comp.matches(v)
+			preAllowed = preAllowed || comp.Version.Pre != "" && comp.Version.Pre != "0" && comp.Version.sameRelease(v)
+		}
+		if matched && preAllowed {
+			return true
+		}
+	}
+	return false
+}
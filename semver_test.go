@@ -0,0 +1,157 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		nums []int
+		pre  string
+		ok   bool
+	}{
+		{"1.2.3", []int{1, 2, 3}, "", true},
+		{"v1.2.3", []int{1, 2, 3}, "", true},
+		{"V1.2", []int{1, 2}, "", true},
+		{"jq-1.7.1", []int{1, 7, 1}, "", true},
+		{"cli/v1.2.0", []int{1, 2, 0}, "", true},
+		{"1.2.3-rc.1", []int{1, 2, 3}, "rc.1", true},
+		{"1.2.3rc1", []int{1, 2, 3}, "rc1", true},
+		{"1.2.3+build.5", []int{1, 2, 3}, "", true},
+		{"1.2.3-beta+exp.sha.5114f85", []int{1, 2, 3}, "beta", true},
+		{"2024-01-15", []int{2024, 1, 15}, "", true},
+		{"", nil, "", false},
+		{"v", nil, "", false},
+		{"nightly", nil, "", false},
+		{"latest", nil, "", false},
+	}
+	for _, tt := range tests {
+		v, ok := parseVersion(tt.in)
+		if ok != tt.ok {
+			t.Errorf("parseVersion(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+			continue
+		}
+		if ok && (!slices.Equal(v.Nums, tt.nums) || v.Pre != tt.pre) {
+			t.Errorf("parseVersion(%q) = %v %q, want %v %q", tt.in, v.Nums, v.Pre, tt.nums, tt.pre)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"1.2.3", "1.2.3", 0, true},
+		{"1.2.4", "1.2.3", 1, true},
+		{"1.10.0", "1.9.0", 1, true},
+		{"2.0.0", "1.99.99", 1, true},
+		{"1.4", "1.4.0", 0, true},
+		{"v1.2.3", "1.2.3", 0, true},
+
+		// Prereleases come before the release they lead up to, in natural order.
+		{"1.0.0-rc.1", "1.0.0", -1, true},
+		{"1.0.0", "1.0.0-rc.1", 1, true},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1, true},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1, true},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1, true},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1, true},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1, true},
+		{"1.0.0-rc9", "1.0.0-rc10", -1, true},
+		{"1.0.0-RC1", "1.0.0-rc1", 0, true},
+		{"1.0.1-rc.1", "1.0.0", 1, true},
+
+		// Build metadata doesn't affect precedence.
+		{"1.0.0+build.1", "1.0.0", 0, true},
+		{"1.0.0+build.1", "1.0.0+build.2", 0, true},
+		{"1.0.0-rc.1+build.1", "1.0.0-rc.1", 0, true},
+		{"1.0.0+build.9", "1.0.1", -1, true},
+
+		{"nightly", "1.0.0", 0, false},
+		{"1.0.0", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := compareVersions(tt.a, tt.b)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIsNewerVersion(t *testing.T) {
+	tests := []struct {
+		version, installed string
+		want               bool
+	}{
+		{"1.2.4", "1.2.3", true},
+		{"v1.2.4", "1.2.3", true},
+		{"1.2.3", "1.2.3", false},
+		{"1.2.2", "1.2.3", false},
+		{"1.0.0", "1.0.0-rc.1", true},
+		{"1.0.0-rc.2", "1.0.0-rc.1", true},
+		{"1.0.0-rc.1", "1.0.0", false},
+		{"1.0.0+build.2", "1.0.0+build.1", false},
+		// Versions that can't be compared are newer if they differ.
+		{"nightly-2", "nightly-1", true},
+		{"nightly", "nightly", false},
+	}
+	for _, tt := range tests {
+		if got := isNewerVersion(tt.version, tt.installed); got != tt.want {
+			t.Errorf("isNewerVersion(%q, %q) = %v, want %v", tt.version, tt.installed, got, tt.want)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "1.2.3", true},
+		{"*", "0.1.0", true},
+		{"1.4", "1.4.2", true},
+		{"1.4", "1.40.0", false},
+		{"1.4.x", "1.4.9", true},
+		{"=1.4.2", "v1.4.2", true},
+		{"^1.4", "1.9.0", true},
+		{"^1.4", "2.0.0", false},
+		{"^0.9", "0.10.0", false},
+		{"~1.4.2", "1.4.9", true},
+		{"~1.4.2", "1.5.0", false},
+		{">=0.9 <2", "1.0.0", true},
+		{">= 0.9, < 2", "2.0.0", false},
+		{"^1 || ^2", "2.3.0", true},
+
+		// Prereleases only match a constraint that names a prerelease of the same version.
+		{"^1.4", "2.0.0-rc1", false},
+		{"^1.4", "1.5.0-rc1", false},
+		{">=2.0.0-rc1", "2.0.0-rc2", true},
+		{">=2.0.0-rc1", "2.1.0-rc1", false},
+
+		{"1.4", "1.4.0+build.1", true},
+		{"nightly", "nightly", true},
+		{"nightly", "1.0.0", false},
+	}
+	for _, tt := range tests {
+		c, err := parseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("parseVersionConstraint(%q) failed: %v", tt.constraint, err)
+			continue
+		}
+		if got := c.satisfies(tt.version); got != tt.want {
+			t.Errorf("%q.satisfies(%q) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionConstraintInvalid(t *testing.T) {
+	for _, s := range []string{"^*", ">=x", "^nightly", ">=1.0 ||", "|| ^1"} {
+		if _, err := parseVersionConstraint(s); err == nil {
+			t.Errorf("parseVersionConstraint(%q) succeeded, want an error", s)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// SETUP_MARKER is written above the lines setup adds to a shell startup file, so that they aren't added twice.
+const SETUP_MARKER = "# Added by infpm setup"
+
+// setupRoots returns the symlink roots setup offers, the default first.
+func setupRoots(home string) []string {
+	return []string{filepath.Join(home, ".local"), filepath.Join(home, ".infpm", "root")}
+}
+
+// chooseRoot asks which symlink root to link packages into, offering the current one as the default.
+func chooseRoot(p *prompter, home string, current string) string {
+	roots := setupRoots(home)
+	if !slices.Contains(roots, current) {
+		roots = append([]string{current}, roots...)
+	}
+
+	def := 0
+	items := []pickerItem{}
+	for i, root := range roots {
+		if root == current {
+			def = i
+		}
+		items = append(items, pickerItem{Label: root})
+	}
+	items = append(items, pickerItem{Label: tr("somewhere else")})
+
+	for {
+		i, err := p.choose(tr("Where should packages be linked? Executables go in bin within it."), items, def)
+		if err != nil {
+			return roots[def]
+		}
+		if i < len(roots) {
+			return roots[i]
+		}
+		var root string
+		_, err = p.text(tr("Directory"), "", func(dir string) error {
+			expanded, err := expandHome(dir)
+			if err == nil {
+				root, err = filepath.Abs(expanded)
+			}
+			return err
+		})
+		if err == nil {
+			return root
+		}
+	}
+}
+
+// appendToRcFile adds snippet to the shell startup file at rc, unless setup has added it already. Returns whether it
+// was added.
+func appendToRcFile(rc string, snippet string) (bool, error) {
+	data, err := os.ReadFile(rc)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if strings.Contains(string(data), SETUP_MARKER+"\n"+snippet) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rc), 0755); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(rc, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	prefix := ""
+	if len(data) > 0 {
+		prefix = "\n"
+		if !strings.HasSuffix(string(data), "\n") {
+			prefix = "\n\n"
+		}
+	}
+	if _, err := f.WriteString(prefix + SETUP_MARKER + "\n" + snippet); err != nil {
+		return false, err
+	}
+	return true, f.Close()
+}
+
+func actionSetup(ctx context.Context, cmd *cli.Command) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	interactive := !nonInteractive && !cmd.Bool("yes")
+	p := newPrompter(interactive)
+
+	root := rootPathFromCmd(cmd)
+	if interactive && cmd.Root().String("root") == "" {
+		root = chooseRoot(p, home, root)
+	}
+	if root != config.Root {
+		if err := setConfigValue("root", root); err != nil {
+			return err
+		}
+		config.Root = root
+		fmt.Println(tr("Saved the symlink root to the config file."))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	fmt.Println(tr("Packages are stored in %s and linked into %s.", pm.StorePath, pm.SymlinkPath))
+
+	binDir := pm.linkedDir("bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return err
+	}
+	if inPath(binDir) {
+		fmt.Println(tr("%s is in your PATH already.", binDir))
+		return nil
+	}
+
+	shell := detectShell()
+	snippet := pathSnippet(shell, binDir)
+	rc := shellRcFile(shell, home)
+	if snippet == "" || rc == "" {
+		fmt.Println(tr("%s isn't in your PATH. Add it in your shell's startup file to run the packages you install.", binDir))
+		return nil
+	}
+	if !interactive {
+		fmt.Println(tr("%s isn't in your PATH. Add this to %s to run the packages you install:", binDir, rc))
+		fmt.Print(snippet)
+		return nil
+	}
+
+	fmt.Println(tr("%s isn't in your PATH. infpm can add this to %s:", binDir, rc))
+	fmt.Print(snippet)
+	if !p.confirm(tr("Add it?"), true) {
+		return nil
+	}
+	added, err := appendToRcFile(rc, snippet)
+	if err != nil {
+		return err
+	}
+	if !added {
+		fmt.Println(tr("%s already has it. Open a new shell, or run: source %s", rc, rc))
+		return nil
+	}
+	fmt.Println(tr("Added. Open a new shell, or run: source %s", rc))
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// detectShell returns the name of the shell infpm was run from, e.g. "zsh", or "" if it can't be determined.
+// The parent process is preferred over $SHELL, which is the login shell rather than the one in use.
+func detectShell() string {
+	if runtime.GOOS == "linux" {
+		comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(os.Getppid()), "comm"))
+		if err == nil {
+			if name := shellName(string(comm)); isKnownShell(name) {
+				return name
+			}
+		}
+	}
+	return shellName(os.Getenv("SHELL"))
+}
+
+// shellName normalises a shell's path or process name, e.g. "-zsh" (a login shell) or "/bin/bash".
+func shellName(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	return strings.TrimPrefix(filepath.Base(s), "-")
+}
+
+func isKnownShell(name string) bool {
+	switch name {
+	case "bash", "zsh", "sh", "dash", "ksh", "mksh", "csh", "tcsh", "fish", "nu", "pwsh", "powershell":
+		return true
+	}
+	return false
+}
+
+// rehashCommand returns the command that makes shell forget the locations of commands it has cached, or "" if
+// shell doesn't cache them.
+func rehashCommand(shell string) string {
+	switch shell {
+	case "zsh", "csh", "tcsh":
+		return "rehash"
+	case "bash", "sh", "dash", "ksh", "mksh":
+		return "hash -r"
+	}
+	return ""
+}
+
+// rehashHook returns shell code that forgets cached command locations before every prompt, so that commands
+// installed or upgraded by infpm are found immediately, or "" if shell doesn't need it.
+func rehashHook(shell string) string {
+	switch shell {
+	case "zsh":
+		return "autoload -Uz add-zsh-hook\n_infpm_rehash() { rehash }\nadd-zsh-hook precmd _infpm_rehash\n"
+	case "bash":
+		return "PROMPT_COMMAND=\"hash -r${PROMPT_COMMAND:+; $PROMPT_COMMAND}\"\n"
+	}
+	return ""
+}
+
+// printRehashHint tells the user how to make their shell pick up newly linked commands, since shells that cache
+// command locations may keep running an old version or fail to find a new one.
+func printRehashHint() {
+	if cmd := rehashCommand(detectShell()); cmd != "" {
+		slog.Info("if your shell can't find the new commands, or runs old versions, run `" + cmd + "`")
+	}
+}
+
+// shellRcFile returns the startup file interactive sessions of shell read, where PATH can be set, or "" if infpm
+// doesn't know it.
+func shellRcFile(shell string, home string) string {
+	switch shell {
+	case "bash":
+		return filepath.Join(home, ".bashrc")
+	case "zsh":
+		if dir := os.Getenv("ZDOTDIR"); dir != "" {
+			return filepath.Join(dir, ".zshrc")
+		}
+		return filepath.Join(home, ".zshrc")
+	case "fish":
+		return filepath.Join(xdgDir("XDG_CONFIG_HOME", filepath.Join(home, ".config")), "fish", "config.fish")
+	case "sh", "dash", "ksh", "mksh":
+		return filepath.Join(home, ".profile")
+	}
+	return ""
+}
+
+// pathSnippet returns shell code that puts dir at the front of PATH, or "" if infpm doesn't know how for shell.
+func pathSnippet(shell string, dir string) string {
+	switch shell {
+	case "fish":
+		return "fish_add_path " + strconv.Quote(dir) + "\n"
+	case "bash", "zsh", "sh", "dash", "ksh", "mksh":
+		return "export PATH=\"" + dir + ":$PATH\"\n"
+	}
+	return ""
+}
+
+// inPath returns whether dir is one of the directories in PATH.
+func inPath(dir string) bool {
+	for _, p := range filepath.SplitList(os.Getenv("PATH")) {
+		if p != "" && filepath.Clean(p) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
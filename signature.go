@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Kinds of signature.
+const (
+	SIGNATURE_PGP      = "pgp"
+	SIGNATURE_MINISIGN = "minisign"
+)
+
+// pgpSignatureExtensions and minisignSignatureExtensions are the extensions of detached signatures, appended to the
+// name of the file they sign. signify signatures also use .sig, and are verified like minisign ones.
+var (
+	pgpSignatureExtensions      = []string{".asc", ".sig", ".gpg"}
+	minisignSignatureExtensions = []string{".minisig", ".sig"}
+)
+
+// verifyKeys are the keys that a download's signature must be made by.
+type verifyKeys struct {
+	Keyring  openpgp.EntityList
+	Minisign *minisignKey
+}
+
+// verifyKeysFor loads the keys opts ask signatures to be verified with. Returns nil if signatures needn't be verified.
+func verifyKeysFor(opts resolveOpts) (*verifyKeys, error) {
+	if opts.NoVerify || opts.Keyring == "" && opts.MinisignKey == "" {
+		return nil, nil
+	}
+	keys := &verifyKeys{}
+	var err error
+	if opts.Keyring != "" {
+		if keys.Keyring, err = loadKeyring(opts.Keyring); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MinisignKey != "" {
+		if keys.Minisign, err = parseMinisignKey(opts.MinisignKey); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// extensions returns the extensions of the signatures that the keys can verify, most specific first.
+func (k *verifyKeys) extensions() []string {
+	exts := []string{}
+	if k.Minisign != nil {
+		exts = append(exts, minisignSignatureExtensions...)
+	}
+	if k.Keyring != nil {
+		for _, ext := range pgpSignatureExtensions {
+			if !slices.Contains(exts, ext) {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	return exts
+}
+
+// signature returns the signature called name with contents data as a check against the keys, or nil if it isn't a
+// kind of signature they can verify, e.g. an OpenPGP .sig when only a minisign key is given.
+func (k *verifyKeys) signature(name string, data []byte) *signatureCheck {
+	if bytes.HasPrefix(data, []byte("untrusted comment:")) {
+		if k.Minisign == nil {
+			return nil
+		}
+		return &signatureCheck{Kind: SIGNATURE_MINISIGN, Name: name, Data: data, Keys: k}
+	}
+	if k.Keyring == nil {
+		return nil
+	}
+	return &signatureCheck{Kind: SIGNATURE_PGP, Name: name, Data: data, Keys: k}
+}
+
+// signatureCheck is a detached signature that a download must be verified against.
+type signatureCheck struct {
+	// Kind is SIGNATURE_PGP or SIGNATURE_MINISIGN.
+	Kind string
+	// Name is the name of the signature file.
+	Name string
+	Data []byte
+	Keys *verifyKeys
+}
+
+// signatureVerifier checks a signature against everything written to it, as it is downloaded.
+type signatureVerifier interface {
+	io.Writer
+	// Verify checks the signature against everything written, returning the ID of the key that made it.
+	Verify() (string, error)
+	// Close abandons the check.
+	Close()
+}
+
+func newSignatureVerifier(sig *signatureCheck) (signatureVerifier, error) {
+	if sig.Kind == SIGNATURE_MINISIGN {
+		return newMinisignVerifier(sig)
+	}
+	return newPgpVerifier(sig), nil
+}
+
+// checkSignature verifies sig against everything read from signed, returning the ID of the key that made it.
+func checkSignature(sig *signatureCheck, signed io.Reader) (string, error) {
+	v, err := newSignatureVerifier(sig)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(v, signed); err != nil {
+		v.Close()
+		return "", err
+	}
+	return v.Verify()
+}
+
+// loadKeyring reads the OpenPGP public keys in the file at path, which may be armored or binary.
+func loadKeyring(path string) (openpgp.EntityList, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, withKind(errUsage, fmt.Errorf("failed to read keyring: %w", err))
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, withKind(errUsage, fmt.Errorf("%s isn't an OpenPGP keyring: %w", path, err))
+	}
+	return keyring, nil
+}
+
+// pgpVerifier checks an OpenPGP signature. The OpenPGP library reads the signed data itself, so it is fed through a
+// pipe from another goroutine.
+type pgpVerifier struct {
+	pw     *io.PipeWriter
+	result chan pgpResult
+}
+
+type pgpResult struct {
+	fingerprint string
+	err         error
+}
+
+func newPgpVerifier(sig *signatureCheck) *pgpVerifier {
+	pr, pw := io.Pipe()
+	v := &pgpVerifier{pw: pw, result: make(chan pgpResult, 1)}
+	go func() {
+		check := openpgp.CheckDetachedSignature
+		if bytes.HasPrefix(bytes.TrimSpace(sig.Data), []byte("-----BEGIN PGP")) {
+			check = openpgp.CheckArmoredDetachedSignature
+		}
+		signer, err := check(sig.Keys.Keyring, pr, bytes.NewReader(sig.Data), nil)
+		// Keep reading if the check gave up early, so that the download isn't blocked or failed by it.
+		io.Copy(io.Discard, pr)
+
+		if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+			v.result <- pgpResult{err: withKind(errVerificationFailed, fmt.Errorf("%s wasn't made by any key in the keyring", sig.Name))}
+		} else if err != nil {
+			v.result <- pgpResult{err: withKind(errVerificationFailed, fmt.Errorf("signature %s is invalid: %w", sig.Name, err))}
+		} else {
+			v.result <- pgpResult{fingerprint: fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint)}
+		}
+	}()
+	return v
+}
+
+func (v *pgpVerifier) Write(p []byte) (int, error) {
+	return v.pw.Write(p)
+}
+
+func (v *pgpVerifier) Verify() (string, error) {
+	v.pw.Close()
+	r := <-v.result
+	return r.fingerprint, r.err
+}
+
+func (v *pgpVerifier) Close() {
+	v.pw.Close()
+}
+
+// minisignKey is a minisign or signify Ed25519 public key.
+type minisignKey struct {
+	Id  [8]byte
+	Key ed25519.PublicKey
+}
+
+// String returns the key's ID the way minisign shows it.
+func (k *minisignKey) String() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.Id[:]))
+}
+
+// encode returns the key in the base64 form minisign prints.
+func (k *minisignKey) encode() string {
+	return base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), k.Id[:]...), k.Key...))
+}
+
+// parseMinisignKey parses a minisign public key, given either as the base64 key itself, e.g. RWQf6LRC..., or as the
+// path to a minisign or signify public key file.
+func parseMinisignKey(s string) (*minisignKey, error) {
+	encoded := strings.TrimSpace(s)
+	if fp, err := expandHome(encoded); err == nil {
+		if data, err := os.ReadFile(fp); err == nil {
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			encoded = strings.TrimSpace(lines[len(lines)-1])
+		}
+	}
+
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(b) != 2+8+ed25519.PublicKeySize || string(b[:2]) != "Ed" {
+		return nil, withKind(errUsage, fmt.Errorf("%q is neither a minisign public key nor a file containing one", s))
+	}
+	k := &minisignKey{Key: ed25519.PublicKey(b[10:])}
+	copy(k.Id[:], b[2:10])
+	return k, nil
+}
+
+// minisignVerifier checks a minisign or signify signature. Signatures of prehashed files, the default since minisign
+// 0.8, are checked against a BLAKE2b-512 digest; legacy and signify signatures need the whole file, so it is held in
+// memory.
+type minisignVerifier struct {
+	sig            *signatureCheck
+	algo           string
+	signature      []byte
+	trustedComment string
+	globalSig      []byte
+	hash           hash.Hash
+	buf            bytes.Buffer
+}
+
+func newMinisignVerifier(sig *signatureCheck) (*minisignVerifier, error) {
+	invalid := withKind(errVerificationFailed, fmt.Errorf("%s isn't a minisign or signify signature", sig.Name))
+	lines := strings.Split(strings.TrimSpace(string(sig.Data)), "\n")
+	if len(lines) < 2 {
+		return nil, invalid
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(b) != 2+8+ed25519.SignatureSize {
+		return nil, invalid
+	}
+
+	v := &minisignVerifier{sig: sig, algo: string(b[:2]), signature: b[10:]}
+	key := sig.Keys.Minisign
+	if !bytes.Equal(b[2:10], key.Id[:]) {
+		return nil, withKind(errVerificationFailed, fmt.Errorf("%s was made by key %016X, not %s", sig.Name, binary.LittleEndian.Uint64(b[2:10]), key))
+	}
+	switch v.algo {
+	case "ED":
+		v.hash, _ = blake2b.New512(nil)
+	case "Ed":
+	default:
+		return nil, withKind(errVerificationFailed, fmt.Errorf("%s uses the unsupported algorithm %q", sig.Name, v.algo))
+	}
+
+	// minisign signatures also sign a trusted comment, which signify signatures don't have.
+	if len(lines) >= 4 {
+		comment, ok := strings.CutPrefix(strings.TrimSpace(lines[2]), "trusted comment: ")
+		if !ok {
+			return nil, invalid
+		}
+		if v.globalSig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3])); err != nil {
+			return nil, invalid
+		}
+		v.trustedComment = comment
+	}
+	return v, nil
+}
+
+func (v *minisignVerifier) Write(p []byte) (int, error) {
+	if v.hash != nil {
+		return v.hash.Write(p)
+	}
+	return v.buf.Write(p)
+}
+
+func (v *minisignVerifier) Verify() (string, error) {
+	key := v.sig.Keys.Minisign
+	message := v.buf.Bytes()
+	if v.hash != nil {
+		message = v.hash.Sum(nil)
+	}
+	if !ed25519.Verify(key.Key, message, v.signature) {
+		return "", withKind(errVerificationFailed, fmt.Errorf("signature %s is invalid", v.sig.Name))
+	}
+	if v.globalSig != nil && !ed25519.Verify(key.Key, append(bytes.Clone(v.signature), v.trustedComment...), v.globalSig) {
+		return "", withKind(errVerificationFailed, fmt.Errorf("the trusted comment of signature %s is invalid", v.sig.Name))
+	}
+	if v.trustedComment != "" {
+		slog.Debug("verified minisign signature", "signature", v.sig.Name, "trustedComment", v.trustedComment)
+	}
+	return key.String(), nil
+}
+
+func (v *minisignVerifier) Close() {
+	v.buf.Reset()
+}
+
+// fetchSignature downloads the detached signature of the file at fileUrl that the keys can verify. Returns nil if
+// there isn't one.
+func fetchSignature(fileUrl string, keys *verifyKeys) *signatureCheck {
+	for _, ext := range keys.extensions() {
+		data, err := fetchSmallUrl(fileUrl + ext)
+		if err != nil {
+			slog.Debug("no signature found", "url", fileUrl+ext, "err", err)
+			continue
+		}
+		if sig := keys.signature(fileUrl+ext, []byte(data)); sig != nil {
+			return sig
+		}
+	}
+	return nil
+}
+
+// fetchAssetSignature downloads the detached signature published for name among the assets of a release that the
+// keys can verify. Returns nil if there isn't one.
+func fetchAssetSignature(release *githubApiReleases, name string, keys *verifyKeys) (*signatureCheck, error) {
+	for _, ext := range keys.extensions() {
+		for _, a := range release.Assets {
+			if a.Name != name+ext {
+				continue
+			}
+			data, err := fetchSmallAsset(a)
+			if err != nil {
+				return nil, err
+			}
+			if sig := keys.signature(a.Name, []byte(data)); sig != nil {
+				return sig, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// githubSignature finds how to verify asset with the keys: with its own signature, or, since many projects only
+// sign their list of checksums, with the signature of the checksum file pc was found in, which is verified here.
+// Returns the asset's signature if it has one, and errVerificationFailed if it can't be verified.
+func githubSignature(release *githubApiReleases, asset *githubApiReleaseAsset, pc *publishedChecksum, keys *verifyKeys) (*signatureCheck, error) {
+	sig, err := fetchAssetSignature(release, asset.Name, keys)
+	if err != nil || sig != nil {
+		return sig, err
+	}
+
+	if pc != nil {
+		sig, err := fetchAssetSignature(release, pc.File, keys)
+		if err != nil {
+			return nil, err
+		}
+		if sig != nil {
+			key, err := checkSignature(sig, strings.NewReader(pc.Data))
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("verified signature of checksum file", "file", pc.File, "signature", sig.Name, "key", key)
+			return nil, nil
+		}
+	}
+	return nil, withKind(errVerificationFailed, fmt.Errorf("release %s publishes no signature for %s; pass --no-verify to install it anyway", release.TagName, asset.Name))
+}
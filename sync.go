@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// PROJECT_ROOT is the default project-local root that sync installs into, relative to the current directory.
+// Packages are stored in store within it, and linked into bin, lib and share as usual.
+const PROJECT_ROOT = ".infpm"
+
+// sameVersion returns whether two versions are the same, ignoring a v prefix, since tags and the versions pinned in
+// other tools' files often differ only by that.
+func sameVersion(a string, b string) bool {
+	return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+}
+
+// projectPackageManager creates the PackageManager for a project-local root. See PackageManagerOpts.WaitForLock for wait.
+func projectPackageManager(root string, wait bool) (*PackageManager, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return NewPackageManager(PackageManagerOpts{
+		StorePath:   filepath.Join(root, "store"),
+		SymlinkPath: root,
+		CachePath:   config.CachePath(),
+		Interactive: !nonInteractive,
+		WaitForLock: wait,
+	})
+}
+
+// syncToolVersions installs the tools pinned in a .tool-versions file at their pinned versions, replacing any other
+// installed versions. Tools that infpm doesn't know how to install, or whose package is pinned, are skipped.
+func syncToolVersions(pm *PackageManager, fp string) error {
+	tools, err := readToolVersions(fp)
+	if err != nil {
+		slog.Error("failed to read .tool-versions file", "path", fp)
+		return err
+	}
+
+	ropts := resolveOpts{Platform: hostPlatform(), NonInteractive: nonInteractive}
+	skipped := []string{}
+	failed := []string{}
+	for _, tool := range tools {
+		if err := interrupted(); err != nil {
+			return err
+		}
+		src := sourceFor(importedPackage{Name: tool.Name})
+		if src == "" {
+			skipped = append(skipped, tool.Name)
+			ciResult(CI_SKIPPED, tool.Name, tool.Version, "unknown source")
+			continue
+		}
+		name := importedName(importedPackage{Name: tool.Name})
+		if pm.db.IsPinned(name) {
+			slog.Info("package is pinned, skipping", "package", name)
+			ciResult(CI_SKIPPED, name, tool.Version, "pinned")
+			continue
+		}
+
+		current := false
+		for _, rec := range pm.db.Find(name) {
+			if sameVersion(rec.Version, tool.Version) {
+				current = true
+				continue
+			}
+			// Other versions would hold on to the links, so remove them first.
+			slog.Info("removing version not pinned in .tool-versions", "package", name, "version", rec.Version)
+			if err := pm.Uninstall(rec); err != nil {
+				return withPackage(err, name, src)
+			}
+		}
+		if current {
+			slog.Info("pinned version is already installed", "package", name, "version", tool.Version)
+			ciResult(CI_SKIPPED, name, tool.Version, "already installed")
+			continue
+		}
+
+		ropts.Tag = tool.Version
+		opts := PreinstallPackageOpts{Name: name, Version: tool.Version, Source: src, Platform: ropts.Platform}
+		if _, err := pm.InstallSource(src, false, opts, ropts); err != nil {
+			slog.Error("failed to install pinned tool", "package", name, "version", tool.Version, "err", err)
+			ciResult(CI_FAILED, name, tool.Version, err.Error())
+			failed = append(failed, name)
+		}
+	}
+
+	if len(skipped) > 0 {
+		fmt.Println(tr("infpm doesn't know where to install these from, so they were skipped: %s", strings.Join(skipped, ", ")))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to install %d tools: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// syncPackagesFile makes the installed packages match a packages file: packages that aren't installed are installed,
+// and packages installed at a version the file doesn't allow are replaced with the newest version it does. If prune is
+// set, installed packages that aren't in the file are uninstalled. Pinned packages are left alone.
+func syncPackagesFile(pm *PackageManager, fp string, prune bool) error {
+	pf, err := readPackagesFile(fp)
+	if err != nil {
+		return err
+	}
+
+	ropts := resolveOpts{Platform: hostPlatform(), NonInteractive: nonInteractive}
+	failed := []string{}
+	for _, name := range pf.Names() {
+		if err := interrupted(); err != nil {
+			return err
+		}
+		spec := pf.Packages[name]
+		if spec.Source == "" {
+			slog.Error("package has no source in packages file", "package", name, "path", fp)
+			ciResult(CI_FAILED, name, spec.Version, "no source")
+			failed = append(failed, name)
+			continue
+		}
+
+		var current *PackageRecord
+		satisfied := false
+		for _, rec := range pm.db.Packages {
+			if !spec.installedFrom(name, rec) || !rec.Platform.IsHost() {
+				continue
+			}
+			current = rec
+			if spec.satisfiesVersion(rec.Version) {
+				satisfied = true
+				break
+			}
+		}
+		if current != nil && pm.db.IsPinned(current.Name) {
+			slog.Info("package is pinned, skipping", "package", current.Name, "version", current.Version)
+			ciResult(CI_SKIPPED, current.Name, current.Version, "pinned")
+			continue
+		}
+		if satisfied {
+			slog.Info("package is installed at a version the packages file allows", "package", name, "version", current.Version)
+			ciResult(CI_SKIPPED, name, current.Version, "already installed")
+			continue
+		}
+
+		if err := pm.syncPackage(name, spec, current, ropts); err != nil {
+			slog.Error("failed to sync package", "package", name, "version", spec.Version, "err", err)
+			ciResult(CI_FAILED, name, spec.Version, err.Error())
+			failed = append(failed, name)
+		}
+	}
+
+	if prune {
+		for _, name := range pm.db.Names() {
+			for _, rec := range pm.db.Find(name) {
+				if pm.db.IsPinned(name) || slices.ContainsFunc(pf.Names(), func(n string) bool { return pf.Packages[n].installedFrom(n, rec) }) {
+					continue
+				}
+				slog.Info("removing package that isn't in the packages file", "package", rec.Name, "version", rec.Version)
+				if err := pm.Uninstall(rec); err != nil {
+					return withPackage(err, rec.Name, rec.Source)
+				}
+				ciResult(CI_UNINSTALLED, rec.Name, rec.Version, "not in packages file")
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sync %d packages: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// syncPackage installs the newest version of a package that spec allows. If current is set, it is an installed
+// version that spec doesn't allow, which is replaced.
+func (pm *PackageManager) syncPackage(name string, spec PackageSpec, current *PackageRecord, ropts resolveOpts) error {
+	c, err := parseVersionConstraint(spec.Version)
+	if err != nil {
+		return err
+	}
+	exact, isExact := c.exact()
+	ropts.Tag = exact
+	if repoPath, ok := releaseRepoPath(spec.Source); ok && !c.isAny() {
+		// A version like 1.4 or ^1.4 names a series of releases rather than one, so find the newest release in it.
+		release, err := findReleaseSatisfying(repoPath, c)
+		if err != nil && !errors.Is(err, errReleaseNotFound) {
+			return err
+		}
+		if release != nil {
+			ropts.Tag = release.TagName
+		} else if !isExact {
+			return withKind(errUsage, fmt.Errorf("no release of %s satisfies %s", spec.Source, spec.Version))
+		}
+	} else if !isExact && !c.isAny() {
+		return withKind(errUsage, fmt.Errorf("the version of %s, %s, is a range, which can only be used with a repository on GitHub or a Gitea host", name, spec.Version))
+	}
+
+	opts := PreinstallPackageOpts{Name: name, Version: exact, Source: spec.Source, Platform: ropts.Platform}
+	if current == nil {
+		_, err := pm.InstallSource(spec.Source, false, opts, ropts)
+		return err
+	}
+	slog.Info("replacing version the packages file doesn't allow", "package", name, "version", current.Version, "want", spec.Version)
+	opts.Name = current.Name
+	opts.Layout = current.linkLayout()
+	_, err = pm.Replace(opts, ropts)
+	return err
+}
+
+func actionSync(ctx context.Context, cmd *cli.Command) error {
+	if !cmd.Bool("tool-versions") {
+		fp := cmd.String("file")
+		if _, err := os.Stat(fp); os.IsNotExist(err) {
+			return withKind(errUsage, errors.New(tr("Nothing to sync from. Declare packages in %s, or use --tool-versions to install the tools in .tool-versions.", fp)))
+		}
+		pm, err := packageManagerFromCmd(cmd)
+		if err != nil {
+			return err
+		}
+		if err := syncPackagesFile(pm, fp, cmd.Bool("prune")); err != nil {
+			return err
+		}
+		printRehashHint()
+		return nil
+	}
+
+	pm, err := projectPackageManager(cmd.String("root"), cmd.Root().Bool("wait"))
+	if err != nil {
+		return err
+	}
+	if err := syncToolVersions(pm, TOOL_VERSIONS_FILENAME); err != nil {
+		return err
+	}
+
+	fmt.Println(tr("Tools are installed in %s. Add it to your PATH to use them.", filepath.Join(pm.SymlinkPath, "bin")))
+	printRehashHint()
+	return nil
+}
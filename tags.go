@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// namesFromCmd returns the package names a command should operate on: the names given as arguments, plus every
+// installed package with the tag given by --tag. Every name must be installed.
+func namesFromCmd(cmd *cli.Command, pm *PackageManager) ([]string, error) {
+	names := cmd.Args().Slice()
+	if tag := cmd.String("tag"); tag != "" {
+		tagged := pm.db.NamesWithTag(tag)
+		if len(tagged) == 0 {
+			return nil, withKind(errUsage, fmt.Errorf("no installed packages are tagged %s", tag))
+		}
+		names = append(names, tagged...)
+	}
+
+	for _, name := range names {
+		if len(pm.db.Find(name)) == 0 {
+			return nil, withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+		}
+	}
+	return names, nil
+}
+
+func actionTagAdd(ctx context.Context, cmd *cli.Command) error {
+	return editTag(cmd, true)
+}
+
+func actionTagRemove(ctx context.Context, cmd *cli.Command) error {
+	return editTag(cmd, false)
+}
+
+// editTag adds or removes the tag given as the last argument to every package named before it.
+func editTag(cmd *cli.Command, add bool) error {
+	args := cmd.Args().Slice()
+	if len(args) < 2 {
+		return withKind(errUsage, errors.New(tr("A package name and a tag are required, e.g. infpm tag add ripgrep work.")))
+	}
+	names, tag := args[:len(args)-1], args[len(args)-1]
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if len(pm.db.Find(name)) == 0 {
+			return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+		}
+
+		if add && !pm.db.AddTag(name, tag) {
+			fmt.Println(tr("%s is already tagged %s", name, tag))
+		} else if !add && !pm.db.RemoveTag(name, tag) {
+			fmt.Println(tr("%s is not tagged %s", name, tag))
+		}
+	}
+	return pm.db.Save()
+}
+
+func actionTagList(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	names := pm.db.Names()
+	if name := cmd.Args().Get(0); name != "" {
+		names = []string{name}
+	}
+	for _, name := range names {
+		if tags := pm.db.Tags[name]; len(tags) > 0 {
+			fmt.Println(name + ": " + strings.Join(tags, ", "))
+		}
+	}
+	return nil
+}
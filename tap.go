@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// TAPS_DIR is the directory in the state directory that taps are cloned into.
+const TAPS_DIR = "taps"
+
+// tapRecipeDirs are the directories of a tap that recipes are looked for in, in order.
+var tapRecipeDirs = []string{"recipes", "."}
+
+// tapNamePattern matches the names of taps and of the recipes in them. Anything else is a path or URL.
+var tapNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// tap is a git repository of recipes, added with tap add, whose packages can be installed by name.
+type tap struct {
+	Name string
+	Path string
+}
+
+// tapsPath returns the directory taps are cloned into.
+func tapsPath() string {
+	return filepath.Join(config.State, TAPS_DIR)
+}
+
+// tapNameFromUrl returns the name a tap is added as by default: the last element of its URL without .git, e.g.
+// infpm-recipes for https://github.com/user/infpm-recipes.git.
+func tapNameFromUrl(gitUrl string) string {
+	name := strings.TrimSuffix(strings.TrimRight(gitUrl, "/"), ".git")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// listTaps returns the taps that have been added, sorted by name.
+func listTaps() ([]tap, error) {
+	entries, err := os.ReadDir(tapsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	taps := []tap{}
+	for _, e := range entries {
+		if e.IsDir() {
+			taps = append(taps, tap{Name: e.Name(), Path: filepath.Join(tapsPath(), e.Name())})
+		}
+	}
+	return taps, nil
+}
+
+// findTap returns the tap called name, failing if it hasn't been added.
+func findTap(name string) (tap, error) {
+	t := tap{Name: name, Path: filepath.Join(tapsPath(), name)}
+	if !tapNamePattern.MatchString(name) {
+		return t, withKind(errUsage, errors.New(tr("There is no tap called %s. See infpm tap list.", name)))
+	}
+	if info, err := os.Stat(t.Path); err != nil || !info.IsDir() {
+		return t, withKind(errUsage, errors.New(tr("There is no tap called %s. See infpm tap list.", name)))
+	}
+	return t, nil
+}
+
+// recipes returns the paths of the recipes in the tap, keyed by name. A recipe in an earlier directory of
+// tapRecipeDirs hides one of the same name in a later one.
+func (t tap) recipes() map[string]string {
+	recipes := map[string]string{}
+	for i := len(tapRecipeDirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(filepath.Join(t.Path, tapRecipeDirs[i]))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutSuffix(e.Name(), RECIPE_EXTENSION)
+			if ok && e.Type().IsRegular() && tapNamePattern.MatchString(name) {
+				recipes[name] = filepath.Join(t.Path, tapRecipeDirs[i], e.Name())
+			}
+		}
+	}
+	return recipes
+}
+
+// findTapRecipe returns the path of the recipe called name in the first tap, by name, that has one.
+func findTapRecipe(name string) (string, bool) {
+	if !tapNamePattern.MatchString(name) || strings.HasSuffix(name, RECIPE_EXTENSION) {
+		return "", false
+	}
+	taps, err := listTaps()
+	if err != nil {
+		slog.Warn("failed to list taps, continuing without them", "err", err)
+		return "", false
+	}
+	for _, t := range taps {
+		for _, dir := range tapRecipeDirs {
+			fp := filepath.Join(t.Path, dir, name+RECIPE_EXTENSION)
+			if info, err := os.Stat(fp); err == nil && info.Mode().IsRegular() {
+				slog.Info("found recipe in tap", "recipe", name, "tap", t.Name)
+				return fp, true
+			}
+		}
+	}
+	return "", false
+}
+
+// runGit runs git with args in dir, passing its output through to stderr.
+func runGit(dir string, args ...string) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return withKind(errUsage, errors.New("taps are git repositories, so working with them needs git, which isn't in PATH"))
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return withKind(errNetwork, fmt.Errorf("git %s failed: %w", strings.Join(args, " "), err))
+	}
+	return nil
+}
+
+// remoteUrl returns the URL the tap was cloned from, or "" if git can't tell.
+func (t tap) remoteUrl() string {
+	out, err := exec.Command("git", "-C", t.Path, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func actionTapAdd(ctx context.Context, cmd *cli.Command) error {
+	gitUrl := cmd.Args().Get(0)
+	if gitUrl == "" {
+		return withKind(errUsage, errors.New(tr("A git URL is required, e.g. infpm tap add https://github.com/user/infpm-recipes.")))
+	}
+	name := cmd.String("name")
+	if name == "" {
+		name = tapNameFromUrl(gitUrl)
+	}
+	if !tapNamePattern.MatchString(name) {
+		return withKind(errUsage, fmt.Errorf("%q can't be used as the name of a tap; choose another with --name", name))
+	}
+
+	t := tap{Name: name, Path: filepath.Join(tapsPath(), name)}
+	if _, err := os.Stat(t.Path); err == nil {
+		return withKind(errConflict, fmt.Errorf("a tap called %s has already been added; update it with infpm tap update %s", name, name))
+	}
+	if err := os.MkdirAll(tapsPath(), 0755); err != nil {
+		return err
+	}
+	slog.Info("cloning tap", "tap", name, "url", gitUrl)
+	if err := runGit(tapsPath(), "clone", "--depth", "1", "--", gitUrl, name); err != nil {
+		os.RemoveAll(t.Path)
+		return err
+	}
+	fmt.Println(tr("Added tap %s with %d recipes.", name, len(t.recipes())))
+	return nil
+}
+
+func actionTapRemove(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() == 0 {
+		return withKind(errUsage, errors.New(tr("The name of a tap is required. See infpm tap list.")))
+	}
+	for _, name := range cmd.Args().Slice() {
+		t, err := findTap(name)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(t.Path); err != nil {
+			return err
+		}
+		fmt.Println(tr("Removed tap %s.", name))
+	}
+	return nil
+}
+
+func actionTapList(ctx context.Context, cmd *cli.Command) error {
+	taps, err := listTaps()
+	if err != nil {
+		return err
+	}
+	if len(taps) == 0 {
+		fmt.Println(tr("No taps have been added."))
+		return nil
+	}
+	for _, t := range taps {
+		fmt.Printf("%s\t%s\t%s\n", t.Name, t.remoteUrl(), tr("%d recipes", len(t.recipes())))
+	}
+	return nil
+}
+
+// actionTapUpdate pulls the latest recipes into the taps given as arguments, or every tap.
+func actionTapUpdate(ctx context.Context, cmd *cli.Command) error {
+	taps := []tap{}
+	for _, name := range cmd.Args().Slice() {
+		t, err := findTap(name)
+		if err != nil {
+			return err
+		}
+		taps = append(taps, t)
+	}
+	if len(taps) == 0 {
+		var err error
+		if taps, err = listTaps(); err != nil {
+			return err
+		}
+	}
+
+	failed := []string{}
+	for _, t := range taps {
+		if err := interrupted(); err != nil {
+			return err
+		}
+		slog.Info("updating tap", "tap", t.Name)
+		// Taps are shallow clones that are never edited, so replace what they have with the latest rather than merge.
+		err := runGit(t.Path, "fetch", "--depth", "1", "origin")
+		if err == nil {
+			err = runGit(t.Path, "reset", "--quiet", "--hard", "FETCH_HEAD")
+		}
+		if err != nil {
+			slog.Error("failed to update tap, continuing", "tap", t.Name, "err", err)
+			failed = append(failed, t.Name)
+			continue
+		}
+		fmt.Println(tr("Updated tap %s.", t.Name))
+	}
+	if len(failed) > 0 {
+		return withKind(errNetwork, fmt.Errorf("failed to update %d taps: %s", len(failed), strings.Join(failed, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// LATEST_VERSION_MAX_BYTES is the most that is read from a source template's latest version endpoint.
+const LATEST_VERSION_MAX_BYTES = 1 << 20
+
+// SourceTemplate describes where a vendor that doesn't publish releases on GitHub puts them, as a URL with
+// placeholders for the version and platform, e.g.
+//
+//	[sources.tool]
+//	url = "https://example.com/tool/{version}/tool-{os}-{arch}.tar.gz"
+//	latest = "https://example.com/tool/latest.json"
+//	latest_field = "version"
+//	arch = { amd64 = "x86_64", arm64 = "aarch64" }
+//
+// The placeholders are {version}, the version without a v prefix, {tag}, the version as given, and {os} and {arch},
+// in Go's naming unless mapped to the vendor's with OS and Arch.
+type SourceTemplate struct {
+	Url string `toml:"url"`
+	// Latest is the URL of an endpoint that returns the latest version, so that the source can be installed without
+	// giving a version and upgraded. If it returns JSON, LatestField is the field holding the version, with dots
+	// between nested fields. Otherwise, LatestPattern is a regular expression matching the version, with the version
+	// in its first group if it has one, or if that is unset too, the first line of the response is the version.
+	Latest        string `toml:"latest"`
+	LatestField   string `toml:"latest_field"`
+	LatestPattern string `toml:"latest_pattern"`
+	// OS and Arch map Go's names for operating systems and architectures to the ones in the vendor's URLs.
+	OS   map[string]string `toml:"os"`
+	Arch map[string]string `toml:"arch"`
+}
+
+// validate checks that the template can be used, naming it name in errors.
+func (t *SourceTemplate) validate(name string) error {
+	if t.Url == "" {
+		return withKind(errUsage, fmt.Errorf("sources.%s must have a url", name))
+	}
+	if _, err := parseSourceUrl(t.expand("", Platform{})); err != nil {
+		return fmt.Errorf("sources.%s: %w", name, err)
+	}
+	if t.LatestPattern != "" {
+		if _, err := regexp.Compile(t.LatestPattern); err != nil {
+			return withKind(errUsage, fmt.Errorf("sources.%s: invalid latest_pattern: %w", name, err))
+		}
+	}
+	return nil
+}
+
+// expand fills the template's placeholders in for a version and platform.
+func (t *SourceTemplate) expand(version string, platform Platform) string {
+	os, arch := platform.OS, platform.Arch
+	if mapped, ok := t.OS[os]; ok {
+		os = mapped
+	}
+	if mapped, ok := t.Arch[arch]; ok {
+		arch = mapped
+	}
+	return strings.NewReplacer(
+		"{version}", strings.TrimPrefix(version, "v"),
+		"{tag}", version,
+		"{os}", os,
+		"{arch}", arch,
+	).Replace(t.Url)
+}
+
+// latestVersion fetches the latest version from the template's Latest endpoint.
+func (t *SourceTemplate) latestVersion() (string, error) {
+	req, err := http.NewRequest(http.MethodGet, t.Latest, nil)
+	if err != nil {
+		return "", withKind(errUsage, err)
+	}
+	authorizeRequest(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", withKind(errNetwork, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", withKind(errNetwork, fmt.Errorf("latest version endpoint returned status %d for %s", resp.StatusCode, redactUrl(resp.Request.URL)))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, LATEST_VERSION_MAX_BYTES))
+	if err != nil {
+		return "", withKind(errNetwork, err)
+	}
+
+	var version string
+	switch {
+	case t.LatestField != "":
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return "", fmt.Errorf("latest version endpoint %s didn't return JSON: %w", t.Latest, err)
+		}
+		for _, field := range strings.Split(t.LatestField, ".") {
+			obj, _ := v.(map[string]any)
+			v = obj[field]
+		}
+		if s, ok := v.(string); ok {
+			version = s
+		}
+	case t.LatestPattern != "":
+		m := regexp.MustCompile(t.LatestPattern).FindStringSubmatch(string(data))
+		if len(m) > 1 {
+			version = m[1]
+		} else if len(m) == 1 {
+			version = m[0]
+		}
+	default:
+		version, _, _ = strings.Cut(strings.TrimSpace(string(data)), "\n")
+	}
+
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return "", fmt.Errorf("no version was found in the response from %s", t.Latest)
+	}
+	return version, nil
+}
+
+// lookupSourceTemplate returns the template a source given by the user refers to: either the name of a source in the
+// config file, or a URL with a {version} placeholder. The name is "" for a URL.
+func lookupSourceTemplate(source string) (*SourceTemplate, string, bool) {
+	if t, ok := config.Sources[source]; ok {
+		return &t, source, true
+	}
+	if isTemplateUrl(source) {
+		return &SourceTemplate{Url: source}, "", true
+	}
+	return nil, "", false
+}
+
+// isTemplateUrl returns whether source is a URL with a version placeholder.
+func isTemplateUrl(source string) bool {
+	return strings.Contains(source, "{version}") || strings.Contains(source, "{tag}")
+}
+
+// canFindLatest returns whether the latest version of source can be found without the user saying what it is.
+func canFindLatest(source string) bool {
+	if isRecipePath(source) {
+		r, err := readRecipe(source)
+		return err == nil && r.canFindLatest()
+	}
+	if _, ok := releaseRepoPath(source); ok {
+		return true
+	}
+	if u, err := parseSourceUrl(source); err == nil {
+		if _, ok := parseWorkflowSource(u); ok {
+			return true
+		}
+	}
+	t, _, ok := lookupSourceTemplate(source)
+	return ok && t.Latest != ""
+}
+
+// latestVersionOf returns the latest version of source, and the page of its release if it has one, considering
+// prereleases if prerelease is set. source must be one that canFindLatest accepts.
+func latestVersionOf(source string, prerelease bool) (string, string, error) {
+	if isRecipePath(source) {
+		r, err := readRecipe(source)
+		if err != nil {
+			return "", "", err
+		}
+		if t, ok := r.template(); ok {
+			version, err := t.latestVersion()
+			return version, "", err
+		}
+		if _, _, ok := r.versionRange(); ok {
+			release, err := r.latestRelease()
+			if err != nil {
+				return "", "", err
+			}
+			return release.TagName, release.HtmlUrl, nil
+		}
+		return latestVersionOf(r.Source, prerelease)
+	}
+	if u, err := parseSourceUrl(source); err == nil {
+		if w, ok := parseWorkflowSource(u); ok {
+			run, _, err := w.latestRun()
+			if err != nil {
+				return "", "", err
+			}
+			return run.version(), run.HtmlUrl, nil
+		}
+	}
+	if repoPath, ok := releaseRepoPath(source); ok {
+		release, err := fetchLatestRelease(repoPath, prerelease)
+		if err != nil {
+			return "", "", err
+		}
+		return release.TagName, release.HtmlUrl, nil
+	}
+	t, _, _ := lookupSourceTemplate(source)
+	version, err := t.latestVersion()
+	return version, "", err
+}
+
+// resolveTemplate finds the tarball to download for a source template: for opts.Tag if it is set, or else the latest
+// version.
+func resolveTemplate(t *SourceTemplate, name string, opts resolveOpts) (*resolvedSource, error) {
+	if opts.Keyring == "" {
+		opts.Keyring = config.KeyringFor(name)
+	}
+	if opts.MinisignKey == "" {
+		opts.MinisignKey = config.MinisignKeyFor(name)
+	}
+	version := opts.Tag
+	if version == "" {
+		if t.Latest == "" {
+			return nil, withKind(errUsage, errors.New(tr("Give the version to install with --version, or set latest for the source in the config file.")))
+		}
+		var err error
+		if version, err = t.latestVersion(); err != nil {
+			return nil, withPackage(err, name, t.Latest)
+		}
+		slog.Info("found latest version", "source", name, "version", version)
+	}
+
+	src := &resolvedSource{Name: name, Version: version, Url: t.expand(version, opts.Platform)}
+	keys, err := verifyKeysFor(opts)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		if src.Signature = fetchSignature(src.Url, keys); src.Signature == nil {
+			return nil, withKind(errVerificationFailed, fmt.Errorf("no signature was found for %s; pass --no-verify to install it anyway", src.Url))
+		}
+	}
+	progress.Emit(progressEvent{Event: PROGRESS_RESOLVE, Package: name, Version: version, Url: src.Url})
+	return src, nil
+}
@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || netbsd
+
+package main
+
+import "syscall"
+
+// The ioctl requests that get and set a terminal's attributes.
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
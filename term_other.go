@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw isn't supported here, so the picker falls back to a plain prompt.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.ErrUnsupported
+}
+
+func terminalWidth(f *os.File) int {
+	return 80
+}
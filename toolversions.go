@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// TOOL_VERSIONS_FILENAME is the name of the file asdf and mise use to pin the versions of tools.
+const TOOL_VERSIONS_FILENAME = ".tool-versions"
+
+// toolVersion is a line of a .tool-versions file.
+type toolVersion struct {
+	Name string
+	// Version is the preferred version of the tool. .tool-versions allows fallbacks to be listed after it, which
+	// infpm ignores.
+	Version string
+}
+
+// readToolVersions parses a .tool-versions file: one tool per line, followed by its versions, with # comments.
+func readToolVersions(fp string) ([]toolVersion, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tools := []toolVersion{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		tools = append(tools, toolVersion{Name: fields[0], Version: fields[1]})
+	}
+	return tools, scanner.Err()
+}
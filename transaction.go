@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// STAGING_DIR is the directory within the store that packages are extracted into before they are moved into place.
+// It is in the store so that moving a package into place is a rename on the same filesystem.
+const STAGING_DIR = ".staging"
+
+// stagingMu stops one install removing the staging directory once it is empty while another is creating its own in it.
+var stagingMu sync.Mutex
+
+// installTx is an install in progress. The package is extracted into a staging directory and only moved into the
+// store and linked once everything else has succeeded. Every change made outside the staging directory is recorded,
+// so that a failed or interrupted install can be rolled back, leaving the store and symlink root as they were.
+type installTx struct {
+	// Staging is where the package is extracted to.
+	Staging   string
+	storePath string
+	// undo are the steps that roll back the changes made so far, in the order they were made.
+	undo []func()
+
+	// reader is closed when the install is interrupted, to stop the extraction reading from it. Downloads are
+	// cancelled anyway, but local files aren't.
+	reader  io.Closer
+	stopped chan struct{}
+}
+
+// beginInstall starts an install into storePath, creating its staging directory. Interrupting infpm while the install
+// is in progress stops reading from reader and makes the install fail, so that it is rolled back. end must be called
+// once the install has been committed or rolled back.
+func beginInstall(storePath string, reader io.Closer) (*installTx, error) {
+	staging := filepath.Join(storePath, STAGING_DIR, generateId())
+	stagingMu.Lock()
+	err := os.MkdirAll(staging, 0755)
+	stagingMu.Unlock()
+	if err != nil {
+		slog.Error("failed to create staging directory", "path", staging)
+		return nil, err
+	}
+
+	tx := &installTx{
+		Staging:   staging,
+		storePath: storePath,
+		reader:    reader,
+		stopped:   make(chan struct{}),
+	}
+	go tx.watchInterrupt()
+	return tx, nil
+}
+
+func (tx *installTx) watchInterrupt() {
+	select {
+	case <-runCtx.Done():
+	case <-tx.stopped:
+		return
+	}
+	slog.Warn("rolling back the install")
+	if tx.reader != nil {
+		tx.reader.Close()
+	}
+}
+
+// checkInterrupted returns an error if the install has been interrupted. Steps that can't be interrupted part way
+// through check this before they start.
+func (tx *installTx) checkInterrupted() error {
+	if interrupted() != nil {
+		return withKind(errInterrupted, errors.New("the install was interrupted"))
+	}
+	return nil
+}
+
+// onRollback records a step that undoes a change the install has made.
+func (tx *installTx) onRollback(undo func()) {
+	tx.undo = append(tx.undo, undo)
+}
+
+// rollback undoes every change the install has made, most recent first.
+func (tx *installTx) rollback() {
+	slog.Info("rolling back install")
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+// end removes the staging directory and stops watching for interrupts.
+func (tx *installTx) end() {
+	close(tx.stopped)
+	os.RemoveAll(tx.Staging)
+	stagingMu.Lock()
+	removeEmptyParents(filepath.Dir(tx.Staging), tx.storePath)
+	stagingMu.Unlock()
+}
+
+// commitDir moves the package from the staging directory to fullPath in the store. Rolling back removes it again.
+func (tx *installTx) commitDir(fullPath string) error {
+	if err := tx.checkInterrupted(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tx.Staging, fullPath); err != nil {
+		slog.Error("failed to move package into the store", "from", tx.Staging, "to", fullPath)
+		return err
+	}
+	tx.onRollback(func() {
+		os.RemoveAll(fullPath)
+		removeEmptyParents(filepath.Dir(fullPath), tx.storePath)
+	})
+	return nil
+}
+
+// backupWrappers records the wrapper scripts a portable install of the package at fullPath is about to write, so that
+// rolling back restores the ones it overwrites and removes the rest.
+func (tx *installTx) backupWrappers(fullPath string, symlinkPath string, layout linkLayout) error {
+	links, err := planDefaultLinks(fullPath, symlinkPath, layout)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(symlinkPath, "bin")
+	for _, l := range links {
+		if filepath.Dir(l.Dst) != binDir {
+			continue
+		}
+		dst := l.Dst
+		data, err := os.ReadFile(dst)
+		switch {
+		case os.IsNotExist(err):
+			tx.onRollback(func() { os.Remove(dst) })
+		case err != nil:
+			return err
+		default:
+			tx.onRollback(func() { os.WriteFile(dst, data, 0755) })
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Uninstall removes a package's links from the symlink root, deletes it from the store and removes its record.
+// Only links that still point into the package are removed, so files the user has replaced are left alone.
+func (pm *PackageManager) Uninstall(rec *PackageRecord) error {
+	fullPath := filepath.Join(pm.StorePath, rec.Path)
+	dsts, err := pm.unlink(rec)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("removing package from the store", "package", rec.Name, "path", fullPath)
+	if err := os.RemoveAll(fullPath); err != nil {
+		slog.Error("failed to remove package directory", "path", fullPath)
+		return err
+	}
+
+	removeEmptyParents(filepath.Dir(fullPath), pm.StorePath)
+	if len(unitsIn(dsts)) > 0 && hasSystemd() {
+		systemctl("daemon-reload")
+	}
+
+	pm.db.Remove(rec)
+	if err := pm.db.Save(); err != nil {
+		return err
+	}
+	pm.logHistory(HISTORY_UNINSTALL, rec)
+	return nil
+}
+
+// Retire removes a package's links and its record like Uninstall, but leaves it in the store, so that rollback can
+// link it again without re-extracting it.
+func (pm *PackageManager) Retire(rec *PackageRecord) error {
+	dsts, err := pm.unlink(rec)
+	if err != nil {
+		return err
+	}
+	if len(unitsIn(dsts)) > 0 && hasSystemd() {
+		systemctl("daemon-reload")
+	}
+	pm.db.Remove(rec)
+	if err := pm.db.Save(); err != nil {
+		return err
+	}
+	pm.logHistory(HISTORY_RETIRE, rec)
+	return nil
+}
+
+// unlink removes a package's links from the symlink root, disabling its systemd units first, and returns every
+// location that was checked.
+func (pm *PackageManager) unlink(rec *PackageRecord) ([]string, error) {
+	fullPath := filepath.Join(pm.StorePath, rec.Path)
+
+	// The link roots may have been reconfigured since the package was linked, so remove the links recorded then as
+	// well as the ones that would be made now.
+	links, err := pm.planLinks(fullPath, rec.linkLayout())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	dsts := slices.Clone(rec.Links)
+	for _, l := range links {
+		dsts = mergeLinks(dsts, []string{l.Dst})
+	}
+	disableUnits(dsts)
+	unlinkInto(dsts, fullPath)
+	return dsts, nil
+}
+
+// unlinkInto removes each of dsts that is a link into the package at fullPath.
+func unlinkInto(dsts []string, fullPath string) {
+	for _, dst := range dsts {
+		if !linksInto(dst, fullPath) {
+			continue
+		}
+		if err := os.Remove(dst); err != nil {
+			slog.Error("failed to remove link, continuing", "path", dst, "err", err)
+		} else {
+			slog.Debug("removed link", "path", dst)
+		}
+	}
+}
+
+// removeEmptyParents removes dir and each of its parents while they are empty, stopping at stop.
+func removeEmptyParents(dir string, stop string) {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); dir != stop && dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		// Remove fails on non-empty directories, which is exactly when we want to stop.
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+func actionUninstall(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() == 0 && cmd.String("tag") == "" {
+		return withKind(errUsage, errors.New(tr("A package name or --tag is required. See --help uninstall.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	names, err := namesFromCmd(cmd, pm)
+	if err != nil {
+		return err
+	}
+
+	recs := []*PackageRecord{}
+	for _, name := range names {
+		for _, rec := range pm.db.Find(name) {
+			if !slices.Contains(recs, rec) {
+				recs = append(recs, rec)
+			}
+		}
+	}
+	// Scripts aren't asked, since they may not expect the question.
+	if len(recs) > 0 && pm.Interactive && isTerminal(os.Stdin) {
+		removing := []string{}
+		for _, rec := range recs {
+			removing = append(removing, rec.String())
+		}
+		fmt.Println(tr("These will be uninstalled: %s", strings.Join(removing, ", ")))
+		if !newPrompter(true).confirm(tr("Continue?"), true) {
+			return withKind(errUsage, errors.New(tr("Nothing was uninstalled.")))
+		}
+	}
+
+	for _, rec := range recs {
+		if err := interrupted(); err != nil {
+			return err
+		}
+		if err := pm.Uninstall(rec); err != nil {
+			return withPackage(err, rec.Name, rec.Source)
+		}
+		if ciMode {
+			ciResult(CI_UNINSTALLED, rec.Name, rec.Version, "")
+		} else {
+			fmt.Println(tr("Uninstalled %s", rec))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// UNITS_DIR is where the rewritten copies of a package's systemd user units are kept, relative to the package.
+const UNITS_DIR = ".infpm-units"
+
+// unitExtensions are the kinds of systemd unit that are installed from packages.
+var unitExtensions = []string{".service", ".socket", ".timer", ".path"}
+
+// unitDirSuffixes are the directories, relative to a package's prefix, that systemd user units are shipped in.
+var unitDirSuffixes = []string{"lib/systemd/user", "share/systemd/user", "share/systemd"}
+
+// userUnitDir returns the directory systemd looks for the user's own units in.
+func userUnitDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// findUnits returns the systemd user units shipped in the package at fullPath.
+func findUnits(fullPath string) ([]string, error) {
+	units := []string{}
+	err := filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == UNITS_DIR {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !slices.Contains(unitExtensions, filepath.Ext(path)) {
+			return nil
+		}
+		dir := filepath.ToSlash(filepath.Dir(path))
+		for _, suffix := range unitDirSuffixes {
+			if strings.HasSuffix(dir, "/"+suffix) {
+				units = append(units, path)
+				break
+			}
+		}
+		return nil
+	})
+	return units, err
+}
+
+// rewriteUnit points the Exec lines of a unit at the package's executables in the store, since units are written for
+// a system install, e.g. ExecStart=/usr/bin/syncthing. Commands the package doesn't ship are left alone.
+func rewriteUnit(unit string, executables map[string]string) string {
+	var b strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(unit))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key, value, ok := strings.Cut(line, "="); ok && strings.HasPrefix(strings.TrimSpace(key), "Exec") {
+			// Exec lines may start with special characters that change how the command is run, e.g. - to ignore failure.
+			value = strings.TrimSpace(value)
+			cmd := strings.TrimLeft(value, "-@:+!")
+			prefix := value[:len(value)-len(cmd)]
+			bin, args, _ := strings.Cut(cmd, " ")
+			if path, ok := executables[filepath.Base(bin)]; ok {
+				line = key + "=" + prefix + path
+				if args != "" {
+					line += " " + args
+				}
+			}
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// linkUnits rewrites the systemd user units shipped by a package into UNITS_DIR and links them into the user's unit
+// directory, returning the links made. links are the package's other planned links, whose executables the units are
+// rewritten to run. Only does anything on Linux.
+func linkUnits(fullPath string, links []plannedLink, binDir string) (_ []string, err error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	found, err := findUnits(fullPath)
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	unitDir, err := userUnitDir()
+	if err != nil {
+		return nil, err
+	}
+
+	executables := map[string]string{}
+	for _, l := range links {
+		if filepath.Dir(l.Dst) != filepath.Clean(binDir) {
+			continue
+		}
+		// systemd only runs commands by absolute path.
+		if src, err := filepath.Abs(l.Src); err == nil {
+			executables[filepath.Base(l.Dst)] = src
+		}
+	}
+
+	linked := []string{}
+	// created are the links made by this call, which are removed again if linking fails part way through.
+	created := []string{}
+	defer func() {
+		if err != nil {
+			unlinkInto(created, fullPath)
+		}
+	}()
+	for _, src := range found {
+		name := filepath.Base(src)
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+
+		rewritten := filepath.Join(fullPath, UNITS_DIR, name)
+		if err := os.MkdirAll(filepath.Dir(rewritten), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(rewritten, []byte(rewriteUnit(string(data), executables)), 0644); err != nil {
+			return nil, err
+		}
+
+		dst := filepath.Join(unitDir, name)
+		if !linkExists(rewritten, dst) {
+			if err := os.MkdirAll(unitDir, 0755); err != nil {
+				return nil, err
+			}
+			if err := os.Symlink(rewritten, dst); err != nil {
+				slog.Error("failed to link systemd unit, continuing", "unit", name, "to", dst, "err", err)
+				continue
+			}
+			slog.Debug("linked systemd unit", "from", rewritten, "to", dst)
+			created = append(created, dst)
+		}
+		linked = append(linked, dst)
+	}
+	return linked, nil
+}
+
+// unitsIn returns the names of the systemd units among a package's links.
+func unitsIn(links []string) []string {
+	unitDir, err := userUnitDir()
+	if err != nil {
+		return nil
+	}
+	units := []string{}
+	for _, l := range links {
+		if filepath.Dir(l) == unitDir {
+			units = append(units, filepath.Base(l))
+		}
+	}
+	return units
+}
+
+// enabledUnits returns which of units are enabled.
+func enabledUnits(units []string) []string {
+	if !hasSystemd() {
+		return nil
+	}
+	enabled := []string{}
+	for _, u := range units {
+		if exec.Command("systemctl", "--user", "is-enabled", "--quiet", u).Run() == nil {
+			enabled = append(enabled, u)
+		}
+	}
+	return enabled
+}
+
+// systemctl runs systemctl --user with args.
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stderr = os.Stderr
+	slog.Debug("running systemctl", "args", cmd.Args)
+	return cmd.Run()
+}
+
+// hasSystemd returns whether the user's systemd instance can be managed: the system was booted with systemd, and
+// this is a login session with a user manager to talk to.
+func hasSystemd() bool {
+	if runtime.GOOS != "linux" || os.Getenv("XDG_RUNTIME_DIR") == "" {
+		return false
+	}
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return false
+	}
+	_, err := exec.LookPath("systemctl")
+	return err == nil
+}
+
+// offerUnits tells systemd about the units of a package that was just installed and, if the user agrees, enables and
+// starts them. When not interactive, units are never enabled.
+func (pm *PackageManager) offerUnits(pkg *Package) {
+	units := unitsIn(pkg.Links)
+	if len(units) == 0 || !hasSystemd() {
+		return
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		slog.Warn("failed to reload systemd user units", "err", err)
+		return
+	}
+
+	if !pm.Interactive {
+		fmt.Println(tr("%s ships systemd user units. Enable them with: systemctl --user enable --now %s", pkg.Name, strings.Join(units, " ")))
+		return
+	}
+	if !newPrompter(true).confirm(tr("%s ships systemd user units: %s. Enable and start them now?", pkg.Name, strings.Join(units, ", ")), false) {
+		return
+	}
+	if err := systemctl(append([]string{"enable", "--now"}, units...)...); err != nil {
+		slog.Error("failed to enable systemd user units", "units", units, "err", err)
+	}
+}
+
+// disableUnits stops and disables the enabled units among a package's links before it is removed.
+func disableUnits(links []string) {
+	units := enabledUnits(unitsIn(links))
+	if len(units) == 0 {
+		return
+	}
+	if err := systemctl(append([]string{"disable", "--now"}, units...)...); err != nil {
+		slog.Warn("failed to disable systemd user units, continuing", "units", units, "err", err)
+	}
+}
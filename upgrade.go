@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+// What became of a package given to upgrade.
+const (
+	UPGRADE_UPGRADED   = "upgraded"
+	UPGRADE_UP_TO_DATE = "up-to-date"
+	UPGRADE_SKIPPED    = "skipped"
+	UPGRADE_FAILED     = "failed"
+)
+
+// upgradeResult is what became of a package given to upgrade: one of the UPGRADE_ constants, with the reason it was
+// skipped or failed.
+type upgradeResult struct {
+	Package string `json:"package"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// latestRecord returns the most recently installed record of the named package, or nil if it isn't installed.
+func (pm *PackageManager) latestRecord(name string) *PackageRecord {
+	var latest *PackageRecord
+	for _, rec := range pm.db.Find(name) {
+		if latest == nil || rec.InstalledAt.After(latest.InstalledAt) {
+			latest = rec
+		}
+	}
+	return latest
+}
+
+// Upgrade installs the latest release of an installed package from the source it was installed from, then removes
+// the versions it replaces. Returns errNothingToUpgrade if the latest release is already installed, or is older than
+// the installed version, which is never downgraded.
+func (pm *PackageManager) Upgrade(name string, ropts resolveOpts) (*Package, error) {
+	current := pm.latestRecord(name)
+	if current == nil {
+		return nil, withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+	}
+	if !canFindLatest(current.Source) {
+		return nil, withKind(errUsage, fmt.Errorf("%s was not installed from a repository on GitHub or a Gitea host, or a source with a latest version endpoint, so infpm can't tell if there is a newer version", name))
+	}
+
+	ropts.Platform = current.Platform
+	src, err := resolveSource(current.Source, ropts)
+	if err != nil {
+		return nil, err
+	}
+	if sameVersion(src.Version, current.Version) {
+		return nil, withKind(errNothingToUpgrade, fmt.Errorf("%s is already at the latest version, %s", name, current.Version))
+	} else if !isNewerVersion(src.Version, current.Version) {
+		return nil, withKind(errNothingToUpgrade, fmt.Errorf("%s %s is newer than the latest release, %s, so it wasn't downgraded", name, current.Version, src.Version))
+	}
+
+	opts := PreinstallPackageOpts{Name: name, Version: src.Version, Source: current.Source, Platform: current.Platform, Layout: current.linkLayout()}
+	ropts.Tag = src.Version
+	return pm.Replace(opts, ropts)
+}
+
+// Replace installs a package from opts.Source, then retires every other installed version of it and links the new
+// one in their place. The old versions stay in the store, so that rollback can return to them.
+func (pm *PackageManager) Replace(opts PreinstallPackageOpts, ropts resolveOpts) (*Package, error) {
+	name := opts.Name
+	pkg, err := pm.InstallSource(opts.Source, false, opts, ropts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The old versions still hold the links the new one wants, so retire them and then link the new one again.
+	// Retiring them disables their systemd units, so note which were enabled to enable the new ones.
+	old := []*PackageRecord{}
+	enabled := []string{}
+	for _, rec := range pm.db.Find(name) {
+		if rec.Id != pkg.Id {
+			old = append(old, rec)
+			enabled = append(enabled, enabledUnits(unitsIn(rec.Links))...)
+		}
+	}
+	for _, rec := range old {
+		slog.Info("retiring upgraded version", "package", name, "version", rec.Version)
+		if err := pm.Retire(rec); err != nil {
+			return nil, err
+		}
+	}
+	if pkg.Symlinked && !pm.Portable {
+		links, err := pm.linkPackage(pkg.Name, pkg.Platform, pkg.FullPath, pkg.Layout)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range pm.db.Find(name) {
+			if rec.Id == pkg.Id {
+				rec.Links = mergeLinks(rec.Links, links)
+			}
+		}
+		if err := pm.db.Save(); err != nil {
+			return nil, err
+		}
+		if len(unitsIn(links)) > 0 && hasSystemd() {
+			systemctl("daemon-reload")
+		}
+		if len(enabled) > 0 {
+			if err := systemctl(append([]string{"enable", "--now"}, enabled...)...); err != nil {
+				slog.Error("failed to enable systemd user units again", "units", enabled, "err", err)
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// githubRepoPath returns the /user/repo path of a source if it is a GitHub repository.
+func githubRepoPath(source string) (string, bool) {
+	u, err := parseSourceUrl(source)
+	if err != nil || getGithubRepoName(u) == "" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// upgradePackages upgrades each of the named packages in turn, carrying on past failures. Pinned packages, and
+// packages whose latest version can't be found, are skipped.
+func (pm *PackageManager) upgradePackages(names []string, ropts resolveOpts) ([]*upgradeResult, []*Package) {
+	results := []*upgradeResult{}
+	pkgs := []*Package{}
+	for _, name := range names {
+		if err := interrupted(); err != nil {
+			results = append(results, &upgradeResult{Package: name, Status: UPGRADE_FAILED, Reason: err.Error()})
+			continue
+		}
+		current := pm.latestRecord(name)
+		if current == nil {
+			results = append(results, &upgradeResult{Package: name, Status: UPGRADE_FAILED, Reason: tr("not installed")})
+			continue
+		}
+		result := &upgradeResult{Package: name, From: current.Version}
+		results = append(results, result)
+		if pm.db.IsPinned(name) {
+			result.Status, result.Reason = UPGRADE_SKIPPED, tr("pinned")
+			continue
+		}
+		if !canFindLatest(current.Source) {
+			result.Status, result.Reason = UPGRADE_SKIPPED, tr("its source has no latest version")
+			continue
+		}
+
+		pkg, err := pm.Upgrade(name, ropts)
+		switch {
+		case errors.Is(err, errNothingToUpgrade):
+			result.Status = UPGRADE_UP_TO_DATE
+		case err != nil:
+			slog.Error("failed to upgrade package, continuing", "package", name, "err", err)
+			result.Status, result.Reason = UPGRADE_FAILED, err.Error()
+		default:
+			result.Status, result.To = UPGRADE_UPGRADED, pkg.Version
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return results, pkgs
+}
+
+// printUpgradeSummary prints a table of what became of each package given to upgrade, followed by the totals.
+func printUpgradeSummary(results []*upgradeResult) error {
+	counts := map[string]int{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, tr("PACKAGE\tFROM\tTO\tRESULT"))
+	for _, r := range results {
+		counts[r.Status]++
+		status := tr(r.Status)
+		if r.Reason != "" {
+			status += ": " + r.Reason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Package, r.From, r.To, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println(tr("Upgraded %d, %d up to date, %d skipped, %d failed.", counts[UPGRADE_UPGRADED], counts[UPGRADE_UP_TO_DATE], counts[UPGRADE_SKIPPED], counts[UPGRADE_FAILED]))
+	return nil
+}
+
+func actionUpgrade(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Bool("all") == (cmd.NArg() > 0 || cmd.String("tag") != "") {
+		return withKind(errUsage, errors.New(tr("Give the packages to upgrade, --tag, or --all to upgrade every package.")))
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	var names []string
+	if cmd.Bool("all") {
+		names = pm.db.Names()
+	} else if names, err = namesFromCmd(cmd, pm); err != nil {
+		return err
+	}
+	// Each package is upgraded for the platform it was installed for.
+	ropts := resolveOpts{Platform: hostPlatform(), NonInteractive: nonInteractive || cmd.Bool("yes"), Prerelease: cmd.Bool("pre")}
+	results, pkgs := pm.upgradePackages(names, ropts)
+
+	if cmd.Bool("json") {
+		if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+			return err
+		}
+	} else if err := printUpgradeSummary(results); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		if pkg.Symlinked && !pm.Portable {
+			printRehashHint()
+			break
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Status == UPGRADE_FAILED {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to upgrade %d of %d packages", failed, len(results))
+	}
+	if len(pkgs) == 0 {
+		return withKind(errNothingToUpgrade, errors.New(tr("Nothing was upgraded.")))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// inUse returns whether any of a package's links still point into it, i.e. whether it is the version of the package
+// that is linked, rather than one installed beside it.
+func (db *Database) inUse(rec *PackageRecord) bool {
+	fullPath := filepath.Join(db.storePath, rec.Path)
+	for _, l := range rec.Links {
+		if linksInto(l, fullPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Use switches the links of a package to one of its installed versions, rec, without reinstalling anything. Each
+// link that points into another version is replaced in one step, so the command it links is never missing; links
+// only the other versions have are removed, and links only rec has are created.
+func (pm *PackageManager) Use(rec *PackageRecord) error {
+	fullPath := filepath.Join(pm.StorePath, rec.Path)
+	others := []*PackageRecord{}
+	for _, other := range pm.db.Find(rec.Name) {
+		if other != rec && other.Platform.IsHost() {
+			others = append(others, other)
+		}
+	}
+
+	links, err := pm.planLinks(fullPath, rec.linkLayout())
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		for _, other := range others {
+			if !linksInto(l.Dst, filepath.Join(pm.StorePath, other.Path)) {
+				continue
+			}
+			if err := replaceLink(l.Src, l.Dst); err != nil {
+				return err
+			}
+			slog.Debug("switched link", "from", l.Src, "to", l.Dst)
+			progress.Emit(progressEvent{Event: PROGRESS_LINK, Package: rec.Name, Path: l.Dst, Target: l.Src})
+			break
+		}
+	}
+
+	// The other versions' units are disabled before the links to them go, like on uninstall.
+	for _, other := range others {
+		otherPath := filepath.Join(pm.StorePath, other.Path)
+		disableUnits(other.Links)
+		unlinkInto(other.Links, otherPath)
+		other.Links = nil
+	}
+
+	linked, err := pm.linkPackage(rec.Name, rec.Platform, fullPath, rec.linkLayout())
+	if err != nil {
+		return err
+	}
+	rec.Links = mergeLinks(rec.Links, linked)
+	if len(unitsIn(linked)) > 0 && hasSystemd() {
+		systemctl("daemon-reload")
+	}
+	if err := pm.db.Save(); err != nil {
+		return err
+	}
+	pm.logHistory(HISTORY_USE, rec)
+	return nil
+}
+
+// replaceLink points the link at dst to src by renaming a new link over it, so dst always exists.
+func replaceLink(src string, dst string) error {
+	tmp := dst + ".infpm-use"
+	os.Remove(tmp)
+	if err := os.Symlink(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func actionUse(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() != 2 {
+		return withKind(errUsage, errors.New(tr("A package name and an installed version are required, e.g. infpm use node 20.11.0.")))
+	}
+	name, version := cmd.Args().Get(0), cmd.Args().Get(1)
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	recs := pm.db.Find(name)
+	if len(recs) == 0 {
+		return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+	}
+	var rec *PackageRecord
+	versions := []string{}
+	for _, r := range recs {
+		if !r.Platform.IsHost() {
+			continue
+		}
+		versions = append(versions, r.Version)
+		// If a version was installed more than once, use the most recent install.
+		if sameVersion(r.Version, version) && (rec == nil || r.InstalledAt.After(rec.InstalledAt)) {
+			rec = r
+		}
+	}
+	if rec == nil {
+		return withKind(errUsage, fmt.Errorf("%s %s is not installed. Installed versions: %s", name, version, strings.Join(versions, ", ")))
+	}
+
+	if err := pm.Use(rec); err != nil {
+		return withPackage(err, rec.Name, rec.Source)
+	}
+	fmt.Println(tr("Now using %s", rec))
+	printRehashHint()
+	return nil
+}
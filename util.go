@@ -5,24 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"runtime"
+	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // githubApiReleases represents the response from the GitHub API specified here:
 // https://docs.github.com/en/rest/releases/releases?apiVersion=2022-11-28#get-the-latest-releasetype
 type githubApiReleases struct {
-	HtmlUrl string                   `json:"html_url"`
-	Name    string                   `json:"name"`
-	Assets  []*githubApiReleaseAsset `json:"assets"`
-	TagName string                   `json:"tag_name"`
+	HtmlUrl    string                   `json:"html_url"`
+	Name       string                   `json:"name"`
+	Assets     []*githubApiReleaseAsset `json:"assets"`
+	TagName    string                   `json:"tag_name"`
+	Draft      bool                     `json:"draft"`
+	Prerelease bool                     `json:"prerelease"`
 }
 
 // githubApiReleaseAsset is a member of the list of assets returned by the GitHub API specified here:
@@ -30,6 +35,21 @@ type githubApiReleases struct {
 type githubApiReleaseAsset struct {
 	Name               string `json:"name"`
 	BrowserDownloadUrl string `json:"browser_download_url"`
+	// ApiUrl is the asset's API endpoint. Unlike BrowserDownloadUrl, it can be downloaded from with a token, which is
+	// needed for assets of private repositories.
+	ApiUrl string `json:"url"`
+	// Size is the size of the asset in bytes, and DownloadCount how many times it has been downloaded.
+	Size          int64 `json:"size"`
+	DownloadCount int   `json:"download_count"`
+}
+
+// downloadUrl returns the URL to download the asset from: the API endpoint if there is a GitHub token to
+// authenticate with, since that works for private repositories too, or else the browser download URL.
+func (a *githubApiReleaseAsset) downloadUrl() string {
+	if token, _ := lookupToken("github"); token != "" && a.ApiUrl != "" {
+		return a.ApiUrl
+	}
+	return a.BrowserDownloadUrl
 }
 
 // getGithubRepoName returns the repo name if if the URL is in the form github.com/user/repo. Otherwise, returns "".
@@ -46,76 +66,470 @@ type fetchedGithubAsset struct {
 	Name    string
 	Version string
 	Url     string
+	// Filename is the name of the asset, which Url doesn't end with if it is the API endpoint.
+	Filename string
+	// Checksums are the asset's checksums published with the release, if any.
+	Checksums []checksum
+	// Signature is the asset's signature published with the release, if it must be verified against one.
+	Signature *signatureCheck
 }
 
-// fetchLatestGithubAsset fetches the latest asset that suits the OS from GitHub, based on the URL.
+// fetchGithubAsset fetches the asset that suits the platform from a GitHub release, based on the URL. The latest
+// release is used unless opts.Tag is set. Releases of repositories on Gitea hosts are fetched the same way.
 // TODO: rework this entire thing to be non-interactive, with an interactive version
-func fetchLatestGithubAsset(u *url.URL) (*fetchedGithubAsset, error) {
-	repoName := getGithubRepoName(u)
+func fetchGithubAsset(u *url.URL, opts resolveOpts) (*fetchedGithubAsset, error) {
+	platform := opts.Platform
+	repoName := getRepoName(u)
 	if repoName == "" {
 		return nil, errors.New("internal: provided URL was not in the form github.com/user/repo")
 	}
+	repoPath := repoPathOf(u)
 
-	apiUrl, _ := url.Parse("https://api.github.com/repos")
-	apiUrl = apiUrl.JoinPath(u.Path).JoinPath("releases/latest")
-
-	resp, err := http.Get(apiUrl.String())
+	var releaseData *githubApiReleases
+	var err error
+	if opts.Tag == "" {
+		releaseData, err = fetchLatestRelease(repoPath, opts.Prerelease)
+	} else {
+		// Tags are usually, but not always, prefixed with v, and versions are often given without it.
+		alt := "v" + opts.Tag
+		if trimmed, ok := strings.CutPrefix(opts.Tag, "v"); ok {
+			alt = trimmed
+		}
+		for _, tag := range []string{opts.Tag, alt} {
+			releaseData, err = fetchGithubRelease(repoPath, "releases/tags/"+tag)
+			if !errors.Is(err, errReleaseNotFound) {
+				break
+			}
+		}
+		if errors.Is(err, errReleaseNotFound) {
+			releaseData, err = findReleaseByTag(repoPath, opts.Tag)
+		}
+	}
+	if errors.Is(err, errReleaseNotFound) {
+		return nil, withKind(errNoMatchingAsset, fmt.Errorf("%s has no release tagged %s", strings.Trim(repoPath, "/"), opts.Tag))
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, errors.New("GitHub returned non-OK status code. This is likely due to a ratelimit imposed by the API. Provide the URL to the release tarball yourself.")
+	if opts.NonInteractive {
+		slog.Info("found release", "release", releaseData.Name, "url", releaseData.HtmlUrl)
+	} else {
+		fmt.Println(tr("Found release: %s. Read about this release: %s", releaseData.Name, releaseData.HtmlUrl))
+	}
+
+	potentialAssets := matchingAssets(releaseData, opts)
+	if len(potentialAssets) == 0 && opts.Tag == "" {
+		// Releases sometimes only ship some builds, so an older release may still have one for this platform.
+		older, assets, err := findOlderRelease(repoPath, opts, releaseData.TagName)
+		if err != nil {
+			return nil, err
+		}
+		if older != nil {
+			if opts.NonInteractive {
+				slog.Warn("latest release has no asset for this platform, using an older release", "latest", releaseData.TagName, "release", older.TagName, "platform", platform)
+			} else {
+				fmt.Println(tr("No assets in the latest release, %s, match %s. Using %s, the newest release that has one: %s", releaseData.TagName, platform, older.TagName, older.HtmlUrl))
+			}
+			releaseData, potentialAssets = older, assets
+		}
+	}
+	// When asked, the user can choose from every asset instead, in case the guesses are wrong.
+	interactive := !opts.NonInteractive && len(releaseData.Assets) > 0
+	if len(potentialAssets) == 0 && opts.Asset != "" && !interactive {
+		names := []string{}
+		for _, asset := range releaseData.Assets {
+			names = append(names, asset.Name)
+		}
+		return nil, withKind(errNoMatchingAsset, fmt.Errorf("no assets in release %s match %s; the assets are: %s", releaseData.TagName, opts.Asset, strings.Join(names, ", ")))
+	}
+	if len(potentialAssets) == 0 && !interactive {
+		return nil, withKind(errNoMatchingAsset, errors.New("no assets in release "+releaseData.TagName+" match "+platform.String()))
 	}
 
-	var releaseData githubApiReleases
-	if err := json.NewDecoder(resp.Body).Decode(&releaseData); err != nil {
-		slog.Error("failed to decode GitHub releases/latest API response")
+	var asset *githubApiReleaseAsset
+	if opts.NonInteractive {
+		if asset, err = chooseAssetNonInteractively(potentialAssets, opts, releaseData.TagName); err != nil {
+			return nil, err
+		}
+	} else if asset, err = chooseAssetInteractively(releaseData, potentialAssets, opts); err != nil {
 		return nil, err
 	}
 
-	fmt.Println("Found latest release: " + releaseData.Name + ". Read about this release: " + releaseData.HtmlUrl)
+	fetched := &fetchedGithubAsset{Name: repoName, Version: releaseData.TagName, Url: asset.downloadUrl(), Filename: asset.Name}
+	pc := fetchPublishedChecksum(releaseData, asset)
+	if pc != nil {
+		slog.Info("found published checksum", "asset", asset.Name, "file", pc.File, "checksum", pc.Checksum)
+		fetched.Checksums = []checksum{pc.Checksum}
+	}
+	keys, err := verifyKeysFor(opts)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		if fetched.Signature, err = githubSignature(releaseData, asset, pc, keys); err != nil {
+			return nil, err
+		}
+	}
+	return fetched, nil
+}
+
+// matchingAssets returns the assets of a release built for the platform, judging by their names, ranked best first.
+// See scoreAsset. If opts.Asset is set, only the assets it matches are considered, and if the names of none of those
+// give away their platform, they are all returned: the user has said which they want.
+func matchingAssets(release *githubApiReleases, opts resolveOpts) []rankedAsset {
+	assets := release.Assets
+	if opts.Asset != "" {
+		assets = []*githubApiReleaseAsset{}
+		for _, asset := range release.Assets {
+			if matchAssetPattern(opts.Asset, asset.Name) {
+				assets = append(assets, asset)
+			}
+		}
+		if len(assets) <= 1 {
+			return rankAssets(assets, opts.Platform, opts.Prefer)
+		}
+	}
 
-	// We want an asset that matches the OS and architecture. Sometimes 'macos' will be used instead of 'darwin', etc, so handle this here.
-	wantedKeywords := []string{runtime.GOOS, runtime.GOARCH, alternativeArchKeywords[runtime.GOOS], alternativeArchKeywords[runtime.GOARCH]}
 	var potentialAssets []*githubApiReleaseAsset
+	for _, asset := range assets {
+		if assetMatchesPlatform(asset.Name, opts.Platform) {
+			potentialAssets = append(potentialAssets, asset)
+		}
+	}
+	if len(potentialAssets) == 0 && opts.Asset != "" {
+		potentialAssets = assets
+	}
+	return rankAssets(potentialAssets, opts.Platform, opts.Prefer)
+}
 
-	for _, asset := range releaseData.Assets {
-		kwCount := 0
-		for _, kw := range wantedKeywords {
-			// We want at least two keywords, i.e. one for arch and one for OS.
-			if kwCount >= 2 {
-				potentialAssets = append(potentialAssets, asset)
+// matchAssetPattern returns whether an asset's name matches pattern, which is a regular expression if it is wrapped
+// in slashes, e.g. /linux-(musl|gnu)/, and a case-insensitive glob otherwise, e.g. *linux-musl*.tar.gz.
+func matchAssetPattern(pattern string, name string) bool {
+	if re, ok := strings.CutPrefix(pattern, "/"); ok && strings.HasSuffix(re, "/") && len(re) > 1 {
+		matched, err := regexp.MatchString(strings.TrimSuffix(re, "/"), name)
+		return err == nil && matched
+	}
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return err == nil && matched
+}
+
+// validateAssetPattern checks that an --asset pattern can be used. See matchAssetPattern.
+func validateAssetPattern(pattern string) error {
+	if re, ok := strings.CutPrefix(pattern, "/"); ok && strings.HasSuffix(re, "/") && len(re) > 1 {
+		if _, err := regexp.Compile(strings.TrimSuffix(re, "/")); err != nil {
+			return withKind(errUsage, fmt.Errorf("invalid asset regular expression %s: %w", pattern, err))
+		}
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return withKind(errUsage, fmt.Errorf("invalid asset glob %s: %w", pattern, err))
+	}
+	return nil
+}
+
+// RELEASES_PER_PAGE and MAX_RELEASE_PAGES bound how far back findOlderRelease looks.
+const (
+	RELEASES_PER_PAGE = 30
+	MAX_RELEASE_PAGES = 5
+)
+
+// findOlderRelease walks back through the releases of the repository at repoPath, newest first, for the newest one
+// after latestTag that has assets for the platform. Drafts are skipped, and so are prereleases unless opts.Prerelease
+// is set, as fetchLatestRelease does. Returns nil if there isn't one within MAX_RELEASE_PAGES pages.
+func findOlderRelease(repoPath string, opts resolveOpts, latestTag string) (*githubApiReleases, []rankedAsset, error) {
+	for page := 1; page <= MAX_RELEASE_PAGES; page++ {
+		slog.Info("searching older releases for an asset matching the platform", "page", page, "platform", opts.Platform)
+		releases := []*githubApiReleases{}
+		endpoint := "releases?per_page=" + strconv.Itoa(RELEASES_PER_PAGE) + "&page=" + strconv.Itoa(page)
+		if err := fetchGithubApi(repoPath, endpoint, &releases); err != nil {
+			return nil, nil, err
+		}
+
+		for _, r := range releases {
+			if r.Draft || r.Prerelease && !opts.Prerelease || r.TagName == latestTag {
+				continue
 			}
+			if assets := matchingAssets(r, opts); len(assets) > 0 {
+				return r, assets, nil
+			}
+			slog.Debug("release has no asset for the platform", "release", r.TagName)
+		}
+		if len(releases) < RELEASES_PER_PAGE {
+			break
+		}
+	}
+	return nil, nil, nil
+}
+
+// findReleaseByTag searches the releases of the repository at repoPath for one matching tag, for tags that can't be
+// fetched directly: tags prefixed with a component, e.g. cli/v1.2.0 in a monorepo, or releases named after the
+// version rather than tagged with it. Returns errReleaseNotFound if there isn't one within MAX_RELEASE_PAGES pages.
+func findReleaseByTag(repoPath string, tag string) (*githubApiReleases, error) {
+	version := strings.TrimPrefix(tag, "v")
+	for page := 1; page <= MAX_RELEASE_PAGES; page++ {
+		releases := []*githubApiReleases{}
+		endpoint := "releases?per_page=" + strconv.Itoa(RELEASES_PER_PAGE) + "&page=" + strconv.Itoa(page)
+		if err := fetchGithubApi(repoPath, endpoint, &releases); err != nil {
+			return nil, err
+		}
 
-			if strings.Contains(strings.ToLower(asset.Name), kw) {
-				kwCount++
+		for _, r := range releases {
+			if r.Draft {
+				continue
 			}
+			_, suffix, _ := strings.Cut(r.TagName, "/")
+			if strings.TrimPrefix(suffix, "v") == version || strings.TrimPrefix(r.Name, "v") == version {
+				slog.Info("found release by searching", "tag", tag, "release", r.TagName)
+				return r, nil
+			}
+		}
+		if len(releases) < RELEASES_PER_PAGE {
+			break
 		}
 	}
+	return nil, errReleaseNotFound
+}
+
+// findReleaseSatisfying walks back through the releases of the repository at repoPath, newest first, for the highest
+// version that satisfies c on the first page with any that do. Drafts and prereleases are skipped. Returns
+// errReleaseNotFound if there isn't one within MAX_RELEASE_PAGES pages.
+func findReleaseSatisfying(repoPath string, c versionConstraint) (*githubApiReleases, error) {
+	for page := 1; page <= MAX_RELEASE_PAGES; page++ {
+		releases := []*githubApiReleases{}
+		endpoint := "releases?per_page=" + strconv.Itoa(RELEASES_PER_PAGE) + "&page=" + strconv.Itoa(page)
+		if err := fetchGithubApi(repoPath, endpoint, &releases); err != nil {
+			return nil, err
+		}
 
-	fmt.Println("The following assets were found that match your operating system and architecture:")
-	for i, asset := range potentialAssets {
-		fmt.Println(strconv.Itoa(i) + ") " + asset.Name)
+		var best *githubApiReleases
+		for _, r := range releases {
+			if r.Draft || r.Prerelease {
+				continue
+			}
+			// Monorepos prefix their tags with a component, e.g. cli/v1.2.0.
+			tag := r.TagName[strings.LastIndex(r.TagName, "/")+1:]
+			if c.satisfies(tag) && (best == nil || isNewerVersion(r.TagName, best.TagName)) {
+				best = r
+			}
+		}
+		if best != nil {
+			slog.Info("found release satisfying version", "version", c, "release", best.TagName)
+			return best, nil
+		}
+		if len(releases) < RELEASES_PER_PAGE {
+			break
+		}
 	}
+	return nil, errReleaseNotFound
+}
 
-	// TODO: Helper function for things like this (there will be a few). Currently panics on non-number input.
-	// TODO: Allow choosing assets outwith the guessed potential assets.
-	chosenAssetIdx := -1
-	for chosenAssetIdx >= len(potentialAssets) || chosenAssetIdx < 0 {
-		fmt.Printf("Please choose an asset to install: ")
-		_, err = fmt.Scanln(&chosenAssetIdx)
-		if err != nil {
-			panic(err)
+// chooseAssetNonInteractively picks the asset to install without asking: the one with the highest score. If several
+// share the highest score, the choice is ambiguous, so it fails rather than guessing.
+func chooseAssetNonInteractively(assets []rankedAsset, opts resolveOpts, tag string) (*githubApiReleaseAsset, error) {
+	tied := []string{}
+	for _, asset := range assets {
+		if asset.Score == assets[0].Score {
+			tied = append(tied, asset.Name)
 		}
 	}
+	if len(tied) == 1 {
+		slog.Info("chose the best matching asset", "asset", assets[0].Name, "score", assets[0].Score, "candidates", len(assets))
+		return assets[0].githubApiReleaseAsset, nil
+	}
+	return nil, withKind(errNoMatchingAsset, fmt.Errorf("%d assets in release %s match %s equally well, and infpm won't guess which to install: %s. Pick one with --prefer, or give the asset's URL",
+		len(tied), tag, opts.Platform, strings.Join(tied, ", ")))
+}
 
-	return &fetchedGithubAsset{
-		Name:    repoName,
-		Version: releaseData.TagName,
-		Url:     potentialAssets[chosenAssetIdx].BrowserDownloadUrl,
-	}, nil
+// chooseAssetInteractively asks which of the assets matching the platform to install, best first. The user can choose
+// to see every asset of the release instead, which they are shown straight away if none match.
+func chooseAssetInteractively(release *githubApiReleases, assets []rankedAsset, opts resolveOpts) (*githubApiReleaseAsset, error) {
+	showAll := len(assets) == 0
+	if showAll {
+		fmt.Println(tr("No assets in release %s match %s.", release.TagName, opts.Platform))
+	}
+	for {
+		list := assets
+		title := tr("The following assets were found that match %s:", opts.Platform)
+		if showAll {
+			list = rankAssets(release.Assets, opts.Platform, opts.Prefer)
+			title = tr("All the assets of release %s:", release.TagName)
+		}
+
+		items := []pickerItem{}
+		for i, asset := range list {
+			items = append(items, pickerItem{Label: asset.Name, Detail: strings.Join(assetNotes(list, i, opts), ", ")})
+		}
+		// The extra choice after the assets is to see every asset.
+		if !showAll {
+			items = append(items, pickerItem{Label: tr("Show all assets")})
+		}
+		i, err := newPrompter(true).choose(title, items, -1)
+		if errors.Is(err, errNoAnswer) {
+			return nil, withKind(errUsage, errors.New(tr("No asset was chosen.")))
+		} else if err != nil {
+			return nil, err
+		}
+		if i == len(list) {
+			showAll = true
+			continue
+		}
+		return list[i].githubApiReleaseAsset, nil
+	}
+}
+
+// assetNotes describes the ith of a ranked list of assets: its size and downloads, and whether it is the best match
+// or matches the build preference. The first is the best match unless it ties with the next.
+func assetNotes(list []rankedAsset, i int, opts resolveOpts) []string {
+	asset := list[i]
+	notes := []string{}
+	if asset.Size > 0 {
+		notes = append(notes, formatBytes(uint64(asset.Size)))
+	}
+	if asset.DownloadCount > 0 {
+		notes = append(notes, tr("%d downloads", asset.DownloadCount))
+	}
+	if i == 0 && assetMatchesPlatform(asset.Name, opts.Platform) && (len(list) == 1 || asset.Score > list[1].Score) {
+		notes = append(notes, tr("best match"))
+	}
+	if opts.Prefer != "" && matchesPreference(asset.Name, opts.Prefer) {
+		notes = append(notes, opts.Prefer)
+	}
+	return notes
+}
+
+// fetchLatestRelease fetches the latest release of the repository at repoPath. releases/latest skips prereleases, so
+// if prerelease is set, the highest version among the most recent releases is found instead, whether or not it is a
+// prerelease. Drafts are always skipped.
+func fetchLatestRelease(repoPath string, prerelease bool) (*githubApiReleases, error) {
+	if !prerelease {
+		return fetchGithubRelease(repoPath, "releases/latest")
+	}
+
+	releases := []*githubApiReleases{}
+	if err := fetchGithubApi(repoPath, "releases?per_page="+strconv.Itoa(RELEASES_PER_PAGE), &releases); err != nil {
+		return nil, err
+	}
+	var latest *githubApiReleases
+	for _, r := range releases {
+		if !r.Draft && (latest == nil || isNewerVersion(r.TagName, latest.TagName)) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return nil, withKind(errUsage, fmt.Errorf("%s has no releases", strings.TrimPrefix(repoPath, "/")))
+	}
+	if latest.Prerelease {
+		slog.Info("latest release is a prerelease", "release", latest.TagName)
+	}
+	return latest, nil
+}
+
+// errReleaseNotFound is returned by fetchGithubRelease if the release doesn't exist.
+var errReleaseNotFound = errors.New("release not found")
+
+// fetchGithubRelease fetches a release of the repository at repoPath (/user/repo, or host/user/repo on a Gitea host;
+// see repoPathOf) from the GitHub or Gitea API. endpoint is the path of the release relative to the repository, e.g.
+// releases/latest.
+func fetchGithubRelease(repoPath string, endpoint string) (*githubApiReleases, error) {
+	releaseData := &githubApiReleases{}
+	if err := fetchGithubApi(repoPath, endpoint, releaseData); err != nil {
+		return nil, err
+	}
+	return releaseData, nil
+}
+
+// fetchGithubApi decodes the response of an endpoint of the repository at repoPath into v. See fetchGithubRelease.
+// endpoint may include a query string.
+func fetchGithubApi(repoPath string, endpoint string, v any) error {
+	path, query, _ := strings.Cut(endpoint, "?")
+	apiUrl := repoApiUrl(repoPath).JoinPath(path)
+	apiUrl.RawQuery = query
+
+	req, err := http.NewRequest(http.MethodGet, apiUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	authorizeRequest(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return withKind(errNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "releases/tags/") {
+		return errReleaseNotFound
+	}
+	if resp.StatusCode != 200 && isGiteaRepoPath(repoPath) {
+		return giteaApiError(resp, repoPath)
+	} else if resp.StatusCode != 200 {
+		return githubApiError(resp, repoPath)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		slog.Error("failed to decode releases API response", "endpoint", endpoint)
+		return err
+	}
+	return nil
+}
+
+// githubApiError explains why a request to the GitHub API for the repository at repoPath failed.
+func githubApiError(resp *http.Response, repoPath string) error {
+	token, source := lookupToken("github")
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return withKind(errUsage, errors.New(tr("GitHub rejected the token from %s. It may have expired or been revoked; replace it, e.g. with infpm auth login github.", source)))
+	case (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) && isGithubRateLimited(resp):
+		reset := tr("soon")
+		if secs, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			reset = tr("at %s", time.Unix(secs, 0).Local().Format(time.TimeOnly))
+		} else if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			reset = tr("in %s", time.Duration(secs)*time.Second)
+		}
+		if token == "" {
+			return withKind(errNetwork, errors.New(tr("GitHub's API rate limit for unauthenticated requests was exceeded; it resets %s. Set GITHUB_TOKEN or log in with infpm auth login github to raise the limit, or provide the URL of the release asset yourself.", reset)))
+		}
+		return withKind(errNetwork, errors.New(tr("GitHub's API rate limit for the token from %s was exceeded; it resets %s.", source, reset)))
+	case resp.StatusCode == http.StatusForbidden:
+		return withKind(errUsage, errors.New(tr("GitHub refused access to %s. If it is private, the token needs permission to read its contents.", strings.TrimPrefix(repoPath, "/"))))
+	case resp.StatusCode == http.StatusNotFound:
+		if token == "" {
+			return withKind(errUsage, errors.New(tr("The GitHub repository %s doesn't exist, has no releases, or is private. To install from a private repository, set GITHUB_TOKEN or log in with infpm auth login github.", strings.TrimPrefix(repoPath, "/"))))
+		}
+		return withKind(errUsage, errors.New(tr("The GitHub repository %s doesn't exist, has no releases, or the token from %s can't access it.", strings.TrimPrefix(repoPath, "/"), source)))
+	}
+	return withKind(errNetwork, fmt.Errorf("GitHub returned status %d for %s", resp.StatusCode, redactUrl(resp.Request.URL)))
+}
+
+// isGithubRateLimited returns whether a failed response from the GitHub API is due to a primary or secondary rate
+// limit, rather than a lack of permission.
+// See https://docs.github.com/en/rest/using-the-rest-api/troubleshooting-the-rest-api#rate-limit-errors.
+func isGithubRateLimited(resp *http.Response) bool {
+	return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != ""
+}
+
+// staticAssetKeywords are found in the names of assets that are (likely) fully statically linked.
+var staticAssetKeywords = []string{"musl", "static"}
+
+// matchesPreference returns whether an asset's name suggests it matches the build preference.
+func matchesPreference(assetName string, prefer string) bool {
+	name := strings.ToLower(assetName)
+	static := false
+	for _, kw := range staticAssetKeywords {
+		if strings.Contains(name, kw) {
+			static = true
+		}
+	}
+
+	switch prefer {
+	case PREFER_STATIC:
+		return static
+	case PREFER_DYNAMIC:
+		return !static
+	}
+	return false
 }
 
 var idLetters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ123456789")
@@ -130,16 +544,103 @@ func generateId() string {
 	return string(b)
 }
 
-func tarExtract(from io.Reader, to string) error {
-	cmd := exec.Command("tar", "-xC", to)
-	cmd.Stdin = from
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// copyFile copies the regular file at src to dst, creating dst with the given mode.
+func copyFile(src string, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyTree recursively copies the file or directory at src to dst, preserving modes and symlinks.
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(path, target, info.Mode().Perm())
+		}
+	})
+}
 
-	if err := cmd.Run(); err != nil {
-		slog.Error("failed to extract archive with tar", "args", cmd.Args)
+// moveTree moves src to dst, falling back to copying and deleting if they're on different filesystems.
+func moveTree(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
 		return err
 	}
+	return os.RemoveAll(src)
+}
 
+// mergeTree moves everything in the directory src into the directory dst, which must be on the same filesystem.
+// Directories dst already has are merged, and anything else in the way is replaced.
+func mergeTree(src string, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		if info, err := os.Lstat(to); err == nil {
+			if e.IsDir() && info.IsDir() {
+				if err := mergeTree(from, to); err != nil {
+					return err
+				}
+				continue
+			}
+			if e.IsDir() || info.IsDir() {
+				if err := os.RemoveAll(to); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// infpmConfigDir returns the directory infpm keeps its configuration and credentials in, e.g. ~/.config/infpm.
+func infpmConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		slog.Error("failed to find the user config directory")
+		return "", err
+	}
+	return filepath.Join(dir, "infpm"), nil
+}
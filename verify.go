@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Kinds of problems found by verification.
+const (
+	VERIFY_MODIFIED   = "modified"
+	VERIFY_MISSING    = "missing"
+	VERIFY_EXTRANEOUS = "extraneous"
+	// VERIFY_UNLINKED is a link recorded for a package that no longer exists.
+	VERIFY_UNLINKED = "unlinked"
+	// VERIFY_HIJACKED is a link recorded for a package that has been replaced by something else.
+	VERIFY_HIJACKED = "hijacked"
+	// VERIFY_DANGLING is a link in the link roots to a file in the store that doesn't exist.
+	VERIFY_DANGLING = "dangling"
+)
+
+// verifyProblem is a single discrepancy between an installed package and its recorded manifest.
+type verifyProblem struct {
+	Kind   string
+	Path   string
+	Detail string
+}
+
+// verifyPackage compares the package's files in the store against its manifest. See verifyFiles.
+func (pm *PackageManager) verifyPackage(rec *PackageRecord, deep bool) ([]verifyProblem, error) {
+	return verifyFiles(filepath.Join(pm.StorePath, rec.Path), rec.Files, deep)
+}
+
+// verifyLinks checks that the links recorded for a package still point into it. A link to another installed version
+// of the package is fine, since use switches between them.
+func (pm *PackageManager) verifyLinks(rec *PackageRecord) []verifyProblem {
+	problems := []verifyProblem{}
+	if !rec.Platform.IsHost() || pm.Portable {
+		return problems
+	}
+	for _, dst := range rec.Links {
+		if linksInto(dst, filepath.Join(pm.StorePath, rec.Path)) || slices.ContainsFunc(pm.db.Find(rec.Name), func(other *PackageRecord) bool {
+			return linksInto(dst, filepath.Join(pm.StorePath, other.Path))
+		}) {
+			continue
+		}
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			problems = append(problems, verifyProblem{Kind: VERIFY_UNLINKED, Path: dst})
+		} else if target, err := os.Readlink(dst); err == nil {
+			problems = append(problems, verifyProblem{VERIFY_HIJACKED, dst, "links to " + target})
+		} else {
+			problems = append(problems, verifyProblem{VERIFY_HIJACKED, dst, "not a link"})
+		}
+	}
+	return problems
+}
+
+// findDanglingLinks looks through the link roots for links into the store whose targets don't exist, e.g. left behind
+// by a package that was removed by hand.
+func (pm *PackageManager) findDanglingLinks() ([]verifyProblem, error) {
+	roots := []string{}
+	for _, dir := range defaultLinkDirs {
+		roots = append(roots, filepath.Join(pm.SymlinkPath, dir))
+	}
+	for _, root := range pm.LinkRoots {
+		roots = append(roots, root)
+	}
+
+	problems := []verifyProblem{}
+	store := filepath.Clean(pm.StorePath) + string(filepath.Separator)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) || os.IsPermission(err) {
+					return nil
+				}
+				return err
+			}
+			if d.Type()&fs.ModeSymlink == 0 {
+				return nil
+			}
+			target, err := os.Readlink(path)
+			if err != nil || !strings.HasPrefix(target, store) {
+				return nil
+			}
+			if _, err := os.Stat(path); os.IsNotExist(err) && !slices.ContainsFunc(problems, func(p verifyProblem) bool { return p.Path == path }) {
+				problems = append(problems, verifyProblem{VERIFY_DANGLING, path, "links to " + target})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// verifyFiles compares the files beneath root against a manifest. Sizes, modes and symlink targets are always
+// compared; if deep is set, the contents of every regular file are re-hashed too.
+func verifyFiles(root string, files []FileEntry, deep bool) ([]verifyProblem, error) {
+	problems := []verifyProblem{}
+	recorded := map[string]bool{}
+
+	for _, want := range files {
+		recorded[want.Path] = true
+
+		got, err := manifestEntry(root, filepath.Join(root, filepath.FromSlash(want.Path)))
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, verifyProblem{Kind: VERIFY_MISSING, Path: want.Path})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case got.Mode != want.Mode:
+			problems = append(problems, verifyProblem{VERIFY_MODIFIED, want.Path, fmt.Sprintf("mode %s, expected %s", got.Mode, want.Mode)})
+		case got.LinkTarget != want.LinkTarget:
+			problems = append(problems, verifyProblem{VERIFY_MODIFIED, want.Path, fmt.Sprintf("links to %s, expected %s", got.LinkTarget, want.LinkTarget)})
+		case got.Size != want.Size:
+			problems = append(problems, verifyProblem{VERIFY_MODIFIED, want.Path, fmt.Sprintf("size %d, expected %d", got.Size, want.Size)})
+		case deep && got.Sha256 != want.Sha256:
+			problems = append(problems, verifyProblem{VERIFY_MODIFIED, want.Path, "sha256 " + got.Sha256 + ", expected " + want.Sha256})
+		}
+	}
+
+	// Don't hash anything while looking for extraneous files; only the paths matter.
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !recorded[filepath.ToSlash(relPath)] {
+			problems = append(problems, verifyProblem{Kind: VERIFY_EXTRANEOUS, Path: filepath.ToSlash(relPath)})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return problems, nil
+}
+
+func actionVerify(ctx context.Context, cmd *cli.Command) error {
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	recs := pm.db.Packages
+	if name := cmd.Args().Get(0); name != "" {
+		if recs = pm.db.Find(name); len(recs) == 0 {
+			return withKind(errUsage, fmt.Errorf("package %s is not installed", name))
+		}
+	}
+
+	failed := 0
+	for _, rec := range recs {
+		problems, err := pm.verifyPackage(rec, cmd.Bool("deep"))
+		if err != nil {
+			return withPackage(err, rec.Name, rec.Source)
+		}
+		problems = append(problems, pm.verifyLinks(rec)...)
+
+		if len(problems) == 0 {
+			fmt.Println(tr("%s: ok", rec))
+			continue
+		}
+
+		failed++
+		fmt.Println(rec.String() + ":")
+		printVerifyProblems(problems)
+	}
+
+	// Dangling links belong to no installed package, so they are only looked for when checking everything.
+	dangling := []verifyProblem{}
+	if cmd.NArg() == 0 && !pm.Portable {
+		if dangling, err = pm.findDanglingLinks(); err != nil {
+			return err
+		}
+	}
+	if len(dangling) > 0 {
+		fmt.Println(tr("Links into the store:"))
+		printVerifyProblems(dangling)
+	}
+
+	if failed > 0 {
+		return withKind(errVerificationFailed, fmt.Errorf("%d package(s) failed verification", failed))
+	} else if len(dangling) > 0 {
+		return withKind(errVerificationFailed, fmt.Errorf("%d link(s) into the store are dangling", len(dangling)))
+	}
+	return nil
+}
+
+// printVerifyProblems prints one line for each problem.
+func printVerifyProblems(problems []verifyProblem) {
+	for _, p := range problems {
+		if p.Detail != "" {
+			fmt.Printf("  %-10s %s (%s)\n", p.Kind, p.Path, p.Detail)
+		} else {
+			fmt.Printf("  %-10s %s\n", p.Kind, p.Path)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/urfave/cli/v3"
+)
+
+// Build information, set at build time with -ldflags, e.g.
+//
+//	-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+//
+// Anything left unset is filled in from the information the Go toolchain embeds, where possible.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes this build of infpm.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+// currentBuildInfo returns the build information set with -ldflags, falling back to what `go build` and
+// `go install` record: the module version and the VCS revision and commit time.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  hostPlatform().String(),
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	modified := false
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+func actionVersion(ctx context.Context, cmd *cli.Command) error {
+	info := currentBuildInfo()
+	if cmd.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(info)
+	}
+
+	fmt.Println("infpm", info.Version)
+	if info.Commit != "" {
+		fmt.Println("commit:    ", info.Commit)
+	}
+	if info.Date != "" {
+		fmt.Println("built:     ", info.Date)
+	}
+	fmt.Println("go version:", info.GoVersion)
+	fmt.Println("platform:  ", info.Platform)
+	return nil
+}
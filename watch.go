@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// FEED_FILENAME is the name of the release feed, stored with the package database.
+const FEED_FILENAME = "feed.jsonl"
+
+// DEFAULT_WATCH_INTERVAL is how often watch polls when no --interval is given.
+const DEFAULT_WATCH_INTERVAL = 6 * time.Hour
+
+// feedEntry is a release observed by watch. The feed file has one entry per line, so it can be followed with tail -f
+// or read by other tools.
+type feedEntry struct {
+	ObservedAt time.Time `json:"observedAt"`
+	Package    string    `json:"package"`
+	Version    string    `json:"version"`
+	// Installed is the version that was installed when the release was observed.
+	Installed string `json:"installed"`
+	Url       string `json:"url"`
+	// Upgraded is whether the package was upgraded to the release automatically.
+	Upgraded bool `json:"upgraded,omitempty"`
+}
+
+// readFeed returns the releases already in the feed at path, keyed by package and version.
+func readFeed(path string) (map[string]bool, error) {
+	seen := map[string]bool{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return seen, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e feedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			slog.Warn("skipping malformed feed entry", "path", path, "err", err)
+			continue
+		}
+		seen[e.Package+"@"+e.Version] = true
+	}
+	return seen, scanner.Err()
+}
+
+// appendFeed appends entries to the feed at path.
+func appendFeed(path string, entries []feedEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// pollReleases checks the latest release of every package installed from GitHub or a source with a latest version, appending releases that aren't
+// installed and haven't been seen before to the feed at feedPath. Packages with auto_upgrade set in the config are
+// upgraded to them, unless they are pinned.
+func (pm *PackageManager) pollReleases(feedPath string, ropts resolveOpts) error {
+	seen, err := readFeed(feedPath)
+	if err != nil {
+		return err
+	}
+
+	entries := []feedEntry{}
+	for _, name := range pm.db.Names() {
+		// Still record the releases seen so far.
+		if interrupted() != nil {
+			break
+		}
+		current := pm.latestRecord(name)
+		if !canFindLatest(current.Source) {
+			slog.Debug("not watching package, it wasn't installed from a repository's releases or a source with a latest version", "package", name, "source", current.Source)
+			continue
+		}
+
+		version, pageUrl, err := latestVersionOf(current.Source, config.PrereleaseFor(name))
+		if err != nil {
+			slog.Error("failed to check for a new release, continuing", "package", name, "err", err)
+			continue
+		}
+		if !isNewerVersion(version, current.Version) || seen[name+"@"+version] {
+			continue
+		}
+
+		slog.Info("observed a new release", "package", name, "version", version, "installed", current.Version)
+		entry := feedEntry{ObservedAt: time.Now().UTC(), Package: name, Version: version, Installed: current.Version, Url: pageUrl}
+		if pc, ok := config.Packages[name]; ok && pc.AutoUpgrade && !pm.db.IsPinned(name) {
+			if _, err := pm.Upgrade(name, ropts); err != nil {
+				slog.Error("failed to upgrade, continuing", "package", name, "err", err)
+			} else {
+				entry.Upgraded = true
+				fmt.Println(tr("Upgraded %s from %s to %s", name, current.Version, version))
+			}
+		}
+		seen[name+"@"+version] = true
+		entries = append(entries, entry)
+	}
+	return appendFeed(feedPath, entries)
+}
+
+func actionWatch(ctx context.Context, cmd *cli.Command) error {
+	interval := DEFAULT_WATCH_INTERVAL
+	if s := cmd.String("interval"); s != "" {
+		var err error
+		if interval, err = parseAge(s); err != nil {
+			return err
+		}
+		if interval < time.Minute {
+			return withKind(errUsage, errors.New(tr("The interval must be at least a minute.")))
+		}
+	}
+
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+	pm.Interactive = false
+	feedPath := cmd.String("feed")
+	if feedPath == "" {
+		feedPath = filepath.Join(pm.statePath(), FEED_FILENAME)
+	}
+	// Upgrades must never stop to ask anything, as nobody is watching.
+	ropts := resolveOpts{Platform: hostPlatform(), NonInteractive: true}
+
+	if cmd.Bool("once") {
+		return pm.pollReleases(feedPath, ropts)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := pm.pollReleases(feedPath, ropts); err != nil {
+			slog.Error("failed to poll for releases", "err", err)
+		}
+		// Don't keep other infpm processes out of the store while waiting.
+		pm.unlockStore()
+		slog.Info("waiting to poll again", "interval", interval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := pm.relockStore(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// ownedFile is a file in the link roots or the store and the installed package it belongs to, as shown by which --json.
+type ownedFile struct {
+	Path    string `json:"path"`
+	Target  string `json:"target"`
+	Package string `json:"package"`
+	Version string `json:"version"`
+}
+
+// owner returns the installed package that the file at path is, or links to, a file of, along with where in the store
+// the file is. Returns nil if no installed package has it.
+func (pm *PackageManager) owner(path string) (*PackageRecord, string, error) {
+	target := path
+	if info, err := os.Lstat(path); err != nil {
+		return nil, "", err
+	} else if info.Mode()&os.ModeSymlink != 0 {
+		if target, err = os.Readlink(path); err != nil {
+			return nil, "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+	}
+
+	target = filepath.Clean(target)
+	for _, rec := range pm.db.Packages {
+		fullPath := filepath.Join(pm.StorePath, rec.Path)
+		if target == fullPath || strings.HasPrefix(target, fullPath+string(filepath.Separator)) {
+			return rec, target, nil
+		}
+	}
+	return nil, target, nil
+}
+
+// findCommand returns where the command called name is: in the bin directory if it is there, or else wherever it is
+// in PATH. Names with a path separator are taken as paths.
+func (pm *PackageManager) findCommand(name string) (string, error) {
+	if strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/') {
+		return filepath.Abs(name)
+	}
+	path := filepath.Join(pm.binDir(), name)
+	if _, err := os.Lstat(path); err == nil {
+		return path, nil
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return filepath.Abs(path)
+	}
+	return "", withKind(errUsage, fmt.Errorf("%s isn't in %s or PATH", name, pm.binDir()))
+}
+
+func actionWhich(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() == 0 {
+		return withKind(errUsage, errors.New(tr("A command or file is required, e.g. infpm which rg.")))
+	}
+	pm, err := packageManagerFromCmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	owned := []ownedFile{}
+	unowned := []string{}
+	for _, name := range cmd.Args().Slice() {
+		path, err := pm.findCommand(name)
+		if err != nil {
+			return err
+		}
+		rec, target, err := pm.owner(path)
+		if os.IsNotExist(err) {
+			return withKind(errUsage, fmt.Errorf("%s doesn't exist", path))
+		} else if err != nil {
+			return err
+		}
+		if rec == nil {
+			unowned = append(unowned, path)
+			continue
+		}
+		owned = append(owned, ownedFile{Path: path, Target: target, Package: rec.Name, Version: rec.Version})
+	}
+
+	if cmd.Bool("json") {
+		if err := json.NewEncoder(os.Stdout).Encode(owned); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range owned {
+			fmt.Println(tr("%s is provided by %s %s (%s)", f.Path, f.Package, f.Version, f.Target))
+		}
+	}
+	if len(unowned) > 0 {
+		return fmt.Errorf("not provided by any installed package: %s", strings.Join(unowned, ", "))
+	}
+	return nil
+}